@@ -0,0 +1,490 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The offset prefix the assembly output puts on each line
+var ASSEMBLY_OFFSET_REGEX = regexp.MustCompile(`^//\s*(0x[0-9A-Fa-f]+)\s*`)
+
+var OPCODES_BY_NAME = map[string]byte{}
+
+func init() {
+	for opcode, info := range OP_MAP {
+		OPCODES_BY_NAME[info.name] = opcode
+	}
+}
+
+// An operation parsed from assembly text, with the jumps and calls still referring to labels and functions by name
+type assembledOperation struct {
+	opcode byte
+	data   []byte
+	line   int
+	// The offset the line was prefixed with, so the original data can be kept for the parts that aren't written
+	offset    uint32
+	hasOffset bool
+	label     string
+	function  string
+	str       string
+}
+
+type assembledFunction struct {
+	operations []*assembledOperation
+	// The operation index of each label, offset prefixes are labels as well so pasted assembly keeps its jumps
+	labels map[string]int
+}
+
+func getOffsetLabel(offset uint32) string {
+	return fmt.Sprintf("0x%08X", offset)
+}
+
+// Splits the operands of a line on spaces and commas, keeping quoted strings together
+func splitAssemblyOperands(text string) []string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "\"") {
+		return []string{text}
+	}
+	return strings.FieldsFunc(text, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })
+}
+
+// Reads a string literal like the assembly output writes them, with backslashes, quotes and line breaks escaped. A
+// backslash before anything else is taken as it is.
+func parseAssemblyString(text string) (string, error) {
+	if len(text) < 2 || !strings.HasPrefix(text, "\"") || !strings.HasSuffix(text, "\"") {
+		return "", fmt.Errorf("expected a quoted string, got %s", text)
+	}
+	text = text[1 : len(text)-1]
+
+	var sb strings.Builder
+	for ii := 0; ii < len(text); ii++ {
+		if text[ii] == '\\' && ii+1 < len(text) {
+			switch text[ii+1] {
+			case 'n':
+				sb.WriteByte('\n')
+				ii++
+				continue
+			case '\\', '"':
+				sb.WriteByte(text[ii+1])
+				ii++
+				continue
+			}
+		}
+		sb.WriteByte(text[ii])
+	}
+	return sb.String(), nil
+}
+
+func parseAssemblyUInt(text string, bits int) (uint64, error) {
+	value, err := strconv.ParseUint(text, 0, bits)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' isn't a %d bit unsigned number", text, bits)
+	}
+	return value, nil
+}
+
+func parseAssemblyLine(op *assembledOperation, name string, operands []string) error {
+	opcode, ok := OPCODES_BY_NAME[name]
+	if !ok || opcode == OP_REMOVED {
+		return fmt.Errorf("unknown operation %s", name)
+	}
+	op.opcode = opcode
+	op.data = make([]byte, OP_MAP[opcode].dataSize)
+
+	expect := func(count int, usage string) error {
+		if len(operands) != count {
+			return fmt.Errorf("%s expects %s", name, usage)
+		}
+		return nil
+	}
+
+	switch opcode {
+	case OP_JUMP, OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE, OP_JUMP_IF_NOT_DEBUG:
+		if err := expect(1, "a label"); err != nil {
+			return err
+		}
+		op.label = operands[0]
+
+	case OP_SCHEDULE_EVERY:
+		if err := expect(3, "a label, a number and an interval"); err != nil {
+			return err
+		}
+		op.label = operands[0]
+		middle, err := parseAssemblyUInt(operands[1], 32)
+		if err != nil {
+			return err
+		}
+		interval, err := strconv.ParseFloat(operands[2], 32)
+		if err != nil {
+			return fmt.Errorf("'%s' isn't an interval", operands[2])
+		}
+		binary.LittleEndian.PutUint32(op.data[4:8], uint32(middle))
+		binary.LittleEndian.PutUint32(op.data[8:12], math.Float32bits(float32(interval)))
+
+	case OP_FUNCTION_CALL_LOCAL, OP_TASK_CALL_LOCAL, OP_FUNCTION_CALL_IMPORTED, OP_TASK_CALL_IMPORTED:
+		if err := expect(2, "a function and a parameter count"); err != nil {
+			return err
+		}
+		op.function = operands[0]
+		count, err := parseAssemblyUInt(operands[1], 32)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(op.data[8:12], uint32(count))
+
+	case OP_LITERAL_STRING:
+		if err := expect(1, "a quoted string"); err != nil {
+			return err
+		}
+		value, err := parseAssemblyString(operands[0])
+		if err != nil {
+			return err
+		}
+		if strings.IndexByte(value, 0) >= 0 {
+			return fmt.Errorf("strings can't have a NUL character")
+		}
+		op.str = value
+
+	case OP_LITERAL_FLT:
+		if err := expect(1, "a float"); err != nil {
+			return err
+		}
+		value, err := strconv.ParseFloat(operands[0], 32)
+		if err != nil {
+			return fmt.Errorf("'%s' isn't a float", operands[0])
+		}
+		binary.LittleEndian.PutUint32(op.data, math.Float32bits(float32(value)))
+
+	case OP_LITERAL_BYTE, OP_LITERAL_SHORT, OP_LITERAL_INT:
+		if err := expect(1, "an int"); err != nil {
+			return err
+		}
+		bits := len(op.data) * 8
+		value, err := strconv.ParseInt(operands[0], 0, bits)
+		if err != nil {
+			return fmt.Errorf("'%s' isn't a %d bit int", operands[0], bits)
+		}
+		switch bits {
+		case 8:
+			op.data[0] = byte(int8(value))
+		case 16:
+			binary.LittleEndian.PutUint16(op.data, uint16(int16(value)))
+		default:
+			binary.LittleEndian.PutUint32(op.data, uint32(int32(value)))
+		}
+
+	default:
+		// Everything else is a plain number as big as its data, or has no data
+		if len(op.data) == 0 {
+			return expect(0, "no operands")
+		}
+		if err := expect(1, "a number"); err != nil {
+			return err
+		}
+		value, err := parseAssemblyUInt(operands[0], len(op.data)*8)
+		if err != nil {
+			return err
+		}
+		if len(op.data) == 1 {
+			op.data[0] = byte(value)
+		} else {
+			binary.LittleEndian.PutUint32(op.data, uint32(value))
+		}
+	}
+	return nil
+}
+
+// Parses the assembly of one function. Lines are written like the assembly output, optionally with its offset
+// prefix, and "name:" lines are labels. Anything after a # and lines starting with // are comments.
+func parseAssembly(text string) (*assembledFunction, error) {
+	result := &assembledFunction{labels: map[string]int{}}
+	addLabel := func(label string, lineNumber int) error {
+		if _, exists := result.labels[label]; exists {
+			return fmt.Errorf("line %d: duplicate label %s", lineNumber, label)
+		}
+		result.labels[label] = len(result.operations)
+		return nil
+	}
+
+	for lineNumber, line := range strings.Split(text, "\n") {
+		lineNumber++
+		if idx := strings.Index(line, "#"); idx >= 0 && !strings.Contains(line[:idx], "\"") {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+
+		op := &assembledOperation{line: lineNumber}
+		if match := ASSEMBLY_OFFSET_REGEX.FindStringSubmatch(line); match != nil {
+			offset, err := parseAssemblyUInt(match[1], 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			op.offset = uint32(offset)
+			op.hasOffset = true
+			line = line[len(match[0]):]
+		}
+		// Other comments, like the function headers of the assembly output
+		if len(line) == 0 || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if strings.HasSuffix(line, ":") && !strings.ContainsAny(line, " \t\"") {
+			if err := addLabel(strings.TrimSuffix(line, ":"), lineNumber); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		name, operands, _ := strings.Cut(line, " ")
+		if err := parseAssemblyLine(op, name, splitAssemblyOperands(operands)); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		if op.hasOffset {
+			if err := addLabel(getOffsetLabel(op.offset), lineNumber); err != nil {
+				return nil, err
+			}
+		}
+		result.operations = append(result.operations, op)
+	}
+
+	if len(result.operations) == 0 || result.operations[len(result.operations)-1].opcode != OP_FUNCTION_END {
+		return nil, fmt.Errorf("the function has to end with OP_FUNCTION_END")
+	}
+	for _, op := range result.operations[:len(result.operations)-1] {
+		if op.opcode == OP_FUNCTION_END {
+			return nil, fmt.Errorf("line %d: OP_FUNCTION_END before the end of the function", op.line)
+		}
+	}
+	return result, nil
+}
+
+func (p *PackagePatch) isFunctionStart(idx int) bool {
+	return idx == 0 || (idx < len(p.operations) && p.operations[idx-1].opcode == OP_FUNCTION_END)
+}
+
+func (p *PackagePatch) getStringIndex(value string) uint32 {
+	for idx, s := range p.strings {
+		if s == value {
+			return uint32(idx)
+		}
+	}
+	p.strings = append(p.strings, value)
+	p.stringsChanged = true
+	return uint32(len(p.strings) - 1)
+}
+
+// Replaces the code of the function starting at the offset with assembly. Local calls go to the functions given by
+// name, or to the offset of their start. Everything that refers to code after the function moves with it.
+func (p *PackagePatch) ReplaceFunction(offset uint32, assembly string, functions map[string]uint32) error {
+	start, err := p.getOperationIndex(offset)
+	if err != nil {
+		return err
+	}
+	if !p.isFunctionStart(start) {
+		return fmt.Errorf("0x%08X isn't the start of a function", offset)
+	}
+	end := start
+	for end < len(p.operations)-1 && p.operations[end].opcode != OP_FUNCTION_END {
+		end++
+	}
+
+	fnc, err := parseAssembly(assembly)
+	if err != nil {
+		return err
+	}
+	delta := len(fnc.operations) - (end - start + 1)
+
+	// Only the start of the function can be referred to from outside of it, and it stays where it is
+	remap := func(idx int) int {
+		switch {
+		case idx < start || idx == start:
+			return idx
+		case idx > end:
+			return idx + delta
+		}
+		return -1
+	}
+	for idx, op := range p.operations {
+		if (idx < start || idx > end) && op.target > start && op.target <= end {
+			return fmt.Errorf("%s at 0x%08X jumps into the function", OP_MAP[op.opcode].name, op.originalOffset)
+		}
+	}
+
+	// Work out the new operations before changing anything, so an error leaves the package as it was
+	replacement := []*patchOperation{}
+	for _, asm := range fnc.operations {
+		op := &patchOperation{opcode: asm.opcode, data: asm.data, target: -1, middleTarget: -1}
+		// The assembly doesn't show the first bytes of call data, so calls pasted with their offset keep them
+		if original, ok := p.offsetToIndex[asm.offset]; ok && asm.hasOffset && len(asm.function) > 0 {
+			if original >= start && original <= end && p.operations[original].opcode == asm.opcode {
+				copy(op.data[0:8], p.operations[original].data[0:8])
+			}
+		}
+
+		switch {
+		case len(asm.label) > 0:
+			label := asm.label
+			if value, err := strconv.ParseUint(label, 0, 32); err == nil {
+				label = getOffsetLabel(uint32(value))
+			}
+			target, ok := fnc.labels[label]
+			if !ok {
+				return fmt.Errorf("line %d: unknown label %s", asm.line, asm.label)
+			}
+			op.target = start + target
+
+		case asm.opcode == OP_FUNCTION_CALL_LOCAL || asm.opcode == OP_TASK_CALL_LOCAL:
+			functionOffset, ok := functions[asm.function]
+			if !ok {
+				value, err := strconv.ParseUint(asm.function, 0, 32)
+				if err != nil {
+					return fmt.Errorf("line %d: unknown function %s", asm.line, asm.function)
+				}
+				functionOffset = uint32(value)
+			}
+			target, ok := p.offsetToIndex[functionOffset]
+			if !ok || !p.isFunctionStart(target) || remap(target) < 0 {
+				return fmt.Errorf("line %d: %s isn't the start of a function", asm.line, asm.function)
+			}
+			op.target = remap(target)
+
+		case asm.opcode == OP_FUNCTION_CALL_IMPORTED || asm.opcode == OP_TASK_CALL_IMPORTED:
+			_, _, err := p.resolveImportedFunction(asm.function, binary.LittleEndian.Uint32(asm.data[8:12]))
+			if err != nil {
+				return fmt.Errorf("line %d: %v", asm.line, err)
+			}
+		}
+		replacement = append(replacement, op)
+	}
+
+	// Move everything that refers to code after the function
+	for idx, op := range p.operations {
+		if (idx < start || idx > end) && op.target >= 0 {
+			op.target = remap(op.target)
+		}
+		if (idx < start || idx > end) && op.middleTarget >= 0 {
+			op.middleTarget = remap(op.middleTarget)
+		}
+	}
+	p.removeImportedCalls(func(call int) bool { return call >= start && call <= end })
+	for _, imp := range p.imports {
+		for _, f := range imp.functions {
+			for ii := range f.calls {
+				f.calls[ii] = remap(f.calls[ii])
+			}
+		}
+	}
+	for _, exp := range p.exports {
+		exp.operation = remap(exp.operation)
+	}
+	offsetToIndex := map[uint32]int{}
+	for offset, idx := range p.offsetToIndex {
+		if newIdx := remap(idx); newIdx >= 0 {
+			offsetToIndex[offset] = newIdx
+		}
+	}
+	p.offsetToIndex = offsetToIndex
+
+	operations := append([]*patchOperation{}, p.operations[:start]...)
+	operations = append(operations, replacement...)
+	p.operations = append(operations, p.operations[end+1:]...)
+
+	for ii, asm := range fnc.operations {
+		idx := start + ii
+		switch asm.opcode {
+		case OP_FUNCTION_CALL_IMPORTED, OP_TASK_CALL_IMPORTED:
+			imp, name, _ := p.resolveImportedFunction(asm.function, binary.LittleEndian.Uint32(asm.data[8:12]))
+			p.addImportedCall(imp, name, idx)
+		case OP_LITERAL_STRING:
+			binary.LittleEndian.PutUint32(p.operations[idx].data, p.getStringIndex(asm.str))
+		}
+	}
+	return nil
+}
+
+// Gets the start offset of each decompiled function, by the names the decompiler gives them
+func getFunctionOffsets() map[string]uint32 {
+	result := map[string]uint32{}
+	for _, fnc := range DECOMPILED_FUNCS {
+		offset := OPERATIONS[fnc.startingIndex].offset
+		result[fnc.declaration.GetScopedName()] = offset
+		result[fnc.declaration.name] = offset
+	}
+	return result
+}
+
+// Runs the replace command, returns the exit code. The function is named the way the decompiler names it, or
+// given by the offset of its start.
+func Replace(pkgFile string, function string, assemblyFile string, outputFile string) int {
+	err := ValidateDiagnosticsOptions()
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "Invalid diagnostics options: %v", err)
+		return GetExitCode()
+	}
+
+	data, err := os.ReadFile(pkgFile)
+	if err != nil {
+		Diagnose(DIAG_READ_FAILED, "Failed to read file: %v", err)
+		return GetExitCode()
+	}
+	assembly, err := os.ReadFile(assemblyFile)
+	if err != nil {
+		Diagnose(DIAG_READ_FAILED, "Failed to read assembly: %v", err)
+		return GetExitCode()
+	}
+
+	if len(INCLUDES_DIR) > 0 {
+		LoadDeclarationsFromHeaders(INCLUDES_DIR)
+	} else {
+		fmt.Printf("No includes directory, skipping the parameter count checks\n")
+	}
+
+	// The function boundaries and names come from the decompiler
+	if !loadPackage(data) {
+		return GetExitCode()
+	}
+	functions := getFunctionOffsets()
+
+	offset, ok := functions[function]
+	if !ok {
+		value, err := strconv.ParseUint(function, 0, 32)
+		if err != nil {
+			Diagnose(DIAG_INVALID_PATCH, "Function %s isn't in the package", function)
+			return EXIT_FATAL
+		}
+		offset = uint32(value)
+	}
+
+	p, err := LoadPackagePatch(data)
+	if err != nil {
+		Diagnose(DIAG_READ_FAILED, "Failed to read file: %v", err)
+		return GetExitCode()
+	}
+
+	fmt.Printf("Replacing function %s at 0x%08X in package: %s\n", function, offset, pkgFile)
+
+	err = p.ReplaceFunction(offset, string(assembly), functions)
+	if err != nil {
+		Diagnose(DIAG_INVALID_PATCH, "%s: %v", assemblyFile, err)
+		fmt.Printf("Not writing the package because of the invalid assembly\n")
+		return EXIT_FATAL
+	}
+
+	result := p.Bytes()
+	if problems := ValidatePackage(result); problems > 0 {
+		fmt.Printf("Not writing the package because the new package has %d problems\n", problems)
+		return EXIT_FATAL
+	}
+
+	fmt.Printf("Writing package: %s\n", outputFile)
+
+	err = os.WriteFile(outputFile, result, 0644)
+	if err != nil {
+		Diagnose(DIAG_WRITE_FAILED, "Failed to write package: %v", err)
+	}
+	return GetExitCode()
+}
@@ -0,0 +1,117 @@
+package decompiler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The Halve function of the calls package pasted from the assembly output, printing before it halves by 4 instead
+const HALVE_ASSEMBLY = `// ==================== START_FUNCTION local_function_0
+# Added
+OP_LITERAL_STRING "halving"
+OP_FUNCTION_CALL_IMPORTED Util.Print 1
+OP_POP_STACK
+
+// 0x0000007E OP_LITERAL_FLT 4.000000
+// 0x00000083 OP_VARIABLE_READ 0
+// 0x00000088 OP_FLT_DIV
+// 0x00000089 OP_JUMP 0x0000008E
+// 0x0000008E OP_LITERAL_ZERO
+// 0x0000008F OP_UNKNOWN_3C
+// 0x00000090 OP_FUNCTION_END
+`
+
+func replaceTestFunction(t *testing.T, function string, assembly string) (string, int) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	if err := os.WriteFile(INPUT_FILE, buildCallsPackage().Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assemblyFile := filepath.Join(dir, "function.asm")
+	if err := os.WriteFile(assemblyFile, []byte(assembly), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newFile := filepath.Join(dir, "replaced.pkg")
+	exitCode := Replace(INPUT_FILE, function, assemblyFile, newFile)
+	return newFile, exitCode
+}
+
+func TestReplaceFunction(t *testing.T) {
+	newFile, exitCode := replaceTestFunction(t, "local_function_0", HALVE_ASSEMBLY)
+	if exitCode != EXIT_SUCCESS {
+		t.Fatalf("expected exit code %d, got %d", EXIT_SUCCESS, exitCode)
+	}
+	data, err := os.ReadFile(newFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The function after it moved, along with its imported calls and the call to it
+	golden, err := os.ReadFile(filepath.Join(GOLDEN_DIR, "calls.pog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Replace(string(golden), "\treturn param_0_ / 2.0;", "\tUtil.Print( \"halving\" );\n\treturn param_0_ / 4.0;", 1)
+
+	resetForTest(t.TempDir())
+	if output := decompilePackageBytes(t, data); output != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, output)
+	}
+}
+
+func TestReplaceFunctionErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		function string
+		assembly string
+	}{
+		{"unknown function", "Missing", HALVE_ASSEMBLY},
+		{"unknown label", "local_function_0", strings.Replace(HALVE_ASSEMBLY, "OP_JUMP 0x0000008E", "OP_JUMP done", 1)},
+		{"unknown operation", "local_function_0", strings.Replace(HALVE_ASSEMBLY, "OP_FLT_DIV", "OP_FLT_DIVIDE", 1)},
+		{"missing end", "local_function_0", strings.Replace(HALVE_ASSEMBLY, "OP_FUNCTION_END", "OP_POP_STACK", 1)},
+		{"parameter count", "local_function_0", strings.Replace(HALVE_ASSEMBLY, "Util.Print 1", "Util.Print 2", 1)},
+		{"not imported", "local_function_0", strings.Replace(HALVE_ASSEMBLY, "Util.Print 1", "Copies.Report 1", 1)},
+		{"local call", "local_function_0", strings.Replace(HALVE_ASSEMBLY, "OP_POP_STACK", "OP_FUNCTION_CALL_LOCAL 0x00000005 0", 1)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			newFile, exitCode := replaceTestFunction(t, c.function, c.assembly)
+			if exitCode != EXIT_FATAL {
+				t.Fatalf("expected exit code %d, got %d", EXIT_FATAL, exitCode)
+			}
+			if _, err := os.Stat(newFile); err == nil {
+				t.Fatalf("expected the package not to be written")
+			}
+		})
+	}
+}
+
+func TestAssemblyStringRoundTrip(t *testing.T) {
+	ResetState()
+	STRING_TABLE = []string{
+		"plain",
+		"Hello \"stranger\"\n",
+		`C:\maps\new`,
+		`ends with a backslash\`,
+		"\\\"\\n",
+	}
+
+	for idx, expected := range STRING_TABLE {
+		text := LiteralStringData{index: uint32(idx)}.String()
+		operands := splitAssemblyOperands(text)
+		if len(operands) != 1 {
+			t.Fatalf("expected %s to be one operand, got %q", text, operands)
+		}
+		value, err := parseAssemblyString(operands[0])
+		if err != nil {
+			t.Fatalf("failed to read %s back: %v", text, err)
+		}
+		if value != expected {
+			t.Errorf("expected %q to read back from %s, got %q", expected, text, value)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package decompiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Command line options for the atomic block checks
+var ATOMIC_COMMENTS bool
+
+// Comma separated list of the packages, or Package.Function names, known to block
+var ATOMIC_BLOCKING string
+
+// Parts of the names of imported functions that block the task calling them, matched ignoring case
+var ATOMIC_BLOCKING_NAMES = []string{"sleep", "wait", "delay", "join"}
+
+// Something in an atomic block that stops the game sim from getting on with anything else
+type AtomicProblem struct {
+	code        string
+	offset      uint32
+	description string
+}
+
+func isBlockingImport(fd *FunctionDeclaration) bool {
+	if matchesTaskName(fd.name, ATOMIC_BLOCKING_NAMES) {
+		return true
+	}
+	for _, entry := range strings.Split(ATOMIC_BLOCKING, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) > 0 && (strings.EqualFold(entry, fd.pkg) || strings.EqualFold(entry, fd.GetScopedName())) {
+			return true
+		}
+	}
+	return false
+}
+
+// Works out which functions of the package can block, because they have a schedule, call an imported function that
+// blocks or call another function that does
+func getBlockingFunctions() map[*FunctionDeclaration]string {
+	result := map[*FunctionDeclaration]string{}
+	for _, fnc := range DECOMPILED_FUNCS {
+		declaration := fnc.declaration
+		WalkBlockElements(fnc.body, func(e BlockElement) bool {
+			if _, ok := e.(*ScheduleBlock); ok {
+				result[declaration] = "has a schedule"
+			}
+			for _, s := range GetElementStatements(e) {
+				s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+					if _, ok := result[declaration]; ok || node.operation.opcode != OP_FUNCTION_CALL_IMPORTED {
+						return
+					}
+					if callee := node.operation.GetFunctionDeclaration(); isBlockingImport(callee) {
+						result[declaration] = fmt.Sprintf("calls %s", callee.GetScopedName())
+					}
+				})
+			}
+			return true
+		})
+	}
+
+	spreadToCallers(result)
+	return result
+}
+
+func getVariableWrites(elements []BlockElement) map[uint32]bool {
+	result := map[uint32]bool{}
+	ForEachStatement(elements, func(s *Statement) {
+		s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+			switch node.operation.opcode {
+			case OP_VARIABLE_WRITE, OP_STRING_VARIABLE_WRITE:
+				result[node.operation.GetVariableStackIndex()] = true
+			}
+		})
+	})
+	return result
+}
+
+// A loop has an obvious bound when its condition reads a variable that the loop changes
+func isLoopBounded(conditional *Statement, writes map[uint32]bool) bool {
+	if conditional == nil {
+		return false
+	}
+
+	result := false
+	conditional.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+		if node.operation.opcode == OP_VARIABLE_READ && writes[node.operation.GetVariableStackIndex()] {
+			result = true
+		}
+	})
+	return result
+}
+
+// Gets the loop kind and whether it has an obvious bound, or an empty kind if the element isn't a loop
+func getLoopBound(e BlockElement) (string, bool) {
+	switch loop := e.(type) {
+	case *WhileLoop:
+		return "while", isLoopBounded(loop.conditional, getVariableWrites(loop.body))
+	case *DoWhileLoop:
+		return "do while", isLoopBounded(loop.conditional, getVariableWrites(loop.body))
+	case *ForLoop:
+		writes := getVariableWrites(loop.body)
+		if loop.increment != nil {
+			for index := range getVariableWrites([]BlockElement{loop.increment}) {
+				writes[index] = true
+			}
+		}
+		return "for", isLoopBounded(loop.conditional, writes)
+	}
+	return "", true
+}
+
+type atomicChecker struct {
+	scope    *Scope
+	blocking map[*FunctionDeclaration]string
+	problems []*AtomicProblem
+}
+
+func (ac *atomicChecker) add(e BlockElement, code string, offset uint32, format string, args ...interface{}) {
+	problem := &AtomicProblem{
+		code:        code,
+		offset:      offset,
+		description: fmt.Sprintf(format, args...),
+	}
+	ac.problems = append(ac.problems, problem)
+	ac.scope.atomicProblems[e] = append(ac.scope.atomicProblems[e], problem)
+}
+
+// Checks the elements of an atomic block. Breaks and continues stay inside the block when there is a loop, or a
+// switch for breaks, between them and the atomic block.
+func (ac *atomicChecker) check(elements []BlockElement, breakInside bool, continueInside bool) {
+	for _, e := range elements {
+		for _, s := range GetElementStatements(e) {
+			s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+				op := node.operation
+				switch op.opcode {
+				case OP_TASK_CALL_LOCAL, OP_TASK_CALL_IMPORTED:
+					ac.add(e, DIAG_ATOMIC_TASK_START, op.offset, "starts %s", op.GetFunctionDeclaration().GetScopedName())
+
+				case OP_FUNCTION_CALL_IMPORTED:
+					if callee := op.GetFunctionDeclaration(); isBlockingImport(callee) {
+						ac.add(e, DIAG_ATOMIC_BLOCKING_CALL, op.offset, "calls %s, which can block", callee.GetScopedName())
+					}
+
+				case OP_FUNCTION_CALL_LOCAL:
+					callee := op.GetFunctionDeclaration()
+					if reason, ok := ac.blocking[callee]; ok {
+						ac.add(e, DIAG_ATOMIC_BLOCKING_CALL, op.offset, "calls %s, which %s", callee.GetScopedName(), reason)
+					}
+
+				case OP_JUMP:
+					switch {
+					case isReturnGraph(node):
+						ac.add(e, DIAG_ATOMIC_EARLY_EXIT, op.offset, "can return before the end of the block")
+					case node.code != nil && *node.code == "break" && !breakInside:
+						ac.add(e, DIAG_ATOMIC_EARLY_EXIT, op.offset, "can break out of the block")
+					case node.code != nil && *node.code == "continue" && !continueInside:
+						ac.add(e, DIAG_ATOMIC_EARLY_EXIT, op.offset, "can continue out of the block")
+					}
+				}
+			})
+		}
+
+		bodyBreakInside := breakInside
+		bodyContinueInside := continueInside
+		offset, _, _ := GetElementOffsetRange(e)
+		switch b := e.(type) {
+		case *ScheduleBlock:
+			ac.add(e, DIAG_ATOMIC_UNBOUNDED_LOOP, b.offset, "has a schedule, which runs until the task stops")
+		case *ScheduleEveryBlock:
+			bodyBreakInside = true
+		case *SwitchBlock:
+			bodyBreakInside = true
+		default:
+			if kind, bounded := getLoopBound(e); len(kind) > 0 {
+				if !bounded {
+					ac.add(e, DIAG_ATOMIC_UNBOUNDED_LOOP, offset, "has a %s loop with no obvious bound", kind)
+				}
+				bodyBreakInside = true
+				bodyContinueInside = true
+			}
+		}
+
+		for _, body := range GetElementBodies(e) {
+			ac.check(*body, bodyBreakInside, bodyContinueInside)
+		}
+	}
+}
+
+// Finds the problems in the atomic blocks of the function, atomic blocks inside them are part of the outer block
+func (fd *FunctionDefinition) CheckAtomicBlocks(blocking map[*FunctionDeclaration]string) []*AtomicProblem {
+	ac := &atomicChecker{
+		scope:    fd.scope,
+		blocking: blocking,
+	}
+	fd.scope.atomicProblems = map[BlockElement][]*AtomicProblem{}
+
+	WalkBlockElements(fd.body, func(e BlockElement) bool {
+		if ab, ok := e.(*AtomicBlock); ok {
+			ac.check(ab.body, false, false)
+			return false
+		}
+		return true
+	})
+	return ac.problems
+}
+
+func checkAllAtomicBlocks() {
+	blocking := getBlockingFunctions()
+	for _, fnc := range DECOMPILED_FUNCS {
+		DIAGNOSTIC_FUNCTION = fnc.declaration
+		for _, problem := range fnc.CheckAtomicBlocks(blocking) {
+			DiagnoseAt(problem.code, problem.offset, "Atomic block %s", problem.description)
+		}
+	}
+	DIAGNOSTIC_FUNCTION = nil
+}
+
+func renderAtomicComments(e BlockElement, scope *Scope, writer CodeWriter) {
+	for _, problem := range scope.atomicProblems[e] {
+		writer.Appendf("// ATOMIC: %s\n", problem.description)
+	}
+}
@@ -0,0 +1,123 @@
+package decompiler
+
+import (
+	"strings"
+	"testing"
+)
+
+// Hold(hunit unit, int count) has an atomic block inside a loop that does everything an atomic block shouldn't
+func buildAtomicPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Hold").Locals(1)
+
+	// while (count > 0) { atomic { ... } count = count - 1; }
+	b.Label("hold.while")
+	b.Int(0).Read(1).Op(OP_INT_GT).Jump(OP_JUMP_IF_FALSE, "hold.endwhile")
+	b.Op(OP_ATOMIC_START)
+
+	// start Util.Wander(unit); Util.Sleep(1.0); Util.Distance(unit, unit); Watch(unit);
+	b.Read(0).StartImported("util", "Wander", 1).Pop()
+	b.Float(1.0).CallImported("util", "Sleep", 1).Pop()
+	b.Read(0).Read(0).CallImported("util", "Distance", 2).Pop()
+	b.Read(0).Call("Watch", 1).Pop()
+
+	// while (count > 5) { Util.Print("waiting"); }
+	b.Label("hold.spin")
+	b.Int(5).Read(1).Op(OP_INT_GT).Jump(OP_JUMP_IF_FALSE, "hold.endspin")
+	b.String("waiting").CallImported("util", "Print", 1).Pop()
+	b.Jump(OP_JUMP, "hold.spin")
+	b.Label("hold.endspin")
+
+	// for (ii = 0; ii < 3; ii++) { if (ii == count) break; }
+	b.Int(0).Store(2)
+	b.Label("hold.for")
+	b.Int(3).Read(2).Op(OP_INT_LT).Jump(OP_JUMP_IF_FALSE, "hold.endfor")
+	b.Read(1).Read(2).Op(OP_EQUALS).Jump(OP_JUMP_IF_FALSE, "hold.nobreakfor")
+	b.Jump(OP_JUMP, "hold.endfor")
+	b.Label("hold.nobreakfor")
+	b.Int(1).Read(2).Op(OP_INT_ADD).Store(2)
+	b.Jump(OP_JUMP, "hold.for")
+	b.Label("hold.endfor")
+
+	// if (count == 2) return;
+	b.Int(2).Read(1).Op(OP_EQUALS).Jump(OP_JUMP_IF_FALSE, "hold.noreturn")
+	b.Op(OP_ATOMIC_STOP)
+	b.Jump(OP_JUMP, "hold.end")
+	b.Label("hold.noreturn")
+
+	// if (count == 3) break;
+	b.Int(3).Read(1).Op(OP_EQUALS).Jump(OP_JUMP_IF_FALSE, "hold.nobreak")
+	b.Op(OP_ATOMIC_STOP)
+	b.Jump(OP_JUMP, "hold.endwhile")
+	b.Label("hold.nobreak")
+
+	b.Op(OP_ATOMIC_STOP)
+	b.Int(1).Read(1).Op(OP_INT_SUB).Store(1)
+	b.Jump(OP_JUMP, "hold.while")
+	b.Label("hold.endwhile")
+
+	// Util.Print("done");
+	b.String("done").CallImported("util", "Print", 1).Pop()
+
+	b.End("hold.end", false)
+
+	// task Watch(hunit unit) { schedule { every 1.0: { Util.Print("watching"); } } }
+	b.Label("Watch")
+	b.Label("watch.schedule")
+	b.Op(OP_SCHEDULE_START)
+	b.Every("watch.loop", 1.0)
+	b.String("watching").CallImported("util", "Print", 1).Pop()
+	b.Label("watch.loop")
+	b.Jump(OP_JUMP, "watch.schedule")
+	b.End("watch.end", true)
+
+	return b
+}
+
+func TestAtomicBlockDiagnostics(t *testing.T) {
+	resetForTest(t.TempDir())
+	ATOMIC_BLOCKING = "Util.Distance"
+	decompileBuiltPackage(t, buildAtomicPackage())
+
+	found := []string{}
+	for _, d := range DIAGNOSTICS {
+		if strings.HasPrefix(d.Code, "A") {
+			found = append(found, d.String())
+		}
+	}
+
+	expected := []string{
+		"warning[A001] Golden.Hold 0x00000017: Atomic block starts Util.Wander",
+		"warning[A002] Golden.Hold 0x0000002A: Atomic block calls Util.Sleep, which can block",
+		"warning[A002] Golden.Hold 0x00000042: Atomic block calls Util.Distance, which can block",
+		"warning[A002] Golden.Hold 0x00000055: Atomic block calls local_function_0, which has a schedule",
+		"warning[A003] Golden.Hold 0x00000063: Atomic block has a while loop with no obvious bound",
+		"warning[A004] Golden.Hold 0x000000D1: Atomic block can return before the end of the block",
+		"warning[A004] Golden.Hold 0x000000E4: Atomic block can break out of the block",
+	}
+	if strings.Join(found, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Wrong atomic block diagnostics:\n%s\nexpected:\n%s", strings.Join(found, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestAtomicBlockComments(t *testing.T) {
+	resetForTest(t.TempDir())
+	ATOMIC_COMMENTS = true
+	output := decompileBuiltPackage(t, buildAtomicPackage())
+
+	for _, expected := range []string{
+		"// ATOMIC: starts Util.Wander\n\t\t\tstart Util.Wander( unit_ );",
+		"// ATOMIC: has a while loop with no obvious bound\n\t\t\twhile ( count_ > 5 )",
+		"// ATOMIC: can break out of the block\n\t\t\t\tbreak;",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Missing atomic comment %q in:\n%s", expected, output)
+		}
+	}
+
+	// The bounded for loop and its break stay inside the atomic block
+	if strings.Count(output, "// ATOMIC:") != 6 {
+		t.Errorf("Wrong number of atomic comments in:\n%s", output)
+	}
+}
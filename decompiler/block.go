@@ -2,7 +2,6 @@ package decompiler
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/juliangruber/go-intersect"
@@ -38,10 +37,11 @@ type BlockElement interface {
 }
 
 type OpGraph struct {
-	code      *string
-	operation *Operation
-	children  []*OpGraph
-	typeName  string
+	code            *string
+	operation       *Operation
+	children        []*OpGraph
+	typeName        string
+	omitParentheses bool
 }
 
 func (og *OpGraph) GetAllReferencedVariableIndices() map[uint32]bool {
@@ -122,14 +122,18 @@ func (og *OpGraph) SetPossibleType(scope *Scope, typeName string) {
 	case OP_LITERAL_ZERO, OP_LITERAL_ONE, OP_LITERAL_BYTE, OP_LITERAL_SHORT, OP_LITERAL_INT:
 		if IsEnumType(typeName) {
 			numberData := og.operation.data.(LiteralInteger)
-			value := numberData.GetValue()
-			if value >= 0 {
-				memberName := ENUM_MAP[typeName].valueToName[uint32(numberData.GetValue())]
-				if len(memberName) > 0 {
-					og.code = &memberName
-				}
+			memberName := ENUM_MAP[typeName].GetValueName(uint32(numberData.GetValue()))
+			if len(memberName) > 0 {
+				og.code = &memberName
 			}
 		}
+
+		// Literals combined with a flag enum value should use the member names too
+	case OP_BITWISE_AND, OP_BITWISE_OR:
+		if IsFlagEnumType(typeName) {
+			og.children[0].SetPossibleType(scope, typeName)
+			og.children[1].SetPossibleType(scope, typeName)
+		}
 	}
 }
 
@@ -208,9 +212,23 @@ func (og *OpGraph) ResolveTypes(scope *Scope) {
 	}
 
 	switch og.operation.opcode {
-	case OP_CAST_FLT_TO_INT, OP_BITWISE_AND, OP_BITWISE_OR, OP_INT_NEG, OP_LITERAL_BYTE, OP_LITERAL_SHORT, OP_LITERAL_INT:
+	case OP_CAST_FLT_TO_INT, OP_INT_NEG, OP_LITERAL_BYTE, OP_LITERAL_SHORT, OP_LITERAL_INT:
 		og.typeName = "int"
 
+	case OP_BITWISE_AND, OP_BITWISE_OR:
+		og.typeName = "int"
+		child1 := og.children[0]
+		child2 := og.children[1]
+
+		// Masking or combining flags keeps the enum type so the literals can be named
+		if IsFlagEnumType(child1.typeName) {
+			child2.SetPossibleType(scope, child1.typeName)
+			og.typeName = child1.typeName
+		} else if IsFlagEnumType(child2.typeName) {
+			child1.SetPossibleType(scope, child2.typeName)
+			og.typeName = child2.typeName
+		}
+
 	case OP_LITERAL_ONE, OP_LITERAL_ZERO:
 		og.typeName = "bool"
 
@@ -291,7 +309,7 @@ func (og *OpGraph) ResolveTypes(scope *Scope) {
 						}
 						if literal != nil {
 							// Replace the enum value with the name if it matches any known values
-							if name, ok := ENUM_MAP[param.typeName].valueToName[uint32(literal.GetValue())]; ok {
+							if name := ENUM_MAP[param.typeName].GetValueName(uint32(literal.GetValue())); len(name) > 0 {
 								child.code = &name
 							}
 						}
@@ -519,12 +537,9 @@ func (og *OpGraph) ResolveTypes(scope *Scope) {
 					// Convert literal integers to enums
 					if IsLiteralInteger(returnOp.operation) {
 						value := GetLiteralIntegerValue(returnOp.operation)
-						enumData := ENUM_MAP[returnType]
-						if value >= 0 {
-							name := enumData.valueToName[uint32(value)]
-							if len(name) > 0 {
-								returnOp.code = &name
-							}
+						name := ENUM_MAP[returnType].GetValueName(uint32(value))
+						if len(name) > 0 {
+							returnOp.code = &name
 						}
 					}
 				} else {
@@ -581,16 +596,29 @@ func (og *OpGraph) checkCodeInternal(scope *Scope, parent *OpGraph) {
 		child1IsHandle := IsHandleType(child1Type)
 		child2IsHandle := IsHandleType(child2Type)
 
-		if child1IsHandle && child2IsHandle && child1Type != child2Type {
-			cw := NewCodeWriter(os.Stdout)
-			cw.Appendf("ERROR: Mismatched handle types in equivalence check at offset 0x%08X. This will cause both handles to be cast to bools and then compared:\n", og.operation.offset)
-			cw.PushIndent()
-			og.Render(scope, cw, false)
-			cw.Append("\n")
-			cw.Appendf("L Type: %s\n", child1Type)
-			cw.Appendf("R Type: %s\n", child2Type)
-			cw.PopIndent()
-			cw.Append("\n")
+		if child1IsHandle && child2IsHandle && child1Type != child2Type && !(INSERT_HANDLE_CASTS && og.insertComparisonHandleCast(child1Type, child2Type)) {
+			var sb strings.Builder
+			og.Render(scope, NewCodeWriter(&sb), false)
+			DiagnoseAt(DIAG_HANDLE_COMPARISON, og.operation.offset, "Mismatched handle types %s and %s in equivalence check '%s', both handles will be cast to bools and then compared", child1Type, child2Type, sb.String())
+		}
+	}
+
+	if INSERT_HANDLE_CASTS {
+		switch og.operation.opcode {
+		case OP_FUNCTION_CALL_IMPORTED, OP_FUNCTION_CALL_LOCAL, OP_TASK_CALL_IMPORTED, OP_TASK_CALL_LOCAL:
+			og.insertParameterHandleCasts()
+
+		case OP_VARIABLE_WRITE:
+			v := scope.variables[og.operation.data.(VariableWriteData).index]
+			assignedType := og.children[0].typeName
+			if og.insertHandleCast(0, v.typeName) {
+				v.castAssignedTypes[assignedType] = true
+			}
+
+		case OP_JUMP:
+			if isReturnGraph(og) && len(og.children) == 1 {
+				og.insertHandleCast(0, scope.function.returnInfo.typeName)
+			}
 		}
 	}
 
@@ -630,14 +658,30 @@ func (node *OpGraph) ShouldUseParentheses(onlyChild bool) bool {
 		return true
 	}
 
+	// Combined flag enum members need to stay grouped when they are an operand
+	if IsLiteralInteger(node.operation) && node.code != nil && strings.Contains(*node.code, "|") {
+		return !onlyChild
+	}
+
+	if node.omitParentheses {
+		return false
+	}
+
 	popCount := 0
 	if node.operation.data != nil {
 		popCount = node.operation.data.PopCount()
 	}
 
 	// For unary operators that are being applied to some math or logical operator
-	if node.code != nil && len(*node.code) > 0 && popCount == 1 && len(node.children[0].children) > 1 && !node.children[0].ShouldRenderBeforeChidlren() {
-		return true
+	if node.code != nil && len(*node.code) > 0 && popCount == 1 {
+		// Look through anything that doesn't render, like casts, to find what the operator is applied to
+		operand := node.children[0]
+		for operand.code != nil && len(*operand.code) == 0 && len(operand.children) == 1 {
+			operand = operand.children[0]
+		}
+		if len(operand.children) > 1 && !operand.ShouldRenderBeforeChidlren() {
+			return true
+		}
 	}
 
 	return !onlyChild && popCount > 1
@@ -649,6 +693,10 @@ func (node *OpGraph) renderSelf(scope *Scope, writer CodeWriter) {
 		node.code = RenderOperationCode(node.operation, scope)
 	}
 
+	if node.beginAnnotation(scope, writer) {
+		defer writer.(AnnotatedWriter).EndAnnotation()
+	}
+
 	if node.code != nil {
 		writer.Append(*node.code)
 	} else {
@@ -680,15 +728,17 @@ func (node *OpGraph) Render(scope *Scope, writer CodeWriter, onlyChild bool) {
 			writer.Append(")")
 		}
 	} else {
+		if node.shouldWrapArguments(scope, writer) {
+			node.renderWrapped(scope, writer)
+			return
+		}
+
 		// Render ourselves
 		node.renderSelf(scope, writer)
 
 		// Render our open parenthesis
 		if node.ShouldUseParentheses(onlyChild) {
-			writer.Append("(")
-			if len(node.children) > 0 {
-				writer.Append(" ")
-			}
+			printOpenParen(writer, len(node.children) == 0)
 		}
 
 		// Render our children
@@ -701,12 +751,26 @@ func (node *OpGraph) Render(scope *Scope, writer CodeWriter, onlyChild bool) {
 
 		// Render our closed parenthesis
 		if node.ShouldUseParentheses(onlyChild) {
-			if len(node.children) > 0 {
-				writer.Append(" ")
-			}
-			writer.Append(")")
+			printCloseParen(writer, len(node.children) == 0)
+		}
+	}
+}
+
+// Renders a call with each of its arguments on their own line
+func (node *OpGraph) renderWrapped(scope *Scope, writer CodeWriter) {
+	node.renderSelf(scope, writer)
+	writer.Append("(\n")
+	writer.PushIndent()
+	for ii := len(node.children) - 1; ii >= 0; ii-- {
+		node.children[ii].Render(scope, writer, true)
+		if ii > 0 {
+			writer.Append(",\n")
+		} else {
+			writer.Append("\n")
 		}
 	}
+	writer.PopIndent()
+	writer.Append(")")
 }
 
 type Statement struct {
@@ -714,9 +778,26 @@ type Statement struct {
 }
 
 func (s *Statement) Render(scope *Scope, writer CodeWriter) {
+	if aw, ok := writer.(AnnotatedWriter); ok {
+		aw.BeginAnnotation(ANNOTATION_STATEMENT, getOffsetAttributes(s.graph.GetOffsetRange()))
+		defer aw.EndAnnotation()
+	}
 	s.graph.Render(scope, writer, true)
 }
 
+// Renders a statement that is part of a block header, such as a conditional
+func (s *Statement) RenderHeader(scope *Scope, writer CodeWriter) {
+	if SOURCE_MAP == nil {
+		s.Render(scope, writer)
+		return
+	}
+
+	line, column := writer.Position()
+	s.Render(scope, writer)
+	min, max := s.graph.GetOffsetRange()
+	SOURCE_MAP.AddEntry(SOURCE_MAP_HEADER, line, column, writer, min, max)
+}
+
 func (s *Statement) RenderAssemblyOffsets(writer CodeWriter) {
 	min, max := s.graph.GetOffsetRange()
 	if min != max {
@@ -748,9 +829,9 @@ func (s *Statement) CheckCode(scope *Scope) {
 
 func shouldHaveNewlineBetween(element1 BlockElement, element2 BlockElement) bool {
 	if element1.SpaceBelow() || element2.SpaceAbove() {
-		_, isIf := element1.(*IfBlock)
 		_, isElse := element2.(*ElseBlock)
-		return !(isIf && isElse)
+		_, isElseIf := element2.(*ElseIfBlock)
+		return !(isIfOrElseIf(element1) && (isElse || isElseIf))
 	}
 	// if !element1.RendersAsBlock() && !element2.RendersAsBlock() {
 	// 	return false
@@ -760,10 +841,37 @@ func shouldHaveNewlineBetween(element1 BlockElement, element2 BlockElement) bool
 	return false
 }
 
+// Whether the second element continues on from the closing brace of the first, like an else
+func continuesBlock(element1 BlockElement, element2 BlockElement) bool {
+	_, isElse := element2.(*ElseBlock)
+	_, isElseIf := element2.(*ElseIfBlock)
+	return isIfOrElseIf(element1) && (isElse || isElseIf)
+}
+
 func RenderBlockElements(elements []BlockElement, scope *Scope, writer CodeWriter) {
+	elements = filterDebugBlocks(elements)
 	for idx := 0; idx < len(elements); idx++ {
 		e := elements[idx]
-		e.Render(scope, writer)
+		if DEAD_CODE_COMMENTS {
+			renderDeadCodeComment(e, scope, writer)
+		}
+		if ATOMIC_COMMENTS {
+			renderAtomicComments(e, scope, writer)
+		}
+		line, column := writer.Position()
+		writer.SetContinuesBlock(idx < len(elements)-1 && continuesBlock(e, elements[idx+1]))
+		if !renderReturnInstrumentation(e, scope, writer) {
+			e.Render(scope, writer)
+		}
+		if SOURCE_MAP != nil {
+			if min, max, ok := GetElementOffsetRange(e); ok {
+				if e.IsBlock() {
+					SOURCE_MAP.AddEntry(SOURCE_MAP_BLOCK, line, column, writer, min, max)
+				} else {
+					SOURCE_MAP.AddEntry(SOURCE_MAP_STATEMENT, line, column, writer, min, max)
+				}
+			}
+		}
 		if !e.IsBlock() {
 			if OUTPUT_ASSEMBLY {
 				writer.Append("; // ")
@@ -775,7 +883,7 @@ func RenderBlockElements(elements []BlockElement, scope *Scope, writer CodeWrite
 			}
 		}
 		if idx < len(elements)-1 && shouldHaveNewlineBetween(e, elements[idx+1]) {
-			writer.Append("\n")
+			printBlankLines(writer)
 		}
 	}
 }
@@ -785,27 +893,26 @@ type IfBlock struct {
 	body        []BlockElement
 }
 
-func (ib *IfBlock) Render(scope *Scope, writer CodeWriter) {
+func renderConditionalBlock(keyword string, conditional *Statement, body []BlockElement, scope *Scope, writer CodeWriter) {
+
+	continued := writer.ContinuesBlock()
 
 	// Write out the top of the block
-	writer.Append("if ( ")
-	ib.conditional.Render(scope, writer)
-	if OUTPUT_ASSEMBLY {
-		writer.Append(" ) // ")
-		ib.conditional.RenderAssemblyOffsets(writer)
-		writer.Append("\n")
-	} else {
-		writer.Append(" )\n")
-	}
-	writer.Append("{\n")
-	writer.PushIndent()
+	writer.Appendf("%s ", keyword)
+	printOpenParen(writer, false)
+	conditional.RenderHeader(scope, writer)
+	printCloseParen(writer, false)
+	printOpenBlock(writer, getAssemblyOffsetsComment(conditional))
 
 	// Write out the body
-	RenderBlockElements(ib.body, scope, writer)
+	RenderBlockElements(body, scope, writer)
 
 	// Write out the bottom of the block
-	writer.PopIndent()
-	writer.Append("}\n")
+	printCloseBlock(writer, continued)
+}
+
+func (ib *IfBlock) Render(scope *Scope, writer CodeWriter) {
+	renderConditionalBlock("if", ib.conditional, ib.body, scope, writer)
 }
 
 func (ib *IfBlock) IsBlock() bool {
@@ -859,16 +966,12 @@ func (eb *ElseBlock) Render(scope *Scope, writer CodeWriter) {
 		writer.Append(" ")
 		RenderBlockElements(eb.body, scope, writer)
 	} else {
-
-		writer.Append("\n")
-		writer.Append("{\n")
-		writer.PushIndent()
+		printOpenBlock(writer, "")
 
 		// Write out the body
 		RenderBlockElements(eb.body, scope, writer)
 
-		writer.PopIndent()
-		writer.Append("}\n")
+		printCloseBlock(writer, false)
 	}
 }
 
@@ -897,6 +1000,9 @@ type DebugBlock struct {
 }
 
 func (db *DebugBlock) Render(scope *Scope, writer CodeWriter) {
+	if renderDebugBlockMode(db, scope, writer) {
+		return
+	}
 
 	inline := false
 
@@ -906,7 +1012,8 @@ func (db *DebugBlock) Render(scope *Scope, writer CodeWriter) {
 			inline = true
 		}
 
-		if !db.body[0].IsBlock() {
+		// Instrumented returns render as more than one statement
+		if !db.body[0].IsBlock() && !(isReturnStatement(db.body[0]) && isInstrumented(scope.function, INSTRUMENT_EXIT)) {
 			inline = true
 		}
 	}
@@ -916,16 +1023,14 @@ func (db *DebugBlock) Render(scope *Scope, writer CodeWriter) {
 		RenderBlockElements(db.body, scope, writer)
 	} else {
 		// Write out the top of the block
-		writer.Append("debug\n")
-		writer.Append("{\n")
-		writer.PushIndent()
+		writer.Append("debug")
+		printOpenBlock(writer, "")
 
 		// Write out the body
 		RenderBlockElements(db.body, scope, writer)
 
 		// Write out the bottom of the block
-		writer.PopIndent()
-		writer.Append("}\n")
+		printCloseBlock(writer, false)
 	}
 }
 
@@ -962,16 +1067,14 @@ type AtomicBlock struct {
 func (db *AtomicBlock) Render(scope *Scope, writer CodeWriter) {
 
 	// Write out the top of the block
-	writer.Append("atomic\n")
-	writer.Append("{\n")
-	writer.PushIndent()
+	writer.Append("atomic")
+	printOpenBlock(writer, "")
 
 	// Write out the body
 	RenderBlockElements(db.body, scope, writer)
 
 	// Write out the bottom of the block
-	writer.PopIndent()
-	writer.Append("}\n")
+	printCloseBlock(writer, false)
 }
 
 func (db *AtomicBlock) IsBlock() bool {
@@ -995,22 +1098,22 @@ func (db *AtomicBlock) CheckCode(scope *Scope) {
 }
 
 type ScheduleBlock struct {
-	body []BlockElement
+	// The offset of the schedule start operation
+	offset uint32
+	body   []BlockElement
 }
 
 func (db *ScheduleBlock) Render(scope *Scope, writer CodeWriter) {
 
 	// Write out the top of the block
-	writer.Append("schedule\n")
-	writer.Append("{\n")
-	writer.PushIndent()
+	writer.Append("schedule")
+	printOpenBlock(writer, "")
 
 	// Write out the body
 	RenderBlockElements(db.body, scope, writer)
 
 	// Write out the bottom of the block
-	writer.PopIndent()
-	writer.Append("}\n")
+	printCloseBlock(writer, false)
 }
 
 func (db *ScheduleBlock) IsBlock() bool {
@@ -1034,6 +1137,11 @@ func (db *ScheduleBlock) CheckCode(scope *Scope) {
 }
 
 type ScheduleEveryBlock struct {
+	// The offsets of the every operation and of the next clause, or the looping jump after the last one
+	offset     uint32
+	skipOffset uint32
+	// Stored with each clause by the compiler, what it means is unknown
+	middle   uint32
 	interval float32
 	body     []BlockElement
 }
@@ -1041,16 +1149,15 @@ type ScheduleEveryBlock struct {
 func (eb *ScheduleEveryBlock) Render(scope *Scope, writer CodeWriter) {
 
 	// Write out the top of the block
-	writer.Appendf("every %s:\n", RenderFloat(eb.interval))
-	writer.Append("{\n")
-	writer.PushIndent()
+	writer.Appendf("every %s:", RenderFloat(eb.interval))
+	printOpenBlock(writer, "")
+	renderBlockInstrumentation(INSTRUMENT_SCHEDULES, eb, scope, writer, fmt.Sprintf("every %s", RenderFloat(eb.interval)))
 
 	// Write out the body
 	RenderBlockElements(eb.body, scope, writer)
 
 	// Write out the bottom of the block
-	writer.PopIndent()
-	writer.Append("}\n")
+	printCloseBlock(writer, false)
 }
 
 func (db *ScheduleEveryBlock) IsBlock() bool {
@@ -1080,24 +1187,18 @@ type WhileLoop struct {
 
 func (wl *WhileLoop) Render(scope *Scope, writer CodeWriter) {
 	// Write out the top of the block
-	writer.Append("while ( ")
-	wl.conditional.Render(scope, writer)
-	if OUTPUT_ASSEMBLY {
-		writer.Append(" ) // ")
-		wl.conditional.RenderAssemblyOffsets(writer)
-		writer.Append("\n")
-	} else {
-		writer.Append(" )\n")
-	}
-	writer.Append("{\n")
-	writer.PushIndent()
+	writer.Append("while ")
+	printOpenParen(writer, false)
+	wl.conditional.RenderHeader(scope, writer)
+	printCloseParen(writer, false)
+	printOpenBlock(writer, getAssemblyOffsetsComment(wl.conditional))
+	renderBlockInstrumentation(INSTRUMENT_LOOPS, wl, scope, writer, "while loop")
 
 	// Write out the body
 	RenderBlockElements(wl.body, scope, writer)
 
 	// Write out the bottom of the block
-	writer.PopIndent()
-	writer.Append("}\n")
+	printCloseBlock(writer, false)
 }
 
 func (wl *WhileLoop) IsBlock() bool {
@@ -1130,25 +1231,20 @@ type DoWhileLoop struct {
 func (wl *DoWhileLoop) Render(scope *Scope, writer CodeWriter) {
 	// Write out the top of the block
 
-	writer.Append("do\n")
-	writer.Append("{\n")
-	writer.PushIndent()
+	writer.Append("do")
+	printOpenBlock(writer, "")
+	renderBlockInstrumentation(INSTRUMENT_LOOPS, wl, scope, writer, "do while loop")
 
 	// Write out the body
 	RenderBlockElements(wl.body, scope, writer)
 
 	// Write out the bottom of the block
-	writer.PopIndent()
-	writer.Append("}\n")
-	writer.Append("while ( ")
-	wl.conditional.Render(scope, writer)
-	if OUTPUT_ASSEMBLY {
-		writer.Append(" ); // ")
-		wl.conditional.RenderAssemblyOffsets(writer)
-		writer.Append("\n")
-	} else {
-		writer.Append(" );\n")
-	}
+	printCloseBlock(writer, true)
+	writer.Append("while ")
+	printOpenParen(writer, false)
+	wl.conditional.RenderHeader(scope, writer)
+	printCloseParen(writer, false)
+	writer.Appendf(";%s\n", getAssemblyOffsetsComment(wl.conditional))
 }
 
 func (wl *DoWhileLoop) IsBlock() bool {
@@ -1222,38 +1318,28 @@ func (fl *ForLoop) renderIncrement(scope *Scope, writer CodeWriter) {
 			}
 		}
 	} else {
-		fl.increment.Render(scope, writer)
+		fl.increment.RenderHeader(scope, writer)
 	}
 }
 
 func (fl *ForLoop) Render(scope *Scope, writer CodeWriter) {
 	// Write out the top of the block
-	writer.Append("for ( ")
-	fl.init.Render(scope, writer)
+	writer.Append("for ")
+	printOpenParen(writer, false)
+	fl.init.RenderHeader(scope, writer)
 	writer.Append("; ")
-	fl.conditional.Render(scope, writer)
+	fl.conditional.RenderHeader(scope, writer)
 	writer.Append("; ")
 	fl.renderIncrement(scope, writer)
-	if OUTPUT_ASSEMBLY {
-		writer.Append(" ) // ")
-		fl.init.RenderAssemblyOffsets(writer)
-		writer.Append("; ")
-		fl.conditional.RenderAssemblyOffsets(writer)
-		writer.Append("; ")
-		fl.increment.RenderAssemblyOffsets(writer)
-		writer.Append("\n")
-	} else {
-		writer.Append(" )\n")
-	}
-	writer.Append("{\n")
-	writer.PushIndent()
+	printCloseParen(writer, false)
+	printOpenBlock(writer, getAssemblyOffsetsComment(fl.init, fl.conditional, fl.increment))
+	renderBlockInstrumentation(INSTRUMENT_LOOPS, fl, scope, writer, "for loop")
 
 	// Write out the body
 	RenderBlockElements(fl.body, scope, writer)
 
 	// Write out the bottom of the block
-	writer.PopIndent()
-	writer.Append("}\n")
+	printCloseBlock(writer, false)
 }
 
 func (fl *ForLoop) IsBlock() bool {
@@ -1294,28 +1380,19 @@ type SwitchBlock struct {
 
 func (sb *SwitchBlock) Render(scope *Scope, writer CodeWriter) {
 	// Write out the top of the block
-	writer.Append("switch ( ")
+	writer.Append("switch ")
+	printOpenParen(writer, false)
 	if sb.conditional != nil {
-		sb.conditional.Render(scope, writer)
+		sb.conditional.RenderHeader(scope, writer)
 	}
-	if OUTPUT_ASSEMBLY {
-		writer.Append(" ) // ")
-		if sb.conditional != nil {
-			sb.conditional.RenderAssemblyOffsets(writer)
-		}
-		writer.Append("\n")
-	} else {
-		writer.Append(" )\n")
-	}
-	writer.Append("{\n")
-	writer.PushIndent()
+	printCloseParen(writer, false)
+	printOpenBlock(writer, getAssemblyOffsetsComment(sb.conditional))
 
 	// Write out the body
 	RenderBlockElements(sb.body, scope, writer)
 
 	// Write out the bottom of the block
-	writer.PopIndent()
-	writer.Append("}\n")
+	printCloseBlock(writer, false)
 }
 
 func (sb *SwitchBlock) IsBlock() bool {
@@ -1342,7 +1419,7 @@ func (sb *SwitchBlock) ResolveTypes(scope *Scope) {
 			for _, child := range sb.body {
 				childCase := child.(*CaseBlock)
 				if childCase.value != nil {
-					code := enumData.valueToName[uint32(*childCase.value)]
+					code := enumData.GetValueName(uint32(*childCase.value))
 					if len(code) > 0 {
 						childCase.valueCode = &code
 					}
@@ -1520,8 +1597,7 @@ func isDebugBlock(idx int, ops []Operation) int {
 		jumpData := op.data.(JumpData)
 		result := offsetToOpIndex(jumpData.offset, ops)
 		if result == -1 {
-			fmt.Printf("ERROR: Failed to deal with debug block at offset 0x%08X\n", op.offset)
-			os.Exit(1)
+			DiagnoseFatalAt(DIAG_DEBUG_BLOCK, op.offset, "Failed to find the end of the debug block")
 		}
 		return result
 	}
@@ -1577,8 +1653,7 @@ func isAtomicBlock(idx int, maxOpIdx int, ops []Operation) int {
 		}
 
 		if lastAtomicStop == -1 {
-			fmt.Printf("ERROR: Failed to deal with atomic block at offset 0x%08X\n", op.offset)
-			os.Exit(2)
+			DiagnoseFatalAt(DIAG_ATOMIC_BLOCK, op.offset, "Failed to find the end of the atomic block")
 		}
 	}
 	return lastAtomicStop
@@ -1598,8 +1673,7 @@ func parseSwitchBlock(scope *Scope, context *BlockContext, condStart int, condEn
 	conditionalStatement := ParseOperations(scope, context, conditionalOps, 0, len(conditionalOps)-1)
 
 	if len(conditionalStatement) == 0 || conditionalStatement[0].IsBlock() {
-		fmt.Printf("ERROR: Failed to parse conditional statement for switch at 0x%08X\n", ops[condStart].offset)
-		os.Exit(3)
+		DiagnoseFatalAt(DIAG_SWITCH, ops[condStart].offset, "Failed to parse the conditional statement for the switch")
 	}
 
 	switchBlock.conditional = conditionalStatement[0].(*Statement)
@@ -1607,8 +1681,7 @@ func parseSwitchBlock(scope *Scope, context *BlockContext, condStart int, condEn
 	for ii := range cases {
 		startIdx := offsetToOpIndex(cases[ii].startingOffset, ops)
 		if startIdx == -1 {
-			fmt.Printf("ERROR: Failed to parse conditional statement for switch at 0x%08X\n", ops[condStart].offset)
-			os.Exit(4)
+			DiagnoseFatalAt(DIAG_SWITCH, ops[condStart].offset, "Failed to parse the conditional statement for the switch")
 		}
 
 		endIdx := -1
@@ -1619,8 +1692,7 @@ func parseSwitchBlock(scope *Scope, context *BlockContext, condStart int, condEn
 		}
 
 		if endIdx == -1 {
-			fmt.Printf("ERROR: Failed to parse conditional statement for switch at 0x%08X\n", ops[condStart].offset)
-			os.Exit(5)
+			DiagnoseFatalAt(DIAG_SWITCH, ops[condStart].offset, "Failed to parse the conditional statement for the switch")
 		}
 
 		caseContext := &BlockContext{
@@ -1849,8 +1921,7 @@ func ParseOperations(scope *Scope, context *BlockContext, ops []Operation, minOp
 					//if jumpData.offset > endOp.offset && jumpData.offset != scope.functionEndOffset {
 					elseEndIdx := offsetToOpIndex(jumpData.offset, ops)
 					if elseEndIdx == -1 {
-						fmt.Printf("ERROR: Failed to parse else block at 0x%08X\n", ops[blockEnd].offset)
-						os.Exit(3)
+						DiagnoseFatalAt(DIAG_ELSE_BLOCK, ops[blockEnd].offset, "Failed to find the end of the else block")
 					}
 
 					// Remove the implicit jump at the end of the if block
@@ -1986,7 +2057,8 @@ func ParseOperations(scope *Scope, context *BlockContext, ops []Operation, minOp
 			ops[blockEnd].Remove()
 
 			schedule := &ScheduleBlock{
-				body: []BlockElement{},
+				offset: ops[idx].offset,
+				body:   []BlockElement{},
 			}
 
 			// Iterate over the "every" blocks
@@ -1998,7 +2070,10 @@ func ParseOperations(scope *Scope, context *BlockContext, ops []Operation, minOp
 				nextIdx := offsetToOpIndex(everyData.skipOffset, ops)
 
 				everyBlock := &ScheduleEveryBlock{
-					interval: everyData.interval,
+					offset:     target.offset,
+					skipOffset: everyData.skipOffset,
+					middle:     everyData.middle,
+					interval:   everyData.interval,
 				}
 
 				everyContext := &BlockContext{
@@ -2064,9 +2139,12 @@ func ParseOperations(scope *Scope, context *BlockContext, ops []Operation, minOp
 			} else if context.IsCurrentBlockIfBlock() && idx == maxOpIdx && jumpData.offset > op.offset {
 				// Flag this as being the jump past the else block
 				statement.graph.FlagAsElseJump()
+			} else if scope.IsUnreachable(op.offset) {
+				// Nothing can jump here so it doesn't matter where it goes
+				DiagnoseAt(DIAG_UNREACHABLE_JUMP, op.offset, "Skipping unreachable jump")
+				statement = nil
 			} else {
-				fmt.Printf("ERROR: Unhandled jump at offset 0x%08X\n", op.offset)
-				os.Exit(1)
+				DiagnoseFatalAt(DIAG_UNHANDLED_JUMP, op.offset, "Unhandled jump")
 			}
 		}
 
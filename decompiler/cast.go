@@ -0,0 +1,122 @@
+package decompiler
+
+// Cache of the cast function declarations for each handle type, nil if the handle type has no cast function
+var CAST_DECLARATIONS = map[string]*FunctionDeclaration{}
+
+func getCastDeclarationForHandleType(handleType string) *FunctionDeclaration {
+	if declaration, ok := CAST_DECLARATIONS[handleType]; ok {
+		return declaration
+	}
+
+	var declaration *FunctionDeclaration = nil
+
+	// The system handle types don't have a package we can get a cast function from
+	if hdata, ok := HANDLE_MAP[handleType]; ok && hdata.sourcePackage != SYSTEM_PACKAGE {
+		castName := GetCastFunctionForHandleType(handleType)
+		if castName != UNKNOWN_TYPE {
+			declaration = FUNC_DECLARATIONS[castName]
+		}
+	}
+
+	CAST_DECLARATIONS[handleType] = declaration
+	return declaration
+}
+
+func needsHandleCast(fromType string, toType string) bool {
+	if !IsHandleType(fromType) || !IsHandleType(toType) {
+		return false
+	}
+	return !HandleIsDerivedFrom(fromType, toType)
+}
+
+func isCastFunctionCall(og *OpGraph) bool {
+	fd := og.operation.GetFunctionDeclaration()
+	return fd != nil && fd.name == "Cast"
+}
+
+// Wraps the node in a call to the cast function of the handle type, returns nil if there is no cast function available
+func newHandleCastNode(node *OpGraph, handleType string) *OpGraph {
+	declaration := getCastDeclarationForHandleType(handleType)
+	if declaration == nil {
+		return nil
+	}
+
+	opcode := OP_FUNCTION_CALL_IMPORTED
+	if declaration.pkg == EXPORTING_PACKAGE {
+		opcode = OP_FUNCTION_CALL_LOCAL
+	} else {
+		AddPackageImport(declaration.pkg)
+	}
+
+	// Use the offset of the node being cast so the offset range of the statement doesn't change
+	cast := &OpGraph{
+		operation: &Operation{
+			offset: node.operation.offset,
+			opcode: opcode,
+			data: FunctionCallData{
+				declaration: declaration,
+			},
+		},
+		children: []*OpGraph{node},
+		typeName: handleType,
+	}
+	cast.code = RenderOperationCode(cast.operation, nil)
+
+	return cast
+}
+
+// Casts the child at the given index to the handle type if it is a handle that doesn't derive from it
+func (og *OpGraph) insertHandleCast(childIdx int, handleType string) bool {
+	child := og.children[childIdx]
+	if !needsHandleCast(child.typeName, handleType) {
+		return false
+	}
+
+	cast := newHandleCastNode(child, handleType)
+	if cast == nil {
+		return false
+	}
+
+	og.children[childIdx] = cast
+	return true
+}
+
+func (og *OpGraph) insertParameterHandleCasts() {
+	fd := og.operation.GetFunctionDeclaration()
+
+	// Don't cast the value being passed to a cast function
+	if fd.parameters == nil || len(*fd.parameters) != len(og.children) || isCastFunctionCall(og) {
+		return
+	}
+
+	for ii := range *fd.parameters {
+		param := &(*fd.parameters)[ii]
+		og.insertHandleCast(len(og.children)-1-ii, param.typeName)
+	}
+}
+
+// Makes both sides of a handle equivalence check the same type by casting one of them to the other's type
+func (og *OpGraph) insertComparisonHandleCast(leftType string, rightType string) bool {
+	// Cast the base side to the derived type, if they aren't related just cast the right side to the left type
+	castIdx := 1
+	castType := leftType
+	if HandleIsDerivedFrom(rightType, leftType) {
+		castIdx = 0
+		castType = rightType
+	}
+
+	// If the handle was cast to a bool for the comparison, the cast needs to go on the handle itself
+	target := og
+	if og.children[castIdx].operation.opcode == OP_CAST_TO_BOOL {
+		target = og.children[castIdx]
+		castIdx = 0
+	}
+
+	cast := newHandleCastNode(target.children[castIdx], castType)
+	if cast == nil {
+		return false
+	}
+
+	target.children[castIdx] = cast
+	return true
+}
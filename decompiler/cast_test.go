@@ -0,0 +1,99 @@
+package decompiler
+
+import (
+	"strings"
+	"testing"
+)
+
+// Retask(htask task, hunit unit) assigns an hobject to a handle type with a Cast function and to a system one without,
+// then passes one to an hunit parameter and compares one with an hunit
+func buildCastPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Retask")
+
+	// unit = Util.FindObject("scout");
+	b.String("scout").CallImported("util", "FindObject", 1).Store(1)
+
+	// task = Util.FindObject("job");
+	b.String("job").CallImported("util", "FindObject", 1).Store(0)
+
+	// Util.HaltTask(task); Util.Wander(unit);
+	b.Read(0).CallImported("util", "HaltTask", 1).Pop()
+	b.Read(1).CallImported("util", "Wander", 1).Pop()
+
+	// Util.Wander(Util.FindObject("patrol"));
+	b.String("patrol").CallImported("util", "FindObject", 1).CallImported("util", "Wander", 1).Pop()
+
+	// if (unit == Util.FindObject("target")) { Util.Log(1); }
+	b.String("target").CallImported("util", "FindObject", 1).Read(1)
+	b.Label("retask.equals")
+	b.Op(OP_EQUALS).Jump(OP_JUMP_IF_FALSE, "retask.endif")
+	b.Int(1).CallImported("util", "Log", 1).Pop()
+	b.Label("retask.endif")
+
+	b.End("retask.end", false)
+
+	return b
+}
+
+func TestHandleAssignmentCasts(t *testing.T) {
+	resetForTest(t.TempDir())
+	DIAGNOSTICS_FAIL_ON = "none"
+	output := decompileBuiltPackage(t, buildCastPackage())
+
+	if !strings.Contains(output, `unit_ = Util.Cast( Util.FindObject( "scout" ) );`) {
+		t.Errorf("Missing cast of the hunit assignment in:\n%s", output)
+	}
+	if !strings.Contains(output, `task_ = Util.FindObject( "job" );`) {
+		t.Errorf("The htask assignment shouldn't be cast in:\n%s", output)
+	}
+
+	assignments := []string{}
+	for _, d := range DIAGNOSTICS {
+		if d.Code == DIAG_HANDLE_ASSIGNMENT {
+			assignments = append(assignments, d.Message)
+		}
+	}
+	if len(assignments) != 1 || !strings.Contains(assignments[0], "using type htask, from which assigned type hobject is not derived") {
+		t.Errorf("Wrong handle assignment diagnostics: %v", assignments)
+	}
+}
+
+func TestHandleParameterAndComparisonCasts(t *testing.T) {
+	resetForTest(t.TempDir())
+	DIAGNOSTICS_FAIL_ON = "none"
+	b := buildCastPackage()
+	output := decompileBuiltPackage(t, b)
+
+	if !strings.Contains(output, `Util.Wander( Util.Cast( Util.FindObject( "patrol" ) ) );`) {
+		t.Errorf("Missing cast of the hunit parameter in:\n%s", output)
+	}
+	if !strings.Contains(output, `if ( unit_ == Util.Cast( Util.FindObject( "target" ) ) )`) {
+		t.Errorf("Missing cast of the hobject side of the comparison in:\n%s", output)
+	}
+	for _, d := range DIAGNOSTICS {
+		if d.Code == DIAG_HANDLE_COMPARISON {
+			t.Errorf("The cast comparison shouldn't be reported: %s", d)
+		}
+	}
+
+	// Without casts the values are left alone and the comparison is reported
+	resetForTest(t.TempDir())
+	DIAGNOSTICS_FAIL_ON = "none"
+	INSERT_HANDLE_CASTS = false
+	output = decompileBuiltPackage(t, b)
+
+	if !strings.Contains(output, `Util.Wander( Util.FindObject( "patrol" ) );`) || !strings.Contains(output, `if ( unit_ == Util.FindObject( "target" ) )`) {
+		t.Errorf("Casts were inserted with casts turned off in:\n%s", output)
+	}
+	comparisons := []*Diagnostic{}
+	for _, d := range DIAGNOSTICS {
+		if d.Code == DIAG_HANDLE_COMPARISON {
+			comparisons = append(comparisons, d)
+		}
+	}
+	if len(comparisons) != 1 || comparisons[0].Offset == nil || *comparisons[0].Offset != uint32(b.labels["retask.equals"]) {
+		t.Errorf("Wrong handle comparison diagnostics: %v", comparisons)
+	}
+}
@@ -0,0 +1,306 @@
+package decompiler
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Gets the indices of the operations that can be executed directly after the operation at the index
+func getOperationSuccessors(idx int, ops []Operation, offsetToIdx map[uint32]int) []int {
+	result := []int{}
+
+	addOffset := func(offset uint32) {
+		if target, ok := offsetToIdx[offset]; ok {
+			result = append(result, target)
+		}
+	}
+
+	op := &ops[idx]
+	switch op.opcode {
+	case OP_FUNCTION_END:
+		return result
+
+	case OP_JUMP:
+		addOffset(op.data.(JumpData).offset)
+		return result
+
+	case OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE:
+		addOffset(op.data.(ConditionalJumpData).offset)
+
+	case OP_JUMP_IF_NOT_DEBUG:
+		addOffset(op.data.(JumpData).offset)
+
+	case OP_SCHEDULE_EVERY:
+		addOffset(op.data.(ScheduleEveryData).skipOffset)
+	}
+
+	if idx+1 < len(ops) {
+		result = append(result, idx+1)
+	}
+
+	return result
+}
+
+// Finds the operations of a function that no path from the start of the function reaches, and the variable writes
+// whose values are never read before being overwritten or the function ends
+func analyzeDeadCode(scope *Scope, ops []Operation) {
+	scope.unreachable = map[uint32]bool{}
+	scope.deadStores = map[uint32]bool{}
+
+	offsetToIdx := map[uint32]int{}
+	for idx := range ops {
+		offsetToIdx[ops[idx].offset] = idx
+	}
+
+	successors := make([][]int, len(ops))
+	for idx := range ops {
+		successors[idx] = getOperationSuccessors(idx, ops, offsetToIdx)
+	}
+
+	// Find everything reachable from the start of the function
+	reachable := make([]bool, len(ops))
+	pending := []int{0}
+	for len(pending) > 0 {
+		idx := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if reachable[idx] {
+			continue
+		}
+		reachable[idx] = true
+		pending = append(pending, successors[idx]...)
+	}
+
+	for idx := range ops {
+		if !reachable[idx] {
+			scope.unreachable[ops[idx].offset] = true
+		}
+	}
+
+	// Work out which variables are live after each operation, iterating until nothing changes
+	variableCount := len(scope.variables)
+	liveOut := make([]map[uint32]bool, len(ops))
+	liveIn := make([]map[uint32]bool, len(ops))
+	for idx := range ops {
+		liveOut[idx] = map[uint32]bool{}
+		liveIn[idx] = map[uint32]bool{}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for idx := len(ops) - 1; idx >= 0; idx-- {
+			for _, succ := range successors[idx] {
+				for index := range liveIn[succ] {
+					if !liveOut[idx][index] {
+						liveOut[idx][index] = true
+						changed = true
+					}
+				}
+			}
+
+			op := &ops[idx]
+			writeIndex := uint32(0xFFFFFFFF)
+			switch op.opcode {
+			case OP_VARIABLE_WRITE, OP_STRING_VARIABLE_WRITE:
+				writeIndex = op.data.(VariableWriteData).index
+
+			case OP_VARIABLE_READ:
+				// The reads at the end of the function are just cleaning up string variables
+				index := op.data.(VariableReadData).index
+				if op.offset < scope.functionEndOffset && !liveIn[idx][index] {
+					liveIn[idx][index] = true
+					changed = true
+				}
+			}
+
+			for index := range liveOut[idx] {
+				if index != writeIndex && !liveIn[idx][index] {
+					liveIn[idx][index] = true
+					changed = true
+				}
+			}
+		}
+	}
+
+	for idx := range ops {
+		op := &ops[idx]
+		switch op.opcode {
+		case OP_VARIABLE_WRITE, OP_STRING_VARIABLE_WRITE:
+			index := op.data.(VariableWriteData).index
+
+			// Writes to parameters might be passed back to the caller, and initializations aren't real assignments
+			if !reachable[idx] || index < scope.localVariableIndexOffset || int(index) >= variableCount || (idx > 0 && ops[idx-1].opcode == OP_VARIABLE_INIT) {
+				continue
+			}
+			if !liveOut[idx][index] {
+				scope.deadStores[op.offset] = true
+			}
+		}
+	}
+}
+
+func (scope *Scope) IsUnreachable(offset uint32) bool {
+	return scope.unreachable[offset]
+}
+
+func isElementUnreachable(scope *Scope, e BlockElement) bool {
+	statementCount := 0
+	unreachable := true
+
+	ForEachStatement([]BlockElement{e}, func(s *Statement) {
+		statementCount++
+		s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+			if !scope.IsUnreachable(node.operation.offset) {
+				unreachable = false
+			}
+		})
+	})
+
+	return statementCount > 0 && unreachable
+}
+
+func getDeadStoreVariable(scope *Scope, s *Statement) *Variable {
+	if s.graph.operation.opcode != OP_POP_STACK || len(s.graph.children) == 0 {
+		return nil
+	}
+	write := s.graph.children[0]
+	if !write.operation.IsVariable() || !scope.deadStores[write.operation.offset] {
+		return nil
+	}
+	return write.operation.GetVariable(scope)
+}
+
+func (fd *FunctionDefinition) GetUnusedLocalVariables() []*Variable {
+	result := []*Variable{}
+	for _, v := range fd.scope.variables[fd.scope.localVariableIndexOffset:] {
+		if v.refCount == 0 {
+			setUnusedLocalType(v)
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// Flags the elements that should be commented as dead code, only the outermost unreachable element gets flagged
+func (fd *FunctionDefinition) DetectDeadCode() {
+	fd.scope.deadCode = map[BlockElement]string{}
+
+	WalkBlockElements(fd.body, func(e BlockElement) bool {
+		if isElementUnreachable(fd.scope, e) {
+			fd.scope.deadCode[e] = "unreachable"
+			return false
+		}
+
+		if s, ok := e.(*Statement); ok {
+			if v := getDeadStoreVariable(fd.scope, s); v != nil {
+				fd.scope.deadCode[e] = fmt.Sprintf("value assigned to %s is never read", v.variableName)
+			}
+		}
+		return true
+	})
+}
+
+func renderDeadCodeComment(e BlockElement, scope *Scope, writer CodeWriter) {
+	if comment, ok := scope.deadCode[e]; ok {
+		writer.Appendf("// DEAD CODE: %s\n", comment)
+	}
+}
+
+// Groups the unreachable operations of the function into contiguous offset ranges
+func (fd *FunctionDefinition) getUnreachableRanges() [][]*Operation {
+	result := [][]*Operation{}
+	var current []*Operation = nil
+
+	for idx := fd.startingIndex; idx < len(OPERATIONS); idx++ {
+		op := &OPERATIONS[idx]
+		if fd.scope.IsUnreachable(op.offset) {
+			current = append(current, op)
+		} else if current != nil {
+			result = append(result, current)
+			current = nil
+		}
+		if op.opcode == OP_FUNCTION_END {
+			break
+		}
+	}
+
+	if current != nil {
+		result = append(result, current)
+	}
+
+	return result
+}
+
+func writeDeadCodeReport() error {
+	fmt.Printf("Writing dead code report: %s\n", DEAD_CODE_REPORT_FILE)
+
+	outputFile, err := os.OpenFile(DEAD_CODE_REPORT_FILE, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	writer := NewCodeWriter(outputFile)
+	writer.Appendf("Package: %s (%s)\n", EXPORTING_PACKAGE, INPUT_FILE)
+
+	for _, fnc := range DECOMPILED_FUNCS {
+		if fnc.scope.unreachable == nil {
+			continue
+		}
+
+		ranges := fnc.getUnreachableRanges()
+
+		deadStores := []uint32{}
+		for offset := range fnc.scope.deadStores {
+			deadStores = append(deadStores, offset)
+		}
+		sort.Slice(deadStores, func(i, j int) bool {
+			return deadStores[i] < deadStores[j]
+		})
+
+		unused := fnc.GetUnusedLocalVariables()
+
+		if len(ranges) == 0 && len(deadStores) == 0 && len(unused) == 0 {
+			continue
+		}
+
+		writer.Appendf("\nFunction: %s\n", fnc.declaration.GetScopedName())
+		writer.PushIndent()
+
+		for _, r := range ranges {
+			first := r[0]
+			last := r[len(r)-1]
+
+			// Single jumps nothing reaches are usually just left behind by the compiler after a return or break
+			note := ""
+			if len(r) == 1 && first.opcode == OP_JUMP {
+				note = " (compiler generated jump)"
+			}
+
+			if first == last {
+				writer.Appendf("Unreachable: 0x%08X, 1 operation%s\n", first.offset, note)
+			} else {
+				writer.Appendf("Unreachable: 0x%08X - 0x%08X, %d operations%s\n", first.offset, last.offset, len(r), note)
+			}
+		}
+
+		for _, offset := range deadStores {
+			idx := offsetToOpIndex(offset, OPERATIONS[fnc.startingIndex:])
+			name := "unknown"
+			if idx != -1 {
+				if v := OPERATIONS[fnc.startingIndex+idx].GetVariable(fnc.scope); v != nil {
+					name = v.variableName
+				}
+			}
+			writer.Appendf("Dead store: 0x%08X, value assigned to %s is never read\n", offset, name)
+		}
+
+		for _, v := range unused {
+			writer.Appendf("Unused local variable: %s %s\n", v.typeName, v.variableName)
+		}
+
+		writer.PopIndent()
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package decompiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Report(int value) overwrites a store before reading it, never reads one of its locals and has code after its return
+func buildDeadCodePackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Report").Locals(2)
+
+	// Util.Log(value);
+	b.Read(0).CallImported("util", "Log", 1).Pop()
+
+	// total = 1; total = value;
+	b.Label("report.dead")
+	b.Int(1).Store(1)
+	b.Read(0).Store(1)
+
+	// Util.Log(total); return;
+	b.Read(1).CallImported("util", "Log", 1).Pop()
+	b.Jump(OP_JUMP, "report.end")
+
+	// Util.Log(2);
+	b.Label("report.unreachable")
+	b.Int(2).CallImported("util", "Log", 1).Pop()
+	b.Label("report.unreachableEnd")
+
+	b.End("report.end", false)
+
+	return b
+}
+
+func TestDeadCode(t *testing.T) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	DEAD_CODE_COMMENTS = true
+	DEAD_CODE_REPORT_FILE = filepath.Join(dir, "dead-code.txt")
+	b := buildDeadCodePackage()
+	output := decompileBuiltPackage(t, b)
+
+	expectedOutput := "\tUtil.Log( value_ );\n" +
+		"\t// DEAD CODE: value assigned to local_0 is never read\n" +
+		"\tlocal_0 = 1;\n" +
+		"\tlocal_0 = value_;\n" +
+		"\tUtil.Log( local_0 );\n" +
+		"\treturn;\n" +
+		"\t// DEAD CODE: unreachable\n" +
+		"\tUtil.Log( 2 );\n"
+	if !strings.Contains(output, expectedOutput) {
+		t.Errorf("expected the output to contain:\n%s\ngot:\n%s", expectedOutput, output)
+	}
+	if !strings.Contains(output, "\tint local_1; // unused\n") {
+		t.Errorf("expected local_1 to be commented as unused, got:\n%s", output)
+	}
+
+	data, err := os.ReadFile(DEAD_CODE_REPORT_FILE)
+	if err != nil {
+		t.Fatalf("failed to read dead code report: %v", err)
+	}
+
+	// The unreachable range ends with the pop after the call, and the dead store is the write after the literal
+	expectedReport := fmt.Sprintf("Function: Golden.Report\n"+
+		"\tUnreachable: 0x%08X - 0x%08X, 3 operations\n"+
+		"\tDead store: 0x%08X, value assigned to local_0 is never read\n"+
+		"\tUnused local variable: int local_1\n",
+		b.labels["report.unreachable"], b.labels["report.unreachableEnd"]-1, b.labels["report.dead"]+1)
+	if report := string(data); !strings.HasSuffix(report, expectedReport) {
+		t.Errorf("expected the report to end with:\n%s\ngot:\n%s", expectedReport, report)
+	}
+}
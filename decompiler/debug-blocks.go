@@ -0,0 +1,237 @@
+package decompiler
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Command line options for the debug blocks
+var DEBUG_BLOCKS string
+var DEBUG_REPORT_FILE string
+
+const (
+	DEBUG_BLOCKS_KEEP     = "keep"
+	DEBUG_BLOCKS_STRIP    = "strip"
+	DEBUG_BLOCKS_COLLAPSE = "collapse"
+)
+
+func ValidateDebugBlocksOption() error {
+	switch DEBUG_BLOCKS {
+	case "", DEBUG_BLOCKS_KEEP, DEBUG_BLOCKS_STRIP, DEBUG_BLOCKS_COLLAPSE:
+		return nil
+	}
+	return fmt.Errorf("unknown debug blocks mode '%s'", DEBUG_BLOCKS)
+}
+
+// A debug block of a function and what running it changes, release builds skip the block entirely
+type DebugBlockInfo struct {
+	block       *DebugBlock
+	startOffset uint32
+	endOffset   uint32
+	statements  int
+	// Reasons release builds behave differently, empty if the block only logs
+	differences []string
+}
+
+func (info *DebugBlockInfo) ChangesBehaviour() bool {
+	return len(info.differences) > 0
+}
+
+// Gets the variables read outside of the debug blocks, which are the ones a debug block can change the release
+// behaviour of by writing to them
+func getReleaseVariableReads(body []BlockElement) map[uint32]bool {
+	result := map[uint32]bool{}
+	WalkBlockElements(body, func(e BlockElement) bool {
+		if _, ok := e.(*DebugBlock); ok {
+			return false
+		}
+		for _, s := range GetElementStatements(e) {
+			s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+				if node.operation.opcode == OP_VARIABLE_READ {
+					result[node.operation.GetVariableStackIndex()] = true
+				}
+			})
+		}
+		return true
+	})
+	return result
+}
+
+// Works out why a debug block makes debug builds behave differently to release builds. Calls to the Debug package
+// only log, anything else the block calls might have side effects the release build doesn't get.
+func getDebugBlockDifferences(db *DebugBlock, scope *Scope, releaseReads map[uint32]bool) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	add := func(format string, args ...interface{}) {
+		difference := fmt.Sprintf(format, args...)
+		if !seen[difference] {
+			seen[difference] = true
+			result = append(result, difference)
+		}
+	}
+
+	ForEachStatement(db.body, func(s *Statement) {
+		s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+			op := node.operation
+			switch op.opcode {
+			case OP_VARIABLE_WRITE, OP_STRING_VARIABLE_WRITE:
+				if releaseReads[op.GetVariableStackIndex()] {
+					name := "unknown"
+					if v := op.GetVariable(scope); v != nil {
+						name = v.variableName
+					}
+					add("assigns %s, which is read outside of debug blocks", name)
+				}
+
+			case OP_FUNCTION_CALL_LOCAL, OP_FUNCTION_CALL_IMPORTED:
+				declaration := op.GetFunctionDeclaration()
+				if !strings.EqualFold(declaration.pkg, "Debug") {
+					add("calls %s", declaration.GetScopedName())
+				}
+
+			case OP_TASK_CALL_LOCAL, OP_TASK_CALL_IMPORTED:
+				add("starts %s", op.GetFunctionDeclaration().GetScopedName())
+
+			case OP_JUMP:
+				if node.code != nil {
+					if keyword := strings.Fields(*node.code); len(keyword) > 0 {
+						add("can %s", keyword[0])
+					}
+				}
+			}
+		})
+	})
+
+	return result
+}
+
+// Finds the debug blocks of the function, debug blocks inside them are part of the outer block
+func (fd *FunctionDefinition) GetDebugBlocks() []*DebugBlockInfo {
+	releaseReads := getReleaseVariableReads(fd.body)
+
+	result := []*DebugBlockInfo{}
+	WalkBlockElements(fd.body, func(e BlockElement) bool {
+		db, ok := e.(*DebugBlock)
+		if !ok {
+			return true
+		}
+
+		info := &DebugBlockInfo{block: db}
+		info.startOffset, info.endOffset, _ = GetElementOffsetRange(db)
+		ForEachStatement(db.body, func(s *Statement) {
+			info.statements++
+		})
+		info.differences = getDebugBlockDifferences(db, fd.scope, releaseReads)
+
+		result = append(result, info)
+		return false
+	})
+	return result
+}
+
+func (s *Scope) GetDebugBlockInfo(db *DebugBlock) *DebugBlockInfo {
+	for _, info := range s.debugBlocks {
+		if info.block == db {
+			return info
+		}
+	}
+	return nil
+}
+
+// Renders a debug block the way the debug blocks mode asks for, returns false to render it as usual
+func renderDebugBlockMode(db *DebugBlock, scope *Scope, writer CodeWriter) bool {
+	info := scope.GetDebugBlockInfo(db)
+	if DEBUG_BLOCKS != DEBUG_BLOCKS_COLLAPSE || info == nil {
+		return false
+	}
+
+	plural := "s"
+	if info.statements == 1 {
+		plural = ""
+	}
+	writer.Appendf("// debug block: %d statement%s, 0x%08X - 0x%08X", info.statements, plural, info.startOffset, info.endOffset)
+	if info.ChangesBehaviour() {
+		writer.Appendf(", release builds differ: %s", strings.Join(info.differences, ", "))
+	}
+	writer.Append("\n")
+	return true
+}
+
+// Leaves the debug blocks out of the elements when they are being stripped
+func filterDebugBlocks(elements []BlockElement) []BlockElement {
+	if DEBUG_BLOCKS != DEBUG_BLOCKS_STRIP {
+		return elements
+	}
+
+	result := []BlockElement{}
+	for _, e := range elements {
+		if _, ok := e.(*DebugBlock); !ok {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func writeDebugReport() error {
+	fmt.Printf("Writing debug report: %s\n", DEBUG_REPORT_FILE)
+
+	outputFile, err := os.OpenFile(DEBUG_REPORT_FILE, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	// The code of the blocks goes in the report as it is, whatever the debug blocks mode is
+	mode := DEBUG_BLOCKS
+	DEBUG_BLOCKS = DEBUG_BLOCKS_KEEP
+	defer func() { DEBUG_BLOCKS = mode }()
+
+	writer := NewCodeWriter(outputFile)
+	writer.Appendf("Package: %s (%s)\n", EXPORTING_PACKAGE, INPUT_FILE)
+
+	differing := []string{}
+	for _, fnc := range DECOMPILED_FUNCS {
+		blocks := fnc.scope.debugBlocks
+		if len(blocks) == 0 {
+			continue
+		}
+
+		writer.Appendf("\nFunction: %s\n", fnc.declaration.GetScopedName())
+		writer.PushIndent()
+
+		changes := false
+		for _, info := range blocks {
+			writer.Appendf("Debug block: 0x%08X - 0x%08X\n", info.startOffset, info.endOffset)
+			writer.PushIndent()
+			RenderBlockElements([]BlockElement{info.block}, fnc.scope, writer)
+			writer.PopIndent()
+
+			if info.ChangesBehaviour() {
+				changes = true
+				for _, difference := range info.differences {
+					writer.Appendf("Release builds differ: %s\n", difference)
+				}
+			} else {
+				writer.Append("Only logs\n")
+			}
+		}
+
+		if changes {
+			differing = append(differing, fnc.declaration.GetScopedName())
+		}
+
+		writer.PopIndent()
+	}
+
+	sort.Strings(differing)
+	writer.Appendf("\nFunctions that behave differently in release builds: %d\n", len(differing))
+	writer.PushIndent()
+	for _, name := range differing {
+		writer.Appendf("%s\n", name)
+	}
+	writer.PopIndent()
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package decompiler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// int Fuzz(int a, int b) with a debug block that only logs and one that changes the result
+func buildDebugBlocksPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Fuzz").Locals(1)
+
+	// total = a;
+	b.Read(0).Store(2)
+
+	// debug Debug.PrintString("fuzzing");
+	b.Jump(OP_JUMP_IF_NOT_DEBUG, "fuzz.log")
+	b.String("fuzzing").CallImported("debug", "PrintString", 1).Pop()
+	b.Label("fuzz.log")
+
+	// debug { total = total + b; Util.Print("fuzzed"); }
+	b.Jump(OP_JUMP_IF_NOT_DEBUG, "fuzz.change")
+	b.Read(1).Read(2).Op(OP_INT_ADD).Store(2)
+	b.String("fuzzed").CallImported("util", "Print", 1).Pop()
+	b.Label("fuzz.change")
+
+	// return total;
+	b.Read(2).Jump(OP_JUMP, "fuzz.end")
+	b.End("fuzz.end", false)
+
+	return b
+}
+
+func TestDebugBlocks(t *testing.T) {
+	output := decompileTestPackage(t, buildDebugBlocksPackage())
+	if strings.Count(output, "\tdebug") != 2 {
+		t.Fatalf("expected both debug blocks to be kept:\n%s", output)
+	}
+
+	resetForTest(t.TempDir())
+	DEBUG_BLOCKS = DEBUG_BLOCKS_STRIP
+	output = decompileBuiltPackage(t, buildDebugBlocksPackage())
+	if strings.Contains(output, "debug") || strings.Contains(output, "fuzz") {
+		t.Errorf("expected the debug blocks to be stripped:\n%s", output)
+	}
+	if !strings.Contains(output, "return local_0;") {
+		t.Errorf("expected the rest of the function to be kept:\n%s", output)
+	}
+
+	resetForTest(t.TempDir())
+	DEBUG_BLOCKS = DEBUG_BLOCKS_COLLAPSE
+	output = decompileBuiltPackage(t, buildDebugBlocksPackage())
+	for _, expected := range []string{
+		"\t// debug block: 1 statement, 0x00000015 - 0x00000027\n",
+		"\t// debug block: 2 statements, 0x0000002D - 0x00000050, release builds differ: assigns local_0, which is read outside of debug blocks, calls Util.Print\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected the output to contain %q:\n%s", expected, output)
+		}
+	}
+}
+
+func TestDebugReport(t *testing.T) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	DEBUG_REPORT_FILE = filepath.Join(dir, "debug.txt")
+	decompileBuiltPackage(t, buildDebugBlocksPackage())
+
+	report, err := os.ReadFile(DEBUG_REPORT_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Package: Golden (` + INPUT_FILE + `)
+
+Function: Golden.Fuzz
+	Debug block: 0x00000015 - 0x00000027
+		debug Debug.PrintString( "fuzzing" );
+	Only logs
+	Debug block: 0x0000002D - 0x00000050
+		debug
+		{
+			local_0 = local_0 + b_;
+			Util.Print( "fuzzed" );
+		}
+	Release builds differ: assigns local_0, which is read outside of debug blocks
+	Release builds differ: calls Util.Print
+
+Functions that behave differently in release builds: 1
+	Golden.Fuzz
+`
+	if string(report) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, report)
+	}
+}
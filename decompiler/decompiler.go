@@ -1,7 +1,7 @@
 package decompiler
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
 	"sort"
@@ -16,6 +16,17 @@ var OUTPUT_ASSEMBLY bool
 var ASSEMBLY_ONLY bool
 var ASSEMBLY_OFFSET_PREFIX bool
 var DEBUG_LOGGING bool
+var INSERT_HANDLE_CASTS bool
+var SIMPLIFY_RULES string
+var ELSE_IF_CHAINS bool
+var GUARD_CLAUSES bool
+var DEAD_CODE_COMMENTS bool
+var DEAD_CODE_REPORT_FILE string
+var SOURCE_MAP_FILE string
+var HTML_OUTPUT_DIR string
+var JSON_AST_FILE string
+var STYLE_FILE string
+var METRICS_FILE string
 
 var EXPORTING_PACKAGE string
 var IMPORTING_PACKAGE string
@@ -59,9 +70,24 @@ func sortPackageImports(imports []string) []string {
 	return results
 }
 
+func AddPackageImport(name string) {
+	if name == EXPORTING_PACKAGE || name == SYSTEM_PACKAGE {
+		return
+	}
+	for _, pkgName := range PACKAGE_IMPORTS {
+		if pkgName == name {
+			return
+		}
+	}
+	PACKAGE_IMPORTS = append(PACKAGE_IMPORTS, name)
+}
+
 func renderPackageImports(writer CodeWriter) {
+	// The logging functions are called from the Debug package, even by packages that don't import anything else
+	logging := DEBUG_LOGGING || isInstrumentationEnabled()
+
 	importCount := len(PACKAGE_IMPORTS)
-	if importCount == 0 {
+	if importCount == 0 && !logging {
 		return
 	}
 
@@ -75,8 +101,7 @@ func renderPackageImports(writer CodeWriter) {
 		import_map[pkgName] = true
 	}
 
-	// This is needed to call the logging function in script
-	if DEBUG_LOGGING {
+	if logging {
 		if _, ok := import_map["Debug"]; !ok {
 			imports = append(imports, "Debug")
 			import_map["Debug"] = true
@@ -107,12 +132,9 @@ func renderPackageImports(writer CodeWriter) {
 	writer.Append("uses ")
 	for ii := 0; ii < importCount; ii++ {
 		if ii > 0 {
-			writer.Append("     ")
+			printListSeparator(writer, "uses")
 		}
 		writer.Append(imports[ii])
-		if ii < importCount-1 {
-			writer.Append(",\n")
-		}
 	}
 	writer.Append(";\n\n")
 }
@@ -125,12 +147,9 @@ func renderFunctionExports(writer CodeWriter) {
 	writer.Append("provides ")
 	for ii := 0; ii < exportCount; ii++ {
 		if ii > 0 {
-			writer.Append("         ")
+			printListSeparator(writer, "provides")
 		}
 		writer.Append(FUNC_EXPORTS[ii].name)
-		if ii < exportCount-1 {
-			writer.Append(",\n")
-		}
 	}
 	writer.Append(";\n\n")
 }
@@ -139,9 +158,8 @@ func renderEnums(writer CodeWriter) {
 	pkg, ok := PACKAGES[strings.ToLower(EXPORTING_PACKAGE)]
 	if ok {
 		for enumName := range pkg.enums {
-			writer.Appendf("enum %s\n", enumName)
-			writer.Append("{\n")
-			writer.PushIndent()
+			writer.Appendf("enum %s", enumName)
+			printOpenBlock(writer, "")
 			keys := []uint32{}
 			for k := range ENUM_MAP[enumName].valueToName {
 				keys = append(keys, k)
@@ -166,56 +184,19 @@ func renderEnums(writer CodeWriter) {
 	}
 }
 
-func readString(file *os.File) (string, error) {
-	var result []byte
-	var err error = nil
-
-	buffer := make([]byte, 1)
-	for err == nil {
-		_, err = file.Read(buffer)
-		if err != nil || buffer[0] == 0 {
-			break
-		}
-		result = append(result, buffer[0])
-	}
-
-	if err != nil {
-		return string(result), err
-	}
-
-	return string(result), nil
-}
-
-func readUInt32BigEndian(file *os.File) (uint32, error) {
-	buffer := make([]byte, 4)
-	n, err := file.Read(buffer)
-	if err != nil {
-		return 0, err
-	}
-	if n != 4 {
-		return 0, fmt.Errorf("EOF")
-	}
-
-	return binary.BigEndian.Uint32(buffer), nil
-}
-
-func readSectionHeader(file *os.File) (*SectionHeader, error) {
+func readSectionHeader(reader *pkgReader) (*SectionHeader, error) {
 	result := new(SectionHeader)
 
 	// Read the section identifier
-	buffer := make([]byte, 4)
-	n, err := file.Read(buffer)
+	buffer, err := reader.ReadBytes(4)
 	if err != nil {
 		return nil, err
 	}
-	if n != 4 {
-		return nil, fmt.Errorf("header not long enough")
-	}
 
 	result.identifier = string(buffer)
 
 	// Read the section length
-	result.length, err = readUInt32BigEndian(file)
+	result.length, err = reader.ReadUInt32BigEndian()
 	if err != nil {
 		return nil, err
 	}
@@ -223,48 +204,45 @@ func readSectionHeader(file *os.File) (*SectionHeader, error) {
 	return result, nil
 }
 
-func readSections(file *os.File, maximumLength uint32) error {
-
-	var length uint32 = 0
-
-	for length < maximumLength {
-		section, err := readSectionHeader(file)
+func readSections(reader *pkgReader) error {
+	for reader.Remaining() > 0 {
+		start := reader.Position()
+		section, err := readSectionHeader(reader)
 		if err != nil {
-			fmt.Printf("Error: Failed to read section: %v", err)
 			return err
 		}
-		length += 8
 		//fmt.Printf("%s ", section.identifier)
-		// Get the original offset
-		start, err := file.Seek(0, 1)
+
+		if section.length > reader.Remaining() {
+			return &PkgError{Section: section.identifier, Offset: start, Err: ErrLengthOutOfRange, Detail: fmt.Sprintf("length %d is past the end of the form, %d bytes left", section.length, reader.Remaining())}
+		}
+
+		sectionReader, err := reader.Section(section.identifier, section.length)
 		if err != nil {
-			fmt.Printf("Error: Failed to get file offset: %v", err)
 			return err
 		}
 
-		err = readSection(file, section)
+		err = readSection(sectionReader, section)
 		if err != nil {
-			fmt.Printf("Error: Failed to read section: %v", err)
-			return err
+			return fmt.Errorf("failed to read section %s: %w", section.identifier, err)
 		}
 
 		//fmt.Printf("\n")
 
-		seek := section.length
-		// If the length is odd, add one so we will be 2 byte aligned
-		seek += seek % 2
-		file.Seek(start+int64(seek), 0)
-		length += seek
+		// If the length is odd there is a padding byte so we will be 2 byte aligned, the last section might not have it
+		if section.length%2 == 1 && reader.Remaining() > 0 {
+			reader.Skip(1)
+		}
 	}
 	return nil
 }
 
-func readSection(file *os.File, section *SectionHeader) error {
+func readSection(reader *pkgReader, section *SectionHeader) error {
 	var err error = nil
 
 	switch section.identifier {
 	case "PIMP":
-		name, err := readString(file)
+		name, err := reader.ReadString()
 		if err != nil {
 			return err
 		}
@@ -272,7 +250,7 @@ func readSection(file *os.File, section *SectionHeader) error {
 		if name != SYSTEM_PACKAGE {
 			_, ok := PACKAGES[name]
 			if !ok {
-				fmt.Printf("ERROR: Importing package '%s' not found in includes!\n", name)
+				Diagnose(DIAG_IMPORT_NOT_FOUND, "Importing package '%s' not found in includes", name)
 				//os.Exit(1)
 			} else {
 				// Get the package name with the correct upper and lower case letters
@@ -283,15 +261,15 @@ func readSection(file *os.File, section *SectionHeader) error {
 		}
 		IMPORTING_PACKAGE = name
 	case "FIMP":
-		name, err := readString(file)
+		name, err := reader.ReadString()
 		if err != nil {
 			return err
 		}
 		//fmt.Printf("%s", name)
-		readFunctionImportSection(file, name)
+		err = readFunctionImportSection(reader, name)
 
 	case "PKHD":
-		name, err := readString(file)
+		name, err := reader.ReadString()
 		if err != nil {
 			return err
 		}
@@ -306,11 +284,11 @@ func readSection(file *os.File, section *SectionHeader) error {
 		EXPORTING_PACKAGE = name
 
 	case "FEXP":
-		name, err := readString(file)
+		name, err := reader.ReadString()
 		if err != nil {
 			return err
 		}
-		funcOffset, err := readUInt32BigEndian(file)
+		funcOffset, err := reader.ReadUInt32BigEndian()
 		if err != nil {
 			return err
 		}
@@ -326,13 +304,13 @@ func readSection(file *os.File, section *SectionHeader) error {
 
 	case "STAB":
 		// Read in the string table
-		strCount, err := readUInt32BigEndian(file)
+		strCount, err := reader.ReadUInt32BigEndian()
 		if err != nil {
 			return err
 		}
 		var idx uint32
 		for idx = 0; idx < strCount; idx++ {
-			str, err := readString(file)
+			str, err := reader.ReadString()
 			if err != nil {
 				return err
 			}
@@ -340,21 +318,21 @@ func readSection(file *os.File, section *SectionHeader) error {
 		}
 
 	case "CODE":
-		err = readCodeSection(file)
+		err = readCodeSection(reader)
 	}
 
 	return err
 }
 
-func readFunctionImportSection(file *os.File, funcName string) error {
-	refCount, err := readUInt32BigEndian(file)
+func readFunctionImportSection(reader *pkgReader, funcName string) error {
+	refCount, err := reader.ReadUInt32BigEndian()
 	if err != nil {
 		return err
 	}
 
 	var idx uint32
 	for idx = 0; idx < refCount; idx++ {
-		offset, err := readUInt32BigEndian(file)
+		offset, err := reader.ReadUInt32BigEndian()
 		if err != nil {
 			return err
 		}
@@ -367,26 +345,38 @@ func readFunctionImportSection(file *os.File, funcName string) error {
 	return nil
 }
 
-func readCodeSection(file *os.File) error {
+// Where the code starts in the file, the offsets in the code are relative to this
+var CODE_FILE_OFFSET int64
 
-	buffer := make([]byte, 4)
-	n, err := file.Read(buffer)
+func readCodeSection(reader *pkgReader) error {
+	codeLength, err := reader.ReadUInt32BigEndian()
 	if err != nil {
 		return err
 	}
-	if n != 4 {
-		return fmt.Errorf("code not long enough")
+	if codeLength > reader.Remaining() {
+		return reader.Error(ErrLengthOutOfRange, "code length %d is past the end of the section, %d bytes left", codeLength, reader.Remaining())
 	}
-	codeLength := binary.BigEndian.Uint32(buffer)
 
-	initialOffset, _ := file.Seek(0, 1)
+	CODE_FILE_OFFSET = int64(reader.Position())
 
-	buffer = make([]byte, codeLength)
-	_, err = file.Read(buffer)
+	buffer, err := reader.ReadBytes(codeLength)
 	if err != nil {
 		return err
 	}
 
+	operations, err := decodeOperations(buffer, uint32(CODE_FILE_OFFSET))
+	if err != nil {
+		return err
+	}
+	OPERATIONS = append(OPERATIONS, operations...)
+	return nil
+}
+
+// Decodes the code into operations, the file offset is only used for the error messages
+func decodeOperations(buffer []byte, fileOffset uint32) ([]Operation, error) {
+	operations := []Operation{}
+	codeLength := uint32(len(buffer))
+
 	var offset uint32
 
 	for offset = 0; offset < codeLength; {
@@ -395,7 +385,7 @@ func readCodeSection(file *os.File) error {
 
 		opInfo, ok := OP_MAP[opcode]
 		if !ok {
-			return fmt.Errorf("unknown opcode 0x%02X at position 0x%08X", opcode, offset+uint32(initialOffset))
+			return nil, &PkgError{Section: "CODE", Offset: fileOffset + offset, Err: ErrUnknownOpcode, Detail: fmt.Sprintf("0x%02X", opcode)}
 		}
 
 		operation := new(Operation)
@@ -404,16 +394,74 @@ func readCodeSection(file *os.File) error {
 
 		offset++
 
+		if uint32(opInfo.dataSize) > codeLength-offset {
+			return nil, &PkgError{Section: "CODE", Offset: fileOffset + offset - 1, Err: ErrTruncated, Detail: fmt.Sprintf("%s needs %d bytes of data, %d left", opInfo.name, opInfo.dataSize, codeLength-offset)}
+		}
+
 		if opInfo.parser != nil {
 			data := buffer[offset : offset+uint32(opInfo.dataSize)]
-			operation.data = opInfo.parser(data, offset-1)
+			var err error
+			operation.data, err = opInfo.parser(data, offset-1)
+			if err != nil {
+				return nil, &PkgError{Section: "CODE", Offset: fileOffset + offset - 1, Err: err, Detail: opInfo.name}
+			}
 		}
 
 		offset += uint32(opInfo.dataSize)
 
-		OPERATIONS = append(OPERATIONS, *operation)
+		operations = append(operations, *operation)
+	}
+
+	// Jumps have to land inside the code, the end of the code counts as the end of the last function
+	for _, operation := range operations {
+		target := codeLength
+		switch data := operation.data.(type) {
+		case JumpData:
+			target = data.offset
+		case ConditionalJumpData:
+			target = data.offset
+		case ScheduleEveryData:
+			target = data.skipOffset
+		}
+		if target > codeLength {
+			return nil, &PkgError{Section: "CODE", Offset: fileOffset + operation.offset, Err: ErrOffsetOutOfRange, Detail: fmt.Sprintf("target 0x%08X is past the end of the code", target)}
+		}
+	}
+
+	return operations, nil
+}
+
+// Reads all of the sections of a pkg file, decoding the code into operations
+func readPackage(data []byte) error {
+	reader := newPkgReader(data)
+
+	form, err := readSectionHeader(reader)
+	if err != nil {
+		return err
+	}
+
+	if form.identifier != "FORM" {
+		return &PkgError{Offset: 0, Err: ErrUnexpectedSection, Detail: fmt.Sprintf("expected FORM, got %q", form.identifier)}
+	}
+	if form.length > reader.Remaining() {
+		return &PkgError{Section: form.identifier, Offset: 0, Err: ErrLengthOutOfRange, Detail: fmt.Sprintf("length %d is past the end of the file, %d bytes left", form.length, reader.Remaining())}
+	}
+
+	reader, err = reader.Section(form.identifier, form.length)
+	if err != nil {
+		return err
 	}
 
+	// Skip this part for now...
+	err = reader.Skip(4)
+	if err != nil {
+		return err
+	}
+
+	return readSections(reader)
+}
+
+func decompileAllFunctions() {
 	for idx := 0; idx < len(OPERATIONS); idx++ {
 		if idx == 0 || OPERATIONS[idx-1].opcode == OP_FUNCTION_END {
 			operation := OPERATIONS[idx]
@@ -425,16 +473,24 @@ func readCodeSection(file *os.File) error {
 			}
 
 			var def *FunctionDefinition
-			idx, def = DecompileFunction(declaration, idx, initialOffset)
+			DIAGNOSTIC_FUNCTION = declaration
+			idx, def = DecompileFunction(declaration, idx, CODE_FILE_OFFSET)
+			DIAGNOSTIC_FUNCTION = nil
 			DECOMPILED_FUNCS = append(DECOMPILED_FUNCS, def)
 		}
 	}
-
-	return nil
 }
 
+// Variables that are assigned to each other can keep swapping types, so give up after this many passes
+const MAX_TYPE_RESOLVE_PASSES = 100
+
 func resolveAllTypes() {
-	for {
+	for pass := 0; ; pass++ {
+		if pass == MAX_TYPE_RESOLVE_PASSES {
+			Diagnose(DIAG_TYPES_NOT_SETTLED, "types still changing after %d passes", MAX_TYPE_RESOLVE_PASSES)
+			break
+		}
+
 		// Resolve the types for each function
 		resolveCount := 0
 
@@ -461,8 +517,34 @@ func resolveAllTypes() {
 func checkAllCode() {
 	// Check the code of each function
 	for _, fnc := range DECOMPILED_FUNCS {
+		DIAGNOSTIC_FUNCTION = fnc.declaration
 		fnc.CheckCode()
 	}
+	DIAGNOSTIC_FUNCTION = nil
+}
+
+func simplifyAllCode() {
+	for _, fnc := range DECOMPILED_FUNCS {
+		fnc.Simplify()
+	}
+}
+
+func restructureAllCode() {
+	for _, fnc := range DECOMPILED_FUNCS {
+		fnc.Restructure()
+	}
+}
+
+func detectAllDeadCode() {
+	for _, fnc := range DECOMPILED_FUNCS {
+		fnc.DetectDeadCode()
+	}
+}
+
+func detectAllDebugBlocks() {
+	for _, fnc := range DECOMPILED_FUNCS {
+		fnc.scope.debugBlocks = fnc.GetDebugBlocks()
+	}
 }
 
 func resolveAllNames() {
@@ -489,11 +571,89 @@ func createWriter() (CodeWriter, error) {
 	return NewCodeWriter(outputFile), nil
 }
 
+// Reads the package and splits its code into functions, returns false if it couldn't be read
+func loadPackage(data []byte) bool {
+	err := readPackage(data)
+
+	if errors.Is(err, ErrUnexpectedSection) {
+		Diagnose(DIAG_UNEXPECTED_SECTION, "Unexpected first section: %v", err)
+		return false
+	}
+	if err != nil {
+		Diagnose(DIAG_READ_FAILED, "Failed to read file: %v", err)
+		return false
+	}
+
+	if len(NAMES_FILE) > 0 {
+		hints, err := LoadNameHints(NAMES_FILE)
+		if err != nil {
+			Diagnose(DIAG_READ_FAILED, "Failed to read names: %v", err)
+			return false
+		}
+		applyNameHints(hints)
+	}
+
+	decompileAllFunctions()
+	return true
+}
+
+// Runs all of the passes that get the functions of a loaded package ready to render
+func analyzeAllFunctions() {
+	// Resolve types until no more are resolved
+	resolveAllTypes()
+
+	// If we finished resolving everything, but we still have some unknown function parameters, set them to int
+	for _, fnc := range DECOMPILED_FUNCS {
+		DIAGNOSTIC_FUNCTION = fnc.declaration
+		if fnc.declaration.parameters != nil {
+			params := *fnc.declaration.parameters
+			for ii := range params {
+				param := &params[ii]
+				if param.typeName == UNKNOWN_TYPE {
+					param.typeName = "int"
+					param.variable.AddReferencedType("int")
+					if param.variable.refCount > 0 {
+						Diagnose(DIAG_PARAMETER_TYPE_DEFAULTED, "Failed to resolve the type for parameter %s id %d of function %s, defaulting to int", param.parameterName, param.variable.id, fnc.declaration.GetScopedName())
+					}
+				}
+			}
+			// Lock in our header types
+			//fnc.declaration.autoDetectTypes = false
+		}
+	}
+	DIAGNOSTIC_FUNCTION = nil
+
+	// Resolve types one more time now that we have our functions better defined
+	resolveAllTypes()
+
+	// Fix functions with unknown return types
+	ResolveAllUnknownFunctionReturnTypes()
+
+	resolveAllTypes()
+
+	checkAllCode()
+
+	simplifyAllCode()
+
+	restructureAllCode()
+
+	resolveAllNames()
+
+	detectAllDeadCode()
+
+	detectAllDebugBlocks()
+
+	checkAllAtomicBlocks()
+
+	// We need to detect the dependencies so we can reorder imports accordingly
+	DetectPackageDependencies()
+}
+
 func Decompile() {
-	f, err := os.Open(INPUT_FILE)
+	data, err := os.ReadFile(INPUT_FILE)
 
 	if err != nil {
-		fmt.Printf("Error: Failed to read file: %v\n", err)
+		Diagnose(DIAG_READ_FAILED, "Failed to read file: %v", err)
 		return
 	}
 
@@ -503,30 +663,43 @@ func Decompile() {
 
 	fmt.Printf("Decompiling package: %s\n", INPUT_FILE)
 
-	if len(INCLUDES_DIR) > 0 {
-		LoadDeclarationsFromHeaders(INCLUDES_DIR)
+	err = ValidateDiagnosticsOptions()
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "Invalid diagnostics options: %v", err)
+		return
 	}
 
-	form, err := readSectionHeader(f)
+	if len(STYLE_FILE) > 0 {
+		err = LoadPrintStyle(STYLE_FILE)
+		if err != nil {
+			Diagnose(DIAG_INVALID_OPTION, "Failed to load style: %v", err)
+			return
+		}
+	}
 
+	err = SetSimplificationRules(SIMPLIFY_RULES)
 	if err != nil {
-		fmt.Printf("Error: Failed to read file header: %v", err)
+		Diagnose(DIAG_INVALID_OPTION, "Invalid simplification rules: %v", err)
 		return
 	}
 
-	if form.identifier != "FORM" {
-		fmt.Printf("Error: Unexpected first section: %s", form.identifier)
+	err = SetInstrumentation(INSTRUMENT)
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "Invalid instrumentation: %v", err)
 		return
 	}
 
-	// Skip this part for now...
-	f.Seek(4, 1)
-	form.length -= 4
+	err = ValidateDebugBlocksOption()
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "Invalid debug blocks option: %v", err)
+		return
+	}
 
-	err = readSections(f, form.length)
+	if len(INCLUDES_DIR) > 0 {
+		LoadDeclarationsFromHeaders(INCLUDES_DIR)
+	}
 
-	if err != nil {
-		fmt.Printf("Error: Failed to read file: %v", err)
+	if !loadPackage(data) {
 		return
 	}
 
@@ -535,7 +708,7 @@ func Decompile() {
 
 		writer, err := createWriter()
 		if err != nil {
-			fmt.Printf("Error: Failed to write file: %v\n", err)
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write file: %v", err)
 			return
 		}
 
@@ -556,50 +729,86 @@ func Decompile() {
 		return
 	}
 
-	// Resolve types until no more are resolved
-	resolveAllTypes()
+	analyzeAllFunctions()
 
-	// If we finished resolving everything, but we still have some unknown function parameters, set them to int
-	for _, fnc := range DECOMPILED_FUNCS {
-		if fnc.declaration.parameters != nil {
-			params := *fnc.declaration.parameters
-			for ii := range params {
-				param := &params[ii]
-				if param.typeName == UNKNOWN_TYPE {
-					param.typeName = "int"
-					param.variable.AddReferencedType("int")
-					if param.variable.refCount > 0 {
-						fmt.Printf("WARN: Failed to resolve the type for parameter %s id %d of function %s, defaulting to int.\n", param.parameterName, param.variable.id, fnc.declaration.GetScopedName())
-					}
-				}
-			}
-			// Lock in our header types
-			//fnc.declaration.autoDetectTypes = false
+	if len(DEAD_CODE_REPORT_FILE) > 0 {
+		err = writeDeadCodeReport()
+		if err != nil {
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write dead code report: %v", err)
 		}
 	}
 
-	// Resolve types one more time now that we have our functions better defined
-	resolveAllTypes()
-
-	// Fix functions with unknown return types
-	ResolveAllUnknownFunctionReturnTypes()
-
-	resolveAllTypes()
-
-	checkAllCode()
+	if len(DEBUG_REPORT_FILE) > 0 {
+		err = writeDebugReport()
+		if err != nil {
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write debug report: %v", err)
+		}
+	}
 
-	resolveAllNames()
+	if len(TASK_REPORT_FILE) > 0 {
+		err = writeTaskReport()
+		if err != nil {
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write task report: %v", err)
+		}
+	}
 
-	// We need to detect the dependencies so we can reorder imports accordingly
-	DetectPackageDependencies()
+	if len(SCHEDULE_REPORT_FILE) > 0 {
+		err = writeScheduleReport()
+		if err != nil {
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write schedule report: %v", err)
+		}
+	}
 
 	writer, err := createWriter()
 	if err != nil {
-		fmt.Printf("Error: Failed to write file: %v\n", err)
+		Diagnose(DIAG_WRITE_FAILED, "Failed to write file: %v", err)
 		return
 	}
 
-	// Start writing the file
+	if len(SOURCE_MAP_FILE) > 0 {
+		SOURCE_MAP = NewSourceMap()
+	}
+
+	renderPackage(writer)
+
+	if SOURCE_MAP != nil {
+		err = writeSourceMap()
+		if err != nil {
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write source map: %v", err)
+		}
+		SOURCE_MAP = nil
+	}
+
+	if len(JSON_AST_FILE) > 0 {
+		err = writeJSONAST()
+		if err != nil {
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write json ast: %v", err)
+		}
+	}
+
+	if len(HTML_OUTPUT_DIR) > 0 {
+		err = writeHTML()
+		if err != nil {
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write html: %v", err)
+		}
+	}
+
+	if len(METRICS_FILE) > 0 {
+		err = writeMetrics()
+		if err != nil {
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write metrics: %v", err)
+		}
+	}
+
+	if len(FINGERPRINTS_FILE) > 0 {
+		err = writeFingerprints()
+		if err != nil {
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write fingerprints: %v", err)
+		}
+	}
+}
+
+func renderPackage(writer CodeWriter) {
 	writer.Appendf("package %s;\n\n", EXPORTING_PACKAGE)
 	renderPackageImports(writer)
 	renderFunctionExports(writer)
@@ -608,8 +817,10 @@ func Decompile() {
 
 	// Render the prototypes
 	for ii := range DECOMPILED_FUNCS {
+		DIAGNOSTIC_FUNCTION = DECOMPILED_FUNCS[ii].declaration
 		DECOMPILED_FUNCS[ii].RenderPrototype(writer)
 	}
+	DIAGNOSTIC_FUNCTION = nil
 
 	writer.Append("\n")
 
@@ -617,9 +828,45 @@ func Decompile() {
 	for ii := range DECOMPILED_FUNCS {
 		fnc := DECOMPILED_FUNCS[ii]
 		if fnc.declaration.parameters == nil {
-			fmt.Printf("ERROR: Unreferenced exported function with no declaration in headers '%s':, cannot determine parameter count and function will not be output.\n", fnc.declaration.GetScopedName())
+			Diagnose(DIAG_UNDECLARED_EXPORT, "Unreferenced exported function with no declaration in headers '%s', cannot determine parameter count and function will not be output", fnc.declaration.GetScopedName())
 			continue
 		}
+		DIAGNOSTIC_FUNCTION = fnc.declaration
 		DECOMPILED_FUNCS[ii].Render(writer)
 	}
+	DIAGNOSTIC_FUNCTION = nil
+}
+
+// Clears everything read from the headers and the package so another package can be decompiled in the same process.
+// The command line options are left alone.
+func ResetState() {
+	EXPORTING_PACKAGE = ""
+	IMPORTING_PACKAGE = ""
+
+	FUNC_EXPORTS = []*FunctionDeclaration{}
+	PACKAGE_IMPORTS = []string{}
+	DECOMPILED_FUNCS = []*FunctionDefinition{}
+	FUNC_DEFINITION_MAP = map[uint32]*FunctionDeclaration{}
+	FUNC_IMPORT_MAP = map[uint32]*FunctionDeclaration{}
+	FUNC_DECLARATIONS = map[string]*FunctionDeclaration{}
+	CAST_DECLARATIONS = map[string]*FunctionDeclaration{}
+	MISSING_DEBUG_FUNCTIONS = map[string]bool{}
+	INSTRUMENTATION = map[string]bool{}
+
+	STRING_TABLE = []string{}
+	OPERATIONS = []Operation{}
+	CODE_FILE_OFFSET = 0
+
+	PACKAGES = map[string]*PackageInfo{}
+	ENUM_MAP = map[string]EnumTypeInfo{}
+	HANDLE_MAP = newHandleMap()
+
+	LOCAL_FUNCTION_ID_COUNTER = 0
+	VARIABLE_ID_COUNTER = 0
+
+	DIAGNOSTICS = []*Diagnostic{}
+	DIAGNOSTIC_FUNCTION = nil
+
+	PRINT_STYLE = DefaultPrintStyle()
+	SOURCE_MAP = nil
 }
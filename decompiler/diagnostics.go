@@ -0,0 +1,317 @@
+package decompiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SEVERITY_INFO Severity = iota
+	SEVERITY_WARNING
+	SEVERITY_ERROR
+	SEVERITY_FATAL
+)
+
+var SEVERITY_NAMES = map[Severity]string{
+	SEVERITY_INFO:    "info",
+	SEVERITY_WARNING: "warning",
+	SEVERITY_ERROR:   "error",
+	SEVERITY_FATAL:   "fatal",
+}
+
+func (s Severity) String() string {
+	return SEVERITY_NAMES[s]
+}
+
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func ParseSeverity(name string) (Severity, error) {
+	for severity, severityName := range SEVERITY_NAMES {
+		if severityName == strings.ToLower(name) {
+			return severity, nil
+		}
+	}
+	return SEVERITY_INFO, fmt.Errorf("unknown severity '%s'", name)
+}
+
+// Diagnostic codes never change meaning once added, so tools can filter on them
+const (
+	// Reading the pkg file and writing the output
+	DIAG_READ_FAILED           = "P001"
+	DIAG_UNEXPECTED_SECTION    = "P002"
+	DIAG_IMPORT_NOT_FOUND      = "P003"
+	DIAG_WRITE_FAILED          = "P004"
+	DIAG_INVALID_OPTION        = "P005"
+	DIAG_UNDECLARED_EXPORT     = "P006"
+	DIAG_VARIABLE_OUT_OF_RANGE = "P007"
+	DIAG_INVALID_TRANSLATION   = "P008"
+	DIAG_STALE_TRANSLATION     = "P009"
+	DIAG_INVALID_PATCH         = "P010"
+	DIAG_SCHEDULE_MIDDLE_MOVED = "P011"
+
+	// Parsing the package headers
+	DIAG_HEADER_PARSE_FAILED      = "H001"
+	DIAG_INVALID_PROTOTYPE        = "H002"
+	DIAG_INVALID_HANDLE           = "H003"
+	DIAG_INVALID_DEPENDENCY       = "H004"
+	DIAG_INVALID_ENUM             = "H005"
+	DIAG_INVALID_ENUM_MEMBER      = "H006"
+	DIAG_PARAMETER_COUNT          = "H007"
+	DIAG_PROTOTYPE_NOT_FOUND      = "H008"
+	DIAG_CAST_FUNCTION_NOT_FOUND  = "H009"
+	DIAG_NAME_HINT_MISMATCH       = "H010"
+	DIAG_DEBUG_FUNCTION_NOT_FOUND = "H011"
+
+	// Rebuilding the control flow
+	DIAG_DEBUG_BLOCK          = "S001"
+	DIAG_ATOMIC_BLOCK         = "S002"
+	DIAG_SWITCH               = "S003"
+	DIAG_ELSE_BLOCK           = "S004"
+	DIAG_UNHANDLED_JUMP       = "S005"
+	DIAG_UNREACHABLE_JUMP     = "S006"
+	DIAG_MISSING_FUNCTION_END = "S007"
+
+	// Type inference
+	DIAG_PARAMETER_TYPE_DEFAULTED = "T001"
+	DIAG_UNKNOWN_LOCAL_TYPE       = "T002"
+	DIAG_UNKNOWN_RETURN_TYPE      = "T003"
+	DIAG_UNKNOWN_PARAMETER_TYPE   = "T004"
+	DIAG_HANDLE_ASSIGNMENT        = "T005"
+	DIAG_HANDLE_COMPARISON        = "T006"
+	DIAG_NOT_AN_INTEGER           = "T007"
+	DIAG_TYPES_NOT_SETTLED        = "T008"
+
+	// Validating the structure of the pkg file
+	DIAG_INVALID_FORM           = "V001"
+	DIAG_INVALID_SECTION        = "V002"
+	DIAG_UNKNOWN_IMPORT_PACKAGE = "V003"
+	DIAG_INVALID_IMPORT_OFFSET  = "V004"
+	DIAG_INVALID_EXPORT_OFFSET  = "V005"
+	DIAG_INVALID_JUMP_TARGET    = "V006"
+	DIAG_INVALID_STRING_INDEX   = "V007"
+	DIAG_INVALID_CODE           = "V008"
+	DIAG_INVALID_CALL_TARGET    = "V009"
+
+	// Checking what atomic blocks do
+	DIAG_ATOMIC_TASK_START     = "A001"
+	DIAG_ATOMIC_BLOCKING_CALL  = "A002"
+	DIAG_ATOMIC_UNBOUNDED_LOOP = "A003"
+	DIAG_ATOMIC_EARLY_EXIT     = "A004"
+)
+
+var DIAGNOSTIC_SEVERITIES = map[string]Severity{
+	DIAG_READ_FAILED:           SEVERITY_FATAL,
+	DIAG_UNEXPECTED_SECTION:    SEVERITY_FATAL,
+	DIAG_IMPORT_NOT_FOUND:      SEVERITY_ERROR,
+	DIAG_WRITE_FAILED:          SEVERITY_FATAL,
+	DIAG_INVALID_OPTION:        SEVERITY_FATAL,
+	DIAG_UNDECLARED_EXPORT:     SEVERITY_ERROR,
+	DIAG_VARIABLE_OUT_OF_RANGE: SEVERITY_ERROR,
+	DIAG_INVALID_TRANSLATION:   SEVERITY_ERROR,
+	DIAG_STALE_TRANSLATION:     SEVERITY_WARNING,
+	DIAG_INVALID_PATCH:         SEVERITY_ERROR,
+	DIAG_SCHEDULE_MIDDLE_MOVED: SEVERITY_WARNING,
+
+	DIAG_HEADER_PARSE_FAILED:      SEVERITY_ERROR,
+	DIAG_INVALID_PROTOTYPE:        SEVERITY_ERROR,
+	DIAG_INVALID_HANDLE:           SEVERITY_ERROR,
+	DIAG_INVALID_DEPENDENCY:       SEVERITY_ERROR,
+	DIAG_INVALID_ENUM:             SEVERITY_WARNING,
+	DIAG_INVALID_ENUM_MEMBER:      SEVERITY_WARNING,
+	DIAG_PARAMETER_COUNT:          SEVERITY_WARNING,
+	DIAG_PROTOTYPE_NOT_FOUND:      SEVERITY_WARNING,
+	DIAG_CAST_FUNCTION_NOT_FOUND:  SEVERITY_ERROR,
+	DIAG_NAME_HINT_MISMATCH:       SEVERITY_WARNING,
+	DIAG_DEBUG_FUNCTION_NOT_FOUND: SEVERITY_WARNING,
+
+	DIAG_DEBUG_BLOCK:          SEVERITY_FATAL,
+	DIAG_ATOMIC_BLOCK:         SEVERITY_FATAL,
+	DIAG_SWITCH:               SEVERITY_FATAL,
+	DIAG_ELSE_BLOCK:           SEVERITY_FATAL,
+	DIAG_UNHANDLED_JUMP:       SEVERITY_FATAL,
+	DIAG_UNREACHABLE_JUMP:     SEVERITY_WARNING,
+	DIAG_MISSING_FUNCTION_END: SEVERITY_FATAL,
+
+	DIAG_PARAMETER_TYPE_DEFAULTED: SEVERITY_WARNING,
+	DIAG_UNKNOWN_LOCAL_TYPE:       SEVERITY_ERROR,
+	DIAG_UNKNOWN_RETURN_TYPE:      SEVERITY_ERROR,
+	DIAG_UNKNOWN_PARAMETER_TYPE:   SEVERITY_ERROR,
+	DIAG_HANDLE_ASSIGNMENT:        SEVERITY_ERROR,
+	DIAG_HANDLE_COMPARISON:        SEVERITY_ERROR,
+	DIAG_NOT_AN_INTEGER:           SEVERITY_ERROR,
+	DIAG_TYPES_NOT_SETTLED:        SEVERITY_WARNING,
+
+	DIAG_INVALID_FORM:           SEVERITY_ERROR,
+	DIAG_INVALID_SECTION:        SEVERITY_ERROR,
+	DIAG_UNKNOWN_IMPORT_PACKAGE: SEVERITY_ERROR,
+	DIAG_INVALID_IMPORT_OFFSET:  SEVERITY_ERROR,
+	DIAG_INVALID_EXPORT_OFFSET:  SEVERITY_ERROR,
+	DIAG_INVALID_JUMP_TARGET:    SEVERITY_ERROR,
+	DIAG_INVALID_STRING_INDEX:   SEVERITY_ERROR,
+	DIAG_INVALID_CODE:           SEVERITY_ERROR,
+	DIAG_INVALID_CALL_TARGET:    SEVERITY_ERROR,
+
+	DIAG_ATOMIC_TASK_START:     SEVERITY_WARNING,
+	DIAG_ATOMIC_BLOCKING_CALL:  SEVERITY_WARNING,
+	DIAG_ATOMIC_UNBOUNDED_LOOP: SEVERITY_WARNING,
+	DIAG_ATOMIC_EARLY_EXIT:     SEVERITY_WARNING,
+}
+
+const (
+	DIAGNOSTICS_FORMAT_TEXT = "text"
+	DIAGNOSTICS_FORMAT_JSON = "json"
+)
+
+// Exit codes for the command line
+const (
+	EXIT_SUCCESS   = 0
+	EXIT_THRESHOLD = 1
+	EXIT_FATAL     = 2
+)
+
+type Diagnostic struct {
+	Code     string   `json:"code"`
+	Severity Severity `json:"severity"`
+	Package  string   `json:"package,omitempty"`
+	Function string   `json:"function,omitempty"`
+	Offset   *uint32  `json:"offset,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (d *Diagnostic) String() string {
+	location := d.Package
+	if len(d.Function) > 0 {
+		location = d.Function
+	}
+	if d.Offset != nil {
+		location = strings.TrimSpace(fmt.Sprintf("%s 0x%08X", location, *d.Offset))
+	}
+
+	if len(location) > 0 {
+		return fmt.Sprintf("%s[%s] %s: %s", d.Severity, d.Code, location, d.Message)
+	}
+	return fmt.Sprintf("%s[%s]: %s", d.Severity, d.Code, d.Message)
+}
+
+var DIAGNOSTICS []*Diagnostic = []*Diagnostic{}
+
+// The function being worked on, so diagnostics raised deep inside it know where they came from
+var DIAGNOSTIC_FUNCTION *FunctionDeclaration
+
+var DIAGNOSTICS_FILE string
+var DIAGNOSTICS_FORMAT string = DIAGNOSTICS_FORMAT_TEXT
+var DIAGNOSTICS_FAIL_ON string = SEVERITY_FATAL.String()
+
+func addDiagnostic(code string, pkg string, offset *uint32, format string, a ...interface{}) *Diagnostic {
+	d := &Diagnostic{
+		Code:     code,
+		Severity: DIAGNOSTIC_SEVERITIES[code],
+		Package:  pkg,
+		Offset:   offset,
+		Message:  fmt.Sprintf(format, a...),
+	}
+	if DIAGNOSTIC_FUNCTION != nil {
+		d.Function = DIAGNOSTIC_FUNCTION.GetScopedName()
+	}
+
+	DIAGNOSTICS = append(DIAGNOSTICS, d)
+	fmt.Println(d.String())
+	return d
+}
+
+func Diagnose(code string, format string, a ...interface{}) *Diagnostic {
+	return addDiagnostic(code, EXPORTING_PACKAGE, nil, format, a...)
+}
+
+func DiagnoseAt(code string, offset uint32, format string, a ...interface{}) *Diagnostic {
+	return addDiagnostic(code, EXPORTING_PACKAGE, &offset, format, a...)
+}
+
+// For diagnostics about the headers of another package
+func DiagnoseInPackage(code string, pkg string, format string, a ...interface{}) *Diagnostic {
+	return addDiagnostic(code, pkg, nil, format, a...)
+}
+
+// Reports a diagnostic that decompiling can't continue past, then exits
+func DiagnoseFatalAt(code string, offset uint32, format string, a ...interface{}) {
+	DiagnoseAt(code, offset, format, a...)
+	FinishDiagnostics()
+	os.Exit(GetExitCode())
+}
+
+func GetExitCode() int {
+	threshold, err := ParseSeverity(DIAGNOSTICS_FAIL_ON)
+	if err != nil {
+		threshold = SEVERITY_FATAL
+	}
+
+	result := EXIT_SUCCESS
+	for _, d := range DIAGNOSTICS {
+		if d.Severity == SEVERITY_FATAL {
+			return EXIT_FATAL
+		}
+		if d.Severity >= threshold {
+			result = EXIT_THRESHOLD
+		}
+	}
+	return result
+}
+
+func RenderDiagnosticsText(diagnostics []*Diagnostic) string {
+	var sb strings.Builder
+	counts := map[Severity]int{}
+	for _, d := range diagnostics {
+		sb.WriteString(d.String())
+		sb.WriteString("\n")
+		counts[d.Severity]++
+	}
+	sb.WriteString(fmt.Sprintf("%d fatal, %d errors, %d warnings, %d info\n", counts[SEVERITY_FATAL], counts[SEVERITY_ERROR], counts[SEVERITY_WARNING], counts[SEVERITY_INFO]))
+	return sb.String()
+}
+
+func RenderDiagnosticsJSON(diagnostics []*Diagnostic) (string, error) {
+	data, err := json.MarshalIndent(diagnostics, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Writes the collected diagnostics to the diagnostics file if one was requested
+func FinishDiagnostics() {
+	if len(DIAGNOSTICS_FILE) == 0 {
+		return
+	}
+
+	var output string
+	var err error
+	if DIAGNOSTICS_FORMAT == DIAGNOSTICS_FORMAT_JSON {
+		output, err = RenderDiagnosticsJSON(DIAGNOSTICS)
+	} else {
+		output = RenderDiagnosticsText(DIAGNOSTICS)
+	}
+
+	if err == nil {
+		err = os.WriteFile(DIAGNOSTICS_FILE, []byte(output), 0644)
+	}
+	if err != nil {
+		fmt.Printf("Error: Failed to write diagnostics: %v\n", err)
+	}
+}
+
+func ValidateDiagnosticsOptions() error {
+	if DIAGNOSTICS_FORMAT != DIAGNOSTICS_FORMAT_TEXT && DIAGNOSTICS_FORMAT != DIAGNOSTICS_FORMAT_JSON {
+		return fmt.Errorf("unknown diagnostics format '%s'", DIAGNOSTICS_FORMAT)
+	}
+	if strings.ToLower(DIAGNOSTICS_FAIL_ON) == "none" {
+		DIAGNOSTICS_FAIL_ON = SEVERITY_FATAL.String()
+	}
+	_, err := ParseSeverity(DIAGNOSTICS_FAIL_ON)
+	return err
+}
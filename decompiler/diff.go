@@ -0,0 +1,543 @@
+package decompiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	DIFF_FORMAT_TEXT = "text"
+	DIFF_FORMAT_JSON = "json"
+)
+
+var DIFF_FORMAT string = DIFF_FORMAT_TEXT
+
+// Unchanged lines shown around each change in a function
+const DIFF_CONTEXT_LINES = 2
+
+// Functions bigger than this after trimming the common start and end are shown as completely replaced
+const MAX_DIFF_CELLS = 4000000
+
+var LOCAL_FUNCTION_NAME_REGEX = regexp.MustCompile(`\blocal_function_\d+\b`)
+
+// Everything the diff needs from a decompiled package, taken before the state is reset for the next one
+type PackageSnapshot struct {
+	Name      string
+	Imports   []string
+	Exports   []string
+	Strings   []string
+	Functions []*FunctionSnapshot
+}
+
+type FunctionSnapshot struct {
+	Name     string
+	Exported bool
+	// Calls to local functions use their local_function_N name, so they can be renamed once the functions are matched
+	Assembly    []string
+	Source      []string
+	Fingerprint string
+	// The local functions called, in the order of the calls
+	LocalCalls []string
+}
+
+func isExportedFunction(declaration *FunctionDeclaration) bool {
+	for _, exp := range FUNC_EXPORTS {
+		if exp == declaration {
+			return true
+		}
+	}
+	return false
+}
+
+// Takes a snapshot of the package that was just decompiled
+func snapshotPackage() *PackageSnapshot {
+	snapshot := &PackageSnapshot{
+		Name:    EXPORTING_PACKAGE,
+		Imports: append([]string{}, PACKAGE_IMPORTS...),
+		Strings: append([]string{}, STRING_TABLE...),
+	}
+	for _, exp := range FUNC_EXPORTS {
+		snapshot.Exports = append(snapshot.Exports, exp.GetScopedName())
+	}
+
+	offsetToIndex := getOperationIndices()
+
+	for _, fnc := range DECOMPILED_FUNCS {
+		fs := &FunctionSnapshot{
+			Name:     fnc.declaration.GetScopedName(),
+			Exported: isExportedFunction(fnc.declaration),
+		}
+
+		fs.Assembly = getNormalizedAssembly(fnc.startingIndex, offsetToIndex)
+		for idx := fnc.startingIndex; idx < fnc.startingIndex+len(fs.Assembly); idx++ {
+			if data, ok := OPERATIONS[idx].data.(FunctionCallData); ok && len(data.declaration.pkg) == 0 {
+				fs.LocalCalls = append(fs.LocalCalls, data.declaration.name)
+			}
+		}
+		fs.Fingerprint = getFunctionHash(fs.Assembly)
+
+		// Functions without a declaration can't be rendered, so they only have their assembly
+		if fnc.declaration.parameters != nil {
+			var sb strings.Builder
+			DIAGNOSTIC_FUNCTION = fnc.declaration
+			fnc.Render(NewCodeWriter(&sb))
+			DIAGNOSTIC_FUNCTION = nil
+			fs.Source = strings.Split(strings.TrimRight(sb.String(), "\n"), "\n")
+		}
+
+		snapshot.Functions = append(snapshot.Functions, fs)
+	}
+	return snapshot
+}
+
+// Decompiles a package and takes a snapshot of it, returns nil if it couldn't be read
+func loadPackageSnapshot(filename string) *PackageSnapshot {
+	ResetState()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		Diagnose(DIAG_READ_FAILED, "Failed to read file: %v", err)
+		return nil
+	}
+
+	fmt.Printf("Decompiling package: %s\n", filename)
+
+	if len(INCLUDES_DIR) > 0 {
+		LoadDeclarationsFromHeaders(INCLUDES_DIR)
+	}
+	if !loadPackage(data) {
+		return nil
+	}
+	analyzeAllFunctions()
+
+	return snapshotPackage()
+}
+
+type FunctionDiff struct {
+	Name string `json:"name"`
+	// The name in the newer package, if a local function was matched to one with a different name
+	NewName  string   `json:"newName,omitempty"`
+	Assembly []string `json:"assembly,omitempty"`
+	Source   []string `json:"source,omitempty"`
+}
+
+type PackageDiff struct {
+	Before           string          `json:"before"`
+	After            string          `json:"after"`
+	Package          string          `json:"package"`
+	NewPackage       string          `json:"newPackage,omitempty"`
+	AddedImports     []string        `json:"addedImports,omitempty"`
+	RemovedImports   []string        `json:"removedImports,omitempty"`
+	AddedExports     []string        `json:"addedExports,omitempty"`
+	RemovedExports   []string        `json:"removedExports,omitempty"`
+	AddedStrings     []string        `json:"addedStrings,omitempty"`
+	RemovedStrings   []string        `json:"removedStrings,omitempty"`
+	AddedFunctions   []string        `json:"addedFunctions,omitempty"`
+	RemovedFunctions []string        `json:"removedFunctions,omitempty"`
+	ChangedFunctions []*FunctionDiff `json:"changedFunctions,omitempty"`
+}
+
+func (pd *PackageDiff) HasChanges() bool {
+	return len(pd.NewPackage) > 0 ||
+		len(pd.AddedImports) > 0 || len(pd.RemovedImports) > 0 ||
+		len(pd.AddedExports) > 0 || len(pd.RemovedExports) > 0 ||
+		len(pd.AddedStrings) > 0 || len(pd.RemovedStrings) > 0 ||
+		len(pd.AddedFunctions) > 0 || len(pd.RemovedFunctions) > 0 ||
+		len(pd.ChangedFunctions) > 0
+}
+
+// Returns what is only in before and what is only in after, sorted
+func diffSets(before []string, after []string) ([]string, []string) {
+	beforeSet := map[string]bool{}
+	afterSet := map[string]bool{}
+	for _, s := range before {
+		beforeSet[s] = true
+	}
+	for _, s := range after {
+		afterSet[s] = true
+	}
+
+	added := []string{}
+	removed := []string{}
+	for s := range afterSet {
+		if !beforeSet[s] {
+			added = append(added, s)
+		}
+	}
+	for s := range beforeSet {
+		if !afterSet[s] {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+type lineEdit struct {
+	kind byte
+	line string
+}
+
+// Works out the edits from before to after with a longest common subsequence
+func diffLineEdits(before []string, after []string) []lineEdit {
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix && before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+
+	edits := []lineEdit{}
+	for _, line := range before[:prefix] {
+		edits = append(edits, lineEdit{kind: ' ', line: line})
+	}
+
+	a := before[prefix : len(before)-suffix]
+	b := after[prefix : len(after)-suffix]
+
+	if len(a)*len(b) > MAX_DIFF_CELLS {
+		for _, line := range a {
+			edits = append(edits, lineEdit{kind: '-', line: line})
+		}
+		for _, line := range b {
+			edits = append(edits, lineEdit{kind: '+', line: line})
+		}
+	} else {
+		// lengths[ii][jj] is the length of the common subsequence of a[ii:] and b[jj:]
+		lengths := make([][]int, len(a)+1)
+		for ii := range lengths {
+			lengths[ii] = make([]int, len(b)+1)
+		}
+		for ii := len(a) - 1; ii >= 0; ii-- {
+			for jj := len(b) - 1; jj >= 0; jj-- {
+				if a[ii] == b[jj] {
+					lengths[ii][jj] = lengths[ii+1][jj+1] + 1
+				} else if lengths[ii+1][jj] >= lengths[ii][jj+1] {
+					lengths[ii][jj] = lengths[ii+1][jj]
+				} else {
+					lengths[ii][jj] = lengths[ii][jj+1]
+				}
+			}
+		}
+
+		ii, jj := 0, 0
+		for ii < len(a) || jj < len(b) {
+			switch {
+			case ii < len(a) && jj < len(b) && a[ii] == b[jj]:
+				edits = append(edits, lineEdit{kind: ' ', line: a[ii]})
+				ii++
+				jj++
+			case jj == len(b) || (ii < len(a) && lengths[ii+1][jj] >= lengths[ii][jj+1]):
+				edits = append(edits, lineEdit{kind: '-', line: a[ii]})
+				ii++
+			default:
+				edits = append(edits, lineEdit{kind: '+', line: b[jj]})
+				jj++
+			}
+		}
+	}
+
+	for _, line := range before[len(before)-suffix:] {
+		edits = append(edits, lineEdit{kind: ' ', line: line})
+	}
+	return edits
+}
+
+// Diffs two lists of lines, returns nil if they are the same. Unchanged lines far from a change are left out.
+func diffLines(before []string, after []string) []string {
+	edits := diffLineEdits(before, after)
+
+	keep := make([]bool, len(edits))
+	changed := false
+	for ii, edit := range edits {
+		if edit.kind == ' ' {
+			continue
+		}
+		changed = true
+		for jj := ii - DIFF_CONTEXT_LINES; jj <= ii+DIFF_CONTEXT_LINES; jj++ {
+			if jj >= 0 && jj < len(edits) {
+				keep[jj] = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	result := []string{}
+	skipped := false
+	for ii, edit := range edits {
+		if !keep[ii] {
+			skipped = true
+			continue
+		}
+		if skipped && len(result) > 0 {
+			result = append(result, "...")
+		}
+		skipped = false
+		result = append(result, fmt.Sprintf("%c %s", edit.kind, edit.line))
+	}
+	return result
+}
+
+func renameLocalFunctions(lines []string, names map[string]string) []string {
+	result := make([]string, len(lines))
+	for ii, line := range lines {
+		result[ii] = LOCAL_FUNCTION_NAME_REGEX.ReplaceAllStringFunc(line, func(name string) string {
+			if renamed, ok := names[name]; ok {
+				return renamed
+			}
+			return name
+		})
+	}
+	return result
+}
+
+// Pairs up the functions of both packages, exported ones by name and local ones by their fingerprint
+func matchFunctions(before *PackageSnapshot, after *PackageSnapshot) map[*FunctionSnapshot]*FunctionSnapshot {
+	matches := map[*FunctionSnapshot]*FunctionSnapshot{}
+
+	exported := map[string]*FunctionSnapshot{}
+	local := map[string][]*FunctionSnapshot{}
+	for _, fs := range after.Functions {
+		if fs.Exported {
+			exported[fs.Name] = fs
+		} else {
+			local[fs.Fingerprint] = append(local[fs.Fingerprint], fs)
+		}
+	}
+
+	for _, fs := range before.Functions {
+		if fs.Exported {
+			if match, ok := exported[fs.Name]; ok {
+				matches[fs] = match
+			}
+			continue
+		}
+		// Functions with the same fingerprint are paired up in the order they appear
+		candidates := local[fs.Fingerprint]
+		if len(candidates) > 0 {
+			matches[fs] = candidates[0]
+			local[fs.Fingerprint] = candidates[1:]
+		}
+	}
+
+	// Local functions that changed are matched through the calls made to them by functions that are already matched
+	beforeByName := map[string]*FunctionSnapshot{}
+	for _, fs := range before.Functions {
+		beforeByName[fs.Name] = fs
+	}
+	afterByName := map[string]*FunctionSnapshot{}
+	for _, fs := range after.Functions {
+		afterByName[fs.Name] = fs
+	}
+	for found := true; found; {
+		found = false
+		matched := map[*FunctionSnapshot]bool{}
+		for _, afterFs := range matches {
+			matched[afterFs] = true
+		}
+		for _, beforeCaller := range before.Functions {
+			afterCaller, ok := matches[beforeCaller]
+			if !ok || len(beforeCaller.LocalCalls) != len(afterCaller.LocalCalls) {
+				continue
+			}
+			for ii, name := range beforeCaller.LocalCalls {
+				beforeFs := beforeByName[name]
+				afterFs := afterByName[afterCaller.LocalCalls[ii]]
+				if beforeFs == nil || afterFs == nil || matches[beforeFs] != nil || matched[afterFs] {
+					continue
+				}
+				matches[beforeFs] = afterFs
+				matched[afterFs] = true
+				found = true
+			}
+		}
+	}
+	return matches
+}
+
+func DiffPackages(before *PackageSnapshot, after *PackageSnapshot) *PackageDiff {
+	result := &PackageDiff{Package: before.Name}
+	if before.Name != after.Name {
+		result.NewPackage = after.Name
+	}
+
+	result.AddedImports, result.RemovedImports = diffSets(before.Imports, after.Imports)
+	result.AddedExports, result.RemovedExports = diffSets(before.Exports, after.Exports)
+	result.AddedStrings, result.RemovedStrings = diffSets(before.Strings, after.Strings)
+
+	matches := matchFunctions(before, after)
+
+	// Give matched local functions the same name, so calls to them don't show up as changes
+	renames := map[string]string{}
+	matched := map[*FunctionSnapshot]bool{}
+	for beforeFs, afterFs := range matches {
+		matched[afterFs] = true
+		if !afterFs.Exported {
+			renames[afterFs.Name] = beforeFs.Name
+		}
+	}
+
+	for _, beforeFs := range before.Functions {
+		afterFs, ok := matches[beforeFs]
+		if !ok {
+			result.RemovedFunctions = append(result.RemovedFunctions, beforeFs.Name)
+			continue
+		}
+
+		fd := &FunctionDiff{
+			Name:     beforeFs.Name,
+			Assembly: diffLines(beforeFs.Assembly, renameLocalFunctions(afterFs.Assembly, renames)),
+			Source:   diffLines(beforeFs.Source, renameLocalFunctions(afterFs.Source, renames)),
+		}
+		if afterFs.Name != beforeFs.Name {
+			fd.NewName = afterFs.Name
+		}
+		if fd.Assembly != nil || fd.Source != nil {
+			result.ChangedFunctions = append(result.ChangedFunctions, fd)
+		}
+	}
+
+	for _, afterFs := range after.Functions {
+		if !matched[afterFs] {
+			result.AddedFunctions = append(result.AddedFunctions, afterFs.Name)
+		}
+	}
+
+	return result
+}
+
+func writeDiffList(writer CodeWriter, title string, added []string, removed []string, quote bool) {
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	format := "%c %s\n"
+	if quote {
+		format = "%c %q\n"
+	}
+
+	writer.Appendf("%s\n", title)
+	writer.PushIndent()
+	for _, s := range added {
+		writer.Appendf(format, '+', s)
+	}
+	for _, s := range removed {
+		writer.Appendf(format, '-', s)
+	}
+	writer.PopIndent()
+}
+
+func RenderPackageDiffText(pd *PackageDiff, writer CodeWriter) {
+	writer.Appendf("Package %s: %s -> %s\n", pd.Package, pd.Before, pd.After)
+	if !pd.HasChanges() {
+		writer.Append("No changes\n")
+		return
+	}
+	if len(pd.NewPackage) > 0 {
+		writer.Appendf("Renamed to %s\n", pd.NewPackage)
+	}
+
+	writeDiffList(writer, "Imports", pd.AddedImports, pd.RemovedImports, false)
+	writeDiffList(writer, "Exports", pd.AddedExports, pd.RemovedExports, false)
+	writeDiffList(writer, "Strings", pd.AddedStrings, pd.RemovedStrings, true)
+	writeDiffList(writer, "Functions", pd.AddedFunctions, pd.RemovedFunctions, false)
+
+	for _, fd := range pd.ChangedFunctions {
+		if len(fd.NewName) > 0 {
+			writer.Appendf("Changed %s (now %s)\n", fd.Name, fd.NewName)
+		} else {
+			writer.Appendf("Changed %s\n", fd.Name)
+		}
+		writer.PushIndent()
+		for _, section := range []struct {
+			title string
+			lines []string
+		}{{"Assembly", fd.Assembly}, {"Source", fd.Source}} {
+			if len(section.lines) == 0 {
+				continue
+			}
+			writer.Appendf("%s\n", section.title)
+			writer.PushIndent()
+			for _, line := range section.lines {
+				writer.Appendf("%s\n", line)
+			}
+			writer.PopIndent()
+		}
+		writer.PopIndent()
+	}
+}
+
+// Runs the diff command, returns the exit code
+func Diff(beforeFile string, afterFile string) int {
+	if DIFF_FORMAT != DIFF_FORMAT_TEXT && DIFF_FORMAT != DIFF_FORMAT_JSON {
+		Diagnose(DIAG_INVALID_OPTION, "Unknown diff format '%s'", DIFF_FORMAT)
+		return GetExitCode()
+	}
+	err := ValidateDiagnosticsOptions()
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "Invalid diagnostics options: %v", err)
+		return GetExitCode()
+	}
+	err = SetSimplificationRules(SIMPLIFY_RULES)
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "Invalid simplification rules: %v", err)
+		return GetExitCode()
+	}
+
+	// Offsets in the output would show every function after an insertion as changed
+	OUTPUT_ASSEMBLY = false
+
+	before := loadPackageSnapshot(beforeFile)
+	if before == nil {
+		return GetExitCode()
+	}
+	// Resetting for the second package clears the diagnostics of the first one
+	diagnostics := DIAGNOSTICS
+	after := loadPackageSnapshot(afterFile)
+	DIAGNOSTICS = append(diagnostics, DIAGNOSTICS...)
+	if after == nil {
+		return GetExitCode()
+	}
+
+	pd := DiffPackages(before, after)
+	pd.Before = beforeFile
+	pd.After = afterFile
+
+	output := os.Stdout
+	if len(OUTPUT_FILE) > 0 {
+		output, err = os.OpenFile(OUTPUT_FILE, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write diff: %v", err)
+			return GetExitCode()
+		}
+		defer output.Close()
+	}
+
+	if DIFF_FORMAT == DIFF_FORMAT_JSON {
+		data, err := json.MarshalIndent(pd, "", "\t")
+		if err != nil {
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write diff: %v", err)
+			return GetExitCode()
+		}
+		output.Write(data)
+		output.Write([]byte("\n"))
+	} else {
+		RenderPackageDiffText(pd, NewCodeWriter(output))
+	}
+
+	if GetExitCode() == EXIT_FATAL {
+		return EXIT_FATAL
+	}
+	if pd.HasChanges() {
+		return EXIT_THRESHOLD
+	}
+	return EXIT_SUCCESS
+}
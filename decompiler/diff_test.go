@@ -0,0 +1,138 @@
+package decompiler
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The calls package, with an unrelated function added at the start to shift every offset and the string printed by
+// the Watch task changed
+func buildPatchedCallsPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Report")
+	b.Read(0).CallImported("util", "Log", 1).Pop()
+	b.End("report.end", false)
+
+	calls := buildCallsPackage()
+	offset := uint32(len(b.code))
+	for name, label := range calls.labels {
+		b.labels[name] = label + offset
+	}
+	for _, fixup := range calls.fixups {
+		b.fixups = append(b.fixups, labelFixup{position: fixup.position + int(offset), label: fixup.label})
+	}
+	for _, exp := range calls.exports {
+		b.exports = append(b.exports, pkgExport{name: exp.name, offset: exp.offset + offset})
+	}
+	for _, imp := range calls.imports {
+		for _, fnc := range imp.functions {
+			for _, callOffset := range fnc.offsets {
+				b.imports = addImportOffset(b.imports, imp.name, fnc.name, callOffset+offset)
+			}
+		}
+	}
+	b.code = append(b.code, calls.code...)
+	for _, s := range calls.strings {
+		if s == "watching" {
+			s = "watching closely"
+		}
+		b.strings = append(b.strings, s)
+	}
+	return b
+}
+
+func addImportOffset(imports []*pkgImport, pkgName string, funcName string, offset uint32) []*pkgImport {
+	var imp *pkgImport
+	for _, existing := range imports {
+		if existing.name == pkgName {
+			imp = existing
+		}
+	}
+	if imp == nil {
+		imp = &pkgImport{name: pkgName}
+		imports = append(imports, imp)
+	}
+	for _, fnc := range imp.functions {
+		if fnc.name == funcName {
+			fnc.offsets = append(fnc.offsets, offset)
+			return imports
+		}
+	}
+	imp.functions = append(imp.functions, &pkgImportedFunction{name: funcName, offsets: []uint32{offset}})
+	return imports
+}
+
+func diffTestPackages(t *testing.T, before *pkgBuilder, after *pkgBuilder) (*PackageDiff, int) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	beforeFile := filepath.Join(dir, "before.pkg")
+	afterFile := filepath.Join(dir, "after.pkg")
+	OUTPUT_FILE = filepath.Join(dir, "diff.json")
+	DIFF_FORMAT = DIFF_FORMAT_JSON
+	defer func() { DIFF_FORMAT = DIFF_FORMAT_TEXT }()
+
+	if err := os.WriteFile(beforeFile, before.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(afterFile, after.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	exitCode := Diff(beforeFile, afterFile)
+
+	data, err := os.ReadFile(OUTPUT_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pd := &PackageDiff{}
+	if err := json.Unmarshal(data, pd); err != nil {
+		t.Fatal(err)
+	}
+	return pd, exitCode
+}
+
+func TestDiffSamePackage(t *testing.T) {
+	pd, exitCode := diffTestPackages(t, buildCallsPackage(), buildCallsPackage())
+	if exitCode != EXIT_SUCCESS || pd.HasChanges() {
+		t.Fatalf("expected no changes, got exit code %d and %+v", exitCode, pd)
+	}
+}
+
+func TestDiffPatchedPackage(t *testing.T) {
+	pd, exitCode := diffTestPackages(t, buildCallsPackage(), buildPatchedCallsPackage())
+	if exitCode != EXIT_THRESHOLD {
+		t.Fatalf("expected exit code %d, got %d", EXIT_THRESHOLD, exitCode)
+	}
+
+	check := func(name string, actual []string, expected []string) {
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected %q, got %q", name, expected, actual)
+		}
+	}
+	check("added exports", pd.AddedExports, []string{"Golden.Report"})
+	check("added functions", pd.AddedFunctions, []string{"Golden.Report"})
+	check("removed functions", pd.RemovedFunctions, nil)
+	check("added strings", pd.AddedStrings, []string{"watching closely"})
+	check("removed strings", pd.RemovedStrings, []string{"watching"})
+
+	// Only the task that prints the string changed, the moved functions are the same
+	if len(pd.ChangedFunctions) != 1 {
+		t.Fatalf("expected 1 changed function, got %+v", pd.ChangedFunctions)
+	}
+	changed := pd.ChangedFunctions[0]
+	check("assembly", changed.Assembly, []string{
+		"  OP_EQUALS",
+		"  OP_JUMP_IF_FALSE L8",
+		`- OP_LITERAL_STRING "watching"`,
+		`+ OP_LITERAL_STRING "watching closely"`,
+		"  OP_FUNCTION_CALL_IMPORTED Util.Print 1",
+		"  OP_POP_STACK",
+	})
+	if len(changed.Source) == 0 {
+		t.Errorf("expected source changes for %s", changed.Name)
+	}
+}
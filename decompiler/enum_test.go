@@ -0,0 +1,63 @@
+package decompiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHasFlagValues(t *testing.T) {
+	cases := []struct {
+		values   []uint32
+		expected bool
+	}{
+		{[]uint32{0, 1, 2, 4}, true},
+		{[]uint32{1, 2, 4, 8}, true},
+		{[]uint32{0, 1, 2, 3, 4}, false},
+		{[]uint32{0, 1, 2}, false},
+		{[]uint32{1, 2, 4, 6}, false},
+	}
+
+	for _, c := range cases {
+		valueToName := map[uint32]string{}
+		for _, value := range c.values {
+			valueToName[value] = "member"
+		}
+		if hasFlagValues(valueToName) != c.expected {
+			t.Errorf("hasFlagValues(%v) should be %v", c.values, c.expected)
+		}
+	}
+}
+
+// Describe(hunit unit) combines the values of a flag enum and of a regular enum
+func buildEnumPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Describe")
+
+	// Util.SetFlags(FLAG_ARMED | FLAG_MOVING);
+	b.Int(3).CallImported("util", "SetFlags", 1).Pop()
+
+	// Util.Log(Util.GetFlags(unit) & FLAG_HIDDEN);
+	b.Int(4).Read(0).CallImported("util", "GetFlags", 1).Op(OP_BITWISE_AND).CallImported("util", "Log", 1).Pop()
+
+	// Util.Log(Util.GetLevel(unit) | 1);
+	b.Int(1).Read(0).CallImported("util", "GetLevel", 1).Op(OP_BITWISE_OR).CallImported("util", "Log", 1).Pop()
+
+	b.End("describe.end", false)
+
+	return b
+}
+
+func TestFlagEnums(t *testing.T) {
+	output := decompileTestPackage(t, buildEnumPackage())
+
+	for _, expected := range []string{
+		"Util.SetFlags( FLAG_ARMED | FLAG_MOVING );",
+		"Util.Log( Util.GetFlags( unit_ ) & FLAG_HIDDEN );",
+		"Util.Log( Util.GetLevel( unit_ ) | 1 );",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Missing %s in:\n%s", expected, output)
+		}
+	}
+}
@@ -0,0 +1,564 @@
+package decompiler
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const FINGERPRINTS_VERSION = 1
+
+var FINGERPRINTS_FILE string
+var NAMES_FILE string
+
+// How similar two functions have to be to end up in the same cluster, from 0 to 1
+var SIMILARITY_THRESHOLD float64 = 0.8
+
+// Smaller functions are too generic to say anything about being copies of each other
+const MIN_CLUSTER_OPERATIONS = 10
+
+// The number of hashes in a MinHash signature, and how many bands they are split into to find candidate pairs
+const MINHASH_SIZE = 64
+const MINHASH_BANDS = 16
+
+// Operations are compared in runs of this many
+const SHINGLE_SIZE = 3
+
+var LOCAL_CALL_REGEX = regexp.MustCompile(`^(OP_FUNCTION_CALL_LOCAL|OP_TASK_CALL_LOCAL) \S+`)
+
+// Writes an operation without any code offsets, so functions that only moved compare the same
+func normalizeOperation(op *Operation, startingIndex int, offsetToIndex map[uint32]int) string {
+	opInfo := OP_MAP[op.opcode]
+	label := func(offset uint32) string {
+		if idx, ok := offsetToIndex[offset]; ok {
+			return fmt.Sprintf("L%d", idx-startingIndex)
+		}
+		return "L?"
+	}
+
+	switch data := op.data.(type) {
+	case JumpData:
+		return fmt.Sprintf("%s %s", opInfo.name, label(data.offset))
+	case ConditionalJumpData:
+		return fmt.Sprintf("%s %s", opInfo.name, label(data.offset))
+	case ScheduleEveryData:
+		return fmt.Sprintf("%s %s %f", opInfo.name, label(data.skipOffset), data.interval)
+	case LiteralStringData:
+		return fmt.Sprintf("%s %q", opInfo.name, STRING_TABLE[data.index])
+	case FunctionCallData:
+		return fmt.Sprintf("%s %s %d", opInfo.name, data.declaration.GetScopedName(), data.PopCount())
+	case nil:
+		return opInfo.name
+	}
+
+	text := op.data.String()
+	if len(text) == 0 {
+		return opInfo.name
+	}
+	return fmt.Sprintf("%s %s", opInfo.name, text)
+}
+
+// Maps code offsets to operation indices, so jumps can be written relative to the start of their function
+func getOperationIndices() map[uint32]int {
+	result := map[uint32]int{}
+	for idx, op := range OPERATIONS {
+		result[op.offset] = idx
+	}
+	return result
+}
+
+func getNormalizedAssembly(startingIndex int, offsetToIndex map[uint32]int) []string {
+	result := []string{}
+	for idx := startingIndex; idx < len(OPERATIONS); idx++ {
+		result = append(result, normalizeOperation(&OPERATIONS[idx], startingIndex, offsetToIndex))
+		if OPERATIONS[idx].opcode == OP_FUNCTION_END {
+			break
+		}
+	}
+	return result
+}
+
+// Hashes the normalized assembly of a function. Local functions are named in the order they are found, so calls
+// to them only count as calls.
+func getFunctionHash(assembly []string) string {
+	hash := sha1.New()
+	for _, line := range assembly {
+		hash.Write([]byte(LOCAL_CALL_REGEX.ReplaceAllString(line, "$1 local")))
+		hash.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// Describes the nesting of the control flow of a function, like "if{while{}}else{}"
+func getControlFlowShape(elements []BlockElement) string {
+	var sb strings.Builder
+	for _, e := range elements {
+		if _, ok := e.(*Statement); ok {
+			continue
+		}
+		sb.WriteString(getBlockElementKind(e))
+		sb.WriteString("{")
+		for _, body := range GetElementBodies(e) {
+			sb.WriteString(getControlFlowShape(*body))
+		}
+		sb.WriteString("}")
+	}
+	return sb.String()
+}
+
+func getImportedCalls(assembly []string) []string {
+	calls := map[string]bool{}
+	for _, line := range assembly {
+		if strings.HasPrefix(line, "OP_FUNCTION_CALL_IMPORTED ") || strings.HasPrefix(line, "OP_TASK_CALL_IMPORTED ") {
+			calls[strings.Fields(line)[1]] = true
+		}
+	}
+
+	result := []string{}
+	for call := range calls {
+		result = append(result, call)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// The features compared between functions: runs of operations without their operands, the imported calls and the
+// control flow shape
+func getSimilarityTokens(assembly []string, imports []string, shape string) []string {
+	opcodes := []string{}
+	for _, line := range assembly {
+		opcodes = append(opcodes, strings.Fields(line)[0])
+	}
+
+	tokens := []string{"shape " + shape}
+	for _, call := range imports {
+		tokens = append(tokens, "call "+call)
+	}
+	for ii := 0; ii+SHINGLE_SIZE <= len(opcodes); ii++ {
+		tokens = append(tokens, strings.Join(opcodes[ii:ii+SHINGLE_SIZE], " "))
+	}
+	return tokens
+}
+
+func mixHash(value uint64) uint64 {
+	value ^= value >> 30
+	value *= 0xBF58476D1CE4E5B9
+	value ^= value >> 27
+	value *= 0x94D049BB133111EB
+	value ^= value >> 31
+	return value
+}
+
+func getMinHash(tokens []string) []uint32 {
+	result := make([]uint32, MINHASH_SIZE)
+	for ii := range result {
+		result[ii] = math.MaxUint32
+	}
+	for _, token := range tokens {
+		h := fnv.New64a()
+		h.Write([]byte(token))
+		tokenHash := h.Sum64()
+		for ii := range result {
+			value := uint32(mixHash(tokenHash + uint64(ii)*0x9E3779B97F4A7C15))
+			if value < result[ii] {
+				result[ii] = value
+			}
+		}
+	}
+	return result
+}
+
+// Estimates how much of the features of two functions are shared, from 0 to 1
+func getSimilarity(a []uint32, b []uint32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	same := 0
+	for ii := range a {
+		if a[ii] == b[ii] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(a))
+}
+
+type FunctionFingerprint struct {
+	Package    string   `json:"package"`
+	Function   string   `json:"function"`
+	ReturnType string   `json:"returnType"`
+	Parameters []string `json:"parameters"`
+	Hash       string   `json:"hash"`
+	Shape      string   `json:"shape"`
+	Imports    []string `json:"imports"`
+	Operations int      `json:"operations"`
+	MinHash    []uint32 `json:"minHash"`
+}
+
+func (ff *FunctionFingerprint) GetScopedName() string {
+	return fmt.Sprintf("%s.%s", ff.Package, ff.Function)
+}
+
+// Whether the function has a real name, rather than one made up for a local function
+func (ff *FunctionFingerprint) IsNamed() bool {
+	return !LOCAL_FUNCTION_NAME_REGEX.MatchString(ff.Function)
+}
+
+type FingerprintReport struct {
+	Version  int                               `json:"version"`
+	Packages map[string][]*FunctionFingerprint `json:"packages"`
+}
+
+func GetPackageFingerprints() []*FunctionFingerprint {
+	offsetToIndex := getOperationIndices()
+	result := []*FunctionFingerprint{}
+
+	for _, fnc := range DECOMPILED_FUNCS {
+		assembly := getNormalizedAssembly(fnc.startingIndex, offsetToIndex)
+		ff := &FunctionFingerprint{
+			Package:    EXPORTING_PACKAGE,
+			Function:   fnc.declaration.name,
+			ReturnType: fnc.declaration.returnInfo.typeName,
+			Parameters: []string{},
+			Hash:       getFunctionHash(assembly),
+			Shape:      getControlFlowShape(fnc.body),
+			Imports:    getImportedCalls(assembly),
+			Operations: len(assembly),
+		}
+		if fnc.declaration.parameters != nil {
+			for _, p := range *fnc.declaration.parameters {
+				// Names are stored like a header declares them, without the underscore resolving the names adds
+				ff.Parameters = append(ff.Parameters, fmt.Sprintf("%s %s", p.typeName, strings.TrimSuffix(p.parameterName, "_")))
+			}
+		}
+		ff.MinHash = getMinHash(getSimilarityTokens(assembly, ff.Imports, ff.Shape))
+		result = append(result, ff)
+	}
+	return result
+}
+
+func LoadFingerprintReport(filename string) (*FingerprintReport, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FingerprintReport{}
+	err = json.Unmarshal(data, report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fingerprints %s: %v", filename, err)
+	}
+	if report.Packages == nil {
+		report.Packages = map[string][]*FunctionFingerprint{}
+	}
+	return report, nil
+}
+
+// Adds this package to the fingerprints file, keeping the packages already in it so a whole corpus can share one file
+func writeFingerprints() error {
+	fmt.Printf("Writing fingerprints: %s\n", FINGERPRINTS_FILE)
+
+	report, err := LoadFingerprintReport(FINGERPRINTS_FILE)
+	if errors.Is(err, fs.ErrNotExist) {
+		report = &FingerprintReport{Packages: map[string][]*FunctionFingerprint{}}
+	} else if err != nil {
+		return err
+	}
+	report.Version = FINGERPRINTS_VERSION
+	report.Packages[EXPORTING_PACKAGE] = GetPackageFingerprints()
+
+	data, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(FINGERPRINTS_FILE, data, 0644)
+}
+
+type FunctionCluster struct {
+	Functions []*FunctionFingerprint
+	// The lowest similarity between any function and the one it was clustered with
+	Similarity float64
+}
+
+func (fc *FunctionCluster) PackageCount() int {
+	packages := map[string]bool{}
+	for _, ff := range fc.Functions {
+		packages[ff.Package] = true
+	}
+	return len(packages)
+}
+
+func findClusterRoot(parents []int, idx int) int {
+	for parents[idx] != idx {
+		parents[idx] = parents[parents[idx]]
+		idx = parents[idx]
+	}
+	return idx
+}
+
+// Groups near duplicate functions. Candidate pairs are the ones sharing a band of their MinHash signatures, and
+// they are joined if their estimated similarity is over the threshold.
+func ClusterFunctions(report *FingerprintReport, threshold float64) []*FunctionCluster {
+	functions := []*FunctionFingerprint{}
+	for _, name := range getSortedFingerprintPackages(report) {
+		for _, ff := range report.Packages[name] {
+			if ff.Operations >= MIN_CLUSTER_OPERATIONS && len(ff.MinHash) == MINHASH_SIZE {
+				functions = append(functions, ff)
+			}
+		}
+	}
+
+	parents := make([]int, len(functions))
+	for ii := range parents {
+		parents[ii] = ii
+	}
+	similarities := make([]float64, len(functions))
+	for ii := range similarities {
+		similarities[ii] = 1
+	}
+
+	rows := MINHASH_SIZE / MINHASH_BANDS
+	checked := map[[2]int]bool{}
+	for band := 0; band < MINHASH_BANDS; band++ {
+		buckets := map[string][]int{}
+		for ii, ff := range functions {
+			key := fmt.Sprint(ff.MinHash[band*rows : (band+1)*rows])
+			buckets[key] = append(buckets[key], ii)
+		}
+
+		for _, bucket := range buckets {
+			for ii := 0; ii < len(bucket); ii++ {
+				for jj := ii + 1; jj < len(bucket); jj++ {
+					pair := [2]int{bucket[ii], bucket[jj]}
+					if checked[pair] {
+						continue
+					}
+					checked[pair] = true
+
+					similarity := getSimilarity(functions[pair[0]].MinHash, functions[pair[1]].MinHash)
+					if similarity < threshold {
+						continue
+					}
+					a := findClusterRoot(parents, pair[0])
+					b := findClusterRoot(parents, pair[1])
+					lowest := math.Min(similarity, math.Min(similarities[a], similarities[b]))
+					parents[b] = a
+					similarities[a] = lowest
+				}
+			}
+		}
+	}
+
+	clusters := map[int]*FunctionCluster{}
+	for ii, ff := range functions {
+		root := findClusterRoot(parents, ii)
+		if clusters[root] == nil {
+			clusters[root] = &FunctionCluster{}
+		}
+		clusters[root].Functions = append(clusters[root].Functions, ff)
+	}
+
+	result := []*FunctionCluster{}
+	for root, cluster := range clusters {
+		if len(cluster.Functions) < 2 {
+			continue
+		}
+		cluster.Similarity = similarities[root]
+		result = append(result, cluster)
+	}
+
+	// Code shared between the most packages is the most interesting to factor out
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].PackageCount() != result[j].PackageCount() {
+			return result[i].PackageCount() > result[j].PackageCount()
+		}
+		if len(result[i].Functions) != len(result[j].Functions) {
+			return len(result[i].Functions) > len(result[j].Functions)
+		}
+		return result[i].Functions[0].GetScopedName() < result[j].Functions[0].GetScopedName()
+	})
+	return result
+}
+
+func getSortedFingerprintPackages(report *FingerprintReport) []string {
+	result := []string{}
+	for name := range report.Packages {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+const NAME_HINTS_VERSION = 1
+
+// A name and prototype for a function with the given hash, learned from a named copy of it
+type NameHint struct {
+	Hash        string   `json:"hash"`
+	Name        string   `json:"name"`
+	ReturnType  string   `json:"returnType"`
+	Parameters  []string `json:"parameters"`
+	LearnedFrom string   `json:"learnedFrom"`
+}
+
+type NameHints struct {
+	Version int         `json:"version"`
+	Hints   []*NameHint `json:"hints"`
+}
+
+// Gives the unnamed functions of each cluster the name and prototype of the named function in it. Clusters with
+// named functions that disagree are left alone, as are copies that take a different number of parameters.
+func LearnNameHints(clusters []*FunctionCluster) *NameHints {
+	result := &NameHints{Version: NAME_HINTS_VERSION, Hints: []*NameHint{}}
+
+	for _, cluster := range clusters {
+		var named *FunctionFingerprint
+		agreed := true
+		for _, ff := range cluster.Functions {
+			if !ff.IsNamed() {
+				continue
+			}
+			if named != nil && (named.Function != ff.Function || strings.Join(named.Parameters, ",") != strings.Join(ff.Parameters, ",")) {
+				agreed = false
+			}
+			named = ff
+		}
+		if named == nil || !agreed {
+			continue
+		}
+
+		for _, ff := range cluster.Functions {
+			if ff.IsNamed() || len(ff.Parameters) != len(named.Parameters) {
+				continue
+			}
+			result.Hints = append(result.Hints, &NameHint{
+				Hash:        ff.Hash,
+				Name:        named.Function,
+				ReturnType:  named.ReturnType,
+				Parameters:  named.Parameters,
+				LearnedFrom: named.GetScopedName(),
+			})
+		}
+	}
+	return result
+}
+
+func LoadNameHints(filename string) (*NameHints, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	hints := &NameHints{}
+	err = json.Unmarshal(data, hints)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse names %s: %v", filename, err)
+	}
+	return hints, nil
+}
+
+// Names the local functions that have a hint, before they are decompiled so the types are used from the start
+func applyNameHints(hints *NameHints) {
+	hintMap := map[string]*NameHint{}
+	for _, hint := range hints.Hints {
+		hintMap[hint.Hash] = hint
+	}
+
+	offsetToIndex := getOperationIndices()
+	for idx := range OPERATIONS {
+		if idx > 0 && OPERATIONS[idx-1].opcode != OP_FUNCTION_END {
+			continue
+		}
+		declaration := FUNC_DEFINITION_MAP[OPERATIONS[idx].offset]
+		if declaration == nil || len(declaration.pkg) > 0 {
+			continue
+		}
+		hint, ok := hintMap[getFunctionHash(getNormalizedAssembly(idx, offsetToIndex))]
+		if !ok {
+			continue
+		}
+		if declaration.parameters != nil && len(*declaration.parameters) != len(hint.Parameters) {
+			DiagnoseAt(DIAG_NAME_HINT_MISMATCH, OPERATIONS[idx].offset, "The name hint %s from %s takes %d parameters, but %s is called with %d", hint.Name, hint.LearnedFrom, len(hint.Parameters), declaration.name, len(*declaration.parameters))
+			continue
+		}
+
+		parameters := []FunctionParameter{}
+		for _, p := range hint.Parameters {
+			parts := strings.Fields(p)
+			if len(parts) != 2 {
+				DiagnoseAt(DIAG_NAME_HINT_MISMATCH, OPERATIONS[idx].offset, "The name hint %s has an invalid parameter '%s'", hint.Name, p)
+				parameters = nil
+				break
+			}
+			parameters = append(parameters, FunctionParameter{typeName: parts[0], parameterName: parts[1]})
+		}
+		if parameters == nil {
+			continue
+		}
+
+		// Copies in the same package have to keep their names apart
+		name := hint.Name
+		for suffix := 2; FUNC_DECLARATIONS[name] != nil || FUNC_DECLARATIONS[EXPORTING_PACKAGE+"."+name] != nil; suffix++ {
+			name = fmt.Sprintf("%s_%d", hint.Name, suffix)
+		}
+		delete(FUNC_DECLARATIONS, declaration.name)
+		declaration.name = name
+		FUNC_DECLARATIONS[name] = declaration
+
+		declaration.parameters = &parameters
+		declaration.returnInfo.typeName = hint.ReturnType
+		declaration.autoDetectTypes = false
+	}
+}
+
+func RenderClustersText(clusters []*FunctionCluster, writer CodeWriter) {
+	for ii, cluster := range clusters {
+		writer.Appendf("Cluster %d: %d functions in %d packages, at least %.0f%% similar\n", ii+1, len(cluster.Functions), cluster.PackageCount(), cluster.Similarity*100)
+		writer.PushIndent()
+		for _, ff := range cluster.Functions {
+			writer.Appendf("%s %s (%d operations)\n", ff.GetScopedName(), ff.Hash, ff.Operations)
+		}
+		writer.PopIndent()
+	}
+	if len(clusters) == 0 {
+		writer.Append("No similar functions\n")
+	}
+}
+
+// Runs the similar command, returns the exit code. Writes the learned name hints if a names file is given.
+func FindSimilarFunctions(fingerprintsFile string, namesFile string) int {
+	report, err := LoadFingerprintReport(fingerprintsFile)
+	if err != nil {
+		fmt.Printf("Error: Failed to read fingerprints: %v\n", err)
+		return EXIT_FATAL
+	}
+	if SIMILARITY_THRESHOLD <= 0 || SIMILARITY_THRESHOLD > 1 {
+		fmt.Printf("Error: The similarity has to be more than 0 and at most 1\n")
+		return EXIT_FATAL
+	}
+
+	clusters := ClusterFunctions(report, SIMILARITY_THRESHOLD)
+	RenderClustersText(clusters, NewCodeWriter(os.Stdout))
+
+	if len(namesFile) > 0 {
+		hints := LearnNameHints(clusters)
+		data, err := json.MarshalIndent(hints, "", "\t")
+		if err == nil {
+			err = os.WriteFile(namesFile, data, 0644)
+		}
+		if err != nil {
+			fmt.Printf("Error: Failed to write names: %v\n", err)
+			return EXIT_FATAL
+		}
+		fmt.Printf("Wrote %d name hints: %s\n", len(hints.Hints), namesFile)
+	}
+	return EXIT_SUCCESS
+}
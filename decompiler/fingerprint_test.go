@@ -0,0 +1,66 @@
+package decompiler
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Reports a clamped value through a local copy of the golden Clamp function
+func buildCopiesPackage() *pkgBuilder {
+	b := newPkgBuilder("copies")
+
+	b.Export("Report")
+	b.Read(0).Int(0).Int(10).Call("clamp", 3).CallImported("util", "Log", 1).Pop()
+	b.End("report.end", false)
+
+	b.Label("clamp")
+	addClampBody(b)
+
+	return b
+}
+
+func TestSimilarFunctions(t *testing.T) {
+	dir := t.TempDir()
+	fingerprintsFile := filepath.Join(dir, "fingerprints.json")
+
+	// Both packages go into the same fingerprints file, like a corpus run
+	resetForTest(dir)
+	FINGERPRINTS_FILE = fingerprintsFile
+	decompileBuiltPackage(t, buildIfElsePackage())
+	resetForTest(dir)
+	FINGERPRINTS_FILE = fingerprintsFile
+	output := decompileBuiltPackage(t, buildCopiesPackage())
+	if !strings.Contains(output, "local_function_0") {
+		t.Fatalf("expected the copy to be a local function:\n%s", output)
+	}
+
+	report, err := LoadFingerprintReport(fingerprintsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters := ClusterFunctions(report, SIMILARITY_THRESHOLD)
+	if len(clusters) != 1 || len(clusters[0].Functions) != 2 {
+		t.Fatalf("expected one cluster of 2 functions, got %d clusters", len(clusters))
+	}
+	if clusters[0].Functions[0].Hash != clusters[0].Functions[1].Hash {
+		t.Errorf("expected the copies to have the same hash")
+	}
+
+	hints := LearnNameHints(clusters)
+	if len(hints.Hints) != 1 || hints.Hints[0].Name != "Clamp" || hints.Hints[0].LearnedFrom != "Golden.Clamp" {
+		t.Fatalf("expected a Clamp hint from Golden.Clamp, got %+v", hints.Hints)
+	}
+
+	// The hint names and types the copy when the package is decompiled again
+	namesFile := filepath.Join(dir, "names.json")
+	if exitCode := FindSimilarFunctions(fingerprintsFile, namesFile); exitCode != EXIT_SUCCESS {
+		t.Fatalf("expected exit code %d, got %d", EXIT_SUCCESS, exitCode)
+	}
+	resetForTest(dir)
+	NAMES_FILE = namesFile
+	output = decompileBuiltPackage(t, buildCopiesPackage())
+	if strings.Contains(output, "local_function_0") || !strings.Contains(output, "prototype int Clamp( int value_, int low_, int high_ );") {
+		t.Fatalf("expected the copy to be named Clamp:\n%s", output)
+	}
+}
@@ -2,7 +2,6 @@ package decompiler
 
 import (
 	"fmt"
-	"os"
 	"regexp"
 	"strings"
 )
@@ -87,21 +86,25 @@ func (fd *FunctionDefinition) ResetPossibleTypes() {
 }
 
 func (fd *FunctionDefinition) CheckCode() {
+	// Check the body first, so the assignments that get a cast inserted are known
+	CheckCode(fd.scope, fd.body)
+
 	for _, v := range fd.scope.variables {
 		if !IsHandleType(v.typeName) {
 			continue
 		}
-		assignedTypes := v.GetAssignedTypes()
+		// What gets assigned to a parameter is kept apart so it doesn't change the parameter type, it still has to fit
+		assignedTypes := append(v.GetAssignedTypes(), v.GetParameterAssignedTypes()...)
 		for _, atype := range assignedTypes {
 			if !IsHandleType(atype) {
 				continue
 			}
-			if !HandleIsDerivedFrom(atype, v.typeName) {
-				fmt.Printf("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!>>> Variable %d using type %s, from which assigned type %s is not derived!\n", v.id, v.typeName, atype)
+			// Assignments that had a cast inserted for them are fine
+			if !v.castAssignedTypes[atype] && !HandleIsDerivedFrom(atype, v.typeName) {
+				Diagnose(DIAG_HANDLE_ASSIGNMENT, "Variable %d using type %s, from which assigned type %s is not derived", v.id, v.typeName, atype)
 			}
 		}
 	}
-	CheckCode(fd.scope, fd.body)
 }
 
 func (fd *FunctionDefinition) ResolveBodyTypes() int {
@@ -231,6 +234,12 @@ func (fd *FunctionDefinition) isLocalVariableInitialAssignment(statement *Statem
 
 	if op1.operation.opcode == OP_POP_STACK && (op2.operation.opcode == OP_VARIABLE_WRITE || op2.operation.opcode == OP_STRING_VARIABLE_WRITE) {
 		varData := op2.operation.data.(VariableWriteData)
+
+		// Parameters aren't declared, so an assignment to one has to stay where it is
+		if varData.index < fd.scope.localVariableIndexOffset {
+			return nil
+		}
+
 		references := op2.children[0].GetAllReferencedVariableIndices()
 		for idx := varData.index; idx < uint32(len(fd.scope.variables)); idx++ {
 			// If this variable assignment references itself or variables that are declared after it, it can't be an initial assignment statement
@@ -265,29 +274,45 @@ func (fd *FunctionDefinition) Render(writer CodeWriter) {
 		PrintFunctionAssembly(fd.declaration, fd.startingIndex, fd.initialOffset, writer)
 	}
 
+	aw, annotated := writer.(AnnotatedWriter)
+	if annotated {
+		aw.BeginAnnotation(ANNOTATION_FUNCTION, map[string]string{"id": fd.declaration.name})
+		defer aw.EndAnnotation()
+	}
+
+	line, column := writer.Position()
+	if SOURCE_MAP != nil {
+		SOURCE_MAP.currentFunction = fd.declaration.GetScopedName()
+		SOURCE_MAP.CodeFileOffset = fd.initialOffset
+	}
+
 	// Write the function header
 	writer.Append(renderFunctionDefinitionHeader(fd.declaration))
+	comment := ""
 	if OUTPUT_ASSEMBLY {
 		if fd.declaration.ReturnsNonVoid() || fd.declaration.HasParameters() {
-			writer.Append(" // ")
+			comment = " // "
 			if fd.declaration.ReturnsNonVoid() {
-				writer.Appendf("Return ID: %d ", fd.declaration.returnInfo.id)
+				comment += fmt.Sprintf("Return ID: %d ", fd.declaration.returnInfo.id)
 			}
 			for _, p := range *fd.declaration.parameters {
-				writer.Appendf("%s ID: %d ", p.parameterName, p.variable.id)
+				comment += fmt.Sprintf("%s ID: %d ", p.parameterName, p.variable.id)
 			}
 		}
 	}
 
-	writer.Append("\n{\n")
-	writer.PushIndent()
+	printOpenBlock(writer, comment)
 
 	assignments := map[uint32]*Statement{}
 
 	endIdx := -1
 
-	// Try to detect initial assignments
+	// Try to detect initial assignments, unless the entry is logged since it has to come before anything runs
+	instrumentEntry := isInstrumented(fd.declaration, INSTRUMENT_ENTRY)
 	for _, be := range fd.body {
+		if instrumentEntry {
+			break
+		}
 		if !be.IsBlock() {
 			statement := be.(*Statement)
 			variable := fd.isLocalVariableInitialAssignment(statement)
@@ -304,19 +329,31 @@ func (fd *FunctionDefinition) Render(writer CodeWriter) {
 		}
 	}
 
-	fd.body = fd.body[len(assignments):]
+	body := fd.body[len(assignments):]
 
 	writeLocalVariableDeclarations(fd.scope.variables[fd.scope.localVariableIndexOffset:], assignments, fd, writer)
 
-	if DEBUG_LOGGING {
+	if instrumentEntry {
+		renderEntryInstrumentation(fd, writer)
+	} else if DEBUG_LOGGING {
 		writer.Appendf(`debug atomic Debug.PrintString("Inside function: %s %s\n");`, EXPORTING_PACKAGE, renderFunctionDefinitionHeader(fd.declaration))
 		writer.Append("\n")
 	}
 
-	RenderBlockElements(fd.body, fd.scope, writer)
+	RenderBlockElements(body, fd.scope, writer)
+	renderEndInstrumentation(fd, writer)
+
+	printCloseBlock(writer, false)
+
+	if SOURCE_MAP != nil {
+		SOURCE_MAP.AddEntry(SOURCE_MAP_FUNCTION, line, column, writer, OPERATIONS[fd.startingIndex].offset, fd.scope.functionEndOffset)
+	}
 
-	writer.PopIndent()
-	writer.Append("}\n\n")
+	if annotated {
+		renderFunctionAssemblyPanel(fd, aw)
+	}
+
+	writer.Append("\n")
 }
 
 func AddFunctionDeclaration(pkg string, name string) *FunctionDeclaration {
@@ -351,7 +388,7 @@ func AddFunctionDeclarationFromPrototype(prototype string) *FunctionDeclaration
 	result.autoDetectTypes = false
 
 	if !strings.HasPrefix(prototype, PROTOTYPE_PREFIX) {
-		fmt.Printf("ERROR: Invalid function prototype: %s\n", prototype)
+		DiagnoseInPackage(DIAG_INVALID_PROTOTYPE, "", "Invalid function prototype: %s", prototype)
 		return nil
 	}
 
@@ -362,7 +399,7 @@ func AddFunctionDeclarationFromPrototype(prototype string) *FunctionDeclaration
 	parts := strings.Split(function, "(")
 
 	if len(parts) != 2 {
-		fmt.Printf("ERROR: Invalid function prototype: %s\n", prototype)
+		DiagnoseInPackage(DIAG_INVALID_PROTOTYPE, "", "Invalid function prototype: %s", prototype)
 		return nil
 	}
 
@@ -370,7 +407,7 @@ func AddFunctionDeclarationFromPrototype(prototype string) *FunctionDeclaration
 	parameterList := parts[1]
 
 	if !strings.HasSuffix(parameterList, ")") {
-		fmt.Printf("ERROR: Invalid function prototype: %s\n", prototype)
+		DiagnoseInPackage(DIAG_INVALID_PROTOTYPE, "", "Invalid function prototype: %s", prototype)
 		return nil
 	}
 	parameterList = strings.TrimSpace(parameterList[:len(parameterList)-1])
@@ -385,7 +422,7 @@ func AddFunctionDeclarationFromPrototype(prototype string) *FunctionDeclaration
 		returnType = parts[0]
 		function = parts[1]
 	default:
-		fmt.Printf("ERROR: Invalid function prototype: %s\n", prototype)
+		DiagnoseInPackage(DIAG_INVALID_PROTOTYPE, "", "Invalid function prototype: %s", prototype)
 		return nil
 	}
 
@@ -412,7 +449,7 @@ func AddFunctionDeclarationFromPrototype(prototype string) *FunctionDeclaration
 				parts = parts[1:]
 			}
 			if len(parts) != 2 {
-				fmt.Printf("ERROR: Invalid function prototype: %s\n", prototype)
+				DiagnoseInPackage(DIAG_INVALID_PROTOTYPE, "", "Invalid function prototype: %s", prototype)
 				return nil
 			}
 			p := FunctionParameter{
@@ -461,26 +498,36 @@ func (f *FunctionDeclaration) GetScopedName() string {
 	}
 }
 
+// Nothing uses the local to give it a type, so guess one to declare it with
+func setUnusedLocalType(lv *Variable) {
+	if lv.typeName == UNKNOWN_TYPE && lv.refCount == 0 {
+		// If it has an init, it is probably a string
+		if lv.hasInit {
+			lv.typeName = "string"
+		} else {
+			lv.typeName = "int"
+		}
+	}
+}
+
 func writeLocalVariableDeclarations(variables []*Variable, assignments map[uint32]*Statement, definition *FunctionDefinition, writer CodeWriter) {
 	written := 0
 	for ii := 0; ii < len(variables); ii++ {
 		lv := variables[ii]
-		if lv.typeName == UNKNOWN_TYPE && lv.refCount == 0 {
-			// If it has an init, it is probably a string
-			if lv.hasInit {
-				lv.typeName = "string"
-			} else {
-				lv.typeName = "int"
-			}
-		}
+		setUnusedLocalType(lv)
 
 		if lv.typeName == UNKNOWN_TYPE {
-			fmt.Printf("ERROR: Failed to determine type for local variable %s id %d in function %s\n", lv.variableName, lv.id, definition.declaration.GetScopedName())
+			Diagnose(DIAG_UNKNOWN_LOCAL_TYPE, "Failed to determine type for local variable %s id %d", lv.variableName, lv.id)
 		}
 
 		if assignment, ok := assignments[lv.stackIndex]; ok {
+			line, column := writer.Position()
 			writer.Appendf("%s ", lv.typeName)
 			assignment.Render(definition.scope, writer)
+			if SOURCE_MAP != nil {
+				min, max := assignment.graph.GetOffsetRange()
+				SOURCE_MAP.AddEntry(SOURCE_MAP_STATEMENT, line, column, writer, min, max)
+			}
 			writer.Append(";")
 		} else {
 			writer.Appendf("%s %s;", lv.typeName, lv.variableName)
@@ -489,10 +536,21 @@ func writeLocalVariableDeclarations(variables []*Variable, assignments map[uint3
 		if OUTPUT_ASSEMBLY {
 			writer.Appendf(" // ID: %d", lv.id)
 		}
+		if DEAD_CODE_COMMENTS && lv.refCount == 0 {
+			if OUTPUT_ASSEMBLY {
+				writer.Append(", unused")
+			} else {
+				writer.Append(" // unused")
+			}
+		}
 		writer.Append("\n")
 		written++
 	}
 
+	if writeDebugReturnVariableDeclaration(definition.declaration, writer) {
+		written++
+	}
+
 	if written > 0 {
 		writer.Append("\n")
 	}
@@ -504,7 +562,7 @@ func renderFunctionDefinitionHeader(declaration *FunctionDeclaration) string {
 	returnType := declaration.returnInfo.typeName
 	if len(returnType) > 0 {
 		if returnType == UNKNOWN_TYPE {
-			fmt.Printf("ERROR: Failed to determine return type id %d for function %s\n", declaration.returnInfo.id, declaration.GetScopedName())
+			Diagnose(DIAG_UNKNOWN_RETURN_TYPE, "Failed to determine return type id %d for function %s", declaration.returnInfo.id, declaration.GetScopedName())
 		}
 		sb.WriteString(fmt.Sprintf("%s ", returnType))
 	}
@@ -512,19 +570,23 @@ func renderFunctionDefinitionHeader(declaration *FunctionDeclaration) string {
 	sb.WriteString(declaration.name)
 	sb.WriteString("(")
 	if declaration.parameters != nil && len(*declaration.parameters) > 0 {
-		sb.WriteString(" ")
+		if PRINT_STYLE.ParenPadding {
+			sb.WriteString(" ")
+		}
 		count := len(*declaration.parameters)
 		for ii := 0; ii < count; ii++ {
 			p := (*declaration.parameters)[ii]
 			if p.typeName == UNKNOWN_TYPE {
-				fmt.Printf("ERROR: Failed to determine type for function parameter %s(%s) id %d\n", declaration.GetScopedName(), p.parameterName, p.variable.id)
+				Diagnose(DIAG_UNKNOWN_PARAMETER_TYPE, "Failed to determine type for function parameter %s(%s) id %d", declaration.GetScopedName(), p.parameterName, p.variable.id)
 			}
 			sb.WriteString(fmt.Sprintf("%s %s", p.typeName, p.parameterName))
 			if ii < count-1 {
 				sb.WriteString(", ")
 			}
 		}
-		sb.WriteString(" ")
+		if PRINT_STYLE.ParenPadding {
+			sb.WriteString(" ")
+		}
 	}
 	sb.WriteString(")")
 
@@ -672,8 +734,7 @@ func DecompileFunction(declaration *FunctionDeclaration, startingIndex int, init
 
 	// Idiot check
 	if functionEnd == nil {
-		fmt.Printf("ERROR: Failed to find end of function: %s", declaration.name)
-		os.Exit(1)
+		DiagnoseFatalAt(DIAG_MISSING_FUNCTION_END, OPERATIONS[startingIndex].offset, "Failed to find end of function: %s", declaration.name)
 	}
 
 	// Save off the ending index
@@ -693,14 +754,14 @@ func DecompileFunction(declaration *FunctionDeclaration, startingIndex int, init
 			case OP_VARIABLE_READ:
 				varData := op.data.(VariableReadData)
 				if varData.index >= variableCount {
-					fmt.Printf("ERROR: Function %s tries to reference variable at index %d while only %d were declared, skipping.\n", declaration.GetScopedName(), varData.index, variableCount)
+					DiagnoseAt(DIAG_VARIABLE_OUT_OF_RANGE, op.offset, "Function tries to reference variable at index %d while only %d were declared, skipping", varData.index, variableCount)
 					return endIdx, definition
 				}
 
 			case OP_VARIABLE_WRITE, OP_STRING_VARIABLE_WRITE:
 				varData := op.data.(VariableWriteData)
 				if varData.index >= variableCount {
-					fmt.Printf("ERROR: Function %s tries to write to variable at index %d while only %d were declared, skipping.\n", declaration.GetScopedName(), varData.index, variableCount)
+					DiagnoseAt(DIAG_VARIABLE_OUT_OF_RANGE, op.offset, "Function tries to write to variable at index %d while only %d were declared, skipping", varData.index, variableCount)
 					return endIdx, definition
 				}
 			}
@@ -710,6 +771,16 @@ func DecompileFunction(declaration *FunctionDeclaration, startingIndex int, init
 	// Save off the end offset so we can detect return statements
 	definition.scope.functionEndOffset = functionEnd.offset
 
+	// Find the code nothing can reach before parsing so we don't trip over it
+	functionOps := OPERATIONS[definition.startingIndex:]
+	for idx := range functionOps {
+		if functionOps[idx].opcode == OP_FUNCTION_END {
+			functionOps = functionOps[:idx+1]
+			break
+		}
+	}
+	analyzeDeadCode(definition.scope, functionOps)
+
 	blockOps := OPERATIONS[startingIndex : endIdx+1]
 	definition.body = ParseOperations(definition.scope, &BlockContext{}, blockOps, 0, len(blockOps)-1)
 
@@ -0,0 +1,365 @@
+package decompiler
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Run "go test ./decompiler -run TestGolden -update" to rewrite the golden files after an intended output change
+var update = flag.Bool("update", false, "rewrite the golden files with the current decompiler output")
+
+const GOLDEN_INCLUDES_DIR = "testdata/include"
+const GOLDEN_DIR = "testdata/golden"
+
+type goldenCase struct {
+	name  string
+	build func() *pkgBuilder
+}
+
+// Each case is a package for testdata/include/Golden.h, which imports from testdata/include/Util.h
+var goldenCases = []goldenCase{
+	{name: "if-else", build: buildIfElsePackage},
+	{name: "loops", build: buildLoopsPackage},
+	{name: "switch", build: buildSwitchPackage},
+	{name: "schedule", build: buildSchedulePackage},
+	{name: "atomic-debug", build: buildAtomicDebugPackage},
+	{name: "strings", build: buildStringsPackage},
+	{name: "calls", build: buildCallsPackage},
+}
+
+// int Clamp(int value, int low, int high)
+func buildIfElsePackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Clamp")
+	addClampBody(b)
+
+	return b
+}
+
+// The body of Clamp, which other test packages copy into local functions
+func addClampBody(b *pkgBuilder) {
+	// if (low > high) return 0;
+	b.Read(2).Read(1).Op(OP_INT_GT).Jump(OP_JUMP_IF_FALSE, "clamp.ordered")
+	b.Int(0).Jump(OP_JUMP, "clamp.end")
+	b.Label("clamp.ordered")
+
+	// if (value < low) value = low; else if (value > high) value = high;
+	b.Read(1).Read(0).Op(OP_INT_LT).Jump(OP_JUMP_IF_FALSE, "clamp.else")
+	b.Read(1).Store(0)
+	b.Jump(OP_JUMP, "clamp.endif")
+	b.Label("clamp.else")
+	b.Read(2).Read(0).Op(OP_INT_GT).Jump(OP_JUMP_IF_FALSE, "clamp.endif")
+	b.Read(2).Store(0)
+	b.Label("clamp.endif")
+
+	// return value;
+	b.Read(0).Jump(OP_JUMP, "clamp.end")
+	b.End("clamp.end", false)
+}
+
+// int SumTo(int count)
+func buildLoopsPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("SumTo").Locals(2)
+
+	// total = 0;
+	b.Int(0).Store(1)
+
+	// for (ii = 0; ii < count; ii++) { if (total > 100000) break; total = total + ii; }
+	b.Int(0).Store(2)
+	b.Label("sum.for")
+	b.Read(0).Read(2).Op(OP_INT_LT).Jump(OP_JUMP_IF_FALSE, "sum.endfor")
+	b.Int(100000).Read(1).Op(OP_INT_GT).Jump(OP_JUMP_IF_FALSE, "sum.nobreak")
+	b.Jump(OP_JUMP, "sum.endfor")
+	b.Label("sum.nobreak")
+	b.Read(2).Read(1).Op(OP_INT_ADD).Store(1)
+	b.Int(1).Read(2).Op(OP_INT_ADD).Store(2)
+	b.Jump(OP_JUMP, "sum.for")
+	b.Label("sum.endfor")
+
+	// while (total > 1000) { total = total - 3; if (total == 20) continue; total = total - 1; }
+	b.Label("sum.while")
+	b.Int(1000).Read(1).Op(OP_INT_GT).Jump(OP_JUMP_IF_FALSE, "sum.endwhile")
+	b.Int(3).Read(1).Op(OP_INT_SUB).Store(1)
+	b.Int(20).Read(1).Op(OP_EQUALS).Jump(OP_JUMP_IF_FALSE, "sum.nocontinue")
+	b.Jump(OP_JUMP, "sum.while")
+	b.Label("sum.nocontinue")
+	b.Int(1).Read(1).Op(OP_INT_SUB).Store(1)
+	b.Jump(OP_JUMP, "sum.while")
+	b.Label("sum.endwhile")
+
+	// do { total = total * 2; } while (total < count);
+	b.Label("sum.do")
+	b.Int(2).Read(1).Op(OP_INT_MUL).Store(1)
+	b.Read(0).Read(1).Op(OP_INT_LT).Jump(OP_JUMP_IF_TRUE, "sum.do")
+
+	// return total;
+	b.Read(1).Jump(OP_JUMP, "sum.end")
+	b.End("sum.end", false)
+
+	return b
+}
+
+// Describe(hunit unit)
+func buildSwitchPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Describe")
+
+	// switch (Util.GetMode(unit)) { case MODE_IDLE: ... case MODE_ATTACK: ... default: ... }
+	b.Jump(OP_JUMP, "describe.switch")
+	b.Label("describe.idle")
+	b.String("idle").CallImported("util", "Print", 1).Pop()
+	b.Jump(OP_JUMP, "describe.endswitch")
+	b.Label("describe.attack")
+	b.String("attack").CallImported("util", "Print", 1).Pop()
+	b.Jump(OP_JUMP, "describe.endswitch")
+	b.Label("describe.default")
+	b.String("other").CallImported("util", "Print", 1).Pop()
+	b.Jump(OP_JUMP, "describe.endswitch")
+	b.Label("describe.switch")
+	b.Read(0).CallImported("util", "GetMode", 1)
+	b.Op(OP_CLONE_STACK).Int(0).Op(OP_EQUALS).Jump(OP_JUMP_IF_TRUE, "describe.idle")
+	b.Op(OP_CLONE_STACK).Int(4).Op(OP_EQUALS).Jump(OP_JUMP_IF_TRUE, "describe.attack")
+	b.Jump(OP_JUMP, "describe.default")
+	b.Label("describe.endswitch")
+	b.Pop()
+
+	b.End("describe.end", false)
+
+	return b
+}
+
+// task Patrol(hunit unit)
+func buildSchedulePackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Patrol")
+
+	// start Util.Wander(unit);
+	b.Read(0).StartImported("util", "Wander", 1).Pop()
+
+	// schedule { every 1.0: { if (Util.Distance(unit, Util.FindUnit("base")) > 50.0) break; } every 5.0: { ... } }
+	b.Label("patrol.schedule")
+	b.Op(OP_SCHEDULE_START)
+	b.Every("patrol.every5", 1.0)
+	b.Float(50.0).Read(0).String("base").CallImported("util", "FindUnit", 1).CallImported("util", "Distance", 2)
+	b.Op(OP_FLT_GT).Jump(OP_JUMP_IF_FALSE, "patrol.nobreak")
+	b.Jump(OP_JUMP, "patrol.endschedule")
+	b.Label("patrol.nobreak")
+	b.Label("patrol.every5")
+	b.Every("patrol.loop", 5.0)
+	b.String("patrolling").CallImported("util", "Print", 1).Pop()
+	b.Label("patrol.loop")
+	b.Jump(OP_JUMP, "patrol.schedule")
+	b.Label("patrol.endschedule")
+
+	// Util.Print("done");
+	b.String("done").CallImported("util", "Print", 1).Pop()
+
+	b.End("patrol.end", true)
+
+	return b
+}
+
+// Report(int value)
+func buildAtomicDebugPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Report")
+
+	// debug Util.Print("report");
+	b.Jump(OP_JUMP_IF_NOT_DEBUG, "report.enddebug")
+	b.String("report").CallImported("util", "Print", 1).Pop()
+	b.Label("report.enddebug")
+
+	// atomic { if (value >= 0 && value != 5) Util.Print("valid"); }
+	b.Op(OP_ATOMIC_START)
+	b.Int(5).Read(0).Op(OP_NOT_EQUALS)
+	b.Int(0).Read(0).Op(OP_INT_GT_EQUALS)
+	b.Op(OP_LOGICAL_AND).Jump(OP_JUMP_IF_FALSE, "report.endif")
+	b.String("valid").CallImported("util", "Print", 1).Pop()
+	b.Label("report.endif")
+	b.Op(OP_ATOMIC_STOP)
+
+	b.End("report.end", false)
+
+	return b
+}
+
+// Greet(string name)
+func buildStringsPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Greet").Locals(1)
+	b.InitString(1)
+
+	// message = "Hello";
+	b.String("Hello").StoreString(1)
+
+	// if (name == "") message = "Hello \"stranger\"\n";
+	b.String("").Read(0).Op(OP_STRING_EQUALS).Jump(OP_JUMP_IF_FALSE, "greet.named")
+	b.String("Hello \"stranger\"\n").StoreString(1)
+	b.Label("greet.named")
+
+	// Util.Print(message); Util.Print(name);
+	b.Read(1).CallImported("util", "Print", 1).Pop()
+	b.Read(0).CallImported("util", "Print", 1).Pop()
+
+	b.End("greet.end", false, 1)
+
+	return b
+}
+
+// float Approach(hunit unit, float speed), with a local function and a local task
+func buildCallsPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Approach").Locals(1)
+
+	// distance = Util.Distance(unit, Util.FindUnit("target"));
+	b.Read(0).String("target").CallImported("util", "FindUnit", 1).CallImported("util", "Distance", 2).Store(2)
+
+	// if (distance > speed) distance = Halve(distance);
+	b.Read(1).Read(2).Op(OP_FLT_GT).Jump(OP_JUMP_IF_FALSE, "approach.endif")
+	b.Read(2).Call("Halve", 1).Store(2)
+	b.Label("approach.endif")
+
+	// start Watch(unit);
+	b.Read(0).Start("Watch", 1).Pop()
+
+	// return -(distance * 0.5);
+	b.Float(0.5).Read(2).Op(OP_FLT_MUL).Op(OP_FLT_NEG).Jump(OP_JUMP, "approach.end")
+	b.End("approach.end", false)
+
+	// Halve(float value) returns value / 2.0
+	b.Label("Halve")
+	b.Float(2.0).Read(0).Op(OP_FLT_DIV).Jump(OP_JUMP, "halve.end")
+	b.End("halve.end", false)
+
+	// task Watch(hunit unit) prints when the unit is the target
+	b.Label("Watch")
+	b.String("target").CallImported("util", "FindUnit", 1).Read(0).Op(OP_EQUALS).Jump(OP_JUMP_IF_FALSE, "watch.endif")
+	b.String("watching").CallImported("util", "Print", 1).Pop()
+	b.Label("watch.endif")
+	b.End("watch.end", true)
+
+	return b
+}
+
+// Resets the decompiler and sets every option to its command line default, writing the files to the directory
+func resetForTest(dir string) {
+	ResetState()
+
+	INCLUDES_DIR = GOLDEN_INCLUDES_DIR
+	INPUT_FILE = filepath.Join(dir, "test.pkg")
+	OUTPUT_FILE = filepath.Join(dir, "test.pog")
+	OUTPUT_ASSEMBLY = false
+	ASSEMBLY_ONLY = false
+	ASSEMBLY_OFFSET_PREFIX = true
+	DEBUG_LOGGING = false
+	INSERT_HANDLE_CASTS = true
+	SIMPLIFY_RULES = "all"
+	ELSE_IF_CHAINS = true
+	GUARD_CLAUSES = false
+	DEAD_CODE_COMMENTS = false
+	DEAD_CODE_REPORT_FILE = ""
+	SOURCE_MAP_FILE = ""
+	HTML_OUTPUT_DIR = ""
+	JSON_AST_FILE = ""
+	STYLE_FILE = ""
+	METRICS_FILE = ""
+	INSTRUMENT = ""
+	INSTRUMENT_INCLUDE = ""
+	INSTRUMENT_EXCLUDE = ""
+	INSTRUMENT_TAG = ""
+	DEBUG_BLOCKS = DEBUG_BLOCKS_KEEP
+	DEBUG_REPORT_FILE = ""
+	TASK_REPORT_FILE = ""
+	SCHEDULE_REPORT_FILE = ""
+	ATOMIC_COMMENTS = false
+	ATOMIC_BLOCKING = ""
+	FINGERPRINTS_FILE = ""
+	NAMES_FILE = ""
+	STRINGS_FORMAT = ""
+	STRINGS_CHARSET = STRINGS_CHARSET_WINDOWS_1252
+	DIAGNOSTICS_FILE = ""
+	DIAGNOSTICS_FORMAT = DIAGNOSTICS_FORMAT_TEXT
+	DIAGNOSTICS_FAIL_ON = SEVERITY_ERROR.String()
+}
+
+// Decompiles a built package with the options already set and returns the pog output
+func decompileBuiltPackage(t *testing.T, b *pkgBuilder) string {
+	t.Helper()
+	return decompilePackageBytes(t, b.Bytes())
+}
+
+// Decompiles the bytes of a package with the options already set and returns the pog output
+func decompilePackageBytes(t *testing.T, data []byte) string {
+	t.Helper()
+
+	err := os.WriteFile(INPUT_FILE, data, 0644)
+	if err != nil {
+		t.Fatalf("failed to write package: %v", err)
+	}
+
+	Decompile()
+
+	for _, d := range DIAGNOSTICS {
+		t.Logf("%s", d)
+	}
+	if GetExitCode() != 0 {
+		t.Errorf("decompiling raised diagnostics at or above %s", DIAGNOSTICS_FAIL_ON)
+	}
+
+	output, err := os.ReadFile(OUTPUT_FILE)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+
+	return string(output)
+}
+
+// Decompiles a built package with the default options and returns the pog output
+func decompileTestPackage(t *testing.T, b *pkgBuilder) string {
+	t.Helper()
+
+	resetForTest(t.TempDir())
+	return decompileBuiltPackage(t, b)
+}
+
+// Compares the output against the named golden file, or rewrites the golden file with -update
+func compareGolden(t *testing.T, name string, output string) {
+	t.Helper()
+
+	goldenFile := filepath.Join(GOLDEN_DIR, name+".pog")
+
+	if *update {
+		err := os.WriteFile(goldenFile, []byte(output), 0644)
+		if err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run with -update to create it: %v", err)
+	}
+
+	if output != string(expected) {
+		t.Errorf("output does not match %s, run with -update if the change is intended\n--- expected\n%s\n--- actual\n%s", goldenFile, expected, output)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	for _, gc := range goldenCases {
+		t.Run(gc.name, func(t *testing.T) {
+			compareGolden(t, gc.name, decompileTestPackage(t, gc.build()))
+		})
+	}
+}
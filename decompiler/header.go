@@ -233,7 +233,7 @@ func parsePackageHandles(contents []byte, pkg *PackageInfo) {
 		baseType := strings.TrimSpace(parts[1])
 
 		if !IsValidIdentifier(typeName) || !IsValidIdentifier(baseType) {
-			fmt.Printf("ERROR: Failed to parse package %s handle definition '%s', invalid identifier.\n", pkg.name, all[ii])
+			DiagnoseInPackage(DIAG_INVALID_HANDLE, pkg.name, "Failed to parse handle definition '%s', invalid identifier", all[ii])
 			continue
 		}
 
@@ -265,7 +265,7 @@ func parsePackageDependencies(contents []byte, pkg *PackageInfo) {
 		for _, dep := range deps {
 			dep = strings.TrimSpace(dep)
 			if !IsValidIdentifier(dep) {
-				fmt.Printf("ERROR: Failed to parse package %s dependency list '%s', invalid identifier %s.\n", pkg.name, all[ii], dep)
+				DiagnoseInPackage(DIAG_INVALID_DEPENDENCY, pkg.name, "Failed to parse dependency list '%s', invalid identifier %s", all[ii], dep)
 				continue
 			}
 			pkg.dependencies[strings.TrimSpace(dep)] = true
@@ -281,6 +281,7 @@ func parsePackageEnums(contents []byte, pkg *PackageInfo) {
 
 	for ii := range all {
 		hasError := false
+		hasCombinedMembers := false
 		enumData := EnumTypeInfo{
 			valueToName: map[uint32]string{},
 			nameToValue: map[string]uint32{},
@@ -293,14 +294,14 @@ func parsePackageEnums(contents []byte, pkg *PackageInfo) {
 		parts := strings.Split(enumDef, "{")
 
 		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
-			fmt.Printf("WARN: Enum name missing for enum in package %s header.\n", pkg.name)
+			DiagnoseInPackage(DIAG_INVALID_ENUM, pkg.name, "Enum name missing for enum in header")
 			continue
 		}
 
 		enumName := strings.TrimSpace(parts[0])
 
 		if !IsValidIdentifier(enumName) {
-			fmt.Printf("WARN: Invalid enum name %s in package %s header.\n", enumName, pkg.name)
+			DiagnoseInPackage(DIAG_INVALID_ENUM, pkg.name, "Invalid enum name %s in header", enumName)
 			continue
 		}
 
@@ -317,6 +318,7 @@ func parsePackageEnums(contents []byte, pkg *PackageInfo) {
 				name = strings.TrimSpace(parts[0])
 				valueStr := strings.TrimSpace(parts[1])
 				if strings.Contains(valueStr, "|") {
+					hasCombinedMembers = true
 					names := strings.Split(valueStr, "|")
 					value = 0
 					for _, name := range names {
@@ -341,7 +343,7 @@ func parsePackageEnums(contents []byte, pkg *PackageInfo) {
 					value = uint32(v)
 				}
 				if err != nil {
-					fmt.Printf("WARN: Failed to parse value for enum %s member %s: %s, %v\n.", enumName, name, valueStr, err)
+					DiagnoseInPackage(DIAG_INVALID_ENUM_MEMBER, pkg.name, "Failed to parse value for enum %s member %s: %s, %v", enumName, name, valueStr, err)
 					hasError = true
 					break
 				}
@@ -349,13 +351,13 @@ func parsePackageEnums(contents []byte, pkg *PackageInfo) {
 				value = nextValue
 				name = strings.TrimSpace(member)
 			} else {
-				fmt.Printf("ERROR: Failed to process member for enum %s.\n", enumName)
+				DiagnoseInPackage(DIAG_INVALID_ENUM_MEMBER, pkg.name, "Failed to process member for enum %s", enumName)
 				hasError = true
 				break
 			}
 
 			if !IsValidIdentifier(name) {
-				fmt.Printf("WARN: Invalid identifier for enum %s member %s.\n.", enumName, name)
+				DiagnoseInPackage(DIAG_INVALID_ENUM_MEMBER, pkg.name, "Invalid identifier for enum %s member %s", enumName, name)
 				hasError = true
 				break
 			}
@@ -366,6 +368,8 @@ func parsePackageEnums(contents []byte, pkg *PackageInfo) {
 		}
 
 		if !hasError {
+			enumData.isFlags = hasCombinedMembers || hasFlagValues(enumData.valueToName)
+
 			// Save off this enum
 			ENUM_MAP[enumName] = enumData
 			pkg.enums[enumName] = true
@@ -389,7 +393,7 @@ func parseInclude(path string) {
 
 	contents, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Printf("ERROR: %s\n", err.Error())
+		DiagnoseInPackage(DIAG_HEADER_PARSE_FAILED, packageName, "%v", err)
 		return
 	}
 
@@ -0,0 +1,232 @@
+package decompiler
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	ANNOTATION_FUNCTION    = "function"
+	ANNOTATION_STATEMENT   = "statement"
+	ANNOTATION_VARIABLE    = "variable"
+	ANNOTATION_CALL        = "call"
+	ANNOTATION_ASSEMBLY    = "assembly"
+	ANNOTATION_INSTRUCTION = "instruction"
+)
+
+// A writer that can attach extra information to ranges of the output, renderers only annotate if the writer supports it
+type AnnotatedWriter interface {
+	CodeWriter
+	BeginAnnotation(kind string, attributes map[string]string)
+	EndAnnotation()
+}
+
+type htmlWriter struct {
+	codeWriter
+	open []string
+}
+
+func NewHTMLWriter(w io.Writer) AnnotatedWriter {
+	return &htmlWriter{
+		codeWriter: codeWriter{
+			ioWrite: w,
+			line:    1,
+			column:  1,
+			textEnd: [2]int{1, 1},
+		},
+	}
+}
+
+// Writes markup without it counting as output, so indentation still happens after it
+func (hw *htmlWriter) appendMarkup(s string) {
+	fmt.Fprint(hw.ioWrite, s)
+}
+
+func (hw *htmlWriter) Append(s string) {
+	hw.handleIndent()
+	hw.internalAppend(html.EscapeString(s))
+}
+
+func (hw *htmlWriter) Appendf(format string, a ...interface{}) {
+	hw.Append(fmt.Sprintf(format, a...))
+}
+
+func renderHTMLAttributes(attributes map[string]string) string {
+	keys := []string{}
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := ""
+	for _, key := range keys {
+		result += fmt.Sprintf(` %s="%s"`, key, html.EscapeString(attributes[key]))
+	}
+	return result
+}
+
+func (hw *htmlWriter) BeginAnnotation(kind string, attributes map[string]string) {
+	hw.handleIndent()
+
+	attrs := renderHTMLAttributes(attributes)
+	switch kind {
+	case ANNOTATION_CALL:
+		hw.appendMarkup(fmt.Sprintf(`<a class="%s"%s>`, kind, attrs))
+		hw.open = append(hw.open, "</a>")
+
+	case ANNOTATION_FUNCTION:
+		hw.appendMarkup(fmt.Sprintf(`<div class="%s"%s>`, kind, attrs))
+		hw.open = append(hw.open, "</div>")
+
+	case ANNOTATION_ASSEMBLY:
+		hw.appendMarkup(fmt.Sprintf(`<details class="%s"%s><summary>Assembly</summary>`, kind, attrs))
+		hw.open = append(hw.open, "</details>")
+
+	default:
+		hw.appendMarkup(fmt.Sprintf(`<span class="%s"%s>`, kind, attrs))
+		hw.open = append(hw.open, "</span>")
+	}
+}
+
+func (hw *htmlWriter) EndAnnotation() {
+	if len(hw.open) == 0 {
+		return
+	}
+	hw.appendMarkup(hw.open[len(hw.open)-1])
+	hw.open = hw.open[:len(hw.open)-1]
+}
+
+func getFunctionAnchor(declaration *FunctionDeclaration) string {
+	// Local functions don't have a package, they are always on the same page
+	if len(declaration.pkg) == 0 || declaration.pkg == EXPORTING_PACKAGE {
+		return "#" + declaration.name
+	}
+	return fmt.Sprintf("%s.html#%s", declaration.pkg, declaration.name)
+}
+
+func getOffsetAttributes(min uint32, max uint32) map[string]string {
+	return map[string]string{
+		"data-start": fmt.Sprint(min),
+		"data-end":   fmt.Sprint(max),
+	}
+}
+
+// Begins an annotation for the node if the writer supports it, returns whether something needs to be ended
+func (node *OpGraph) beginAnnotation(scope *Scope, writer CodeWriter) bool {
+	aw, ok := writer.(AnnotatedWriter)
+	if !ok {
+		return false
+	}
+
+	if node.operation.IsVariable() {
+		v := node.operation.GetVariable(scope)
+		if v == nil {
+			return false
+		}
+		aw.BeginAnnotation(ANNOTATION_VARIABLE, map[string]string{
+			"title":   fmt.Sprintf("%s %s, ID: %d", v.typeName, v.variableName, v.id),
+			"data-id": fmt.Sprint(v.id),
+		})
+		return true
+	}
+
+	if declaration := node.operation.GetFunctionDeclaration(); declaration != nil {
+		aw.BeginAnnotation(ANNOTATION_CALL, map[string]string{
+			"href":  getFunctionAnchor(declaration),
+			"title": renderFunctionDefinitionHeader(declaration),
+		})
+		return true
+	}
+
+	return false
+}
+
+func renderFunctionAssemblyPanel(fd *FunctionDefinition, writer AnnotatedWriter) {
+	writer.BeginAnnotation(ANNOTATION_ASSEMBLY, nil)
+	for idx := fd.startingIndex; idx < len(OPERATIONS); idx++ {
+		operation := OPERATIONS[idx]
+		writer.BeginAnnotation(ANNOTATION_INSTRUCTION, map[string]string{"data-offset": fmt.Sprint(operation.offset)})
+		writer.Appendf("0x%08X ", operation.offset)
+		operation.WriteAssembly(writer)
+		writer.EndAnnotation()
+		writer.Append("\n")
+
+		if operation.opcode == OP_FUNCTION_END {
+			break
+		}
+	}
+	writer.EndAnnotation()
+}
+
+const HTML_STYLE = `
+body { background: #1e1e1e; color: #d4d4d4; font-family: monospace; }
+pre { tab-size: 4; }
+a.call { color: #dcdcaa; text-decoration: none; }
+a.call:hover { text-decoration: underline; }
+span.variable { color: #9cdcfe; cursor: help; }
+details.assembly { color: #808080; margin: 0 0 1em 2em; }
+details.assembly summary { cursor: pointer; }
+.highlight { background: #264f78; }
+`
+
+// Highlights the instructions of the statement under the mouse, or the innermost statement of the instruction
+const HTML_SCRIPT = `
+function clearHighlights() {
+	document.querySelectorAll('.highlight').forEach(e => e.classList.remove('highlight'));
+}
+document.querySelectorAll('div.function').forEach(fn => {
+	const statements = Array.from(fn.querySelectorAll('span.statement'));
+	const instructions = Array.from(fn.querySelectorAll('span.instruction'));
+	statements.forEach(s => {
+		s.addEventListener('mouseover', ev => {
+			ev.stopPropagation();
+			clearHighlights();
+			const start = +s.dataset.start, end = +s.dataset.end;
+			s.classList.add('highlight');
+			instructions.filter(i => +i.dataset.offset >= start && +i.dataset.offset <= end).forEach(i => i.classList.add('highlight'));
+		});
+	});
+	instructions.forEach(i => {
+		i.addEventListener('mouseover', () => {
+			clearHighlights();
+			const offset = +i.dataset.offset;
+			const matches = statements.filter(s => offset >= +s.dataset.start && offset <= +s.dataset.end);
+			matches.sort((a, b) => (a.dataset.end - a.dataset.start) - (b.dataset.end - b.dataset.start));
+			i.classList.add('highlight');
+			if (matches.length > 0) {
+				matches[0].classList.add('highlight');
+			}
+		});
+	});
+	fn.addEventListener('mouseleave', clearHighlights);
+});
+`
+
+func writeHTML() error {
+	filename := filepath.Join(HTML_OUTPUT_DIR, fmt.Sprintf("%s.html", EXPORTING_PACKAGE))
+	fmt.Printf("Writing html: %s\n", filename)
+
+	err := os.MkdirAll(HTML_OUTPUT_DIR, 0755)
+	if err != nil {
+		return err
+	}
+
+	outputFile, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	title := html.EscapeString(EXPORTING_PACKAGE)
+	fmt.Fprintf(outputFile, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>%s</style>\n</head>\n<body>\n<h1>%s</h1>\n<p>%s</p>\n<pre>", title, HTML_STYLE, title, html.EscapeString(strings.TrimSpace(INPUT_FILE)))
+
+	renderPackage(NewHTMLWriter(outputFile))
+
+	fmt.Fprintf(outputFile, "</pre>\n<script>%s</script>\n</body>\n</html>\n", HTML_SCRIPT)
+	return nil
+}
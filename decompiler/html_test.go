@@ -0,0 +1,33 @@
+package decompiler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTMLLocalFunctionLinks(t *testing.T) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	HTML_OUTPUT_DIR = filepath.Join(dir, "html")
+	decompileBuiltPackage(t, buildTasksPackage())
+
+	page, err := os.ReadFile(filepath.Join(HTML_OUTPUT_DIR, EXPORTING_PACKAGE+".html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`id="local_function_0"`,
+		`href="#local_function_0"`,
+		`href="Util.html#Wander"`,
+	} {
+		if !strings.Contains(string(page), expected) {
+			t.Errorf("Missing %s in:\n%s", expected, page)
+		}
+	}
+	if strings.Contains(string(page), `href=".html`) {
+		t.Errorf("Link to a page without a package in:\n%s", page)
+	}
+}
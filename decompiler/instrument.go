@@ -0,0 +1,381 @@
+package decompiler
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Command line options for the debug instrumentation
+var INSTRUMENT string
+var INSTRUMENT_INCLUDE string
+var INSTRUMENT_EXCLUDE string
+var INSTRUMENT_TAG string
+
+const (
+	INSTRUMENT_ENTRY     = "entry"
+	INSTRUMENT_EXIT      = "exit"
+	INSTRUMENT_LOOPS     = "loops"
+	INSTRUMENT_SCHEDULES = "schedules"
+)
+
+var INSTRUMENT_PROBES = []string{
+	INSTRUMENT_ENTRY,
+	INSTRUMENT_EXIT,
+	INSTRUMENT_LOOPS,
+	INSTRUMENT_SCHEDULES,
+}
+
+var INSTRUMENTATION = map[string]bool{}
+
+// Holds the value of a return statement while the exit is logged, so the returned expression is only evaluated once
+const DEBUG_RETURN_VARIABLE = "debug_return_"
+
+// The functions of the Debug package used to print each type of value
+const (
+	DEBUG_PRINT_STRING = "Debug.PrintString"
+	DEBUG_PRINT_INT    = "Debug.PrintInt"
+	DEBUG_PRINT_FLOAT  = "Debug.PrintFloat"
+	DEBUG_PRINT_HANDLE = "Debug.PrintHandle"
+)
+
+// Debug functions that were already reported as missing from the Debug header
+var MISSING_DEBUG_FUNCTIONS = map[string]bool{}
+
+// Enables the instrumentation probes from a comma separated list, "all", "none" and - prefixes work like they do
+// for the simplification rules. The include and exclude filters and the tag are checked at the same time.
+func SetInstrumentation(probes string) error {
+	INSTRUMENTATION = map[string]bool{}
+
+	for _, probe := range strings.Split(probes, ",") {
+		probe = strings.TrimSpace(probe)
+		enable := !strings.HasPrefix(probe, "-")
+		probe = strings.TrimPrefix(probe, "-")
+
+		switch probe {
+		case "", "none":
+			continue
+
+		case "all":
+			for _, p := range INSTRUMENT_PROBES {
+				INSTRUMENTATION[p] = enable
+			}
+
+		default:
+			known := false
+			for _, p := range INSTRUMENT_PROBES {
+				if p == probe {
+					known = true
+					break
+				}
+			}
+			if !known {
+				return fmt.Errorf("unknown instrumentation probe '%s'", probe)
+			}
+			INSTRUMENTATION[probe] = enable
+		}
+	}
+
+	for _, pattern := range append(getInstrumentFilters(INSTRUMENT_INCLUDE), getInstrumentFilters(INSTRUMENT_EXCLUDE)...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid instrumentation filter '%s'", pattern)
+		}
+	}
+
+	// The tag ends up inside string literals, which can't escape everything
+	if strings.ContainsAny(INSTRUMENT_TAG, "\"\\\n\t") {
+		return fmt.Errorf("the instrumentation tag can't have quotes, backslashes, tabs or new lines")
+	}
+
+	return nil
+}
+
+func isInstrumentationEnabled() bool {
+	for _, enabled := range INSTRUMENTATION {
+		if enabled {
+			return true
+		}
+	}
+	return false
+}
+
+func getInstrumentFilters(filters string) []string {
+	result := []string{}
+	for _, filter := range strings.Split(filters, ",") {
+		filter = strings.TrimSpace(filter)
+		if len(filter) > 0 {
+			result = append(result, strings.ToLower(filter))
+		}
+	}
+	return result
+}
+
+// Gets the name of the function used in the debug messages, local functions get the exporting package too
+func getInstrumentedName(declaration *FunctionDeclaration) string {
+	return fmt.Sprintf("%s.%s", EXPORTING_PACKAGE, declaration.name)
+}
+
+// Filters without a . match the package, the others match Package.Function. Both can use * and ? wildcards.
+func matchesInstrumentFilter(filters []string, declaration *FunctionDeclaration) bool {
+	for _, filter := range filters {
+		name := strings.ToLower(getInstrumentedName(declaration))
+		if !strings.Contains(filter, ".") {
+			name = strings.ToLower(EXPORTING_PACKAGE)
+		}
+		if matched, _ := path.Match(filter, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func isInstrumented(declaration *FunctionDeclaration, probe string) bool {
+	if !INSTRUMENTATION[probe] || declaration == nil {
+		return false
+	}
+	includes := getInstrumentFilters(INSTRUMENT_INCLUDE)
+	if len(includes) > 0 && !matchesInstrumentFilter(includes, declaration) {
+		return false
+	}
+	return !matchesInstrumentFilter(getInstrumentFilters(INSTRUMENT_EXCLUDE), declaration)
+}
+
+// Checks the Debug header declares a print function. Without a Debug header there is nothing to check against, so
+// the function is assumed to be there.
+func hasDebugFunction(name string) bool {
+	if _, ok := PACKAGES["debug"]; !ok {
+		return true
+	}
+	if _, ok := FUNC_DECLARATIONS[name]; ok {
+		return true
+	}
+	if !MISSING_DEBUG_FUNCTIONS[name] {
+		MISSING_DEBUG_FUNCTIONS[name] = true
+		Diagnose(DIAG_DEBUG_FUNCTION_NOT_FOUND, "The Debug header doesn't declare %s, the values it would print are logged as ?", name)
+	}
+	return false
+}
+
+// Part of a debug message, either text or a value that is printed with the Debug function for its type
+type debugMessagePart struct {
+	text     string
+	value    string
+	typeName string
+}
+
+func debugText(format string, args ...interface{}) debugMessagePart {
+	return debugMessagePart{text: fmt.Sprintf(format, args...)}
+}
+
+func debugValue(value string, typeName string) debugMessagePart {
+	return debugMessagePart{value: value, typeName: typeName}
+}
+
+func writeDebugCall(writer CodeWriter, function string, argument string) {
+	writer.Append(function)
+	printOpenParen(writer, false)
+	writer.Append(argument)
+	printCloseParen(writer, false)
+	writer.Append(";\n")
+}
+
+func writeDebugString(writer CodeWriter, text string) {
+	writeDebugCall(writer, DEBUG_PRINT_STRING, fmt.Sprintf(`"%s"`, strings.ReplaceAll(text, "\n", `\n`)))
+}
+
+// Prints a value by its type. Enums and bools print the name of their value, since there is no way to print an
+// enum as a number without knowing how the game declares the Debug functions.
+func writeDebugValue(writer CodeWriter, value string, typeName string) {
+	function := ""
+	switch {
+	case typeName == "string":
+		function = DEBUG_PRINT_STRING
+	case typeName == "int":
+		function = DEBUG_PRINT_INT
+	case typeName == "float":
+		function = DEBUG_PRINT_FLOAT
+	case IsHandleType(typeName):
+		function = DEBUG_PRINT_HANDLE
+
+	case typeName == "bool":
+		writeDebugIfChain(writer, []string{value}, []string{"true"}, "false")
+		return
+
+	case IsEnumType(typeName):
+		enum := ENUM_MAP[typeName]
+		values := []uint32{}
+		for v := range enum.valueToName {
+			values = append(values, v)
+		}
+		sort.Slice(values, func(i, j int) bool {
+			return values[i] < values[j]
+		})
+
+		conditions := []string{}
+		names := []string{}
+		for _, v := range values {
+			conditions = append(conditions, fmt.Sprintf("%s == %s", value, enum.valueToName[v]))
+			names = append(names, enum.valueToName[v])
+		}
+		writeDebugIfChain(writer, conditions, names, "?")
+		return
+	}
+
+	if len(function) > 0 && hasDebugFunction(function) {
+		writeDebugCall(writer, function, value)
+	} else {
+		writeDebugString(writer, "?")
+	}
+}
+
+// Prints the text of the first condition that is true, or the fallback if none of them are
+func writeDebugIfChain(writer CodeWriter, conditions []string, texts []string, fallback string) {
+	for ii, condition := range conditions {
+		if ii > 0 {
+			writer.Append("else ")
+		}
+		writer.Append("if ")
+		printOpenParen(writer, false)
+		writer.Append(condition)
+		printCloseParen(writer, false)
+		printOpenBlock(writer, "")
+		writeDebugString(writer, texts[ii])
+		printCloseBlock(writer, true)
+	}
+	if len(conditions) > 0 {
+		writer.Append("else")
+		printOpenBlock(writer, "")
+		writeDebugString(writer, fallback)
+		printCloseBlock(writer, false)
+	} else {
+		writeDebugString(writer, fallback)
+	}
+}
+
+// Writes a message as a debug atomic block, so it is left out of release builds and other tasks can't print in
+// the middle of it. The tag goes at the start of the message and neighbouring text is merged into one print.
+func writeDebugMessage(writer CodeWriter, parts ...debugMessagePart) {
+	if len(INSTRUMENT_TAG) > 0 {
+		parts = append([]debugMessagePart{debugText("[%s] ", INSTRUMENT_TAG)}, parts...)
+	}
+
+	merged := []debugMessagePart{}
+	for _, part := range parts {
+		last := len(merged) - 1
+		if len(part.value) == 0 && last >= 0 && len(merged[last].value) == 0 {
+			merged[last].text += part.text
+		} else {
+			merged = append(merged, part)
+		}
+	}
+
+	if len(merged) == 1 && len(merged[0].value) == 0 {
+		writer.Append("debug atomic ")
+		writeDebugString(writer, merged[0].text)
+		return
+	}
+
+	writer.Append("debug atomic")
+	printOpenBlock(writer, "")
+	for _, part := range merged {
+		if len(part.value) > 0 {
+			writeDebugValue(writer, part.value, part.typeName)
+		} else {
+			writeDebugString(writer, part.text)
+		}
+	}
+	printCloseBlock(writer, false)
+}
+
+// Logs the function name and the value of each parameter at the start of the function
+func renderEntryInstrumentation(fd *FunctionDefinition, writer CodeWriter) {
+	declaration := fd.declaration
+	parts := []debugMessagePart{debugText("%s(", getInstrumentedName(declaration))}
+	if declaration.HasParameters() {
+		for ii, p := range *declaration.parameters {
+			if ii > 0 {
+				parts = append(parts, debugText(","))
+			}
+			parts = append(parts, debugText(" %s = ", p.parameterName), debugValue(p.parameterName, p.typeName))
+		}
+		parts = append(parts, debugText(" "))
+	}
+	parts = append(parts, debugText(")\n"))
+
+	writeDebugMessage(writer, parts...)
+	if len(fd.body) > 0 {
+		printBlankLines(writer)
+	}
+}
+
+// Whether the returned values of a function are logged, which needs a local variable to hold them
+func hasDebugReturnVariable(declaration *FunctionDeclaration) bool {
+	if !isInstrumented(declaration, INSTRUMENT_EXIT) || !declaration.ReturnsNonVoid() {
+		return false
+	}
+	returnType := declaration.returnInfo.typeName
+	return returnType != UNKNOWN_TYPE && returnType != "task"
+}
+
+func writeDebugReturnVariableDeclaration(declaration *FunctionDeclaration, writer CodeWriter) bool {
+	if !hasDebugReturnVariable(declaration) {
+		return false
+	}
+	writer.Appendf("%s %s;\n", declaration.returnInfo.typeName, DEBUG_RETURN_VARIABLE)
+	return true
+}
+
+func writeExitMessage(declaration *FunctionDeclaration, writer CodeWriter, returnValue bool) {
+	if returnValue {
+		writeDebugMessage(writer,
+			debugText("%s returns ", getInstrumentedName(declaration)),
+			debugValue(DEBUG_RETURN_VARIABLE, declaration.returnInfo.typeName),
+			debugText("\n"))
+	} else {
+		writeDebugMessage(writer, debugText("%s returns\n", getInstrumentedName(declaration)))
+	}
+}
+
+// Logs the exit before a return statement. Returned values are stored in a variable first so they can be logged,
+// in which case the return is rendered here and true is returned.
+func renderReturnInstrumentation(e BlockElement, scope *Scope, writer CodeWriter) bool {
+	if !isReturnStatement(e) || !isInstrumented(scope.function, INSTRUMENT_EXIT) {
+		return false
+	}
+
+	graph := e.(*Statement).graph
+	if len(graph.children) == 1 && hasDebugReturnVariable(scope.function) {
+		writer.Appendf("%s = ", DEBUG_RETURN_VARIABLE)
+		graph.children[0].Render(scope, writer, true)
+		writer.Append(";\n")
+		writeExitMessage(scope.function, writer, true)
+		writer.Appendf("return %s", DEBUG_RETURN_VARIABLE)
+		return true
+	}
+
+	writeExitMessage(scope.function, writer, false)
+	return false
+}
+
+// Logs the exit of functions that run off the end of their body rather than returning
+func renderEndInstrumentation(fd *FunctionDefinition, writer CodeWriter) {
+	if !isInstrumented(fd.declaration, INSTRUMENT_EXIT) || endsInReturn(fd.body) {
+		return
+	}
+	if len(fd.body) > 0 {
+		printBlankLines(writer)
+	}
+	writeExitMessage(fd.declaration, writer, false)
+}
+
+// Logs each time around a loop or each time an every block of a schedule runs
+func renderBlockInstrumentation(probe string, e BlockElement, scope *Scope, writer CodeWriter, description string) {
+	if !isInstrumented(scope.function, probe) {
+		return
+	}
+	if min, _, ok := GetElementOffsetRange(e); ok {
+		description += fmt.Sprintf(" at 0x%08X", min)
+	}
+	writeDebugMessage(writer, debugText("%s %s\n", getInstrumentedName(scope.function), description))
+}
@@ -0,0 +1,102 @@
+package decompiler
+
+import (
+	"strings"
+	"testing"
+)
+
+// Announce(eMode mode, bool loud)
+func buildAnnouncePackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Announce")
+
+	// if (loud) Util.Print("announce");
+	b.Read(1).Jump(OP_JUMP_IF_FALSE, "announce.endif")
+	b.String("announce").CallImported("util", "Print", 1).Pop()
+	b.Label("announce.endif")
+
+	b.End("announce.end", false)
+
+	return b
+}
+
+// Instrumented packages are compared against their own golden files, -update rewrites them too
+var instrumentCases = []goldenCase{
+	{name: "instrument-loops", build: buildLoopsPackage},
+	{name: "instrument-schedule", build: buildSchedulePackage},
+	{name: "instrument-calls", build: buildCallsPackage},
+	{name: "instrument-announce", build: buildAnnouncePackage},
+}
+
+func TestInstrumentation(t *testing.T) {
+	for _, gc := range instrumentCases {
+		t.Run(gc.name, func(t *testing.T) {
+			resetForTest(t.TempDir())
+			INSTRUMENT = "all"
+			INSTRUMENT_TAG = "run1"
+			compareGolden(t, gc.name, decompileBuiltPackage(t, gc.build()))
+		})
+	}
+}
+
+func TestInstrumentationFilters(t *testing.T) {
+	resetForTest(t.TempDir())
+	INSTRUMENT = "all,-exit"
+	INSTRUMENT_INCLUDE = "golden"
+	INSTRUMENT_EXCLUDE = "Golden.local_function_*"
+	output := decompileBuiltPackage(t, buildCallsPackage())
+
+	if !strings.Contains(output, `Debug.PrintString( "Golden.Approach( unit_ = " );`) {
+		t.Errorf("expected the entry of Approach to be logged:\n%s", output)
+	}
+	if strings.Contains(output, `"Golden.local_function_`) || strings.Contains(output, "returns") {
+		t.Errorf("expected only the entry of Approach to be logged:\n%s", output)
+	}
+
+	resetForTest(t.TempDir())
+	INSTRUMENT = "entry"
+	INSTRUMENT_INCLUDE = "Other.*"
+	output = decompileBuiltPackage(t, buildCallsPackage())
+	if strings.Contains(output, "Debug.") {
+		t.Errorf("expected nothing to be logged for another package:\n%s", output)
+	}
+}
+
+func TestInstrumentationOptions(t *testing.T) {
+	for _, c := range []struct {
+		probes string
+		filter string
+		tag    string
+	}{
+		{probes: "entry,ticks"},
+		{probes: "entry", filter: "Golden.[Cl"},
+		{probes: "entry", tag: `say "hi"`},
+	} {
+		INSTRUMENT_INCLUDE = c.filter
+		INSTRUMENT_TAG = c.tag
+		if err := SetInstrumentation(c.probes); err == nil {
+			t.Errorf("expected %+v to be rejected", c)
+		}
+	}
+	INSTRUMENT_INCLUDE = ""
+	INSTRUMENT_TAG = ""
+}
+
+func TestMissingDebugFunction(t *testing.T) {
+	resetForTest(t.TempDir())
+	LoadDeclarationsFromHeaders(GOLDEN_INCLUDES_DIR)
+	delete(FUNC_DECLARATIONS, DEBUG_PRINT_HANDLE)
+
+	var sb strings.Builder
+	writer := NewCodeWriter(&sb)
+	writeDebugValue(writer, "unit_", "hunit")
+	writeDebugValue(writer, "other_", "hunit")
+
+	if sb.String() != "Debug.PrintString( \"?\" );\nDebug.PrintString( \"?\" );\n" {
+		t.Errorf("expected the handles to be printed as ?, got:\n%s", sb.String())
+	}
+	if len(DIAGNOSTICS) != 1 || DIAGNOSTICS[0].Code != DIAG_DEBUG_FUNCTION_NOT_FOUND {
+		t.Errorf("expected one %s diagnostic, got %v", DIAG_DEBUG_FUNCTION_NOT_FOUND, DIAGNOSTICS)
+	}
+}
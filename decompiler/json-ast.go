@@ -0,0 +1,278 @@
+package decompiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Bump this whenever the layout of the exported tree changes in a way that could break consumers
+const JSON_AST_SCHEMA_VERSION = 1
+
+type JSONVariable struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type JSONExpression struct {
+	Op       string            `json:"op"`
+	Offset   uint32            `json:"offset"`
+	Type     string            `json:"type,omitempty"`
+	Code     string            `json:"code,omitempty"`
+	Value    interface{}       `json:"value,omitempty"`
+	Variable *JSONVariable     `json:"variable,omitempty"`
+	Function string            `json:"function,omitempty"`
+	Operands []*JSONExpression `json:"operands,omitempty"`
+}
+
+type JSONNode struct {
+	Kind        string          `json:"kind"`
+	StartOffset uint32          `json:"startOffset"`
+	EndOffset   uint32          `json:"endOffset"`
+	Text        string          `json:"text,omitempty"`
+	Expression  *JSONExpression `json:"expression,omitempty"`
+	Condition   *JSONExpression `json:"condition,omitempty"`
+	Init        *JSONExpression `json:"init,omitempty"`
+	Increment   *JSONExpression `json:"increment,omitempty"`
+	Interval    *float32        `json:"interval,omitempty"`
+	Middle      *uint32         `json:"middle,omitempty"`
+	Value       *int32          `json:"value,omitempty"`
+	ValueCode   string          `json:"valueCode,omitempty"`
+	IsDefault   bool            `json:"isDefault,omitempty"`
+	Body        []*JSONNode     `json:"body,omitempty"`
+}
+
+type JSONFunction struct {
+	Name        string         `json:"name"`
+	ScopedName  string         `json:"scopedName"`
+	ReturnType  string         `json:"returnType"`
+	Parameters  []JSONVariable `json:"parameters"`
+	Locals      []JSONVariable `json:"locals"`
+	StartOffset uint32         `json:"startOffset"`
+	EndOffset   uint32         `json:"endOffset"`
+	Body        []*JSONNode    `json:"body"`
+}
+
+type JSONPackage struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	Package       string         `json:"package"`
+	Source        string         `json:"source"`
+	Imports       []string       `json:"imports"`
+	Functions     []JSONFunction `json:"functions"`
+}
+
+func newJSONVariable(v *Variable) *JSONVariable {
+	return &JSONVariable{
+		ID:   v.id,
+		Name: v.variableName,
+		Type: v.typeName,
+	}
+}
+
+func getLiteralValue(operation *Operation) interface{} {
+	switch operation.opcode {
+	case OP_LITERAL_ONE:
+		return 1
+	case OP_LITERAL_ZERO:
+		return 0
+	case OP_LITERAL_BYTE:
+		return operation.data.(LiteralByteData).value
+	case OP_LITERAL_SHORT:
+		return operation.data.(LiteralShortData).value
+	case OP_LITERAL_INT:
+		return operation.data.(LiteralIntData).value
+	case OP_LITERAL_FLT:
+		return operation.data.(LiteralFloatData).value
+	case OP_LITERAL_STRING:
+		return STRING_TABLE[operation.data.(LiteralStringData).index]
+	}
+	return nil
+}
+
+// Operands are listed in the order they appear in the source, which for calls is the reverse of the graph order
+func (og *OpGraph) ToJSON(scope *Scope) *JSONExpression {
+	result := &JSONExpression{
+		Op:     OP_MAP[og.operation.opcode].name,
+		Offset: og.operation.offset,
+		Type:   og.typeName,
+		Value:  getLiteralValue(og.operation),
+	}
+
+	if og.code != nil {
+		result.Code = strings.TrimSpace(*og.code)
+	}
+
+	if og.operation.IsVariable() {
+		if v := og.operation.GetVariable(scope); v != nil {
+			result.Variable = newJSONVariable(v)
+		}
+	}
+
+	if declaration := og.operation.GetFunctionDeclaration(); declaration != nil {
+		result.Function = declaration.GetScopedName()
+	}
+
+	if og.ShouldRenderBeforeChidlren() {
+		for ii := len(og.children) - 1; ii >= 0; ii-- {
+			result.Operands = append(result.Operands, og.children[ii].ToJSON(scope))
+		}
+	} else {
+		for _, child := range og.children {
+			result.Operands = append(result.Operands, child.ToJSON(scope))
+		}
+	}
+
+	return result
+}
+
+func statementToJSON(s *Statement, scope *Scope) *JSONExpression {
+	if s == nil {
+		return nil
+	}
+	return s.graph.ToJSON(scope)
+}
+
+func getBlockElementKind(e BlockElement) string {
+	switch e.(type) {
+	case *Statement:
+		return "statement"
+	case *IfBlock:
+		return "if"
+	case *ElseIfBlock:
+		return "elseIf"
+	case *ElseBlock:
+		return "else"
+	case *DebugBlock:
+		return "debug"
+	case *AtomicBlock:
+		return "atomic"
+	case *ScheduleBlock:
+		return "schedule"
+	case *ScheduleEveryBlock:
+		return "every"
+	case *WhileLoop:
+		return "while"
+	case *DoWhileLoop:
+		return "doWhile"
+	case *ForLoop:
+		return "for"
+	case *SwitchBlock:
+		return "switch"
+	case *CaseBlock:
+		return "case"
+	}
+	return "unknown"
+}
+
+func BlockElementToJSON(e BlockElement, scope *Scope) *JSONNode {
+	result := &JSONNode{
+		Kind: getBlockElementKind(e),
+	}
+	result.StartOffset, result.EndOffset, _ = GetElementOffsetRange(e)
+
+	switch b := e.(type) {
+	case *Statement:
+		var sb strings.Builder
+		b.Render(scope, NewCodeWriter(&sb))
+		result.Text = sb.String()
+		result.Expression = statementToJSON(b, scope)
+
+	case *IfBlock:
+		result.Condition = statementToJSON(b.conditional, scope)
+
+	case *ElseIfBlock:
+		result.Condition = statementToJSON(b.conditional, scope)
+
+	case *WhileLoop:
+		result.Condition = statementToJSON(b.conditional, scope)
+
+	case *DoWhileLoop:
+		result.Condition = statementToJSON(b.conditional, scope)
+
+	case *ForLoop:
+		result.Init = statementToJSON(b.init, scope)
+		result.Condition = statementToJSON(b.conditional, scope)
+		result.Increment = statementToJSON(b.increment, scope)
+
+	case *SwitchBlock:
+		result.Condition = statementToJSON(b.conditional, scope)
+
+	case *ScheduleEveryBlock:
+		interval := b.interval
+		result.Interval = &interval
+		middle := b.middle
+		result.Middle = &middle
+
+	case *CaseBlock:
+		result.Value = b.value
+		if b.valueCode != nil {
+			result.ValueCode = *b.valueCode
+		}
+		result.IsDefault = b.value == nil
+	}
+
+	for _, body := range GetElementBodies(e) {
+		result.Body = append(result.Body, BlockElementsToJSON(*body, scope)...)
+	}
+
+	return result
+}
+
+func BlockElementsToJSON(elements []BlockElement, scope *Scope) []*JSONNode {
+	result := []*JSONNode{}
+	for _, e := range elements {
+		result = append(result, BlockElementToJSON(e, scope))
+	}
+	return result
+}
+
+func (fd *FunctionDefinition) ToJSON() JSONFunction {
+	result := JSONFunction{
+		Name:        fd.declaration.name,
+		ScopedName:  fd.declaration.GetScopedName(),
+		ReturnType:  fd.declaration.GetReturnType(),
+		Parameters:  []JSONVariable{},
+		Locals:      []JSONVariable{},
+		StartOffset: OPERATIONS[fd.startingIndex].offset,
+		EndOffset:   fd.scope.functionEndOffset,
+		Body:        BlockElementsToJSON(fd.body, fd.scope),
+	}
+
+	for _, p := range *fd.declaration.parameters {
+		result.Parameters = append(result.Parameters, JSONVariable{ID: p.variable.id, Name: p.parameterName, Type: p.typeName})
+	}
+
+	for _, v := range fd.scope.variables[fd.scope.localVariableIndexOffset:] {
+		result.Locals = append(result.Locals, *newJSONVariable(v))
+	}
+
+	return result
+}
+
+func writeJSONAST() error {
+	fmt.Printf("Writing json ast: %s\n", JSON_AST_FILE)
+
+	pkg := JSONPackage{
+		SchemaVersion: JSON_AST_SCHEMA_VERSION,
+		Package:       EXPORTING_PACKAGE,
+		Source:        INPUT_FILE,
+		Imports:       append([]string{}, PACKAGE_IMPORTS...),
+		Functions:     []JSONFunction{},
+	}
+
+	for _, fnc := range DECOMPILED_FUNCS {
+		if fnc.declaration.parameters == nil {
+			continue
+		}
+		pkg.Functions = append(pkg.Functions, fnc.ToJSON())
+	}
+
+	data, err := json.MarshalIndent(pkg, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(JSON_AST_FILE, data, 0644)
+}
@@ -0,0 +1,96 @@
+package decompiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Lists the kind and offset range of every node, indented by depth
+func getJSONNodeOutline(nodes []*JSONNode, depth int) []string {
+	lines := []string{}
+	for _, n := range nodes {
+		lines = append(lines, fmt.Sprintf("%s%s %d-%d", strings.Repeat("\t", depth), n.Kind, n.StartOffset, n.EndOffset))
+		lines = append(lines, getJSONNodeOutline(n.Body, depth+1)...)
+	}
+	return lines
+}
+
+func TestJSONASTSwitch(t *testing.T) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	JSON_AST_FILE = filepath.Join(dir, "test.json")
+	b := buildSwitchPackage()
+	decompileBuiltPackage(t, b)
+
+	data, err := os.ReadFile(JSON_AST_FILE)
+	if err != nil {
+		t.Fatalf("failed to read json ast: %v", err)
+	}
+	var pkg JSONPackage
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		t.Fatalf("failed to parse json ast: %v", err)
+	}
+
+	if pkg.SchemaVersion != JSON_AST_SCHEMA_VERSION || pkg.Package != "Golden" || strings.Join(pkg.Imports, ",") != "Util" {
+		t.Fatalf("unexpected package header: %d %s %v", pkg.SchemaVersion, pkg.Package, pkg.Imports)
+	}
+	if len(pkg.Functions) != 1 {
+		t.Fatalf("expected one function, got %d", len(pkg.Functions))
+	}
+	fnc := pkg.Functions[0]
+	if fnc.ScopedName != "Golden.Describe" || fnc.StartOffset != 0 || fnc.EndOffset != uint32(b.labels["describe.end"]) {
+		t.Errorf("unexpected function %s at %d-%d", fnc.ScopedName, fnc.StartOffset, fnc.EndOffset)
+	}
+	if len(fnc.Parameters) != 1 || fnc.Parameters[0] != (JSONVariable{ID: 0, Name: "unit_", Type: "hunit"}) {
+		t.Errorf("unexpected parameters %v", fnc.Parameters)
+	}
+
+	// Each case starts at its jump target and ends at its break, and the statements in it cover its calls
+	idle, attack, other := b.labels["describe.idle"], b.labels["describe.attack"], b.labels["describe.default"]
+	expected := []string{
+		fmt.Sprintf("switch 0-%d", b.labels["describe.switch"]+5),
+		fmt.Sprintf("\tcase %d-%d", idle, idle+19),
+		fmt.Sprintf("\t\tstatement %d-%d", idle, idle+18),
+		fmt.Sprintf("\t\tstatement %d-%d", idle+19, idle+19),
+		fmt.Sprintf("\tcase %d-%d", attack, attack+19),
+		fmt.Sprintf("\t\tstatement %d-%d", attack, attack+18),
+		fmt.Sprintf("\t\tstatement %d-%d", attack+19, attack+19),
+		fmt.Sprintf("\tcase %d-%d", other, other+18),
+		fmt.Sprintf("\t\tstatement %d-%d", other, other+18),
+	}
+	if outline := getJSONNodeOutline(fnc.Body, 0); strings.Join(outline, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected outline:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(outline, "\n"))
+	}
+
+	// The switch is on the typed result of the call, and the cases carry their enum constants
+	switchNode := fnc.Body[0]
+	call := switchNode.Condition.Operands[0]
+	if call.Op != "OP_FUNCTION_CALL_IMPORTED" || call.Function != "Util.GetMode" || call.Type != "eMode" {
+		t.Errorf("unexpected condition call %s %s %s", call.Op, call.Function, call.Type)
+	}
+	read := call.Operands[0]
+	if read.Op != "OP_VARIABLE_READ" || read.Offset != uint32(b.labels["describe.switch"]) || read.Type != "hunit" || read.Variable == nil || read.Variable.Name != "unit_" {
+		t.Errorf("unexpected call argument %+v", read)
+	}
+	cases := switchNode.Body
+	if *cases[0].Value != 0 || cases[0].ValueCode != "MODE_IDLE" || *cases[1].Value != 4 || cases[1].ValueCode != "MODE_ATTACK" {
+		t.Errorf("unexpected case values %v %s %v %s", *cases[0].Value, cases[0].ValueCode, *cases[1].Value, cases[1].ValueCode)
+	}
+	if cases[2].Value != nil || !cases[2].IsDefault {
+		t.Errorf("expected the last case to be the default")
+	}
+
+	// Statements keep their rendered text and the literal values in their expressions
+	statement := cases[0].Body[0]
+	literal := statement.Expression.Operands[0].Operands[0]
+	if statement.Text != `Util.Print( "idle" )` || literal.Op != "OP_LITERAL_STRING" || literal.Offset != uint32(idle) || literal.Type != "string" || literal.Value != "idle" {
+		t.Errorf("unexpected statement %q with literal %+v", statement.Text, literal)
+	}
+	if cases[0].Body[1].Text != "break" {
+		t.Errorf("expected the case to end with a break, got %q", cases[0].Body[1].Text)
+	}
+}
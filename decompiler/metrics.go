@@ -0,0 +1,332 @@
+package decompiler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+)
+
+const METRICS_VERSION = 1
+
+// Matches the generic names with or without the underscore parameter names get when the names are resolved
+var GENERIC_NAME_REGEX = regexp.MustCompile(`^(param|local)_\d+_?$`)
+
+// Lower is better for every count
+type QualityCounts struct {
+	UnresolvedTypes     int `json:"unresolvedTypes"`
+	DefaultedParameters int `json:"defaultedParameters"`
+	GenericNames        int `json:"genericNames"`
+	UnstructuredRegions int `json:"unstructuredRegions"`
+	CastMismatches      int `json:"castMismatches"`
+	FallbackRenderings  int `json:"fallbackRenderings"`
+}
+
+type namedCount struct {
+	name  string
+	value int
+}
+
+func (qc *QualityCounts) getNamedCounts() []namedCount {
+	return []namedCount{
+		{"unresolved types", qc.UnresolvedTypes},
+		{"defaulted parameters", qc.DefaultedParameters},
+		{"generic names", qc.GenericNames},
+		{"unstructured regions", qc.UnstructuredRegions},
+		{"cast mismatches", qc.CastMismatches},
+		{"fallback renderings", qc.FallbackRenderings},
+	}
+}
+
+func (qc *QualityCounts) Total() int {
+	total := 0
+	for _, c := range qc.getNamedCounts() {
+		total += c.value
+	}
+	return total
+}
+
+func (qc *QualityCounts) Add(other QualityCounts) {
+	qc.UnresolvedTypes += other.UnresolvedTypes
+	qc.DefaultedParameters += other.DefaultedParameters
+	qc.GenericNames += other.GenericNames
+	qc.UnstructuredRegions += other.UnstructuredRegions
+	qc.CastMismatches += other.CastMismatches
+	qc.FallbackRenderings += other.FallbackRenderings
+}
+
+type FunctionMetrics struct {
+	Name      string `json:"name"`
+	Variables int    `json:"variables"`
+	QualityCounts
+}
+
+type PackageMetrics struct {
+	Package   string            `json:"package"`
+	Source    string            `json:"source"`
+	Variables int               `json:"variables"`
+	Totals    QualityCounts     `json:"totals"`
+	Functions []FunctionMetrics `json:"functions"`
+}
+
+type MetricsReport struct {
+	Version  int                        `json:"version"`
+	Packages map[string]*PackageMetrics `json:"packages"`
+}
+
+func countFunctionDiagnostics(fd *FunctionDefinition, codes ...string) int {
+	name := fd.declaration.GetScopedName()
+	count := 0
+	for _, d := range DIAGNOSTICS {
+		if d.Function != name {
+			continue
+		}
+		for _, code := range codes {
+			if d.Code == code {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Counts the jumps that were left in the code as is rather than being turned into a structure. Unreachable code isn't
+// counted, the compiler leaves jumps after returns and breaks that are unreachable in code that is fine.
+func countUnstructuredJumps(fd *FunctionDefinition) int {
+	count := 0
+	ForEachStatement(fd.body, func(s *Statement) {
+		switch s.graph.operation.opcode {
+		case OP_JUMP, OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE, OP_JUMP_IF_NOT_DEBUG:
+			if s.graph.code == nil && !s.graph.IsElseJump() {
+				count++
+			}
+		}
+	})
+	return count
+}
+
+// Counts the nodes that have no code and would be output as their raw operation
+func countFallbackRenderings(fd *FunctionDefinition) int {
+	count := 0
+	ForEachStatement(fd.body, func(s *Statement) {
+		s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+			if node.code == nil && !node.IsElseJump() && RenderOperationCode(node.operation, fd.scope) == nil {
+				count++
+			}
+		})
+	})
+	return count
+}
+
+func (fd *FunctionDefinition) GetMetrics() FunctionMetrics {
+	result := FunctionMetrics{
+		Name:      fd.declaration.GetScopedName(),
+		Variables: len(fd.scope.variables),
+	}
+
+	if fd.declaration.returnInfo.typeName == UNKNOWN_TYPE {
+		result.UnresolvedTypes++
+	}
+	for _, v := range fd.scope.variables {
+		if v.typeName == UNKNOWN_TYPE {
+			result.UnresolvedTypes++
+		}
+		if GENERIC_NAME_REGEX.MatchString(v.variableName) {
+			result.GenericNames++
+		}
+	}
+
+	result.DefaultedParameters = countFunctionDiagnostics(fd, DIAG_PARAMETER_TYPE_DEFAULTED)
+	result.CastMismatches = countFunctionDiagnostics(fd, DIAG_HANDLE_ASSIGNMENT, DIAG_HANDLE_COMPARISON)
+	result.UnstructuredRegions = countUnstructuredJumps(fd)
+	result.FallbackRenderings = countFallbackRenderings(fd)
+
+	return result
+}
+
+func GetPackageMetrics() *PackageMetrics {
+	result := &PackageMetrics{
+		Package:   EXPORTING_PACKAGE,
+		Source:    INPUT_FILE,
+		Functions: []FunctionMetrics{},
+	}
+
+	for _, fnc := range DECOMPILED_FUNCS {
+		if fnc.declaration.parameters == nil {
+			continue
+		}
+		metrics := fnc.GetMetrics()
+		result.Variables += metrics.Variables
+		result.Totals.Add(metrics.QualityCounts)
+		result.Functions = append(result.Functions, metrics)
+	}
+
+	return result
+}
+
+func LoadMetricsReport(filename string) (*MetricsReport, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MetricsReport{}
+	err = json.Unmarshal(data, report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metrics report %s: %v", filename, err)
+	}
+	if report.Packages == nil {
+		report.Packages = map[string]*PackageMetrics{}
+	}
+	return report, nil
+}
+
+// Adds this package to the metrics file, keeping the packages already in it so a whole corpus can share one report
+func writeMetrics() error {
+	fmt.Printf("Writing metrics: %s\n", METRICS_FILE)
+
+	report, err := LoadMetricsReport(METRICS_FILE)
+	if errors.Is(err, fs.ErrNotExist) {
+		report = &MetricsReport{Packages: map[string]*PackageMetrics{}}
+	} else if err != nil {
+		return err
+	}
+	report.Version = METRICS_VERSION
+	report.Packages[EXPORTING_PACKAGE] = GetPackageMetrics()
+
+	data, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(METRICS_FILE, data, 0644)
+}
+
+func formatDelta(before int, after int) string {
+	return fmt.Sprintf("%d -> %d (%+d)", before, after, after-before)
+}
+
+func getSortedPackageNames(reports ...*MetricsReport) []string {
+	names := map[string]bool{}
+	for _, report := range reports {
+		for name := range report.Packages {
+			names[name] = true
+		}
+	}
+
+	result := []string{}
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func mapFunctionMetrics(pkg *PackageMetrics) map[string]FunctionMetrics {
+	result := map[string]FunctionMetrics{}
+	if pkg != nil {
+		for _, fnc := range pkg.Functions {
+			result[fnc.Name] = fnc
+		}
+	}
+	return result
+}
+
+// Prints the differences between two metrics reports, returns whether anything got worse
+func CompareMetricsReports(before *MetricsReport, after *MetricsReport, writer CodeWriter) bool {
+	regressed := false
+	var beforeTotals, afterTotals QualityCounts
+
+	for _, name := range getSortedPackageNames(before, after) {
+		beforePkg := before.Packages[name]
+		afterPkg := after.Packages[name]
+
+		if beforePkg == nil {
+			writer.Appendf("%s: added\n", name)
+			afterTotals.Add(afterPkg.Totals)
+			continue
+		}
+		if afterPkg == nil {
+			writer.Appendf("%s: removed\n", name)
+			beforeTotals.Add(beforePkg.Totals)
+			continue
+		}
+		beforeTotals.Add(beforePkg.Totals)
+		afterTotals.Add(afterPkg.Totals)
+
+		if beforePkg.Totals == afterPkg.Totals {
+			continue
+		}
+
+		writer.Appendf("%s: %s\n", name, formatDelta(beforePkg.Totals.Total(), afterPkg.Totals.Total()))
+		writer.PushIndent()
+
+		beforeCounts := beforePkg.Totals.getNamedCounts()
+		afterCounts := afterPkg.Totals.getNamedCounts()
+		for ii := range beforeCounts {
+			if beforeCounts[ii].value != afterCounts[ii].value {
+				writer.Appendf("%s: %s\n", beforeCounts[ii].name, formatDelta(beforeCounts[ii].value, afterCounts[ii].value))
+			}
+		}
+
+		beforeFunctions := mapFunctionMetrics(beforePkg)
+		afterFunctions := mapFunctionMetrics(afterPkg)
+		functionNames := []string{}
+		for fnName := range afterFunctions {
+			functionNames = append(functionNames, fnName)
+		}
+		sort.Strings(functionNames)
+
+		for _, fnName := range functionNames {
+			beforeFn, ok := beforeFunctions[fnName]
+			if !ok {
+				continue
+			}
+			afterFn := afterFunctions[fnName]
+			if beforeFn.Total() < afterFn.Total() {
+				writer.Appendf("regressed %s: %s\n", fnName, formatDelta(beforeFn.Total(), afterFn.Total()))
+			} else if beforeFn.Total() > afterFn.Total() {
+				writer.Appendf("improved %s: %s\n", fnName, formatDelta(beforeFn.Total(), afterFn.Total()))
+			}
+		}
+
+		writer.PopIndent()
+
+		if afterPkg.Totals.Total() > beforePkg.Totals.Total() {
+			regressed = true
+		}
+	}
+
+	writer.Appendf("Total: %s\n", formatDelta(beforeTotals.Total(), afterTotals.Total()))
+	writer.PushIndent()
+	beforeCounts := beforeTotals.getNamedCounts()
+	afterCounts := afterTotals.getNamedCounts()
+	for ii := range beforeCounts {
+		writer.Appendf("%s: %s\n", beforeCounts[ii].name, formatDelta(beforeCounts[ii].value, afterCounts[ii].value))
+	}
+	writer.PopIndent()
+
+	return regressed
+}
+
+// Runs the compare command, returns the exit code
+func CompareMetrics(beforeFile string, afterFile string) int {
+	before, err := LoadMetricsReport(beforeFile)
+	if err != nil {
+		fmt.Printf("Error: Failed to read metrics: %v\n", err)
+		return EXIT_FATAL
+	}
+	after, err := LoadMetricsReport(afterFile)
+	if err != nil {
+		fmt.Printf("Error: Failed to read metrics: %v\n", err)
+		return EXIT_FATAL
+	}
+
+	if CompareMetricsReports(before, after, NewCodeWriter(os.Stdout)) {
+		return EXIT_THRESHOLD
+	}
+	return EXIT_SUCCESS
+}
@@ -0,0 +1,110 @@
+package decompiler
+
+import (
+	"strings"
+	"testing"
+)
+
+// Report(int value) returns early from an if, with the jump the compiler leaves after the return, and calls
+// Halve(float value), which only gets a generic parameter name
+func buildMetricsPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Report")
+
+	// if (value > 1) { Util.Log(1); return; }
+	b.Int(1).Read(0).Op(OP_INT_GT).Jump(OP_JUMP_IF_FALSE, "report.endif")
+	b.Int(1).CallImported("util", "Log", 1).Pop()
+	b.Jump(OP_JUMP, "report.end")
+	b.Jump(OP_JUMP, "report.endif")
+	b.Label("report.endif")
+
+	// Util.Log(2); Halve(3.0);
+	b.Int(2).CallImported("util", "Log", 1).Pop()
+	b.Float(3.0).Call("Halve", 1).Pop()
+	b.End("report.end", false)
+
+	// Halve(float value) returns value / 2.0
+	b.Label("Halve")
+	b.Float(2.0).Read(0).Op(OP_FLT_DIV).Jump(OP_JUMP, "halve.end")
+	b.End("halve.end", false)
+
+	return b
+}
+
+func TestFunctionMetrics(t *testing.T) {
+	decompileTestPackage(t, buildMetricsPackage())
+
+	metrics := GetPackageMetrics()
+	if len(metrics.Functions) != 2 {
+		t.Fatalf("expected metrics for 2 functions, got %d", len(metrics.Functions))
+	}
+
+	expected := map[string]QualityCounts{
+		"Golden.Report":    {},
+		"local_function_0": {GenericNames: 1},
+	}
+	for _, fnc := range metrics.Functions {
+		if fnc.QualityCounts != expected[fnc.Name] {
+			t.Errorf("expected %s to have %+v, got %+v", fnc.Name, expected[fnc.Name], fnc.QualityCounts)
+		}
+	}
+	if metrics.Totals != (QualityCounts{GenericNames: 1}) {
+		t.Errorf("expected the totals to add up the functions, got %+v", metrics.Totals)
+	}
+
+	// A jump left as it is counts as an unstructured region
+	report := DECOMPILED_FUNCS[0]
+	report.body = append(report.body, &Statement{graph: &OpGraph{operation: &Operation{opcode: OP_JUMP_IF_TRUE}}})
+	if counts := report.GetMetrics().QualityCounts; counts.UnstructuredRegions != 1 {
+		t.Errorf("expected 1 unstructured region, got %+v", counts)
+	}
+}
+
+func TestCompareMetricsReports(t *testing.T) {
+	before := &MetricsReport{Packages: map[string]*PackageMetrics{
+		"Golden": {
+			Totals: QualityCounts{GenericNames: 2, UnresolvedTypes: 1},
+			Functions: []FunctionMetrics{
+				{Name: "Golden.Report", QualityCounts: QualityCounts{UnresolvedTypes: 1}},
+				{Name: "local_function_0", QualityCounts: QualityCounts{GenericNames: 2}},
+			},
+		},
+		"Removed": {Totals: QualityCounts{CastMismatches: 1}},
+	}}
+	after := &MetricsReport{Packages: map[string]*PackageMetrics{
+		"Golden": {
+			Totals: QualityCounts{GenericNames: 1, FallbackRenderings: 3},
+			Functions: []FunctionMetrics{
+				{Name: "Golden.Report", QualityCounts: QualityCounts{FallbackRenderings: 3}},
+				{Name: "local_function_0", QualityCounts: QualityCounts{GenericNames: 1}},
+			},
+		},
+		"Added": {Totals: QualityCounts{DefaultedParameters: 1}},
+	}}
+
+	var sb strings.Builder
+	if !CompareMetricsReports(before, after, NewCodeWriter(&sb)) {
+		t.Errorf("expected the comparison to regress")
+	}
+
+	expected := `Added: added
+Golden: 3 -> 4 (+1)
+	unresolved types: 1 -> 0 (-1)
+	generic names: 2 -> 1 (-1)
+	fallback renderings: 0 -> 3 (+3)
+	regressed Golden.Report: 1 -> 3 (+2)
+	improved local_function_0: 2 -> 1 (-1)
+Removed: removed
+Total: 4 -> 5 (+1)
+	unresolved types: 1 -> 0 (-1)
+	defaulted parameters: 0 -> 1 (+1)
+	generic names: 2 -> 1 (-1)
+	unstructured regions: 0 -> 0 (+0)
+	cast mismatches: 1 -> 0 (-1)
+	fallback renderings: 0 -> 3 (+3)
+`
+	if sb.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, sb.String())
+	}
+}
@@ -13,7 +13,10 @@ type OperationData interface {
 	PopCount() int
 }
 
-type OperationParser func(data []byte, codeOffset uint32) OperationData
+// Counts in the code that are used to size things, no real script comes anywhere near this
+const MAX_DECODED_COUNT = 0xFFFF
+
+type OperationParser func(data []byte, codeOffset uint32) (OperationData, error)
 
 type OperationInfo struct {
 	name     string
@@ -206,8 +209,8 @@ func (d PopStackData) PopCount() int {
 	return 1
 }
 
-func ParsePopStack(data []byte, codeOffset uint32) OperationData {
-	return PopStackData{}
+func ParsePopStack(data []byte, codeOffset uint32) (OperationData, error) {
+	return PopStackData{}, nil
 }
 
 type LiteralInteger interface {
@@ -234,16 +237,16 @@ func (d LiteralBitData) GetValue() int {
 	return d.value
 }
 
-func ParseLiteralZero(data []byte, codeOffset uint32) OperationData {
+func ParseLiteralZero(data []byte, codeOffset uint32) (OperationData, error) {
 	return LiteralBitData{
 		value: 0,
-	}
+	}, nil
 }
 
-func ParseLiteralOne(data []byte, codeOffset uint32) OperationData {
+func ParseLiteralOne(data []byte, codeOffset uint32) (OperationData, error) {
 	return LiteralBitData{
 		value: 1,
-	}
+	}, nil
 }
 
 type LiteralByteData struct {
@@ -266,10 +269,10 @@ func (d LiteralByteData) GetValue() int {
 	return int(d.value)
 }
 
-func ParseLiteralByte(data []byte, codeOffset uint32) OperationData {
+func ParseLiteralByte(data []byte, codeOffset uint32) (OperationData, error) {
 	return LiteralByteData{
 		value: int8(data[0]),
-	}
+	}, nil
 }
 
 type LiteralShortData struct {
@@ -292,10 +295,10 @@ func (d LiteralShortData) GetValue() int {
 	return int(d.value)
 }
 
-func ParseLiteralShort(data []byte, codeOffset uint32) OperationData {
+func ParseLiteralShort(data []byte, codeOffset uint32) (OperationData, error) {
 	return LiteralShortData{
 		value: int16(binary.LittleEndian.Uint16(data)),
-	}
+	}, nil
 }
 
 type LiteralIntData struct {
@@ -318,10 +321,10 @@ func (d LiteralIntData) GetValue() int {
 	return int(d.value)
 }
 
-func ParseLiteralInt(data []byte, codeOffset uint32) OperationData {
+func ParseLiteralInt(data []byte, codeOffset uint32) (OperationData, error) {
 	return LiteralIntData{
 		value: int32(binary.LittleEndian.Uint32(data)),
-	}
+	}, nil
 }
 
 type LiteralFloatData struct {
@@ -340,10 +343,10 @@ func (d LiteralFloatData) PopCount() int {
 	return 0
 }
 
-func ParseLiteralFloat(data []byte, codeOffset uint32) OperationData {
+func ParseLiteralFloat(data []byte, codeOffset uint32) (OperationData, error) {
 	return LiteralFloatData{
 		value: math.Float32frombits(binary.LittleEndian.Uint32(data)),
-	}
+	}, nil
 }
 
 type OperatorData struct {
@@ -361,8 +364,8 @@ func (d OperatorData) PopCount() int {
 	return 2
 }
 
-func ParseOperator(data []byte, codeOffset uint32) OperationData {
-	return OperatorData{}
+func ParseOperator(data []byte, codeOffset uint32) (OperationData, error) {
+	return OperatorData{}, nil
 }
 
 type UnaryOperatorData struct {
@@ -380,8 +383,8 @@ func (d UnaryOperatorData) PopCount() int {
 	return 1
 }
 
-func ParseUnaryOperator(data []byte, codeOffset uint32) OperationData {
-	return UnaryOperatorData{}
+func ParseUnaryOperator(data []byte, codeOffset uint32) (OperationData, error) {
+	return UnaryOperatorData{}, nil
 }
 
 type VariableReadData struct {
@@ -400,10 +403,10 @@ func (d VariableReadData) PopCount() int {
 	return 0
 }
 
-func ParseVariableRead(data []byte, codeOffset uint32) OperationData {
+func ParseVariableRead(data []byte, codeOffset uint32) (OperationData, error) {
 	return VariableReadData{
 		index: binary.LittleEndian.Uint32(data),
-	}
+	}, nil
 }
 
 type VariableWriteData struct {
@@ -422,10 +425,10 @@ func (d VariableWriteData) PopCount() int {
 	return 1
 }
 
-func ParseVariableWrite(data []byte, codeOffset uint32) OperationData {
+func ParseVariableWrite(data []byte, codeOffset uint32) (OperationData, error) {
 	return VariableWriteData{
 		index: binary.LittleEndian.Uint32(data),
-	}
+	}, nil
 }
 
 type CountDataUInt8 struct {
@@ -444,10 +447,10 @@ func (d CountDataUInt8) PopCount() int {
 	return int(d.count)
 }
 
-func ParseCountUInt8(data []byte, codeOffset uint32) OperationData {
+func ParseCountUInt8(data []byte, codeOffset uint32) (OperationData, error) {
 	return CountDataUInt8{
 		count: uint8(data[0]),
-	}
+	}, nil
 }
 
 type CountDataUInt32 struct {
@@ -466,10 +469,14 @@ func (d CountDataUInt32) PopCount() int {
 	return 0
 }
 
-func ParseCountUInt32(data []byte, codeOffset uint32) OperationData {
-	return CountDataUInt32{
-		count: binary.LittleEndian.Uint32(data),
+func ParseCountUInt32(data []byte, codeOffset uint32) (OperationData, error) {
+	count := binary.LittleEndian.Uint32(data)
+	if count > MAX_DECODED_COUNT {
+		return nil, fmt.Errorf("%w: %d", ErrCountOutOfRange, count)
 	}
+	return CountDataUInt32{
+		count: count,
+	}, nil
 }
 
 type JumpData struct {
@@ -494,11 +501,11 @@ func (d JumpData) PopCount() int {
 	return 0
 }
 
-func ParseJump(data []byte, codeOffset uint32) OperationData {
+func ParseJump(data []byte, codeOffset uint32) (OperationData, error) {
 	return JumpData{
 		codeOffset: codeOffset,
 		offset:     binary.LittleEndian.Uint32(data),
-	}
+	}, nil
 }
 
 type ConditionalJumpData struct {
@@ -523,11 +530,11 @@ func (d ConditionalJumpData) PopCount() int {
 	return 1
 }
 
-func ParseConditionalJump(data []byte, codeOffset uint32) OperationData {
+func ParseConditionalJump(data []byte, codeOffset uint32) (OperationData, error) {
 	return ConditionalJumpData{
 		codeOffset: codeOffset,
 		offset:     binary.LittleEndian.Uint32(data),
-	}
+	}, nil
 }
 
 type FunctionCallData struct {
@@ -559,10 +566,14 @@ func (d FunctionCallData) PopCount() int {
 	return len(*d.declaration.parameters)
 }
 
-func ParseFunctionCallLocal(data []byte, codeOffset uint32) OperationData {
+func ParseFunctionCallLocal(data []byte, codeOffset uint32) (OperationData, error) {
 	offset := binary.LittleEndian.Uint32(data[4:8])
 	parameterCount := binary.LittleEndian.Uint32(data[8:12])
 
+	if parameterCount > MAX_DECODED_COUNT {
+		return nil, fmt.Errorf("%w: %d parameters", ErrCountOutOfRange, parameterCount)
+	}
+
 	declaration, ok := FUNC_DEFINITION_MAP[offset]
 
 	if !ok {
@@ -570,9 +581,7 @@ func ParseFunctionCallLocal(data []byte, codeOffset uint32) OperationData {
 	}
 
 	if declaration.parameters != nil && len(*declaration.parameters) != int(parameterCount) {
-		fmt.Printf("WARN: Function prototype in header does not parameter count for function call: %s\n", declaration.GetScopedName())
-		fmt.Printf("    Header parameter count: %d\n", len(*declaration.parameters))
-		fmt.Printf("      Call parameter count: %d\n", parameterCount)
+		DiagnoseAt(DIAG_PARAMETER_COUNT, codeOffset, "Function prototype in header does not match the parameter count for function call %s, header has %d and call has %d", declaration.GetScopedName(), len(*declaration.parameters), parameterCount)
 		declaration.parameters = nil
 	}
 
@@ -589,13 +598,17 @@ func ParseFunctionCallLocal(data []byte, codeOffset uint32) OperationData {
 
 	return FunctionCallData{
 		declaration: declaration,
-	}
+	}, nil
 }
 
-func ParseTaskCallLocal(data []byte, codeOffset uint32) OperationData {
+func ParseTaskCallLocal(data []byte, codeOffset uint32) (OperationData, error) {
 	offset := binary.LittleEndian.Uint32(data[4:8])
 	parameterCount := binary.LittleEndian.Uint32(data[8:12])
 
+	if parameterCount > MAX_DECODED_COUNT {
+		return nil, fmt.Errorf("%w: %d parameters", ErrCountOutOfRange, parameterCount)
+	}
+
 	declaration, ok := FUNC_DEFINITION_MAP[offset]
 
 	if !ok {
@@ -603,9 +616,7 @@ func ParseTaskCallLocal(data []byte, codeOffset uint32) OperationData {
 	}
 
 	if declaration.parameters != nil && len(*declaration.parameters) != int(parameterCount) {
-		fmt.Printf("WARN: Function prototype in header does not parameter count for function call: %s\n", declaration.GetScopedName())
-		fmt.Printf("    Header parameter count: %d\n", len(*declaration.parameters))
-		fmt.Printf("      Call parameter count: %d\n", parameterCount)
+		DiagnoseAt(DIAG_PARAMETER_COUNT, codeOffset, "Function prototype in header does not match the parameter count for function call %s, header has %d and call has %d", declaration.GetScopedName(), len(*declaration.parameters), parameterCount)
 		declaration.parameters = nil
 	}
 
@@ -624,22 +635,27 @@ func ParseTaskCallLocal(data []byte, codeOffset uint32) OperationData {
 
 	return FunctionCallData{
 		declaration: declaration,
-	}
+	}, nil
 }
 
-func ParseFunctionCallImported(data []byte, codeOffset uint32) OperationData {
-	declaration := FUNC_IMPORT_MAP[codeOffset]
+func ParseFunctionCallImported(data []byte, codeOffset uint32) (OperationData, error) {
+	declaration, ok := FUNC_IMPORT_MAP[codeOffset]
+	if !ok {
+		return nil, ErrMissingImport
+	}
 	parameterCount := binary.LittleEndian.Uint32(data[8:12])
 
+	if parameterCount > MAX_DECODED_COUNT {
+		return nil, fmt.Errorf("%w: %d parameters", ErrCountOutOfRange, parameterCount)
+	}
+
 	if declaration.parameters != nil && len(*declaration.parameters) != int(parameterCount) {
-		fmt.Printf("WARN: Function prototype in header does not parameter count for function call: %s\n", declaration.GetScopedName())
-		fmt.Printf("    Header parameter count: %d\n", len(*declaration.parameters))
-		fmt.Printf("      Call parameter count: %d\n", parameterCount)
+		DiagnoseAt(DIAG_PARAMETER_COUNT, codeOffset, "Function prototype in header does not match the parameter count for function call %s, header has %d and call has %d", declaration.GetScopedName(), len(*declaration.parameters), parameterCount)
 		declaration.parameters = nil
 	}
 
 	if declaration.parameters == nil {
-		fmt.Printf("WARN: Failed to load function prototype for imported function %s\n", declaration.GetScopedName())
+		DiagnoseAt(DIAG_PROTOTYPE_NOT_FOUND, codeOffset, "Failed to load function prototype for imported function %s", declaration.GetScopedName())
 		params := make([]FunctionParameter, parameterCount)
 		for ii := 0; ii < len(params); ii++ {
 			p := &params[ii]
@@ -652,13 +668,16 @@ func ParseFunctionCallImported(data []byte, codeOffset uint32) OperationData {
 
 	return FunctionCallData{
 		declaration: declaration,
-	}
+	}, nil
 }
 
-func ParseTaskCallImported(data []byte, codeOffset uint32) OperationData {
-	result := ParseFunctionCallImported(data, codeOffset)
+func ParseTaskCallImported(data []byte, codeOffset uint32) (OperationData, error) {
+	result, err := ParseFunctionCallImported(data, codeOffset)
+	if err != nil {
+		return nil, err
+	}
 	result.(FunctionCallData).declaration.returnInfo.typeName = "task"
-	return result
+	return result, nil
 }
 
 type StringInitData struct {
@@ -677,18 +696,21 @@ func (d StringInitData) PopCount() int {
 	return 0
 }
 
-func ParseStringInit(data []byte, codeOffset uint32) OperationData {
+func ParseStringInit(data []byte, codeOffset uint32) (OperationData, error) {
 	return StringInitData{
 		value: binary.LittleEndian.Uint32(data),
-	}
+	}, nil
 }
 
 type LiteralStringData struct {
 	index uint32
 }
 
+// Backslashes, quotes and line breaks are escaped, the same ones parseAssemblyString reads back
 func (d LiteralStringData) String() string {
-	value := strings.ReplaceAll(STRING_TABLE[d.index], "\n", "\\n")
+	value := strings.ReplaceAll(STRING_TABLE[d.index], "\\", "\\\\")
+	value = strings.ReplaceAll(value, "\"", "\\\"")
+	value = strings.ReplaceAll(value, "\n", "\\n")
 	return fmt.Sprintf("\"%s\"", value)
 }
 
@@ -700,10 +722,14 @@ func (d LiteralStringData) PopCount() int {
 	return 0
 }
 
-func ParseLiteralString(data []byte, codeOffset uint32) OperationData {
-	return LiteralStringData{
-		index: binary.LittleEndian.Uint32(data),
+func ParseLiteralString(data []byte, codeOffset uint32) (OperationData, error) {
+	index := binary.LittleEndian.Uint32(data)
+	if index >= uint32(len(STRING_TABLE)) {
+		return nil, fmt.Errorf("%w: %d, the table has %d strings", ErrStringOutOfRange, index, len(STRING_TABLE))
 	}
+	return LiteralStringData{
+		index: index,
+	}, nil
 }
 
 type EmptyData struct {
@@ -721,8 +747,8 @@ func (d EmptyData) PopCount() int {
 	return 0
 }
 
-func ParseEmpty(data []byte, codeOffset uint32) OperationData {
-	return EmptyData{}
+func ParseEmpty(data []byte, codeOffset uint32) (OperationData, error) {
+	return EmptyData{}, nil
 }
 
 type ScheduleEveryData struct {
@@ -749,11 +775,11 @@ func (d ScheduleEveryData) PopCount() int {
 	return 0
 }
 
-func ParseScheduleEvery(data []byte, codeOffset uint32) OperationData {
+func ParseScheduleEvery(data []byte, codeOffset uint32) (OperationData, error) {
 	return ScheduleEveryData{
 		codeOffset: codeOffset,
 		skipOffset: binary.LittleEndian.Uint32(data[0:4]),
 		middle:     binary.LittleEndian.Uint32(data[4:8]),
 		interval:   math.Float32frombits(binary.LittleEndian.Uint32(data[8:12])),
-	}
+	}, nil
 }
@@ -93,7 +93,7 @@ func GetLiteralIntegerValue(operation *Operation) int32 {
 	case OP_LITERAL_ZERO, OP_LITERAL_ONE, OP_LITERAL_BYTE, OP_LITERAL_SHORT, OP_LITERAL_INT:
 		return int32(operation.data.(LiteralInteger).GetValue())
 	}
-	fmt.Printf("ERROR: Trying to get integer value for non-integer type")
+	DiagnoseAt(DIAG_NOT_AN_INTEGER, operation.offset, "Trying to get integer value for non-integer type")
 	return -1
 }
 
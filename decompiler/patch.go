@@ -0,0 +1,588 @@
+package decompiler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type patchOperation struct {
+	opcode byte
+	data   []byte
+	// The offset in the original package, which is how edits refer to the operation
+	originalOffset uint32
+	// The index of the operation a jump or local call goes to, or -1. Jumps to the end of the code use the number
+	// of operations.
+	target int
+	// The index of the operation whose offset the middle number of an OP_SCHEDULE_EVERY matches, or -1. What the
+	// number means is unknown, so it is never changed, but moving that operation is reported.
+	middleTarget int
+}
+
+type patchImportedFunction struct {
+	name string
+	// The indices of the calls to the function
+	calls []int
+}
+
+type patchImport struct {
+	name      string
+	functions []*patchImportedFunction
+}
+
+type patchExport struct {
+	name      string
+	operation int
+}
+
+// A pkg file decoded far enough to edit it. Everything that refers to code is kept as operation indices, so
+// operations can change size and the offsets are worked out again when the package is written.
+type PackagePatch struct {
+	formType   []byte
+	sections   []*PackageSection
+	imports    []*patchImport
+	exports    []*patchExport
+	operations []*patchOperation
+	// The operation index of each offset in the original code
+	offsetToIndex map[uint32]int
+	strings       []string
+	// The STAB section is only written again when strings were added, so it stays as it was otherwise
+	stringsChanged bool
+}
+
+// Gets the byte in the operation data holding the code offset the operation refers to, or -1
+func getOperationTargetPosition(opcode byte) int {
+	switch opcode {
+	case OP_JUMP, OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE, OP_JUMP_IF_NOT_DEBUG, OP_SCHEDULE_EVERY:
+		return 0
+	case OP_FUNCTION_CALL_LOCAL, OP_TASK_CALL_LOCAL:
+		return 4
+	}
+	return -1
+}
+
+func (p *PackagePatch) getOperationIndex(offset uint32) (int, error) {
+	idx, ok := p.offsetToIndex[offset]
+	if !ok || idx == len(p.operations) {
+		return 0, fmt.Errorf("0x%08X isn't the start of an operation", offset)
+	}
+	return idx, nil
+}
+
+func (p *PackagePatch) decodeCode(code []byte) error {
+	codeLength := uint32(len(code))
+	for offset := uint32(0); offset < codeLength; {
+		opInfo, ok := OP_MAP[code[offset]]
+		if !ok {
+			return &PkgError{Section: "CODE", Offset: offset, Err: ErrUnknownOpcode, Detail: fmt.Sprintf("0x%02X", code[offset])}
+		}
+		dataSize := uint32(opInfo.dataSize)
+		if dataSize > codeLength-offset-1 {
+			return &PkgError{Section: "CODE", Offset: offset, Err: ErrTruncated, Detail: fmt.Sprintf("%s needs %d bytes of data", opInfo.name, dataSize)}
+		}
+
+		p.offsetToIndex[offset] = len(p.operations)
+		p.operations = append(p.operations, &patchOperation{
+			opcode:         code[offset],
+			data:           append([]byte{}, code[offset+1:offset+1+dataSize]...),
+			originalOffset: offset,
+			target:         -1,
+			middleTarget:   -1,
+		})
+		offset += 1 + dataSize
+	}
+	p.offsetToIndex[codeLength] = len(p.operations)
+
+	for _, op := range p.operations {
+		if op.opcode == OP_SCHEDULE_EVERY {
+			if middle := binary.LittleEndian.Uint32(op.data[4:8]); middle != 0 {
+				if idx, ok := p.offsetToIndex[middle]; ok {
+					op.middleTarget = idx
+				}
+			}
+		}
+
+		position := getOperationTargetPosition(op.opcode)
+		if position < 0 {
+			continue
+		}
+		target := binary.LittleEndian.Uint32(op.data[position:])
+		idx, ok := p.offsetToIndex[target]
+		if !ok {
+			return &PkgError{Section: "CODE", Offset: op.originalOffset, Err: ErrOffsetOutOfRange, Detail: fmt.Sprintf("target 0x%08X isn't the start of an operation", target)}
+		}
+		op.target = idx
+	}
+	return nil
+}
+
+// Decodes a pkg file for patching
+func LoadPackagePatch(data []byte) (*PackagePatch, error) {
+	formType, sections, err := splitPackageSections(data)
+	if err != nil {
+		return nil, err
+	}
+	p := &PackagePatch{formType: formType, sections: sections, offsetToIndex: map[uint32]int{}}
+
+	for _, section := range sections {
+		if section.Identifier != "CODE" {
+			continue
+		}
+		reader := newPkgReader(section.Data)
+		length, err := reader.ReadUInt32BigEndian()
+		if err != nil {
+			return nil, err
+		}
+		code, err := reader.ReadBytes(length)
+		if err != nil {
+			return nil, err
+		}
+		err = p.decodeCode(code)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The import and export offsets can only be turned into operations once the code is decoded
+	for _, section := range sections {
+		reader := newPkgReader(section.Data)
+		switch section.Identifier {
+		case "PIMP":
+			name, err := reader.ReadString()
+			if err != nil {
+				return nil, err
+			}
+			p.imports = append(p.imports, &patchImport{name: name})
+
+		case "FIMP":
+			if len(p.imports) == 0 {
+				return nil, &PkgError{Section: "FIMP", Err: ErrUnexpectedSection, Detail: "function import before any package import"}
+			}
+			name, err := reader.ReadString()
+			if err != nil {
+				return nil, err
+			}
+			count, err := reader.ReadUInt32BigEndian()
+			if err != nil {
+				return nil, err
+			}
+			fnc := &patchImportedFunction{name: name}
+			for ii := uint32(0); ii < count; ii++ {
+				offset, err := reader.ReadUInt32BigEndian()
+				if err != nil {
+					return nil, err
+				}
+				idx, err := p.getOperationIndex(offset)
+				if err != nil {
+					return nil, fmt.Errorf("import of %s: %v", name, err)
+				}
+				fnc.calls = append(fnc.calls, idx)
+			}
+			imp := p.imports[len(p.imports)-1]
+			imp.functions = append(imp.functions, fnc)
+
+		case "STAB":
+			count, err := reader.ReadUInt32BigEndian()
+			if err != nil {
+				return nil, err
+			}
+			for ii := uint32(0); ii < count; ii++ {
+				value, err := reader.ReadString()
+				if err != nil {
+					return nil, err
+				}
+				p.strings = append(p.strings, value)
+			}
+
+		case "FEXP":
+			name, err := reader.ReadString()
+			if err != nil {
+				return nil, err
+			}
+			offset, err := reader.ReadUInt32BigEndian()
+			if err != nil {
+				return nil, err
+			}
+			idx, err := p.getOperationIndex(offset)
+			if err != nil {
+				return nil, fmt.Errorf("export of %s: %v", name, err)
+			}
+			p.exports = append(p.exports, &patchExport{name: name, operation: idx})
+		}
+	}
+	return p, nil
+}
+
+// Changes the value of an OP_LITERAL_INT, OP_LITERAL_SHORT, OP_LITERAL_BYTE or OP_LITERAL_FLT. Integers are stored
+// in the smallest of the integer literals the value fits in.
+func (p *PackagePatch) SetLiteral(offset uint32, value string) error {
+	idx, err := p.getOperationIndex(offset)
+	if err != nil {
+		return err
+	}
+	op := p.operations[idx]
+
+	switch op.opcode {
+	case OP_LITERAL_FLT:
+		number, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return fmt.Errorf("'%s' isn't a float", value)
+		}
+		op.data = binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(number)))
+
+	case OP_LITERAL_INT, OP_LITERAL_SHORT, OP_LITERAL_BYTE:
+		number, err := strconv.ParseInt(value, 0, 32)
+		if err != nil {
+			return fmt.Errorf("'%s' isn't an int", value)
+		}
+		switch {
+		case number >= math.MinInt8 && number <= math.MaxInt8:
+			op.opcode = OP_LITERAL_BYTE
+			op.data = []byte{byte(int8(number))}
+		case number >= math.MinInt16 && number <= math.MaxInt16:
+			op.opcode = OP_LITERAL_SHORT
+			op.data = binary.LittleEndian.AppendUint16(nil, uint16(int16(number)))
+		default:
+			op.opcode = OP_LITERAL_INT
+			op.data = binary.LittleEndian.AppendUint32(nil, uint32(int32(number)))
+		}
+
+	default:
+		return fmt.Errorf("0x%08X is %s, not a number literal", offset, OP_MAP[op.opcode].name)
+	}
+	return nil
+}
+
+func (p *PackagePatch) findImport(pkg string) *patchImport {
+	for _, imp := range p.imports {
+		if strings.EqualFold(imp.name, pkg) {
+			return imp
+		}
+	}
+	return nil
+}
+
+// Adds a package import, so calls can be retargeted to its functions
+func (p *PackagePatch) AddImport(pkg string) error {
+	if p.findImport(pkg) != nil {
+		return fmt.Errorf("%s is already imported", pkg)
+	}
+	// Package imports are lower case in pkg files, the headers have the real case
+	p.imports = append(p.imports, &patchImport{name: strings.ToLower(pkg)})
+	return nil
+}
+
+// Makes an imported call at the offset call a different function of an imported package, given as Pkg.Name. The
+// parameter count is checked against the headers when they are loaded.
+func (p *PackagePatch) RetargetCall(offset uint32, function string) error {
+	idx, err := p.getOperationIndex(offset)
+	if err != nil {
+		return err
+	}
+	op := p.operations[idx]
+	if op.opcode != OP_FUNCTION_CALL_IMPORTED && op.opcode != OP_TASK_CALL_IMPORTED {
+		return fmt.Errorf("0x%08X is %s, not an imported call", offset, OP_MAP[op.opcode].name)
+	}
+
+	imp, name, err := p.resolveImportedFunction(function, binary.LittleEndian.Uint32(op.data[8:12]))
+	if err != nil {
+		return err
+	}
+
+	// Take the call out of the function it imported before, dropping functions that are no longer called
+	p.removeImportedCalls(func(call int) bool { return call == idx })
+	p.addImportedCall(imp, name, idx)
+	return nil
+}
+
+// Finds the import for a Pkg.Name function. The function and its parameter count are checked against the headers
+// when they are loaded, and the name gets the case the headers give it.
+func (p *PackagePatch) resolveImportedFunction(function string, parameterCount uint32) (*patchImport, string, error) {
+	pkg, name, ok := strings.Cut(function, ".")
+	if !ok || len(pkg) == 0 || len(name) == 0 {
+		return nil, "", fmt.Errorf("'%s' isn't a Pkg.Name function", function)
+	}
+	imp := p.findImport(pkg)
+	if imp == nil {
+		return nil, "", fmt.Errorf("%s isn't imported, add an import for it first", pkg)
+	}
+
+	if info, ok := PACKAGES[strings.ToLower(pkg)]; ok {
+		declaration, ok := FUNC_DECLARATIONS[fmt.Sprintf("%s.%s", info.name, name)]
+		if !ok {
+			return nil, "", fmt.Errorf("%s isn't declared in the headers", function)
+		}
+		name = declaration.name
+		if declaration.parameters != nil && len(*declaration.parameters) != int(parameterCount) {
+			return nil, "", fmt.Errorf("%s takes %d parameters but the call passes %d", function, len(*declaration.parameters), parameterCount)
+		}
+	}
+	return imp, name, nil
+}
+
+// Removes the calls from the function imports, dropping functions that are no longer called
+func (p *PackagePatch) removeImportedCalls(remove func(call int) bool) {
+	for _, imp := range p.imports {
+		functions := imp.functions[:0]
+		for _, fnc := range imp.functions {
+			calls := fnc.calls[:0]
+			for _, call := range fnc.calls {
+				if !remove(call) {
+					calls = append(calls, call)
+				}
+			}
+			fnc.calls = calls
+			if len(fnc.calls) > 0 {
+				functions = append(functions, fnc)
+			}
+		}
+		imp.functions = functions
+	}
+}
+
+func (p *PackagePatch) addImportedCall(imp *patchImport, name string, call int) {
+	for _, fnc := range imp.functions {
+		if fnc.name == name {
+			fnc.calls = append(fnc.calls, call)
+			return
+		}
+	}
+	imp.functions = append(imp.functions, &patchImportedFunction{name: name, calls: []int{call}})
+}
+
+func (p *PackagePatch) RenameExport(name string, newName string) error {
+	var export *patchExport
+	for _, exp := range p.exports {
+		if exp.name == newName {
+			return fmt.Errorf("%s is already exported", newName)
+		}
+		if exp.name == name {
+			export = exp
+		}
+	}
+	if export == nil {
+		return fmt.Errorf("%s isn't exported", name)
+	}
+	export.name = newName
+	return nil
+}
+
+// Lays out the code again, fixing up the jump and call targets, and returns the new offset of every operation with
+// the offset of the end of the code last
+func (p *PackagePatch) relink() ([]byte, []uint32) {
+	offsets := make([]uint32, len(p.operations)+1)
+	offset := uint32(0)
+	for idx, op := range p.operations {
+		offsets[idx] = offset
+		offset += 1 + uint32(len(op.data))
+	}
+	offsets[len(p.operations)] = offset
+
+	code := []byte{}
+	for idx, op := range p.operations {
+		if op.target >= 0 {
+			binary.LittleEndian.PutUint32(op.data[getOperationTargetPosition(op.opcode):], offsets[op.target])
+		}
+		if op.middleTarget >= 0 {
+			if middle := binary.LittleEndian.Uint32(op.data[4:8]); middle != offsets[op.middleTarget] {
+				DiagnoseAt(DIAG_SCHEDULE_MIDDLE_MOVED, offsets[idx], "The middle number %d of the every block matches the offset of an operation that moved to 0x%08X, it is written unchanged since what it means is unknown", middle, offsets[op.middleTarget])
+			}
+		}
+		code = append(code, op.opcode)
+		code = append(code, op.data...)
+	}
+	return code, offsets
+}
+
+// Writes the patched package. The sections keep their order, with the imports and exports written where the
+// first of them was.
+func (p *PackagePatch) Bytes() []byte {
+	code, offsets := p.relink()
+
+	importSections := []*PackageSection{}
+	for _, imp := range p.imports {
+		importSections = append(importSections, &PackageSection{Identifier: "PIMP", Data: appendCString(nil, imp.name), Padded: true})
+		for _, fnc := range imp.functions {
+			data := appendCString(nil, fnc.name)
+			data = binary.BigEndian.AppendUint32(data, uint32(len(fnc.calls)))
+			for _, call := range fnc.calls {
+				data = binary.BigEndian.AppendUint32(data, offsets[call])
+			}
+			importSections = append(importSections, &PackageSection{Identifier: "FIMP", Data: data, Padded: true})
+		}
+	}
+	exportSections := []*PackageSection{}
+	for _, exp := range p.exports {
+		data := appendCString(nil, exp.name)
+		data = binary.BigEndian.AppendUint32(data, offsets[exp.operation])
+		exportSections = append(exportSections, &PackageSection{Identifier: "FEXP", Data: data, Padded: true})
+	}
+
+	sections := []*PackageSection{}
+	for _, section := range p.sections {
+		switch section.Identifier {
+		case "STAB":
+			if p.stringsChanged {
+				section = &PackageSection{Identifier: "STAB", Data: encodeStringTable(p.strings), Padded: true}
+			}
+			sections = append(sections, section)
+		case "PIMP", "FIMP":
+			sections = append(sections, importSections...)
+			importSections = nil
+		case "FEXP":
+			sections = append(sections, exportSections...)
+			exportSections = nil
+		case "CODE":
+			data := binary.BigEndian.AppendUint32(nil, uint32(len(code)))
+			data = append(data, code...)
+			// An unpadded odd length is only kept when the code is still the same length, new code always gets padded
+			padded := section.Padded || len(data) != len(section.Data)
+			sections = append(sections, &PackageSection{Identifier: "CODE", Data: data, Padded: padded})
+		case "PKHD":
+			sections = append(sections, section)
+			// Packages without imports get the new ones after the header
+			if !p.hasSection("PIMP") {
+				sections = append(sections, importSections...)
+				importSections = nil
+			}
+		default:
+			sections = append(sections, section)
+		}
+	}
+	return joinPackageSections(p.formType, sections)
+}
+
+func (p *PackagePatch) hasSection(identifier string) bool {
+	for _, section := range p.sections {
+		if section.Identifier == identifier {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses an offset like the ones in the assembly output
+func parsePatchOffset(value string) (uint32, error) {
+	offset, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' isn't a code offset", value)
+	}
+	return uint32(offset), nil
+}
+
+// Applies one line of a patch file. Offsets are always the ones in the original package.
+func (p *PackagePatch) applyPatchLine(fields []string) error {
+	expect := func(count int, usage string) error {
+		if len(fields) != count {
+			return fmt.Errorf("expected %s", usage)
+		}
+		return nil
+	}
+
+	switch fields[0] {
+	case "literal":
+		if err := expect(3, "literal <offset> <value>"); err != nil {
+			return err
+		}
+		offset, err := parsePatchOffset(fields[1])
+		if err != nil {
+			return err
+		}
+		return p.SetLiteral(offset, fields[2])
+
+	case "call":
+		if err := expect(3, "call <offset> <Pkg.Name>"); err != nil {
+			return err
+		}
+		offset, err := parsePatchOffset(fields[1])
+		if err != nil {
+			return err
+		}
+		return p.RetargetCall(offset, fields[2])
+
+	case "export":
+		if err := expect(3, "export <name> <new-name>"); err != nil {
+			return err
+		}
+		return p.RenameExport(fields[1], fields[2])
+
+	case "import":
+		if err := expect(2, "import <package>"); err != nil {
+			return err
+		}
+		return p.AddImport(fields[1])
+	}
+	return fmt.Errorf("unknown patch '%s'", fields[0])
+}
+
+// Runs the patch command, returns the exit code. The patched package is validated before it is written.
+func Patch(pkgFile string, patchFile string, outputFile string) int {
+	err := ValidateDiagnosticsOptions()
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "Invalid diagnostics options: %v", err)
+		return GetExitCode()
+	}
+
+	data, err := os.ReadFile(pkgFile)
+	if err != nil {
+		Diagnose(DIAG_READ_FAILED, "Failed to read file: %v", err)
+		return GetExitCode()
+	}
+	patchData, err := os.ReadFile(patchFile)
+	if err != nil {
+		Diagnose(DIAG_READ_FAILED, "Failed to read patch: %v", err)
+		return GetExitCode()
+	}
+
+	if len(INCLUDES_DIR) > 0 {
+		LoadDeclarationsFromHeaders(INCLUDES_DIR)
+	} else {
+		fmt.Printf("No includes directory, skipping the parameter count checks\n")
+	}
+
+	p, err := LoadPackagePatch(data)
+	if err != nil {
+		Diagnose(DIAG_READ_FAILED, "Failed to read file: %v", err)
+		return GetExitCode()
+	}
+
+	fmt.Printf("Patching package: %s\n", pkgFile)
+
+	failed := false
+	scanner := bufio.NewScanner(bytes.NewReader(patchData))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		err = p.applyPatchLine(fields)
+		if err != nil {
+			Diagnose(DIAG_INVALID_PATCH, "%s line %d: %v", patchFile, lineNumber, err)
+			failed = true
+		}
+	}
+	if failed {
+		fmt.Printf("Not writing the package because of invalid patches\n")
+		return EXIT_FATAL
+	}
+
+	result := p.Bytes()
+	if problems := ValidatePackage(result); problems > 0 {
+		fmt.Printf("Not writing the package because the patched package has %d problems\n", problems)
+		return EXIT_FATAL
+	}
+
+	fmt.Printf("Writing package: %s\n", outputFile)
+
+	err = os.WriteFile(outputFile, result, 0644)
+	if err != nil {
+		Diagnose(DIAG_WRITE_FAILED, "Failed to write package: %v", err)
+	}
+	return GetExitCode()
+}
@@ -0,0 +1,204 @@
+package decompiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Finds the offset of the first operation with the opcode whose data starts with the value
+func findPatchOperation(t *testing.T, p *PackagePatch, opcode byte, value []byte) uint32 {
+	t.Helper()
+	for _, op := range p.operations {
+		if op.opcode == opcode && bytes.HasPrefix(op.data, value) {
+			return op.originalOffset
+		}
+	}
+	t.Fatalf("no %s operation with data %X", OP_MAP[opcode].name, value)
+	return 0
+}
+
+func findImportedCall(t *testing.T, p *PackagePatch, name string) uint32 {
+	t.Helper()
+	for _, imp := range p.imports {
+		for _, fnc := range imp.functions {
+			if fnc.name == name {
+				return p.operations[fnc.calls[0]].originalOffset
+			}
+		}
+	}
+	t.Fatalf("no call to %s", name)
+	return 0
+}
+
+func loadTestPatch(t *testing.T, b *pkgBuilder) *PackagePatch {
+	t.Helper()
+	resetForTest(t.TempDir())
+	LoadDeclarationsFromHeaders(INCLUDES_DIR)
+	p, err := LoadPackagePatch(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPatchUnchanged(t *testing.T) {
+	for _, gc := range goldenCases {
+		t.Run(gc.name, func(t *testing.T) {
+			if data := loadTestPatch(t, gc.build()).Bytes(); !bytes.Equal(data, gc.build().Bytes()) {
+				t.Fatalf("expected the package to be unchanged")
+			}
+		})
+	}
+}
+
+func TestPatchLiterals(t *testing.T) {
+	p := loadTestPatch(t, buildLoopsPackage())
+
+	// One literal shrinks and one grows, so the code after each of them moves
+	shrink := findPatchOperation(t, p, OP_LITERAL_INT, binary.LittleEndian.AppendUint32(nil, 100000))
+	grow := findPatchOperation(t, p, OP_LITERAL_BYTE, []byte{3})
+	if err := p.SetLiteral(shrink, "5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetLiteral(grow, "70000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetLiteral(grow+1, "1"); err == nil {
+		t.Errorf("expected an error for an offset inside an operation")
+	}
+
+	golden, err := os.ReadFile(filepath.Join(GOLDEN_DIR, "loops.pog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Replace(string(golden), "> 100000 )", "> 5 )", 1)
+	expected = strings.Replace(expected, "- 3;", "- 70000;", 1)
+
+	if output := decompilePackageBytes(t, p.Bytes()); output != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, output)
+	}
+}
+
+func TestPatchImportsAndExports(t *testing.T) {
+	p := loadTestPatch(t, buildCallsPackage())
+
+	print := findImportedCall(t, p, "Print")
+	distance := findImportedCall(t, p, "Distance")
+
+	if err := p.RetargetCall(print, "Util.Log"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RetargetCall(distance, "Util.Log"); err == nil {
+		t.Errorf("expected an error for a call with the wrong parameter count")
+	}
+	if err := p.RetargetCall(print, "Copies.Report"); err == nil {
+		t.Errorf("expected an error for a package that isn't imported")
+	}
+	if err := p.AddImport("Copies"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RenameExport("Approach", "Report"); err != nil {
+		t.Fatal(err)
+	}
+
+	data := p.Bytes()
+	if problems := validateTestPackage(t, data); len(problems) > 0 {
+		t.Fatalf("expected a valid package, got %v", problems)
+	}
+	ResetState()
+	LoadDeclarationsFromHeaders(INCLUDES_DIR)
+	if !loadPackage(data) {
+		t.Fatalf("failed to load the patched package")
+	}
+	if FUNC_IMPORT_MAP[print].GetScopedName() != "Util.Log" {
+		t.Errorf("expected the call to be to Util.Log, got %s", FUNC_IMPORT_MAP[print].GetScopedName())
+	}
+	if len(PACKAGE_IMPORTS) != 2 || PACKAGE_IMPORTS[1] != "Copies" {
+		t.Errorf("expected Copies to be imported, got %v", PACKAGE_IMPORTS)
+	}
+	if FUNC_EXPORTS[0].name != "Report" {
+		t.Errorf("expected the export to be renamed, got %s", FUNC_EXPORTS[0].name)
+	}
+}
+
+func TestPatchCommand(t *testing.T) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	b := buildLoopsPackage()
+	if err := os.WriteFile(INPUT_FILE, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patchFile := filepath.Join(dir, "test.patch")
+	newFile := filepath.Join(dir, "patched.pkg")
+	patch := fmt.Sprintf("# Balance\nliteral 0x%08X 250 # was 1000\nexport SumTo Total\n", b.labels["sum.while"])
+
+	if err := os.WriteFile(patchFile, []byte(patch), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if exitCode := Patch(INPUT_FILE, patchFile, newFile); exitCode != EXIT_SUCCESS {
+		t.Fatalf("expected exit code %d, got %d", EXIT_SUCCESS, exitCode)
+	}
+	if _, err := os.Stat(newFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// A wrong patch stops the package from being written
+	os.Remove(newFile)
+	if err := os.WriteFile(patchFile, []byte(patch+"export Missing Other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if exitCode := Patch(INPUT_FILE, patchFile, newFile); exitCode != EXIT_FATAL {
+		t.Fatalf("expected exit code %d, got %d", EXIT_FATAL, exitCode)
+	}
+	if _, err := os.Stat(newFile); err == nil {
+		t.Fatalf("expected the package not to be written")
+	}
+}
+
+func TestPatchScheduleMiddle(t *testing.T) {
+	b := newPkgBuilder("golden")
+	b.Export("Sentry")
+
+	// Util.Log(5); schedule { every 1.0: { Util.Print("alive"); } }, with the middle number matching the offset of the
+	// every operation
+	b.Int(5).CallImported("util", "Log", 1).Pop()
+	b.Label("sentry.schedule")
+	b.Op(OP_SCHEDULE_START)
+	everyOffset := uint32(len(b.code))
+	b.EveryMiddle("sentry.loop", everyOffset, 1.0)
+	b.String("alive").CallImported("util", "Print", 1).Pop()
+	b.Label("sentry.loop")
+	b.Jump(OP_JUMP, "sentry.schedule")
+	b.End("sentry.end", true)
+
+	p := loadTestPatch(t, b)
+	if err := p.SetLiteral(findPatchOperation(t, p, OP_LITERAL_BYTE, []byte{5}), "70000"); err != nil {
+		t.Fatal(err)
+	}
+	data := p.Bytes()
+
+	// The every operation moved but what the middle number means isn't known, so it is only reported
+	if len(DIAGNOSTICS) != 1 || DIAGNOSTICS[0].Code != DIAG_SCHEDULE_MIDDLE_MOVED {
+		t.Fatalf("expected a %s diagnostic, got %v", DIAG_SCHEDULE_MIDDLE_MOVED, DIAGNOSTICS)
+	}
+	patched, err := LoadPackagePatch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range patched.operations {
+		if op.opcode != OP_SCHEDULE_EVERY {
+			continue
+		}
+		if op.originalOffset == everyOffset {
+			t.Errorf("expected the every operation to move")
+		}
+		if middle := binary.LittleEndian.Uint32(op.data[4:8]); middle != everyOffset {
+			t.Errorf("expected the middle number to stay %d, got %d", everyOffset, middle)
+		}
+	}
+}
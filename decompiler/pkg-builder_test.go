@@ -0,0 +1,286 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Builds pkg files from Go so the tests can decompile packages without needing any real game files. Jumps, calls
+// and schedule blocks refer to labels, which are resolved to code offsets when the package bytes are built.
+type pkgBuilder struct {
+	name    string
+	imports []*pkgImport
+	exports []pkgExport
+	strings []string
+	code    []byte
+	labels  map[string]uint32
+	fixups  []labelFixup
+}
+
+type pkgImport struct {
+	name      string
+	functions []*pkgImportedFunction
+}
+
+type pkgImportedFunction struct {
+	name    string
+	offsets []uint32
+}
+
+type pkgExport struct {
+	name   string
+	offset uint32
+}
+
+type labelFixup struct {
+	position int
+	label    string
+}
+
+func newPkgBuilder(name string) *pkgBuilder {
+	return &pkgBuilder{
+		name:   name,
+		labels: map[string]uint32{},
+	}
+}
+
+// Marks the current code offset with a label that jumps can target
+func (b *pkgBuilder) Label(name string) *pkgBuilder {
+	if _, exists := b.labels[name]; exists {
+		panic(fmt.Sprintf("duplicate label %s", name))
+	}
+	b.labels[name] = uint32(len(b.code))
+	return b
+}
+
+// Starts an exported function at the current code offset, it can also be called through a label of the same name
+func (b *pkgBuilder) Export(name string) *pkgBuilder {
+	b.exports = append(b.exports, pkgExport{name: name, offset: uint32(len(b.code))})
+	return b.Label(name)
+}
+
+// Appends an operation with its raw data
+func (b *pkgBuilder) Op(opcode byte, data ...byte) *pkgBuilder {
+	info, ok := OP_MAP[opcode]
+	if !ok {
+		panic(fmt.Sprintf("unknown opcode 0x%02X", opcode))
+	}
+	if len(data) != info.dataSize {
+		panic(fmt.Sprintf("%s needs %d bytes of data, got %d", info.name, info.dataSize, len(data)))
+	}
+	b.code = append(b.code, opcode)
+	b.code = append(b.code, data...)
+	return b
+}
+
+func (b *pkgBuilder) opUInt32(opcode byte, value uint32) *pkgBuilder {
+	return b.Op(opcode, binary.LittleEndian.AppendUint32(nil, value)...)
+}
+
+func (b *pkgBuilder) opLabel(opcode byte, label string) *pkgBuilder {
+	b.fixups = append(b.fixups, labelFixup{position: len(b.code) + 1, label: label})
+	return b.opUInt32(opcode, 0)
+}
+
+// Pushes an integer using the smallest literal operation that can hold it, like the compiler does
+func (b *pkgBuilder) Int(value int32) *pkgBuilder {
+	switch {
+	case value == 0:
+		return b.Op(OP_LITERAL_ZERO)
+	case value == 1:
+		return b.Op(OP_LITERAL_ONE)
+	case value >= math.MinInt8 && value <= math.MaxInt8:
+		return b.Op(OP_LITERAL_BYTE, byte(int8(value)))
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		return b.Op(OP_LITERAL_SHORT, binary.LittleEndian.AppendUint16(nil, uint16(int16(value)))...)
+	}
+	return b.opUInt32(OP_LITERAL_INT, uint32(value))
+}
+
+func (b *pkgBuilder) Float(value float32) *pkgBuilder {
+	return b.opUInt32(OP_LITERAL_FLT, math.Float32bits(value))
+}
+
+// Pushes a string literal, adding it to the string table if it isn't there already
+func (b *pkgBuilder) String(value string) *pkgBuilder {
+	index := -1
+	for ii, s := range b.strings {
+		if s == value {
+			index = ii
+			break
+		}
+	}
+	if index == -1 {
+		index = len(b.strings)
+		b.strings = append(b.strings, value)
+	}
+	return b.opUInt32(OP_LITERAL_STRING, uint32(index))
+}
+
+func (b *pkgBuilder) Read(index uint32) *pkgBuilder {
+	return b.opUInt32(OP_VARIABLE_READ, index)
+}
+
+func (b *pkgBuilder) Write(index uint32) *pkgBuilder {
+	return b.opUInt32(OP_VARIABLE_WRITE, index)
+}
+
+func (b *pkgBuilder) WriteString(index uint32) *pkgBuilder {
+	return b.opUInt32(OP_STRING_VARIABLE_WRITE, index)
+}
+
+func (b *pkgBuilder) Pop() *pkgBuilder {
+	return b.Op(OP_POP_STACK)
+}
+
+// Writes the value on the stack to a variable as a statement of its own
+func (b *pkgBuilder) Store(index uint32) *pkgBuilder {
+	return b.Write(index).Pop()
+}
+
+func (b *pkgBuilder) StoreString(index uint32) *pkgBuilder {
+	return b.WriteString(index).Pop()
+}
+
+func (b *pkgBuilder) Jump(opcode byte, label string) *pkgBuilder {
+	return b.opLabel(opcode, label)
+}
+
+func (b *pkgBuilder) call(opcode byte, label string, parameterCount uint32) *pkgBuilder {
+	b.fixups = append(b.fixups, labelFixup{position: len(b.code) + 5, label: label})
+	data := make([]byte, 12)
+	binary.LittleEndian.PutUint32(data[8:12], parameterCount)
+	return b.Op(opcode, data...)
+}
+
+func (b *pkgBuilder) Call(label string, parameterCount uint32) *pkgBuilder {
+	return b.call(OP_FUNCTION_CALL_LOCAL, label, parameterCount)
+}
+
+func (b *pkgBuilder) Start(label string, parameterCount uint32) *pkgBuilder {
+	return b.call(OP_TASK_CALL_LOCAL, label, parameterCount)
+}
+
+func (b *pkgBuilder) callImported(opcode byte, pkgName string, funcName string, parameterCount uint32) *pkgBuilder {
+	var imp *pkgImport
+	for _, existing := range b.imports {
+		if existing.name == pkgName {
+			imp = existing
+		}
+	}
+	if imp == nil {
+		imp = &pkgImport{name: pkgName}
+		b.imports = append(b.imports, imp)
+	}
+
+	var fnc *pkgImportedFunction
+	for _, existing := range imp.functions {
+		if existing.name == funcName {
+			fnc = existing
+		}
+	}
+	if fnc == nil {
+		fnc = &pkgImportedFunction{name: funcName}
+		imp.functions = append(imp.functions, fnc)
+	}
+	fnc.offsets = append(fnc.offsets, uint32(len(b.code)))
+
+	data := make([]byte, 12)
+	binary.LittleEndian.PutUint32(data[8:12], parameterCount)
+	return b.Op(opcode, data...)
+}
+
+func (b *pkgBuilder) CallImported(pkgName string, funcName string, parameterCount uint32) *pkgBuilder {
+	return b.callImported(OP_FUNCTION_CALL_IMPORTED, pkgName, funcName, parameterCount)
+}
+
+func (b *pkgBuilder) StartImported(pkgName string, funcName string, parameterCount uint32) *pkgBuilder {
+	return b.callImported(OP_TASK_CALL_IMPORTED, pkgName, funcName, parameterCount)
+}
+
+// Reserves the stack space for the local variables at the start of a function
+func (b *pkgBuilder) Locals(count uint32) *pkgBuilder {
+	return b.opUInt32(OP_PUSH_STACK_N, count)
+}
+
+// Initializes a string local variable, which the compiler does before anything else in the function
+func (b *pkgBuilder) InitString(index uint32) *pkgBuilder {
+	return b.opUInt32(OP_VARIABLE_INIT, 0).Store(index)
+}
+
+// Starts an every block of a schedule, skipping to the label when it isn't time to run it
+func (b *pkgBuilder) Every(skipLabel string, interval float32) *pkgBuilder {
+	return b.EveryMiddle(skipLabel, 0, interval)
+}
+
+// Starts an every block of a schedule with the middle number the compiler stores with it
+func (b *pkgBuilder) EveryMiddle(skipLabel string, middle uint32, interval float32) *pkgBuilder {
+	b.fixups = append(b.fixups, labelFixup{position: len(b.code) + 1, label: skipLabel})
+	data := make([]byte, 12)
+	binary.LittleEndian.PutUint32(data[4:8], middle)
+	binary.LittleEndian.PutUint32(data[8:12], math.Float32bits(interval))
+	return b.Op(OP_SCHEDULE_EVERY, data...)
+}
+
+// Ends a function, returns jump to the label. String locals are cleaned up before the function end, and the
+// return type of functions without a declaration is detected from how the function ends.
+func (b *pkgBuilder) End(label string, task bool, stringLocals ...uint32) *pkgBuilder {
+	b.Label(label)
+	for _, index := range stringLocals {
+		b.Read(index).Op(OP_UNKNOWN_3B).Pop()
+	}
+	if task {
+		b.Op(OP_UNKNOWN_40)
+	} else {
+		b.Op(OP_LITERAL_ZERO)
+	}
+	return b.Op(OP_UNKNOWN_3C).Op(OP_FUNCTION_END)
+}
+
+// Resolves the labels and lays out all of the sections of the package
+func (b *pkgBuilder) Bytes() []byte {
+	code := append([]byte{}, b.code...)
+	for _, fixup := range b.fixups {
+		offset, ok := b.labels[fixup.label]
+		if !ok {
+			panic(fmt.Sprintf("undefined label %s", fixup.label))
+		}
+		binary.LittleEndian.PutUint32(code[fixup.position:], offset)
+	}
+
+	sections := []byte{}
+	sections = appendSection(sections, "PKHD", appendCString(nil, b.name))
+
+	for _, imp := range b.imports {
+		sections = appendSection(sections, "PIMP", appendCString(nil, imp.name))
+		for _, fnc := range imp.functions {
+			data := appendCString(nil, fnc.name)
+			data = binary.BigEndian.AppendUint32(data, uint32(len(fnc.offsets)))
+			for _, offset := range fnc.offsets {
+				data = binary.BigEndian.AppendUint32(data, offset)
+			}
+			sections = appendSection(sections, "FIMP", data)
+		}
+	}
+
+	for _, exp := range b.exports {
+		data := appendCString(nil, exp.name)
+		data = binary.BigEndian.AppendUint32(data, exp.offset)
+		sections = appendSection(sections, "FEXP", data)
+	}
+
+	stab := binary.BigEndian.AppendUint32(nil, uint32(len(b.strings)))
+	for _, s := range b.strings {
+		stab = appendCString(stab, s)
+	}
+	sections = appendSection(sections, "STAB", stab)
+
+	codeData := binary.BigEndian.AppendUint32(nil, uint32(len(code)))
+	codeData = append(codeData, code...)
+	sections = appendSection(sections, "CODE", codeData)
+
+	// The form type is skipped by the decompiler
+	form := append([]byte("PKG "), sections...)
+	return appendSection(nil, "FORM", form)
+}
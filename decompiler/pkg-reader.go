@@ -0,0 +1,116 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The kinds of problem a malformed pkg file can have, use errors.Is to check for them
+var (
+	ErrTruncated         = errors.New("unexpected end of data")
+	ErrLengthOutOfRange  = errors.New("length out of range")
+	ErrUnexpectedSection = errors.New("unexpected section")
+	ErrUnknownOpcode     = errors.New("unknown opcode")
+	ErrStringOutOfRange  = errors.New("string index out of range")
+	ErrOffsetOutOfRange  = errors.New("code offset out of range")
+	ErrCountOutOfRange   = errors.New("count out of range")
+	ErrMissingImport     = errors.New("imported call without a function import")
+)
+
+// Where in the file reading went wrong and why
+type PkgError struct {
+	Section string
+	Offset  uint32
+	Err     error
+	Detail  string
+}
+
+func (e *PkgError) Error() string {
+	location := fmt.Sprintf("0x%08X", e.Offset)
+	if len(e.Section) > 0 {
+		location = fmt.Sprintf("%s section at %s", e.Section, location)
+	}
+	if len(e.Detail) > 0 {
+		return fmt.Sprintf("%s: %v, %s", location, e.Err, e.Detail)
+	}
+	return fmt.Sprintf("%s: %v", location, e.Err)
+}
+
+func (e *PkgError) Unwrap() error {
+	return e.Err
+}
+
+// Reads the parts of a pkg file from memory, every read is bounds checked so malformed files give an error
+type pkgReader struct {
+	data    []byte
+	offset  uint32
+	base    uint32
+	section string
+}
+
+func newPkgReader(data []byte) *pkgReader {
+	return &pkgReader{data: data}
+}
+
+// The position in the file, for error messages and code offsets
+func (r *pkgReader) Position() uint32 {
+	return r.base + r.offset
+}
+
+func (r *pkgReader) Remaining() uint32 {
+	return uint32(len(r.data)) - r.offset
+}
+
+func (r *pkgReader) Error(err error, format string, a ...interface{}) *PkgError {
+	return &PkgError{
+		Section: r.section,
+		Offset:  r.Position(),
+		Err:     err,
+		Detail:  fmt.Sprintf(format, a...),
+	}
+}
+
+func (r *pkgReader) ReadBytes(length uint32) ([]byte, error) {
+	if length > r.Remaining() {
+		return nil, r.Error(ErrTruncated, "needed %d bytes, %d left", length, r.Remaining())
+	}
+	result := r.data[r.offset : r.offset+length]
+	r.offset += length
+	return result, nil
+}
+
+func (r *pkgReader) Skip(length uint32) error {
+	_, err := r.ReadBytes(length)
+	return err
+}
+
+func (r *pkgReader) ReadUInt32BigEndian() (uint32, error) {
+	buffer, err := r.ReadBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(buffer), nil
+}
+
+// Reads a null terminated string
+func (r *pkgReader) ReadString() (string, error) {
+	for end := r.offset; end < uint32(len(r.data)); end++ {
+		if r.data[end] == 0 {
+			result := string(r.data[r.offset:end])
+			r.offset = end + 1
+			return result, nil
+		}
+	}
+	return "", r.Error(ErrTruncated, "string isn't terminated")
+}
+
+// Splits off the next length bytes into a reader of their own, so a section can't read past its end
+func (r *pkgReader) Section(identifier string, length uint32) (*pkgReader, error) {
+	start := r.Position()
+	data, err := r.ReadBytes(length)
+	if err != nil {
+		return nil, err
+	}
+	return &pkgReader{data: data, base: start, section: identifier}, nil
+}
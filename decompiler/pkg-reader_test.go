@@ -0,0 +1,98 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// Every malformed file has to give a typed error, anything else (including a panic) is a bug in the reader
+func checkReadError(t *testing.T, err error) {
+	if err == nil {
+		return
+	}
+	var pkgErr *PkgError
+	if !errors.As(err, &pkgErr) {
+		t.Fatalf("untyped error: %v", err)
+	}
+}
+
+func FuzzReadPackage(f *testing.F) {
+	for _, gc := range goldenCases {
+		data := gc.build().Bytes()
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+	}
+	f.Add([]byte{})
+	f.Add([]byte("FORM"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ResetState()
+		checkReadError(t, readPackage(data))
+	})
+}
+
+func FuzzDecodeOperations(f *testing.F) {
+	f.Add(newPkgBuilder("test").Export("Test").Locals(1).String("a").StoreString(0).Int(300).Store(0).End("end", false).code)
+	f.Add(newPkgBuilder("test").Label("top").Int(1).Jump(OP_JUMP_IF_FALSE, "top").Float(1.5).Call("top", 1).code)
+	f.Add([]byte{OP_LITERAL_INT, 1, 2})
+
+	f.Fuzz(func(t *testing.T, code []byte) {
+		ResetState()
+		STRING_TABLE = []string{"a", "b"}
+		_, err := decodeOperations(code, 0)
+		checkReadError(t, err)
+	})
+}
+
+func TestReadPackageErrors(t *testing.T) {
+	valid := newPkgBuilder("test").Export("Test").String("hello").Pop().End("end", false).Bytes()
+
+	// Points a literal string at an entry past the end of the string table
+	badString := newPkgBuilder("test").Export("Test").String("hello").Pop().End("end", false)
+	binary.LittleEndian.PutUint32(badString.code[1:], 7)
+
+	badOpcode := newPkgBuilder("test").Export("Test").End("end", false)
+	badOpcode.code[0] = 0x00
+
+	truncatedOp := newPkgBuilder("test").Export("Test").Int(100000)
+	truncatedOp.code = truncatedOp.code[:len(truncatedOp.code)-1]
+
+	badJump := newPkgBuilder("test").Export("Test").Op(OP_JUMP, 0xFF, 0xFF, 0, 0).End("end", false)
+
+	oversized := append([]byte{}, valid...)
+	binary.BigEndian.PutUint32(oversized[4:], 0xFFFFFFF0)
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{name: "empty", data: []byte{}, expected: ErrTruncated},
+		{name: "truncated", data: valid[:len(valid)-3], expected: ErrLengthOutOfRange},
+		{name: "not a form", data: append([]byte("LIST"), valid[4:]...), expected: ErrUnexpectedSection},
+		{name: "oversized form", data: oversized, expected: ErrLengthOutOfRange},
+		{name: "string out of range", data: badString.Bytes(), expected: ErrStringOutOfRange},
+		{name: "valid", data: valid, expected: nil},
+		{name: "unknown opcode", data: badOpcode.Bytes(), expected: ErrUnknownOpcode},
+		{name: "truncated operation", data: truncatedOp.Bytes(), expected: ErrTruncated},
+		{name: "jump out of range", data: badJump.Bytes(), expected: ErrOffsetOutOfRange},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ResetState()
+			err := readPackage(c.data)
+			if c.expected == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, c.expected) {
+				t.Fatalf("expected %v, got %v", c.expected, err)
+			}
+			checkReadError(t, err)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A section of a pkg file kept as raw bytes, so a package can be rewritten without decoding what isn't changed
+type PackageSection struct {
+	Identifier string
+	Data       []byte
+	// Whether the section was followed by a padding byte, the last section of a file might not be
+	Padded bool
+}
+
+func appendSection(buffer []byte, identifier string, data []byte) []byte {
+	buffer = append(buffer, identifier...)
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(len(data)))
+	buffer = append(buffer, data...)
+	// Sections are padded to be 2 byte aligned
+	if len(data)%2 == 1 {
+		buffer = append(buffer, 0)
+	}
+	return buffer
+}
+
+func appendCString(buffer []byte, value string) []byte {
+	buffer = append(buffer, value...)
+	return append(buffer, 0)
+}
+
+// Splits a pkg file into its form type and sections
+func splitPackageSections(data []byte) ([]byte, []*PackageSection, error) {
+	reader := newPkgReader(data)
+	form, err := readSectionHeader(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if form.identifier != "FORM" {
+		return nil, nil, &PkgError{Offset: 0, Err: ErrUnexpectedSection, Detail: fmt.Sprintf("expected FORM, got %q", form.identifier)}
+	}
+	reader, err = reader.Section(form.identifier, form.length)
+	if err != nil {
+		return nil, nil, err
+	}
+	formType, err := reader.ReadBytes(4)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sections := []*PackageSection{}
+	for reader.Remaining() > 0 {
+		header, err := readSectionHeader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		sectionData, err := reader.ReadBytes(header.length)
+		if err != nil {
+			return nil, nil, err
+		}
+		section := &PackageSection{Identifier: header.identifier, Data: sectionData}
+		if header.length%2 == 1 && reader.Remaining() > 0 {
+			reader.Skip(1)
+			section.Padded = true
+		}
+		sections = append(sections, section)
+	}
+	return formType, sections, nil
+}
+
+// Lays the sections out into a pkg file again with their lengths worked out from their data. Odd length sections
+// only get a padding byte if they are marked as padded, so an unchanged package comes out byte for byte the same.
+func joinPackageSections(formType []byte, sections []*PackageSection) []byte {
+	form := append([]byte{}, formType...)
+	for _, section := range sections {
+		form = append(form, section.Identifier...)
+		form = binary.BigEndian.AppendUint32(form, uint32(len(section.Data)))
+		form = append(form, section.Data...)
+		if len(section.Data)%2 == 1 && section.Padded {
+			form = append(form, 0)
+		}
+	}
+	return appendSection(nil, "FORM", form)
+}
@@ -0,0 +1,173 @@
+package decompiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	BRACE_STYLE_NEXT_LINE = "next-line"
+	BRACE_STYLE_SAME_LINE = "same-line"
+)
+
+// Controls the layout of the generated pog code, the defaults match the style of the original scripts
+type PrintStyle struct {
+	// Spaces per indent level, zero indents with tabs
+	IndentWidth int `json:"indentWidth"`
+	// How wide a tab is when working out line widths
+	TabWidth     int    `json:"tabWidth"`
+	BraceStyle   string `json:"braceStyle"`
+	ParenPadding bool   `json:"parenPadding"`
+	// The number of blank lines written around blocks
+	BlankLines int `json:"blankLines"`
+	// Calls that would go past this width are wrapped with one argument per line, zero disables wrapping
+	MaxLineWidth int `json:"maxLineWidth"`
+	// Line up the entries of uses and provides lists under the first one
+	AlignLists bool `json:"alignLists"`
+}
+
+func DefaultPrintStyle() PrintStyle {
+	return PrintStyle{
+		IndentWidth:  0,
+		TabWidth:     4,
+		BraceStyle:   BRACE_STYLE_NEXT_LINE,
+		ParenPadding: true,
+		BlankLines:   1,
+		MaxLineWidth: 0,
+		AlignLists:   true,
+	}
+}
+
+var PRINT_STYLE PrintStyle = DefaultPrintStyle()
+
+// Loads a style file over the defaults, so it only needs to contain the settings that differ
+func LoadPrintStyle(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	style := DefaultPrintStyle()
+	err = json.Unmarshal(data, &style)
+	if err != nil {
+		return err
+	}
+
+	if style.BraceStyle != BRACE_STYLE_NEXT_LINE && style.BraceStyle != BRACE_STYLE_SAME_LINE {
+		return fmt.Errorf("unknown brace style '%s'", style.BraceStyle)
+	}
+	if style.IndentWidth < 0 || style.TabWidth < 1 || style.BlankLines < 0 || style.MaxLineWidth < 0 {
+		return fmt.Errorf("widths and counts can't be negative")
+	}
+
+	PRINT_STYLE = style
+	return nil
+}
+
+func getIndentString() string {
+	if PRINT_STYLE.IndentWidth == 0 {
+		return "\t"
+	}
+	return strings.Repeat(" ", PRINT_STYLE.IndentWidth)
+}
+
+func getIndentWidth() int {
+	if PRINT_STYLE.IndentWidth == 0 {
+		return PRINT_STYLE.TabWidth
+	}
+	return PRINT_STYLE.IndentWidth
+}
+
+func printOpenParen(writer CodeWriter, empty bool) {
+	writer.Append("(")
+	if PRINT_STYLE.ParenPadding && !empty {
+		writer.Append(" ")
+	}
+}
+
+func printCloseParen(writer CodeWriter, empty bool) {
+	if PRINT_STYLE.ParenPadding && !empty {
+		writer.Append(" ")
+	}
+	writer.Append(")")
+}
+
+// Writes the opening brace of a block, the comment goes at the end of the header line
+func printOpenBlock(writer CodeWriter, comment string) {
+	if PRINT_STYLE.BraceStyle == BRACE_STYLE_SAME_LINE {
+		writer.Appendf(" {%s\n", comment)
+	} else {
+		writer.Appendf("%s\n", comment)
+		// Appended on its own so the brace gets indented
+		writer.Append("{\n")
+	}
+	writer.PushIndent()
+}
+
+// Writes the closing brace of a block, continues on the same line if something like an else or while follows
+func printCloseBlock(writer CodeWriter, continued bool) {
+	writer.PopIndent()
+	if continued && PRINT_STYLE.BraceStyle == BRACE_STYLE_SAME_LINE {
+		writer.Append("} ")
+	} else {
+		writer.Append("}\n")
+	}
+}
+
+func printBlankLines(writer CodeWriter) {
+	for ii := 0; ii < PRINT_STYLE.BlankLines; ii++ {
+		writer.Append("\n")
+	}
+}
+
+// Writes the separator between the entries of a uses or provides list
+func printListSeparator(writer CodeWriter, keyword string) {
+	writer.Append(",\n")
+	if PRINT_STYLE.AlignLists {
+		writer.Append(strings.Repeat(" ", len(keyword)+1))
+	} else {
+		writer.Append(getIndentString())
+	}
+}
+
+func getAssemblyOffsetsComment(statements ...*Statement) string {
+	if !OUTPUT_ASSEMBLY {
+		return ""
+	}
+
+	var sb strings.Builder
+	writer := NewCodeWriter(&sb)
+	writer.Append(" // ")
+	for ii, s := range statements {
+		if ii > 0 {
+			writer.Append("; ")
+		}
+		if s != nil {
+			s.RenderAssemblyOffsets(writer)
+		}
+	}
+	return sb.String()
+}
+
+// Gets how wide the line is so far, counting indentation by its visual width
+func getLineWidth(writer CodeWriter) int {
+	_, column := writer.Position()
+	indent := writer.IndentLevel()
+	return (column - 1) + indent*(getIndentWidth()-len(getIndentString()))
+}
+
+// Gets how wide an expression is when rendered on a single line
+func (node *OpGraph) getRenderedWidth(scope *Scope) int {
+	var sb strings.Builder
+	node.Render(scope, NewMeasuringWriter(&sb), true)
+	return len(sb.String())
+}
+
+func (node *OpGraph) shouldWrapArguments(scope *Scope, writer CodeWriter) bool {
+	if PRINT_STYLE.MaxLineWidth == 0 || writer.IsMeasuring() || !node.operation.IsFunctionCall() || len(node.children) < 2 {
+		return false
+	}
+	return getLineWidth(writer)+node.getRenderedWidth(scope) > PRINT_STYLE.MaxLineWidth
+}
@@ -0,0 +1,184 @@
+package decompiler
+
+import "strings"
+
+type ElseIfBlock struct {
+	conditional *Statement
+	body        []BlockElement
+}
+
+func (eib *ElseIfBlock) Render(scope *Scope, writer CodeWriter) {
+	renderConditionalBlock("else if", eib.conditional, eib.body, scope, writer)
+}
+
+func (eib *ElseIfBlock) IsBlock() bool {
+	return true
+}
+
+func (eib *ElseIfBlock) SpaceAbove() bool {
+	return true
+}
+
+func (eib *ElseIfBlock) SpaceBelow() bool {
+	return true
+}
+
+func (eib *ElseIfBlock) ResolveTypes(scope *Scope) {
+	eib.conditional.ResolveTypes(scope)
+	ResolveTypes(scope, eib.body)
+}
+
+func (eib *ElseIfBlock) CheckCode(scope *Scope) {
+	eib.conditional.CheckCode(scope)
+	CheckCode(scope, eib.body)
+}
+
+func isIfOrElseIf(e BlockElement) bool {
+	switch e.(type) {
+	case *IfBlock, *ElseIfBlock:
+		return true
+	}
+	return false
+}
+
+func isReturnGraph(graph *OpGraph) bool {
+	return graph.operation.opcode == OP_JUMP && graph.code != nil && strings.HasPrefix(*graph.code, "return")
+}
+
+func isReturnStatement(e BlockElement) bool {
+	s, ok := e.(*Statement)
+	return ok && isReturnGraph(s.graph)
+}
+
+func endsInReturn(body []BlockElement) bool {
+	return len(body) > 0 && isReturnStatement(body[len(body)-1])
+}
+
+// Checks if the body of an else block is an if with nothing but its own else ifs and else after it
+func isIfChain(body []BlockElement) bool {
+	if len(body) == 0 {
+		return false
+	}
+	if _, ok := body[0].(*IfBlock); !ok {
+		return false
+	}
+	for idx := 1; idx < len(body); idx++ {
+		switch body[idx].(type) {
+		case *ElseIfBlock:
+			continue
+		case *ElseBlock:
+			if idx == len(body)-1 {
+				continue
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Turns else { if ... } into else if ...
+func collapseElseIfChains(elements []BlockElement) []BlockElement {
+	result := []BlockElement{}
+
+	for _, e := range elements {
+		// Collapse the inner chains first so we only ever have to look one level down
+		for _, body := range GetElementBodies(e) {
+			*body = collapseElseIfChains(*body)
+		}
+
+		if elseBlock, ok := e.(*ElseBlock); ok && len(result) > 0 && isIfOrElseIf(result[len(result)-1]) && isIfChain(elseBlock.body) {
+			ifBlock := elseBlock.body[0].(*IfBlock)
+			result = append(result, &ElseIfBlock{
+				conditional: ifBlock.conditional,
+				body:        ifBlock.body,
+			})
+			result = append(result, elseBlock.body[1:]...)
+			continue
+		}
+
+		result = append(result, e)
+	}
+
+	return result
+}
+
+func negateConditional(conditional *Statement) *Statement {
+	jump := *conditional.graph
+	jump.children = []*OpGraph{negateExpression(conditional.graph.children[0])}
+
+	result := &Statement{
+		graph: &jump,
+	}
+	result.Simplify()
+
+	return result
+}
+
+// Removes the else parts after branches that return, and inverts if/else blocks where only the else returns so
+// the early return comes first
+func insertGuardClauses(elements []BlockElement) []BlockElement {
+	result := []BlockElement{}
+
+	for idx := 0; idx < len(elements); idx++ {
+		e := elements[idx]
+
+		for _, body := range GetElementBodies(e) {
+			*body = insertGuardClauses(*body)
+		}
+
+		if idx+1 < len(elements) {
+			switch next := elements[idx+1].(type) {
+			case *ElseBlock:
+				ifBlock, isIf := e.(*IfBlock)
+
+				// if ( c ) { A } else { B; return; } -> if ( !c ) { B; return; } A
+				if isIf && !endsInReturn(ifBlock.body) && endsInReturn(next.body) {
+					next.body = insertGuardClauses(next.body)
+					result = append(result, &IfBlock{
+						conditional: negateConditional(ifBlock.conditional),
+						body:        next.body,
+					})
+					result = append(result, ifBlock.body...)
+					idx++
+					continue
+				}
+
+				// if ( c ) { A; return; } else { B } -> if ( c ) { A; return; } B
+				if isIf && endsInReturn(ifBlock.body) {
+					next.body = insertGuardClauses(next.body)
+					result = append(result, e)
+					result = append(result, next.body...)
+					idx++
+					continue
+				}
+
+			case *ElseIfBlock:
+				// if ( c1 ) { A; return; } else if ( c2 ) { B } -> if ( c1 ) { A; return; } if ( c2 ) { B }
+				// Only the head of a chain can be split off, a branch further down only runs when the ones before it
+				// didn't. The else if that is split off becomes the head of the rest of the chain, so a chain where
+				// every branch returns is still split up all the way.
+				if ifBlock, isIf := e.(*IfBlock); isIf && endsInReturn(ifBlock.body) {
+					result = append(result, e)
+					elements[idx+1] = &IfBlock{
+						conditional: next.conditional,
+						body:        next.body,
+					}
+					continue
+				}
+			}
+		}
+
+		result = append(result, e)
+	}
+
+	return result
+}
+
+func (fd *FunctionDefinition) Restructure() {
+	if ELSE_IF_CHAINS {
+		fd.body = collapseElseIfChains(fd.body)
+	}
+	if GUARD_CLAUSES {
+		fd.body = insertGuardClauses(fd.body)
+	}
+}
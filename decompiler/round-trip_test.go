@@ -0,0 +1,997 @@
+package decompiler
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// The round trip tests generate random functions, compile them to bytecode with the same patterns the compiler
+// uses, decompile them and check that the result has the same structure and does the same thing as the original.
+
+const ROUND_TRIP_PROGRAMS = 200
+const ROUND_TRIP_MAX_DEPTH = 3
+const ROUND_TRIP_MAX_ITERATIONS = 200
+
+// Every generated function is int Golden.Fuzz(int a, int b) with three int locals
+const ROUND_TRIP_PARAMETERS = 2
+const ROUND_TRIP_LOCALS = 3
+
+var ROUND_TRIP_INPUTS = [][ROUND_TRIP_PARAMETERS]int32{
+	{0, 0},
+	{3, 7},
+	{-5, 2},
+	{100, 1},
+}
+
+const (
+	GEN_ASSIGN = iota
+	GEN_LOG
+	GEN_IF
+	GEN_WHILE
+	GEN_FOR
+	GEN_DO_WHILE
+	GEN_SWITCH
+	GEN_BREAK
+	GEN_CONTINUE
+	GEN_RETURN
+)
+
+type genExpr struct {
+	opcode    byte
+	value     int32
+	index     uint32
+	arguments []*genExpr
+}
+
+type genCase struct {
+	value *int32
+	body  []*genStmt
+}
+
+type genStmt struct {
+	kind      int
+	index     uint32
+	expr      *genExpr
+	body      []*genStmt
+	elseBody  []*genStmt
+	init      *genStmt
+	increment *genStmt
+	cases     []*genCase
+}
+
+// Tracks what a jump statement at the current point of the generated code would target
+type genContext struct {
+	canBreak    bool
+	canContinue bool
+}
+
+type programGenerator struct {
+	rng *rand.Rand
+}
+
+var GEN_LITERALS = []int32{0, 1, 2, 5, -3, 100, 1000, 70000}
+
+var GEN_BINARY_OPERATORS = []byte{
+	OP_INT_ADD, OP_INT_SUB, OP_INT_MUL,
+	OP_EQUALS, OP_NOT_EQUALS, OP_INT_GT, OP_INT_LT, OP_INT_GT_EQUALS, OP_INT_LT_EQUALS,
+	OP_LOGICAL_AND, OP_LOGICAL_OR,
+}
+
+var GEN_COMPARISONS = []byte{
+	OP_EQUALS, OP_NOT_EQUALS, OP_INT_GT, OP_INT_LT, OP_INT_GT_EQUALS, OP_INT_LT_EQUALS,
+}
+
+func (g *programGenerator) variable() uint32 {
+	return uint32(g.rng.Intn(ROUND_TRIP_PARAMETERS + ROUND_TRIP_LOCALS))
+}
+
+func (g *programGenerator) local() uint32 {
+	return uint32(ROUND_TRIP_PARAMETERS + g.rng.Intn(ROUND_TRIP_LOCALS))
+}
+
+func (g *programGenerator) literal() *genExpr {
+	return &genExpr{opcode: OP_LITERAL_INT, value: GEN_LITERALS[g.rng.Intn(len(GEN_LITERALS))]}
+}
+
+func (g *programGenerator) expression(depth int) *genExpr {
+	choice := g.rng.Intn(10)
+	if depth <= 0 {
+		choice = g.rng.Intn(2)
+	}
+
+	switch choice {
+	case 0:
+		return g.literal()
+	case 1, 2:
+		return &genExpr{opcode: OP_VARIABLE_READ, index: g.variable()}
+	case 3:
+		opcode := OP_INT_NEG
+		if g.rng.Intn(2) == 0 {
+			opcode = OP_LOGICAL_NOT
+		}
+		return &genExpr{opcode: opcode, arguments: []*genExpr{g.expression(depth - 1)}}
+	case 4:
+		return &genExpr{opcode: OP_FUNCTION_CALL_IMPORTED, arguments: []*genExpr{g.expression(depth - 1), g.expression(depth - 1)}}
+	}
+
+	opcode := GEN_BINARY_OPERATORS[g.rng.Intn(len(GEN_BINARY_OPERATORS))]
+	return &genExpr{opcode: opcode, arguments: []*genExpr{g.expression(depth - 1), g.expression(depth - 1)}}
+}
+
+func (g *programGenerator) condition() *genExpr {
+	opcode := GEN_COMPARISONS[g.rng.Intn(len(GEN_COMPARISONS))]
+	result := &genExpr{opcode: opcode, arguments: []*genExpr{g.expression(1), g.expression(1)}}
+	if g.rng.Intn(4) == 0 {
+		other := GEN_COMPARISONS[g.rng.Intn(len(GEN_COMPARISONS))]
+		logical := OP_LOGICAL_AND
+		if g.rng.Intn(2) == 0 {
+			logical = OP_LOGICAL_OR
+		}
+		result = &genExpr{opcode: logical, arguments: []*genExpr{result, {opcode: other, arguments: []*genExpr{g.expression(1), g.expression(1)}}}}
+	}
+	return result
+}
+
+func (g *programGenerator) assignment(index uint32) *genStmt {
+	return &genStmt{kind: GEN_ASSIGN, index: index, expr: g.expression(2)}
+}
+
+// Generates a block of statements, ending it with a break, continue or return some of the time
+func (g *programGenerator) block(depth int, context genContext) []*genStmt {
+	result := []*genStmt{}
+	count := 1 + g.rng.Intn(3)
+	for ii := 0; ii < count; ii++ {
+		result = append(result, g.statement(depth, context))
+	}
+
+	switch g.rng.Intn(8) {
+	case 0:
+		if context.canBreak {
+			result = append(result, &genStmt{kind: GEN_BREAK})
+		}
+	case 1:
+		if context.canContinue {
+			result = append(result, &genStmt{kind: GEN_CONTINUE})
+		}
+	case 2:
+		result = append(result, &genStmt{kind: GEN_RETURN, expr: g.expression(2)})
+	}
+
+	return result
+}
+
+func (g *programGenerator) statement(depth int, context genContext) *genStmt {
+	choice := g.rng.Intn(9)
+	if depth <= 0 {
+		choice = g.rng.Intn(2)
+	}
+
+	switch choice {
+	case 0:
+		return g.assignment(g.local())
+	case 1:
+		return &genStmt{kind: GEN_LOG, expr: g.expression(2)}
+	case 2, 3:
+		result := &genStmt{kind: GEN_IF, expr: g.condition(), body: g.block(depth-1, context)}
+		if g.rng.Intn(2) == 0 {
+			result.elseBody = g.block(depth-1, context)
+		}
+		return result
+	case 4:
+		// Only while loops can continue, since a continue in the others would have to skip to the condition
+		return &genStmt{kind: GEN_WHILE, expr: g.condition(), body: g.block(depth-1, genContext{canBreak: true, canContinue: true})}
+	case 5:
+		index := g.local()
+		return &genStmt{
+			kind:      GEN_FOR,
+			init:      &genStmt{kind: GEN_ASSIGN, index: index, expr: g.literal()},
+			expr:      &genExpr{opcode: OP_INT_LT, arguments: []*genExpr{{opcode: OP_VARIABLE_READ, index: index}, g.expression(1)}},
+			increment: &genStmt{kind: GEN_ASSIGN, index: index, expr: &genExpr{opcode: OP_INT_ADD, arguments: []*genExpr{{opcode: OP_VARIABLE_READ, index: index}, {opcode: OP_LITERAL_INT, value: 1}}}},
+			body:      g.block(depth-1, genContext{canBreak: true}),
+		}
+	case 6:
+		return &genStmt{kind: GEN_DO_WHILE, expr: g.condition(), body: g.block(depth-1, genContext{canBreak: true})}
+	case 7:
+		result := &genStmt{kind: GEN_SWITCH, expr: g.expression(2)}
+		caseContext := genContext{canBreak: true, canContinue: context.canContinue}
+		used := map[int32]bool{}
+		for ii := 1 + g.rng.Intn(3); ii > 0; ii-- {
+			value := int32(g.rng.Intn(6))
+			if used[value] {
+				continue
+			}
+			used[value] = true
+			body := g.block(depth-1, caseContext)
+			// Most cases break at the end, the rest fall through into the next one
+			if body[len(body)-1].kind < GEN_BREAK && g.rng.Intn(5) != 0 {
+				body = append(body, &genStmt{kind: GEN_BREAK})
+			}
+			result.cases = append(result.cases, &genCase{value: &value, body: body})
+		}
+		if g.rng.Intn(2) == 0 {
+			result.cases = append(result.cases, &genCase{body: g.block(depth-1, caseContext)})
+		}
+		return result
+	}
+
+	return g.assignment(g.local())
+}
+
+// Generates the body of a function that initializes its locals, does some random work and returns
+func (g *programGenerator) function() []*genStmt {
+	result := []*genStmt{}
+	for ii := 0; ii < ROUND_TRIP_LOCALS; ii++ {
+		result = append(result, &genStmt{kind: GEN_ASSIGN, index: uint32(ROUND_TRIP_PARAMETERS + ii), expr: g.literal()})
+	}
+	result = append(result, g.block(ROUND_TRIP_MAX_DEPTH, genContext{})...)
+	if result[len(result)-1].kind != GEN_RETURN {
+		result = append(result, &genStmt{kind: GEN_RETURN, expr: g.expression(2)})
+	}
+	return result
+}
+
+// Compiles generated functions with the same instruction patterns as the compiler
+type programCompiler struct {
+	b          *pkgBuilder
+	labelCount int
+}
+
+type compileContext struct {
+	breakLabel    string
+	continueLabel string
+}
+
+func (c *programCompiler) newLabel(name string) string {
+	c.labelCount++
+	return fmt.Sprintf("%s.%d", name, c.labelCount)
+}
+
+func (c *programCompiler) expression(e *genExpr) {
+	switch e.opcode {
+	case OP_LITERAL_INT:
+		c.b.Int(e.value)
+
+	case OP_VARIABLE_READ:
+		c.b.Read(e.index)
+
+	case OP_INT_NEG, OP_LOGICAL_NOT:
+		c.expression(e.arguments[0])
+		c.b.Op(e.opcode)
+
+	case OP_FUNCTION_CALL_IMPORTED:
+		// Arguments are pushed in order
+		for _, argument := range e.arguments {
+			c.expression(argument)
+		}
+		c.b.CallImported("util", "Random", uint32(len(e.arguments)))
+
+	default:
+		// The right side of an operator is pushed first
+		c.expression(e.arguments[1])
+		c.expression(e.arguments[0])
+		c.b.Op(e.opcode)
+	}
+}
+
+func (c *programCompiler) block(body []*genStmt, context compileContext) {
+	for _, s := range body {
+		c.statement(s, context)
+	}
+}
+
+func (c *programCompiler) statement(s *genStmt, context compileContext) {
+	switch s.kind {
+	case GEN_ASSIGN:
+		c.expression(s.expr)
+		c.b.Store(s.index)
+
+	case GEN_LOG:
+		c.expression(s.expr)
+		c.b.CallImported("util", "Log", 1).Pop()
+
+	case GEN_IF:
+		endLabel := c.newLabel("endif")
+		c.expression(s.expr)
+		if s.elseBody == nil {
+			c.b.Jump(OP_JUMP_IF_FALSE, endLabel)
+			c.block(s.body, context)
+		} else {
+			elseLabel := c.newLabel("else")
+			c.b.Jump(OP_JUMP_IF_FALSE, elseLabel)
+			c.block(s.body, context)
+			c.b.Jump(OP_JUMP, endLabel)
+			c.b.Label(elseLabel)
+			c.block(s.elseBody, context)
+		}
+		c.b.Label(endLabel)
+
+	case GEN_WHILE:
+		conditionLabel := c.newLabel("while")
+		endLabel := c.newLabel("endwhile")
+		c.b.Label(conditionLabel)
+		c.expression(s.expr)
+		c.b.Jump(OP_JUMP_IF_FALSE, endLabel)
+		c.block(s.body, compileContext{breakLabel: endLabel, continueLabel: conditionLabel})
+		c.b.Jump(OP_JUMP, conditionLabel)
+		c.b.Label(endLabel)
+
+	case GEN_FOR:
+		conditionLabel := c.newLabel("for")
+		endLabel := c.newLabel("endfor")
+		c.statement(s.init, context)
+		c.b.Label(conditionLabel)
+		c.expression(s.expr)
+		c.b.Jump(OP_JUMP_IF_FALSE, endLabel)
+		c.block(s.body, compileContext{breakLabel: endLabel})
+		c.statement(s.increment, context)
+		c.b.Jump(OP_JUMP, conditionLabel)
+		c.b.Label(endLabel)
+
+	case GEN_DO_WHILE:
+		startLabel := c.newLabel("do")
+		endLabel := c.newLabel("enddo")
+		c.b.Label(startLabel)
+		c.block(s.body, compileContext{breakLabel: endLabel})
+		c.expression(s.expr)
+		c.b.Jump(OP_JUMP_IF_TRUE, startLabel)
+		c.b.Label(endLabel)
+
+	case GEN_SWITCH:
+		dispatchLabel := c.newLabel("switch")
+		endLabel := c.newLabel("endswitch")
+		caseContext := compileContext{breakLabel: endLabel, continueLabel: context.continueLabel}
+		caseLabels := []string{}
+
+		c.b.Jump(OP_JUMP, dispatchLabel)
+		for ii, cs := range s.cases {
+			caseLabels = append(caseLabels, c.newLabel("case"))
+			c.b.Label(caseLabels[ii])
+			c.block(cs.body, caseContext)
+		}
+		// The last case always gets a break so it doesn't run into the dispatch
+		c.b.Jump(OP_JUMP, endLabel)
+
+		c.b.Label(dispatchLabel)
+		c.expression(s.expr)
+		for ii, cs := range s.cases {
+			if cs.value != nil {
+				c.b.Op(OP_CLONE_STACK).Int(*cs.value).Op(OP_EQUALS).Jump(OP_JUMP_IF_TRUE, caseLabels[ii])
+			} else {
+				c.b.Jump(OP_JUMP, caseLabels[ii])
+			}
+		}
+		c.b.Label(endLabel)
+		c.b.Pop()
+
+	case GEN_BREAK:
+		c.b.Jump(OP_JUMP, context.breakLabel)
+
+	case GEN_CONTINUE:
+		c.b.Jump(OP_JUMP, context.continueLabel)
+
+	case GEN_RETURN:
+		c.expression(s.expr)
+		c.b.Jump(OP_JUMP, "fuzz.end")
+	}
+}
+
+func compileProgram(body []*genStmt) *pkgBuilder {
+	c := &programCompiler{b: newPkgBuilder("golden")}
+	c.b.Export("Fuzz").Locals(ROUND_TRIP_LOCALS)
+	c.block(body, compileContext{})
+	c.b.End("fuzz.end", false)
+	return c.b
+}
+
+// The imported functions both programs can call, Random has to be deterministic so the results can be compared
+func randomStub(low int32, high int32) int32 {
+	return (low*7 + high*3) & 15
+}
+
+func boolToInt(value bool) int32 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func applyOperator(opcode byte, left int32, right int32) (int32, bool) {
+	switch opcode {
+	case OP_INT_ADD:
+		return left + right, true
+	case OP_INT_SUB:
+		return left - right, true
+	case OP_INT_MUL:
+		return left * right, true
+	case OP_EQUALS:
+		return boolToInt(left == right), true
+	case OP_NOT_EQUALS:
+		return boolToInt(left != right), true
+	case OP_INT_GT:
+		return boolToInt(left > right), true
+	case OP_INT_LT:
+		return boolToInt(left < right), true
+	case OP_INT_GT_EQUALS:
+		return boolToInt(left >= right), true
+	case OP_INT_LT_EQUALS:
+		return boolToInt(left <= right), true
+	case OP_LOGICAL_AND:
+		return boolToInt(left != 0 && right != 0), true
+	case OP_LOGICAL_OR:
+		return boolToInt(left != 0 || right != 0), true
+	}
+	return 0, false
+}
+
+func applyUnaryOperator(opcode byte, value int32) (int32, bool) {
+	switch opcode {
+	case OP_INT_NEG:
+		return -value, true
+	case OP_LOGICAL_NOT:
+		return boolToInt(value == 0), true
+	case OP_CAST_TO_BOOL:
+		return boolToInt(value != 0), true
+	}
+	return 0, false
+}
+
+const (
+	SIGNAL_NONE = iota
+	SIGNAL_BREAK
+	SIGNAL_CONTINUE
+	SIGNAL_RETURN
+	SIGNAL_TIMEOUT
+)
+
+// What a run of a function did, which has to be the same for the original and decompiled functions
+type programTrace struct {
+	logged     []int32
+	result     int32
+	timedOut   bool
+	iterations int
+}
+
+func (pt *programTrace) String() string {
+	if pt.timedOut {
+		return fmt.Sprintf("logged %v then timed out", pt.logged)
+	}
+	return fmt.Sprintf("logged %v returned %d", pt.logged, pt.result)
+}
+
+// Counts a loop iteration, returns false when the function has run for too long
+func (pt *programTrace) iterate() bool {
+	pt.iterations++
+	if pt.iterations > ROUND_TRIP_MAX_ITERATIONS {
+		pt.timedOut = true
+		return false
+	}
+	return true
+}
+
+// Runs the generated functions
+type genInterpreter struct {
+	variables []int32
+	trace     *programTrace
+}
+
+func (gi *genInterpreter) evaluate(e *genExpr) int32 {
+	switch e.opcode {
+	case OP_LITERAL_INT:
+		return e.value
+	case OP_VARIABLE_READ:
+		return gi.variables[e.index]
+	case OP_FUNCTION_CALL_IMPORTED:
+		low := gi.evaluate(e.arguments[0])
+		high := gi.evaluate(e.arguments[1])
+		return randomStub(low, high)
+	}
+
+	if len(e.arguments) == 1 {
+		value, _ := applyUnaryOperator(e.opcode, gi.evaluate(e.arguments[0]))
+		return value
+	}
+
+	right := gi.evaluate(e.arguments[1])
+	left := gi.evaluate(e.arguments[0])
+	value, _ := applyOperator(e.opcode, left, right)
+	return value
+}
+
+func (gi *genInterpreter) block(body []*genStmt) int {
+	for _, s := range body {
+		if signal := gi.statement(s); signal != SIGNAL_NONE {
+			return signal
+		}
+	}
+	return SIGNAL_NONE
+}
+
+// Runs a loop body, returns whether the loop should stop and the signal to pass on
+func loopSignal(signal int) (bool, int) {
+	switch signal {
+	case SIGNAL_BREAK:
+		return true, SIGNAL_NONE
+	case SIGNAL_RETURN, SIGNAL_TIMEOUT:
+		return true, signal
+	}
+	return false, SIGNAL_NONE
+}
+
+func (gi *genInterpreter) statement(s *genStmt) int {
+	switch s.kind {
+	case GEN_ASSIGN:
+		gi.variables[s.index] = gi.evaluate(s.expr)
+
+	case GEN_LOG:
+		gi.trace.logged = append(gi.trace.logged, gi.evaluate(s.expr))
+
+	case GEN_IF:
+		if gi.evaluate(s.expr) != 0 {
+			return gi.block(s.body)
+		} else if s.elseBody != nil {
+			return gi.block(s.elseBody)
+		}
+
+	case GEN_WHILE:
+		for gi.evaluate(s.expr) != 0 {
+			if !gi.trace.iterate() {
+				return SIGNAL_TIMEOUT
+			}
+			if stop, signal := loopSignal(gi.block(s.body)); stop {
+				return signal
+			}
+		}
+
+	case GEN_FOR:
+		// A generated for loop never continues, so the increment is always run after the body
+		gi.statement(s.init)
+		for gi.evaluate(s.expr) != 0 {
+			if !gi.trace.iterate() {
+				return SIGNAL_TIMEOUT
+			}
+			if stop, signal := loopSignal(gi.block(s.body)); stop {
+				return signal
+			}
+			gi.statement(s.increment)
+		}
+
+	case GEN_DO_WHILE:
+		for {
+			if !gi.trace.iterate() {
+				return SIGNAL_TIMEOUT
+			}
+			if stop, signal := loopSignal(gi.block(s.body)); stop {
+				return signal
+			}
+			if gi.evaluate(s.expr) == 0 {
+				break
+			}
+		}
+
+	case GEN_SWITCH:
+		value := gi.evaluate(s.expr)
+		start := -1
+		for ii, cs := range s.cases {
+			if cs.value != nil && *cs.value == value {
+				start = ii
+				break
+			}
+		}
+		if start == -1 && len(s.cases) > 0 && s.cases[len(s.cases)-1].value == nil {
+			start = len(s.cases) - 1
+		}
+		if start == -1 {
+			return SIGNAL_NONE
+		}
+		for _, cs := range s.cases[start:] {
+			signal := gi.block(cs.body)
+			if signal == SIGNAL_BREAK {
+				return SIGNAL_NONE
+			} else if signal != SIGNAL_NONE {
+				return signal
+			}
+		}
+
+	case GEN_BREAK:
+		return SIGNAL_BREAK
+
+	case GEN_CONTINUE:
+		return SIGNAL_CONTINUE
+
+	case GEN_RETURN:
+		gi.trace.result = gi.evaluate(s.expr)
+		return SIGNAL_RETURN
+	}
+
+	return SIGNAL_NONE
+}
+
+func runGeneratedProgram(body []*genStmt, inputs [ROUND_TRIP_PARAMETERS]int32) *programTrace {
+	gi := &genInterpreter{
+		variables: make([]int32, ROUND_TRIP_PARAMETERS+ROUND_TRIP_LOCALS),
+		trace:     &programTrace{logged: []int32{}},
+	}
+	copy(gi.variables, inputs[:])
+	gi.block(body)
+	return gi.trace
+}
+
+// Runs the decompiled functions with the meaning their output code has
+type decompiledInterpreter struct {
+	t         *testing.T
+	variables map[uint32]int32
+	trace     *programTrace
+}
+
+func (di *decompiledInterpreter) evaluate(node *OpGraph) int32 {
+	op := node.operation
+	switch op.opcode {
+	case OP_LITERAL_ZERO, OP_LITERAL_ONE, OP_LITERAL_BYTE, OP_LITERAL_SHORT, OP_LITERAL_INT:
+		return GetLiteralIntegerValue(op)
+
+	case OP_VARIABLE_READ:
+		return di.variables[op.data.(VariableReadData).index]
+
+	case OP_VARIABLE_WRITE:
+		value := di.evaluate(node.children[0])
+		di.variables[op.data.(VariableWriteData).index] = value
+		return value
+
+	case OP_POP_STACK:
+		return di.evaluate(node.children[0])
+
+	case OP_FUNCTION_CALL_IMPORTED:
+		// The arguments were pushed in order, so the first argument is the last child
+		arguments := []int32{}
+		for ii := len(node.children) - 1; ii >= 0; ii-- {
+			arguments = append(arguments, di.evaluate(node.children[ii]))
+		}
+		switch op.data.(FunctionCallData).declaration.name {
+		case "Random":
+			return randomStub(arguments[0], arguments[1])
+		case "Log":
+			di.trace.logged = append(di.trace.logged, arguments[0])
+			return 0
+		}
+	}
+
+	if len(node.children) == 1 {
+		if value, ok := applyUnaryOperator(op.opcode, di.evaluate(node.children[0])); ok {
+			return value
+		}
+	} else if len(node.children) == 2 {
+		right := di.evaluate(node.children[1])
+		left := di.evaluate(node.children[0])
+		if value, ok := applyOperator(op.opcode, left, right); ok {
+			return value
+		}
+	}
+
+	di.t.Fatalf("can't run decompiled operation %s at 0x%08X", OP_MAP[op.opcode].name, op.offset)
+	return 0
+}
+
+func (di *decompiledInterpreter) statement(s *Statement) int {
+	graph := s.graph
+	if graph.operation.opcode == OP_JUMP && graph.code != nil {
+		switch {
+		case strings.HasPrefix(*graph.code, "return"):
+			if len(graph.children) > 0 {
+				di.trace.result = di.evaluate(graph.children[0])
+			}
+			return SIGNAL_RETURN
+		case *graph.code == "break":
+			return SIGNAL_BREAK
+		case *graph.code == "continue":
+			return SIGNAL_CONTINUE
+		}
+		di.t.Fatalf("can't run decompiled jump '%s' at 0x%08X", *graph.code, graph.operation.offset)
+	}
+	di.evaluate(graph)
+	return SIGNAL_NONE
+}
+
+// Conditions are the conditional jump out of the block, or a pop for a do-while loop
+func (di *decompiledInterpreter) condition(s *Statement) bool {
+	graph := s.graph
+	switch graph.operation.opcode {
+	case OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE, OP_POP_STACK:
+		graph = graph.children[0]
+	}
+	return di.evaluate(graph) != 0
+}
+
+func (di *decompiledInterpreter) block(elements []BlockElement) int {
+	// Whether an if or else if in the current chain already ran, so the rest of the chain is skipped
+	chainTaken := false
+
+	for _, element := range elements {
+		signal := SIGNAL_NONE
+
+		switch e := element.(type) {
+		case *Statement:
+			signal = di.statement(e)
+
+		case *IfBlock:
+			chainTaken = di.condition(e.conditional)
+			if chainTaken {
+				signal = di.block(e.body)
+			}
+
+		case *ElseIfBlock:
+			if !chainTaken && di.condition(e.conditional) {
+				chainTaken = true
+				signal = di.block(e.body)
+			}
+
+		case *ElseBlock:
+			if !chainTaken {
+				signal = di.block(e.body)
+			}
+
+		case *WhileLoop:
+			for di.condition(e.conditional) {
+				if !di.trace.iterate() {
+					return SIGNAL_TIMEOUT
+				}
+				if stop, loopResult := loopSignal(di.block(e.body)); stop {
+					signal = loopResult
+					break
+				}
+			}
+
+		case *ForLoop:
+			// A continue in a for loop still runs the increment
+			di.statement(e.init)
+			for di.condition(e.conditional) {
+				if !di.trace.iterate() {
+					return SIGNAL_TIMEOUT
+				}
+				if stop, loopResult := loopSignal(di.block(e.body)); stop {
+					signal = loopResult
+					break
+				}
+				di.statement(e.increment)
+			}
+
+		case *DoWhileLoop:
+			for {
+				if !di.trace.iterate() {
+					return SIGNAL_TIMEOUT
+				}
+				if stop, loopResult := loopSignal(di.block(e.body)); stop {
+					signal = loopResult
+					break
+				}
+				if !di.condition(e.conditional) {
+					break
+				}
+			}
+
+		case *SwitchBlock:
+			signal = di.switchBlock(e)
+
+		default:
+			di.t.Fatalf("can't run decompiled block %T", element)
+		}
+
+		if signal != SIGNAL_NONE {
+			return signal
+		}
+	}
+	return SIGNAL_NONE
+}
+
+func (di *decompiledInterpreter) switchBlock(sb *SwitchBlock) int {
+	value := di.evaluate(sb.conditional.graph)
+	start := -1
+	for ii, element := range sb.body {
+		cb := element.(*CaseBlock)
+		if cb.value != nil && *cb.value == value {
+			start = ii
+			break
+		}
+	}
+	if start == -1 {
+		for ii, element := range sb.body {
+			if element.(*CaseBlock).value == nil {
+				start = ii
+			}
+		}
+	}
+	if start == -1 {
+		return SIGNAL_NONE
+	}
+	for _, element := range sb.body[start:] {
+		signal := di.block(element.(*CaseBlock).body)
+		if signal == SIGNAL_BREAK {
+			return SIGNAL_NONE
+		} else if signal != SIGNAL_NONE {
+			return signal
+		}
+	}
+	return SIGNAL_NONE
+}
+
+func runDecompiledFunction(t *testing.T, fd *FunctionDefinition, inputs [ROUND_TRIP_PARAMETERS]int32) *programTrace {
+	di := &decompiledInterpreter{
+		t:         t,
+		variables: map[uint32]int32{},
+		trace:     &programTrace{logged: []int32{}},
+	}
+	for ii, value := range inputs {
+		di.variables[uint32(ii)] = value
+	}
+	di.block(fd.body)
+	return di.trace
+}
+
+// Describes the control flow of a generated function, for loops are described as the while loop they compile to
+func genShape(body []*genStmt) string {
+	parts := []string{}
+	for _, s := range body {
+		switch s.kind {
+		case GEN_ASSIGN, GEN_LOG:
+			parts = append(parts, "stmt")
+		case GEN_IF:
+			parts = append(parts, fmt.Sprintf("if{%s}", genShape(s.body)))
+			if s.elseBody != nil {
+				parts = append(parts, fmt.Sprintf("else{%s}", genShape(s.elseBody)))
+			}
+		case GEN_WHILE:
+			parts = append(parts, fmt.Sprintf("while{%s}", genShape(s.body)))
+		case GEN_FOR:
+			parts = append(parts, "stmt", fmt.Sprintf("while{%s}", genShape(append(append([]*genStmt{}, s.body...), s.increment))))
+		case GEN_DO_WHILE:
+			parts = append(parts, fmt.Sprintf("do{%s}", genShape(s.body)))
+		case GEN_SWITCH:
+			cases := []string{}
+			for _, cs := range s.cases {
+				label := "default"
+				if cs.value != nil {
+					label = fmt.Sprintf("case %d", *cs.value)
+				}
+				cases = append(cases, fmt.Sprintf("%s{%s}", label, genShape(cs.body)))
+			}
+			parts = append(parts, fmt.Sprintf("switch{%s}", strings.Join(cases, ";")))
+		case GEN_BREAK:
+			parts = append(parts, "break")
+		case GEN_CONTINUE:
+			parts = append(parts, "continue")
+		case GEN_RETURN:
+			parts = append(parts, "return")
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+func statementShape(s *Statement) string {
+	graph := s.graph
+	if graph.operation.opcode == OP_JUMP && graph.code != nil {
+		if strings.HasPrefix(*graph.code, "return") {
+			return "return"
+		}
+		return *graph.code
+	}
+	return "stmt"
+}
+
+// Describes the control flow of a decompiled function in the same way as genShape
+func decompiledShape(elements []BlockElement) string {
+	parts := []string{}
+	for _, element := range elements {
+		switch e := element.(type) {
+		case *Statement:
+			parts = append(parts, statementShape(e))
+		case *IfBlock:
+			parts = append(parts, fmt.Sprintf("if{%s}", decompiledShape(e.body)))
+		case *ElseBlock:
+			parts = append(parts, fmt.Sprintf("else{%s}", decompiledShape(e.body)))
+		case *WhileLoop:
+			parts = append(parts, fmt.Sprintf("while{%s}", decompiledShape(e.body)))
+		case *ForLoop:
+			parts = append(parts, "stmt", fmt.Sprintf("while{%s}", decompiledShape(append(append([]BlockElement{}, e.body...), e.increment))))
+		case *DoWhileLoop:
+			parts = append(parts, fmt.Sprintf("do{%s}", decompiledShape(e.body)))
+		case *SwitchBlock:
+			cases := []string{}
+			for _, child := range e.body {
+				cb := child.(*CaseBlock)
+				label := "default"
+				if cb.value != nil {
+					label = fmt.Sprintf("case %d", *cb.value)
+				}
+				cases = append(cases, fmt.Sprintf("%s{%s}", label, decompiledShape(cb.body)))
+			}
+			parts = append(parts, fmt.Sprintf("switch{%s}", strings.Join(cases, ";")))
+		default:
+			parts = append(parts, fmt.Sprintf("%T", element))
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// Decompiles a generated program and returns the decompiled function and its output code
+func decompileGeneratedProgram(t *testing.T, body []*genStmt, configure func()) (*FunctionDefinition, string) {
+	t.Helper()
+
+	resetForTest(t.TempDir())
+	configure()
+	output := decompileBuiltPackage(t, compileProgram(body))
+
+	if len(DECOMPILED_FUNCS) != 1 {
+		t.Fatalf("expected 1 decompiled function, got %d", len(DECOMPILED_FUNCS))
+	}
+	return DECOMPILED_FUNCS[0], output
+}
+
+func TestRoundTrip(t *testing.T) {
+	programs := ROUND_TRIP_PROGRAMS
+	if testing.Short() {
+		programs = 20
+	}
+
+	for seed := int64(1); seed <= int64(programs); seed++ {
+		t.Run(fmt.Sprintf("seed-%d", seed), func(t *testing.T) {
+			g := &programGenerator{rng: rand.New(rand.NewSource(seed))}
+			body := g.function()
+
+			// Check the structure without anything that would rearrange the blocks
+			fd, output := decompileGeneratedProgram(t, body, func() {
+				SIMPLIFY_RULES = "none"
+				ELSE_IF_CHAINS = false
+				GUARD_CLAUSES = false
+			})
+			expected := genShape(body)
+			actual := decompiledShape(fd.body)
+			if expected != actual {
+				t.Fatalf("structure doesn't match\nexpected %s\nactual   %s\n%s", expected, actual, output)
+			}
+
+			// Check the meaning with everything that could rearrange the code turned on
+			fd, output = decompileGeneratedProgram(t, body, func() {
+				GUARD_CLAUSES = true
+			})
+			for _, inputs := range ROUND_TRIP_INPUTS {
+				expected := runGeneratedProgram(body, inputs)
+				actual := runDecompiledFunction(t, fd, inputs)
+				if expected.String() != actual.String() {
+					t.Fatalf("behavior doesn't match for inputs %v\nexpected %s\nactual   %s\n%s", inputs, expected, actual, output)
+				}
+			}
+		})
+	}
+}
+
+// An else if that returns in the middle of a chain mustn't pull the else after it out of the chain
+func TestGuardClausesElseIfChain(t *testing.T) {
+	equals := func(value int32) *genExpr {
+		return &genExpr{opcode: OP_EQUALS, arguments: []*genExpr{{opcode: OP_VARIABLE_READ, index: 0}, {opcode: OP_LITERAL_INT, value: value}}}
+	}
+	log := func(value int32) *genStmt {
+		return &genStmt{kind: GEN_LOG, expr: &genExpr{opcode: OP_LITERAL_INT, value: value}}
+	}
+	zero := &genExpr{opcode: OP_LITERAL_INT, value: 0}
+
+	// if (a == 1) { Log(1); } else if (a == 2) { Log(2); return 0; } else { Log(3); } Log(4); return 0;
+	body := []*genStmt{
+		{kind: GEN_IF, expr: equals(1), body: []*genStmt{log(1)}, elseBody: []*genStmt{
+			{kind: GEN_IF, expr: equals(2), body: []*genStmt{log(2), {kind: GEN_RETURN, expr: zero}}, elseBody: []*genStmt{log(3)}},
+		}},
+		log(4),
+		{kind: GEN_RETURN, expr: zero},
+	}
+
+	fd, output := decompileGeneratedProgram(t, body, func() {
+		GUARD_CLAUSES = true
+	})
+	for _, inputs := range [][ROUND_TRIP_PARAMETERS]int32{{1, 0}, {2, 0}, {3, 0}} {
+		expected := runGeneratedProgram(body, inputs)
+		actual := runDecompiledFunction(t, fd, inputs)
+		if expected.String() != actual.String() {
+			t.Fatalf("behavior doesn't match for inputs %v\nexpected %s\nactual   %s\n%s", inputs, expected, actual, output)
+		}
+	}
+}
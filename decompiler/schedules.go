@@ -0,0 +1,241 @@
+package decompiler
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Command line option for the schedule report
+var SCHEDULE_REPORT_FILE string
+
+// An every clause of a schedule and the calls it makes each time it runs
+type ScheduleClause struct {
+	every  *ScheduleEveryBlock
+	offset uint32
+	// What the middle number of the every operation means isn't known, it is only reported
+	middle   uint32
+	interval float32
+	// Call sites of imported functions in the clause, by scoped name
+	importedCalls map[string]int
+	// Imported functions the clause reaches through calls to functions of the package
+	indirectCalls []string
+}
+
+type ScheduleInfo struct {
+	function    *FunctionDefinition
+	startOffset uint32
+	endOffset   uint32
+	clauses     []*ScheduleClause
+}
+
+// Intervals are taken to be in seconds, like they are written in the source. How often a clause with an interval of
+// 0 or less runs isn't known.
+func (clause *ScheduleClause) HasKnownRate() bool {
+	return clause.interval > 0
+}
+
+func (clause *ScheduleClause) RunsPerSecond() float64 {
+	return 1 / float64(clause.interval)
+}
+
+func (clause *ScheduleClause) ImportedCallCount() int {
+	count := 0
+	for _, calls := range clause.importedCalls {
+		count += calls
+	}
+	return count
+}
+
+// Gets the imported functions a local function calls, directly or through other local functions
+func getReachableImportedCalls(fd *FunctionDeclaration, seen map[*FunctionDeclaration]bool, result map[string]bool) {
+	if seen[fd] {
+		return
+	}
+	seen[fd] = true
+
+	for _, fnc := range DECOMPILED_FUNCS {
+		if fnc.declaration != fd {
+			continue
+		}
+		ForEachStatement(fnc.body, func(s *Statement) {
+			s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+				switch node.operation.opcode {
+				case OP_FUNCTION_CALL_IMPORTED:
+					result[node.operation.GetFunctionDeclaration().GetScopedName()] = true
+				case OP_FUNCTION_CALL_LOCAL:
+					getReachableImportedCalls(node.operation.GetFunctionDeclaration(), seen, result)
+				}
+			})
+		})
+	}
+}
+
+func newScheduleClause(eb *ScheduleEveryBlock) *ScheduleClause {
+	clause := &ScheduleClause{
+		every:         eb,
+		offset:        eb.offset,
+		middle:        eb.middle,
+		interval:      eb.interval,
+		importedCalls: map[string]int{},
+	}
+
+	seen := map[*FunctionDeclaration]bool{}
+	indirect := map[string]bool{}
+	ForEachStatement(eb.body, func(s *Statement) {
+		s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+			switch node.operation.opcode {
+			case OP_FUNCTION_CALL_IMPORTED:
+				clause.importedCalls[node.operation.GetFunctionDeclaration().GetScopedName()]++
+			case OP_FUNCTION_CALL_LOCAL:
+				getReachableImportedCalls(node.operation.GetFunctionDeclaration(), seen, indirect)
+			}
+		})
+	})
+
+	for name := range indirect {
+		if clause.importedCalls[name] == 0 {
+			clause.indirectCalls = append(clause.indirectCalls, name)
+		}
+	}
+	sort.Strings(clause.indirectCalls)
+
+	return clause
+}
+
+// Finds the schedules of the function with their every clauses
+func (fd *FunctionDefinition) GetSchedules() []*ScheduleInfo {
+	result := []*ScheduleInfo{}
+	WalkBlockElements(fd.body, func(e BlockElement) bool {
+		schedule, ok := e.(*ScheduleBlock)
+		if !ok {
+			return true
+		}
+
+		info := &ScheduleInfo{
+			function:    fd,
+			startOffset: schedule.offset,
+			endOffset:   schedule.offset,
+		}
+		for _, child := range schedule.body {
+			if eb, ok := child.(*ScheduleEveryBlock); ok {
+				info.clauses = append(info.clauses, newScheduleClause(eb))
+				info.endOffset = eb.skipOffset
+			}
+		}
+
+		result = append(result, info)
+		return true
+	})
+	return result
+}
+
+func describeScheduleRate(clause *ScheduleClause) string {
+	if !clause.HasKnownRate() {
+		return "how often it runs is unknown"
+	}
+	return fmt.Sprintf("runs %.2f times a second", clause.RunsPerSecond())
+}
+
+// Describes the middle number with the operation whose offset it matches, if there is one, to help work out what
+// it means
+func describeScheduleMiddle(clause *ScheduleClause) string {
+	if clause.middle == 0 {
+		return "middle 0"
+	}
+	if idx := offsetToOpIndex(clause.middle, OPERATIONS); idx >= 0 {
+		return fmt.Sprintf("middle %d, the offset of an %s", clause.middle, OP_MAP[OPERATIONS[idx].opcode].name)
+	}
+	return fmt.Sprintf("middle %d", clause.middle)
+}
+
+func writeScheduleReport() error {
+	fmt.Printf("Writing schedule report: %s\n", SCHEDULE_REPORT_FILE)
+
+	outputFile, err := os.OpenFile(SCHEDULE_REPORT_FILE, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	writer := NewCodeWriter(outputFile)
+	writer.Appendf("Package: %s (%s)\n", EXPORTING_PACKAGE, INPUT_FILE)
+
+	type scheduleCost struct {
+		name   string
+		clause *ScheduleClause
+		cost   float64
+	}
+	costs := []scheduleCost{}
+
+	for _, fnc := range DECOMPILED_FUNCS {
+		schedules := fnc.GetSchedules()
+		if len(schedules) == 0 {
+			continue
+		}
+
+		name := fnc.declaration.GetScopedName()
+		writer.Appendf("\nFunction: %s\n", name)
+		writer.PushIndent()
+
+		for _, info := range schedules {
+			writer.Appendf("Schedule: 0x%08X - 0x%08X\n", info.startOffset, info.endOffset)
+			writer.PushIndent()
+
+			for _, clause := range info.clauses {
+				writer.Appendf("Every %s: 0x%08X, %s, %s\n", RenderFloat(clause.interval), clause.offset, describeScheduleMiddle(clause), describeScheduleRate(clause))
+				writer.PushIndent()
+
+				calls := []string{}
+				for callee, count := range clause.importedCalls {
+					if count > 1 {
+						calls = append(calls, fmt.Sprintf("%s x%d", callee, count))
+					} else {
+						calls = append(calls, callee)
+					}
+				}
+				sort.Strings(calls)
+				if len(calls) > 0 {
+					writer.Appendf("Calls: %s\n", strings.Join(calls, ", "))
+				} else {
+					writer.Append("Calls no imported functions\n")
+				}
+				if len(clause.indirectCalls) > 0 {
+					writer.Appendf("Calls through local functions: %s\n", strings.Join(clause.indirectCalls, ", "))
+				}
+
+				writer.PopIndent()
+
+				cost := math.Inf(1)
+				if clause.HasKnownRate() {
+					cost = float64(clause.ImportedCallCount()) * clause.RunsPerSecond()
+				}
+				costs = append(costs, scheduleCost{name: name, clause: clause, cost: cost})
+			}
+
+			writer.PopIndent()
+		}
+
+		writer.PopIndent()
+	}
+
+	// The clauses that make the most imported calls go first, the ones with an unknown rate could run any number of
+	// times so they go before all of them
+	sort.SliceStable(costs, func(i, j int) bool {
+		return costs[i].cost > costs[j].cost
+	})
+	writer.Appendf("\nEvery blocks by imported calls a second: %d\n", len(costs))
+	writer.PushIndent()
+	for _, cost := range costs {
+		rate := "unknown"
+		if !math.IsInf(cost.cost, 1) {
+			rate = fmt.Sprintf("%.2f", cost.cost)
+		}
+		writer.Appendf("%s: %s every %s at 0x%08X\n", rate, cost.name, RenderFloat(cost.clause.interval), cost.clause.offset)
+	}
+	writer.PopIndent()
+
+	return nil
+}
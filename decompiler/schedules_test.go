@@ -0,0 +1,73 @@
+package decompiler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// task Sentry(hunit unit) has a schedule with three every clauses, one with an interval of 0
+func buildSchedulesPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Sentry")
+
+	// The middle numbers are arbitrary, the last one is the offset of the second every operation
+	// schedule { every 0.0: { Look(unit); } every 0.5: { ... } every 10.0: { Util.Print("alive"); } }
+	b.Label("sentry.schedule")
+	b.Op(OP_SCHEDULE_START)
+	b.EveryMiddle("sentry.every05", 0, 0.0)
+	b.Read(0).Call("Look", 1).Pop()
+	b.Label("sentry.every05")
+	b.EveryMiddle("sentry.every10", 3, 0.5)
+	b.Read(0).String("base").CallImported("util", "FindUnit", 1).CallImported("util", "Distance", 2).Pop()
+	b.Read(0).CallImported("util", "Wander", 1).Pop()
+	b.Read(0).CallImported("util", "Wander", 1).Pop()
+	b.Label("sentry.every10")
+	b.EveryMiddle("sentry.loop", 0x21, 10.0)
+	b.String("alive").CallImported("util", "Print", 1).Pop()
+	b.Label("sentry.loop")
+	b.Jump(OP_JUMP, "sentry.schedule")
+
+	b.End("sentry.end", true)
+
+	// Look(hunit unit) { Util.Print("looking"); }
+	b.Label("Look")
+	b.String("looking").CallImported("util", "Print", 1).Pop()
+	b.End("look.end", false)
+
+	return b
+}
+
+func TestScheduleReport(t *testing.T) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	SCHEDULE_REPORT_FILE = filepath.Join(dir, "schedules.txt")
+	decompileBuiltPackage(t, buildSchedulesPackage())
+
+	report, err := os.ReadFile(SCHEDULE_REPORT_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Package: Golden (` + INPUT_FILE + `)
+
+Function: Golden.Sentry
+	Schedule: 0x00000000 - 0x00000099
+		Every 0.0: 0x00000001, middle 0, how often it runs is unknown
+			Calls no imported functions
+			Calls through local functions: Util.Print
+		Every 0.50: 0x00000021, middle 3, runs 2.00 times a second
+			Calls: Util.Distance, Util.FindUnit, Util.Wander x2
+		Every 10.0: 0x00000079, middle 33, the offset of an OP_SCHEDULE_EVERY, runs 0.10 times a second
+			Calls: Util.Print
+
+Every blocks by imported calls a second: 3
+	unknown: Golden.Sentry every 0.0 at 0x00000001
+	8.00: Golden.Sentry every 0.50 at 0x00000021
+	0.10: Golden.Sentry every 10.0 at 0x00000079
+`
+	if string(report) != expected {
+		t.Errorf("Wrong schedule report:\n%s\nexpected:\n%s", report, expected)
+	}
+}
@@ -0,0 +1,404 @@
+package decompiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	SIMPLIFY_DOUBLE_NEGATION    = "double-negation"
+	SIMPLIFY_NEGATED_COMPARISON = "negated-comparison"
+	SIMPLIFY_DE_MORGAN          = "de-morgan"
+	SIMPLIFY_BOOL_COMPARISON    = "bool-comparison"
+	SIMPLIFY_ZERO_COMPARISON    = "zero-comparison"
+	SIMPLIFY_CONSTANT_FOLDING   = "constant-folding"
+	SIMPLIFY_PARENTHESES        = "parentheses"
+)
+
+var SIMPLIFICATION_RULES = []string{
+	SIMPLIFY_DOUBLE_NEGATION,
+	SIMPLIFY_NEGATED_COMPARISON,
+	SIMPLIFY_DE_MORGAN,
+	SIMPLIFY_BOOL_COMPARISON,
+	SIMPLIFY_ZERO_COMPARISON,
+	SIMPLIFY_CONSTANT_FOLDING,
+	SIMPLIFY_PARENTHESES,
+}
+
+var SIMPLIFICATIONS = map[string]bool{}
+
+// Enables the simplification rules from a comma separated list. "all" and "none" can be used, and a rule can be
+// disabled again by prefixing it with a -, for example "all,-constant-folding".
+func SetSimplificationRules(rules string) error {
+	SIMPLIFICATIONS = map[string]bool{}
+
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.TrimSpace(rule)
+		enable := !strings.HasPrefix(rule, "-")
+		rule = strings.TrimPrefix(rule, "-")
+
+		switch rule {
+		case "", "none":
+			continue
+
+		case "all":
+			for _, r := range SIMPLIFICATION_RULES {
+				SIMPLIFICATIONS[r] = enable
+			}
+
+		default:
+			known := false
+			for _, r := range SIMPLIFICATION_RULES {
+				if r == rule {
+					known = true
+					break
+				}
+			}
+			if !known {
+				return fmt.Errorf("unknown simplification rule '%s'", rule)
+			}
+			SIMPLIFICATIONS[rule] = enable
+		}
+	}
+
+	return nil
+}
+
+// Pairs of comparisons that are the logical negation of each other. The float comparisons are left out since
+// they aren't safe to invert.
+var INVERTED_COMPARISONS = map[byte]byte{
+	OP_EQUALS:        OP_NOT_EQUALS,
+	OP_NOT_EQUALS:    OP_EQUALS,
+	OP_INT_GT:        OP_INT_LT_EQUALS,
+	OP_INT_LT:        OP_INT_GT_EQUALS,
+	OP_INT_GT_EQUALS: OP_INT_LT,
+	OP_INT_LT_EQUALS: OP_INT_GT,
+}
+
+func newOperatorNode(opcode byte, offset uint32, children []*OpGraph, typeName string) *OpGraph {
+	var data OperationData = OperatorData{}
+	if len(children) == 1 {
+		data = UnaryOperatorData{}
+	}
+
+	node := &OpGraph{
+		operation: &Operation{
+			offset: offset,
+			opcode: opcode,
+			data:   data,
+		},
+		children: children,
+		typeName: typeName,
+	}
+	node.code = RenderOperationCode(node.operation, nil)
+
+	return node
+}
+
+func newLiteralNode(operation *Operation, typeName string) *OpGraph {
+	node := &OpGraph{
+		operation: operation,
+		typeName:  typeName,
+	}
+	node.code = RenderOperationCode(operation, nil)
+
+	return node
+}
+
+func isBooleanExpression(node *OpGraph) bool {
+	return node.typeName == "bool" || node.operation.opcode == OP_CAST_TO_BOOL
+}
+
+// Gets the value of a zero or one literal as long as it hasn't been turned into something like none or an enum member
+func getBoolLiteralValue(node *OpGraph) (bool, bool) {
+	switch node.operation.opcode {
+	case OP_LITERAL_ZERO, OP_LITERAL_ONE:
+		if node.code != nil {
+			switch *node.code {
+			case "0", "1", "true", "false":
+			default:
+				return false, false
+			}
+		}
+		return node.operation.opcode == OP_LITERAL_ONE, true
+	}
+	return false, false
+}
+
+// Gets the value of an integer literal that is still rendered as a plain number
+func getPlainIntegerLiteral(node *OpGraph) (int32, bool) {
+	if !IsLiteralInteger(node.operation) || node.typeName != "int" {
+		return 0, false
+	}
+	if node.code != nil && *node.code != fmt.Sprintf("%d", GetLiteralIntegerValue(node.operation)) {
+		return 0, false
+	}
+	return GetLiteralIntegerValue(node.operation), true
+}
+
+func getPlainFloatLiteral(node *OpGraph) (float32, bool) {
+	if node.operation.opcode != OP_LITERAL_FLT {
+		return 0, false
+	}
+	return node.operation.data.(LiteralFloatData).value, true
+}
+
+// Builds the logical negation of an expression, using the simplification rules to avoid a ! where possible
+func negateExpression(node *OpGraph) *OpGraph {
+	not := newOperatorNode(OP_LOGICAL_NOT, node.operation.offset, []*OpGraph{node}, "bool")
+	return not.simplifySelf()
+}
+
+// Checks if an expression can be negated without adding a !
+func canNegateWithoutNot(node *OpGraph) bool {
+	if node.operation.opcode == OP_LOGICAL_NOT && SIMPLIFICATIONS[SIMPLIFY_DOUBLE_NEGATION] && isBooleanExpression(node.children[0]) {
+		return true
+	}
+	_, invertible := INVERTED_COMPARISONS[node.operation.opcode]
+	return invertible && SIMPLIFICATIONS[SIMPLIFY_NEGATED_COMPARISON]
+}
+
+func (og *OpGraph) simplifyNot() *OpGraph {
+	child := og.children[0]
+
+	switch child.operation.opcode {
+	case OP_LOGICAL_NOT:
+		// !!x -> x, only for booleans since it would otherwise change the type of the expression
+		if SIMPLIFICATIONS[SIMPLIFY_DOUBLE_NEGATION] && isBooleanExpression(child.children[0]) {
+			return child.children[0]
+		}
+
+	case OP_LOGICAL_AND, OP_LOGICAL_OR:
+		// !(a && b) -> !a || !b, but only if it gets rid of the negations entirely
+		if SIMPLIFICATIONS[SIMPLIFY_DE_MORGAN] && canNegateWithoutNot(child.children[0]) && canNegateWithoutNot(child.children[1]) {
+			opcode := OP_LOGICAL_OR
+			if child.operation.opcode == OP_LOGICAL_OR {
+				opcode = OP_LOGICAL_AND
+			}
+			children := []*OpGraph{negateExpression(child.children[0]), negateExpression(child.children[1])}
+			return newOperatorNode(opcode, child.operation.offset, children, "bool")
+		}
+
+	default:
+		// !(a == b) -> a != b
+		if inverted, ok := INVERTED_COMPARISONS[child.operation.opcode]; ok && SIMPLIFICATIONS[SIMPLIFY_NEGATED_COMPARISON] {
+			return newOperatorNode(inverted, child.operation.offset, child.children, child.typeName)
+		}
+	}
+
+	return nil
+}
+
+func (og *OpGraph) simplifyComparison() *OpGraph {
+	for idx := range og.children {
+		literal := og.children[idx]
+		other := og.children[1-idx]
+
+		value, ok := getBoolLiteralValue(literal)
+		if !ok {
+			continue
+		}
+
+		if other.operation.opcode == OP_CAST_TO_BOOL {
+			// Only compares against zero are plain boolean tests of whatever was cast
+			if !SIMPLIFICATIONS[SIMPLIFY_ZERO_COMPARISON] || value {
+				continue
+			}
+		} else if !SIMPLIFICATIONS[SIMPLIFY_BOOL_COMPARISON] || other.typeName != "bool" {
+			continue
+		}
+
+		// x == true -> x, x != true -> !x, x == false -> !x, x != false -> x
+		if value == (og.operation.opcode == OP_EQUALS) {
+			return other
+		}
+		return negateExpression(other)
+	}
+
+	return nil
+}
+
+func (og *OpGraph) foldConstants() *OpGraph {
+	offset, _ := og.GetOffsetRange()
+
+	if len(og.children) == 1 {
+		if value, ok := getPlainIntegerLiteral(og.children[0]); ok && og.operation.opcode == OP_INT_NEG {
+			return newLiteralNode(&Operation{offset: offset, opcode: OP_LITERAL_INT, data: LiteralIntData{value: -value}}, "int")
+		}
+		if value, ok := getPlainFloatLiteral(og.children[0]); ok && og.operation.opcode == OP_FLT_NEG {
+			return newLiteralNode(&Operation{offset: offset, opcode: OP_LITERAL_FLT, data: LiteralFloatData{value: -value}}, "float")
+		}
+		return nil
+	}
+
+	if len(og.children) != 2 {
+		return nil
+	}
+
+	left, leftOk := getPlainIntegerLiteral(og.children[0])
+	right, rightOk := getPlainIntegerLiteral(og.children[1])
+	if leftOk && rightOk {
+		var result int32
+		switch og.operation.opcode {
+		case OP_INT_ADD:
+			result = left + right
+		case OP_INT_SUB:
+			result = left - right
+		case OP_INT_MUL:
+			result = left * right
+		case OP_INT_DIV, OP_INT_MOD:
+			if right == 0 {
+				return nil
+			}
+			if og.operation.opcode == OP_INT_DIV {
+				result = left / right
+			} else {
+				result = left % right
+			}
+		default:
+			return nil
+		}
+		return newLiteralNode(&Operation{offset: offset, opcode: OP_LITERAL_INT, data: LiteralIntData{value: result}}, "int")
+	}
+
+	leftFlt, leftOk := getPlainFloatLiteral(og.children[0])
+	rightFlt, rightOk := getPlainFloatLiteral(og.children[1])
+	if leftOk && rightOk {
+		var result float32
+		switch og.operation.opcode {
+		case OP_FLT_ADD:
+			result = leftFlt + rightFlt
+		case OP_FLT_SUB:
+			result = leftFlt - rightFlt
+		case OP_FLT_MUL:
+			result = leftFlt * rightFlt
+		case OP_FLT_DIV:
+			if rightFlt == 0 {
+				return nil
+			}
+			result = leftFlt / rightFlt
+		default:
+			return nil
+		}
+		return newLiteralNode(&Operation{offset: offset, opcode: OP_LITERAL_FLT, data: LiteralFloatData{value: result}}, "float")
+	}
+
+	return nil
+}
+
+// Applies the first simplification that matches this node, returns nil if none of them do
+func (og *OpGraph) applySimplification() *OpGraph {
+	switch og.operation.opcode {
+	case OP_LOGICAL_NOT:
+		return og.simplifyNot()
+
+	case OP_EQUALS, OP_NOT_EQUALS:
+		return og.simplifyComparison()
+
+	case OP_INT_ADD, OP_INT_SUB, OP_INT_MUL, OP_INT_DIV, OP_INT_MOD, OP_INT_NEG,
+		OP_FLT_ADD, OP_FLT_SUB, OP_FLT_MUL, OP_FLT_DIV, OP_FLT_NEG:
+		if SIMPLIFICATIONS[SIMPLIFY_CONSTANT_FOLDING] {
+			return og.foldConstants()
+		}
+	}
+
+	return nil
+}
+
+func (og *OpGraph) simplifySelf() *OpGraph {
+	node := og
+	for {
+		simplified := node.applySimplification()
+		if simplified == nil {
+			return node
+		}
+		node = simplified
+	}
+}
+
+// Simplifies the children and then this node, returns the node that should replace this one
+func (og *OpGraph) Simplify() *OpGraph {
+	for idx := range og.children {
+		og.children[idx] = og.children[idx].Simplify()
+	}
+	return og.simplifySelf()
+}
+
+func operatorPrecedence(opcode byte) int {
+	switch opcode {
+	case OP_INT_MUL, OP_INT_DIV, OP_INT_MOD, OP_FLT_MUL, OP_FLT_DIV:
+		return 5
+	case OP_INT_ADD, OP_INT_SUB, OP_FLT_ADD, OP_FLT_SUB:
+		return 4
+	case OP_EQUALS, OP_NOT_EQUALS, OP_STRING_EQUALS,
+		OP_INT_GT, OP_INT_LT, OP_INT_GT_EQUALS, OP_INT_LT_EQUALS,
+		OP_FLT_GT, OP_FLT_LT, OP_FLT_GT_EQUALS, OP_FLT_LT_EQUALS:
+		return 3
+	case OP_LOGICAL_AND:
+		return 2
+	case OP_LOGICAL_OR:
+		return 1
+	}
+
+	// The bitwise operators always keep their parentheses since their precedence trips people up
+	return 0
+}
+
+func canOmitParentheses(parent *OpGraph, child *OpGraph, isLeft bool) bool {
+	parentPrecedence := operatorPrecedence(parent.operation.opcode)
+	childPrecedence := operatorPrecedence(child.operation.opcode)
+
+	if parentPrecedence == 0 || childPrecedence == 0 {
+		return false
+	}
+
+	// Keep a && b inside of || grouped, it's easier to read
+	if childPrecedence == 2 && parentPrecedence == 1 {
+		return false
+	}
+
+	if childPrecedence > parentPrecedence {
+		// Comparing comparisons is unusual enough that it should stay grouped
+		return !(childPrecedence == 3 && parentPrecedence == 3)
+	}
+
+	if childPrecedence == parentPrecedence {
+		// The logical operators are associative, the math operators are only from the left
+		return childPrecedence <= 2 || (isLeft && childPrecedence >= 4)
+	}
+
+	return false
+}
+
+func (og *OpGraph) removeRedundantParentheses() {
+	// The value of an assignment never needs grouping, the same as a call argument or a returned value
+	switch og.operation.opcode {
+	case OP_VARIABLE_WRITE, OP_STRING_VARIABLE_WRITE:
+		og.omitParentheses = true
+	}
+
+	if !og.ShouldRenderBeforeChidlren() {
+		for idx, child := range og.children {
+			if !child.ShouldRenderBeforeChidlren() && canOmitParentheses(og, child, idx == 0) {
+				child.omitParentheses = true
+			}
+		}
+	}
+
+	for _, child := range og.children {
+		child.removeRedundantParentheses()
+	}
+}
+
+func (s *Statement) Simplify() {
+	s.graph = s.graph.Simplify()
+	if SIMPLIFICATIONS[SIMPLIFY_PARENTHESES] {
+		s.graph.removeRedundantParentheses()
+	}
+}
+
+func (fd *FunctionDefinition) Simplify() {
+	ForEachStatement(fd.body, func(s *Statement) {
+		s.Simplify()
+	})
+}
@@ -0,0 +1,149 @@
+package decompiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestVariableNode(name string, typeName string) *OpGraph {
+	return &OpGraph{
+		code:      &name,
+		operation: &Operation{opcode: OP_VARIABLE_READ, data: VariableReadData{}},
+		typeName:  typeName,
+	}
+}
+
+func newTestAssignmentNode(name string, value *OpGraph) *OpGraph {
+	code := name + " = "
+	return &OpGraph{
+		code:      &code,
+		operation: &Operation{opcode: OP_VARIABLE_WRITE, data: VariableWriteData{}},
+		children:  []*OpGraph{value},
+		typeName:  value.typeName,
+	}
+}
+
+func newTestIntNode(value int32) *OpGraph {
+	return newLiteralNode(&Operation{opcode: OP_LITERAL_INT, data: LiteralIntData{value: value}}, "int")
+}
+
+func newTestBoolNode(value bool) *OpGraph {
+	if value {
+		return newLiteralNode(&Operation{opcode: OP_LITERAL_ONE, data: LiteralBitData{value: 1}}, "int")
+	}
+	return newLiteralNode(&Operation{opcode: OP_LITERAL_ZERO, data: LiteralBitData{value: 0}}, "int")
+}
+
+func newTestOperatorNode(opcode byte, typeName string, children ...*OpGraph) *OpGraph {
+	return newOperatorNode(opcode, 0, children, typeName)
+}
+
+// Simplifies the expression with the rules and renders it
+func renderSimplified(rules string, graph *OpGraph) string {
+	defer SetSimplificationRules("all")
+	SetSimplificationRules(rules)
+
+	s := &Statement{graph: graph}
+	s.Simplify()
+
+	var sb strings.Builder
+	s.Render(nil, NewCodeWriter(&sb))
+	return sb.String()
+}
+
+func TestSimplificationRules(t *testing.T) {
+	a := func() *OpGraph { return newTestVariableNode("a", "bool") }
+	x := func() *OpGraph { return newTestVariableNode("x", "int") }
+	y := func() *OpGraph { return newTestVariableNode("y", "int") }
+	z := func() *OpGraph { return newTestVariableNode("z", "int") }
+
+	cases := []struct {
+		rule       string
+		expression func() *OpGraph
+		expected   string
+		unchanged  string
+	}{
+		{
+			SIMPLIFY_DOUBLE_NEGATION,
+			func() *OpGraph {
+				return newTestOperatorNode(OP_LOGICAL_NOT, "bool", newTestOperatorNode(OP_LOGICAL_NOT, "bool", a()))
+			},
+			"a",
+			"!!a",
+		},
+		{
+			SIMPLIFY_NEGATED_COMPARISON,
+			func() *OpGraph {
+				return newTestOperatorNode(OP_LOGICAL_NOT, "bool", newTestOperatorNode(OP_INT_GT, "bool", x(), y()))
+			},
+			"x <= y",
+			"!( x > y )",
+		},
+		{
+			SIMPLIFY_DE_MORGAN,
+			func() *OpGraph {
+				and := newTestOperatorNode(OP_LOGICAL_AND, "bool", newTestOperatorNode(OP_LOGICAL_NOT, "bool", a()), newTestOperatorNode(OP_INT_GT, "bool", x(), y()))
+				return newTestOperatorNode(OP_LOGICAL_NOT, "bool", and)
+			},
+			"a || x <= y",
+			"!( !a && x > y )",
+		},
+		{
+			SIMPLIFY_BOOL_COMPARISON,
+			func() *OpGraph { return newTestOperatorNode(OP_EQUALS, "bool", a(), newTestBoolNode(false)) },
+			"!a",
+			"a == 0",
+		},
+		{
+			SIMPLIFY_ZERO_COMPARISON,
+			func() *OpGraph {
+				cast := newTestOperatorNode(OP_CAST_TO_BOOL, "bool", x())
+				return newTestOperatorNode(OP_NOT_EQUALS, "bool", cast, newTestBoolNode(false))
+			},
+			"x",
+			"x != 0",
+		},
+		{
+			SIMPLIFY_CONSTANT_FOLDING,
+			func() *OpGraph {
+				return newTestOperatorNode(OP_INT_MUL, "int", newTestOperatorNode(OP_INT_ADD, "int", newTestIntNode(2), newTestIntNode(3)), x())
+			},
+			"5 * x",
+			"(2 + 3) * x",
+		},
+		{
+			SIMPLIFY_PARENTHESES,
+			func() *OpGraph {
+				return newTestOperatorNode(OP_INT_ADD, "int", x(), newTestOperatorNode(OP_INT_MUL, "int", y(), z()))
+			},
+			"x + y * z",
+			"x + (y * z)",
+		},
+		{
+			SIMPLIFY_PARENTHESES,
+			func() *OpGraph {
+				return newTestOperatorNode(OP_INT_MUL, "int", newTestOperatorNode(OP_INT_ADD, "int", x(), y()), z())
+			},
+			"(x + y) * z",
+			"(x + y) * z",
+		},
+		{
+			SIMPLIFY_PARENTHESES,
+			func() *OpGraph {
+				return newTestAssignmentNode("x", newTestOperatorNode(OP_INT_ADD, "int", x(), y()))
+			},
+			"x = x + y",
+			"x = ( x + y )",
+		},
+	}
+
+	// Each expression is simplified with every rule and then with every rule but the one it tests
+	for _, c := range cases {
+		if output := renderSimplified("all", c.expression()); output != c.expected {
+			t.Errorf("expected %s to simplify to %s, got %s", c.rule, c.expected, output)
+		}
+		if output := renderSimplified("all,-"+c.rule, c.expression()); output != c.unchanged {
+			t.Errorf("expected %s to stay %s without the rule, got %s", c.rule, c.unchanged, output)
+		}
+	}
+}
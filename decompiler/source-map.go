@@ -0,0 +1,98 @@
+package decompiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	SOURCE_MAP_FUNCTION  = "function"
+	SOURCE_MAP_BLOCK     = "block"
+	SOURCE_MAP_HEADER    = "header"
+	SOURCE_MAP_STATEMENT = "statement"
+)
+
+// Links a range of lines and columns in the pog output to the range of CODE offsets it was decompiled from, the end
+// offset is the offset of the last operation in the range
+type SourceMapEntry struct {
+	Kind        string `json:"kind"`
+	Function    string `json:"function"`
+	StartLine   int    `json:"startLine"`
+	StartColumn int    `json:"startColumn"`
+	EndLine     int    `json:"endLine"`
+	EndColumn   int    `json:"endColumn"`
+	StartOffset uint32 `json:"startOffset"`
+	EndOffset   uint32 `json:"endOffset"`
+}
+
+type SourceMap struct {
+	Version int    `json:"version"`
+	File    string `json:"file"`
+	Source  string `json:"source"`
+	Package string `json:"package"`
+	// The position of the CODE section's data in the pkg file, add this to an offset to get its position in the file
+	CodeFileOffset  int64            `json:"codeFileOffset"`
+	Entries         []SourceMapEntry `json:"entries"`
+	currentFunction string
+}
+
+// Only set while rendering if a source map was requested
+var SOURCE_MAP *SourceMap
+
+func NewSourceMap() *SourceMap {
+	return &SourceMap{
+		Version: 1,
+		File:    OUTPUT_FILE,
+		Source:  INPUT_FILE,
+		Package: EXPORTING_PACKAGE,
+		Entries: []SourceMapEntry{},
+	}
+}
+
+// Records an entry from the given start position to the end of the last text written, the line breaks and blank lines
+// after it aren't part of the entry
+func (sm *SourceMap) AddEntry(kind string, startLine int, startColumn int, writer CodeWriter, startOffset uint32, endOffset uint32) {
+	endLine, endColumn := writer.TextEndPosition()
+	sm.Entries = append(sm.Entries, SourceMapEntry{
+		Kind:        kind,
+		Function:    sm.currentFunction,
+		StartLine:   startLine,
+		StartColumn: startColumn,
+		EndLine:     endLine,
+		EndColumn:   endColumn,
+		StartOffset: startOffset,
+		EndOffset:   endOffset,
+	})
+}
+
+// Finds the CODE offset range covered by all of the statements in the element
+func GetElementOffsetRange(e BlockElement) (uint32, uint32, bool) {
+	var min uint32 = 0xFFFFFFFF
+	var max uint32 = 0
+	found := false
+
+	ForEachStatement([]BlockElement{e}, func(s *Statement) {
+		statementMin, statementMax := s.graph.GetOffsetRange()
+		if statementMin < min {
+			min = statementMin
+		}
+		if statementMax > max {
+			max = statementMax
+		}
+		found = true
+	})
+
+	return min, max, found
+}
+
+func writeSourceMap() error {
+	fmt.Printf("Writing source map: %s\n", SOURCE_MAP_FILE)
+
+	data, err := json.MarshalIndent(SOURCE_MAP, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(SOURCE_MAP_FILE, data, 0644)
+}
@@ -0,0 +1,77 @@
+package decompiler
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Report(int value) has a local with an initializer followed by an if block with a blank line after it
+func buildSourceMapPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Report").Locals(1)
+
+	// int total = value + 1;
+	b.Int(1).Read(0).Op(OP_INT_ADD).Store(1)
+
+	// if (total > 2) { Util.Log(total); }
+	b.Int(2).Read(1).Op(OP_INT_GT).Jump(OP_JUMP_IF_FALSE, "report.endif")
+	b.Read(1).CallImported("util", "Log", 1).Pop()
+	b.Label("report.endif")
+
+	// Util.Log(value);
+	b.Read(0).CallImported("util", "Log", 1).Pop()
+
+	b.End("report.end", false)
+
+	return b
+}
+
+// Gets the text of the output an entry covers
+func getSourceMapEntryText(lines []string, entry SourceMapEntry) string {
+	if entry.StartLine == entry.EndLine {
+		return lines[entry.StartLine-1][entry.StartColumn-1 : entry.EndColumn-1]
+	}
+	parts := []string{lines[entry.StartLine-1][entry.StartColumn-1:]}
+	parts = append(parts, lines[entry.StartLine:entry.EndLine-1]...)
+	parts = append(parts, lines[entry.EndLine-1][:entry.EndColumn-1])
+	return strings.Join(parts, "\n")
+}
+
+func TestSourceMapEntries(t *testing.T) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	SOURCE_MAP_FILE = filepath.Join(dir, "test.map")
+	output := decompileBuiltPackage(t, buildSourceMapPackage())
+	lines := strings.Split(output, "\n")
+
+	data, err := os.ReadFile(SOURCE_MAP_FILE)
+	if err != nil {
+		t.Fatalf("failed to read source map: %v", err)
+	}
+	var sourceMap SourceMap
+	if err := json.Unmarshal(data, &sourceMap); err != nil {
+		t.Fatalf("failed to parse source map: %v", err)
+	}
+
+	texts := map[string][]string{}
+	for _, entry := range sourceMap.Entries {
+		texts[entry.Kind] = append(texts[entry.Kind], getSourceMapEntryText(lines, entry))
+	}
+
+	// The initializer merged into the declaration still gets an entry
+	expectedStatements := []string{"int local_0 = value_ + 1", "Util.Log( local_0 )", "Util.Log( value_ )"}
+	if strings.Join(texts[SOURCE_MAP_STATEMENT], "|") != strings.Join(expectedStatements, "|") {
+		t.Errorf("Expected statement entries %q, got %q", expectedStatements, texts[SOURCE_MAP_STATEMENT])
+	}
+
+	// Blocks and functions end at their closing brace, before the line break and the blank line after them
+	for _, kind := range []string{SOURCE_MAP_BLOCK, SOURCE_MAP_FUNCTION} {
+		if len(texts[kind]) != 1 || !strings.HasSuffix(texts[kind][0], "}") {
+			t.Errorf("Expected one %s entry ending at its closing brace, got %q", kind, texts[kind])
+		}
+	}
+}
@@ -0,0 +1,616 @@
+package decompiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	STRINGS_FORMAT_PO  = "po"
+	STRINGS_FORMAT_CSV = "csv"
+)
+
+// The format of the strings export and import files, worked out from the file extension when it isn't set
+var STRINGS_FORMAT string
+
+const (
+	STRINGS_CHARSET_WINDOWS_1252 = "windows-1252"
+	STRINGS_CHARSET_UTF8         = "utf-8"
+)
+
+// The encoding of the strings in the package. The export and import files are always UTF-8, strings are converted
+// from and to this encoding.
+var STRINGS_CHARSET = STRINGS_CHARSET_WINDOWS_1252
+
+// The characters of the Windows-1252 bytes 0x80 to 0x9F, the bytes it leaves undefined map to the control character
+// with the same value so every byte survives an export and import
+var WINDOWS_1252_HIGH = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021, 0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014, 0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+var STRINGS_CSV_HEADER = []string{"index", "source", "translation", "context"}
+
+// Where a string from the string table is used
+type StringReference struct {
+	Function string
+	Offset   uint32
+	// The function the string is passed to and the name of the parameter, empty if it isn't passed to one
+	Call      string
+	Parameter string
+}
+
+func (sr *StringReference) Usage() string {
+	if len(sr.Call) == 0 {
+		return "not passed to a function"
+	}
+	return fmt.Sprintf("%s( %s )", sr.Call, sr.Parameter)
+}
+
+// A string from the string table with its translation
+type StringEntry struct {
+	Index       uint32
+	Source      string
+	Translation string
+	References  []StringReference
+}
+
+// Gets each different way the string is used
+func (se *StringEntry) GetUsages() []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, ref := range se.References {
+		usage := ref.Usage()
+		if !seen[usage] {
+			seen[usage] = true
+			result = append(result, usage)
+		}
+	}
+	if len(result) == 0 {
+		result = append(result, "not used by any function")
+	}
+	return result
+}
+
+func (se *StringEntry) GetLocations() []string {
+	result := []string{}
+	for _, ref := range se.References {
+		result = append(result, fmt.Sprintf("%s:0x%08X", ref.Function, ref.Offset))
+	}
+	return result
+}
+
+// Finds the call each string literal in the graph is an argument of, keyed by the offset of the literal
+func findStringCalls(og *OpGraph, call string, parameter string, result map[uint32]StringReference) {
+	if og.operation.opcode == OP_LITERAL_STRING {
+		result[og.operation.offset] = StringReference{Call: call, Parameter: parameter}
+	}
+
+	if og.operation.IsFunctionCall() {
+		declaration := og.operation.data.(FunctionCallData).declaration
+		for idx, child := range og.children {
+			parameterIndex := len(og.children) - 1 - idx
+			name := fmt.Sprintf("parameter %d", parameterIndex+1)
+			if declaration.parameters != nil && len(*declaration.parameters) == len(og.children) {
+				name = (*declaration.parameters)[parameterIndex].parameterName
+			}
+			findStringCalls(child, declaration.GetScopedName(), name, result)
+		}
+		return
+	}
+
+	for _, child := range og.children {
+		findStringCalls(child, call, parameter, result)
+	}
+}
+
+// Gets the string table of the decompiled package with where each string is used
+func GetStringEntries() []*StringEntry {
+	calls := map[uint32]StringReference{}
+	for _, fnc := range DECOMPILED_FUNCS {
+		ForEachStatement(fnc.body, func(s *Statement) {
+			findStringCalls(s.graph, "", "", calls)
+		})
+	}
+
+	result := []*StringEntry{}
+	for idx, s := range STRING_TABLE {
+		result = append(result, &StringEntry{Index: uint32(idx), Source: decodePackageString(s)})
+	}
+
+	// Go through the operations as well, so strings in code that didn't make it into a statement are still found
+	for _, fnc := range DECOMPILED_FUNCS {
+		for idx := fnc.startingIndex; idx < len(OPERATIONS); idx++ {
+			op := &OPERATIONS[idx]
+			if data, ok := op.data.(LiteralStringData); ok {
+				ref := calls[op.offset]
+				ref.Function = fnc.declaration.GetScopedName()
+				ref.Offset = op.offset
+				result[data.index].References = append(result[data.index].References, ref)
+			}
+			if op.opcode == OP_FUNCTION_END {
+				break
+			}
+		}
+	}
+	return result
+}
+
+func getStringsFormat(filename string) (string, error) {
+	format := STRINGS_FORMAT
+	if len(format) == 0 {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	}
+	if format != STRINGS_FORMAT_PO && format != STRINGS_FORMAT_CSV {
+		return "", fmt.Errorf("unknown strings format '%s', use po or csv", format)
+	}
+	return format, nil
+}
+
+func validateStringsCharset() error {
+	if STRINGS_CHARSET != STRINGS_CHARSET_WINDOWS_1252 && STRINGS_CHARSET != STRINGS_CHARSET_UTF8 {
+		return fmt.Errorf("unknown strings charset '%s', use windows-1252 or utf-8", STRINGS_CHARSET)
+	}
+	return nil
+}
+
+// Converts a string from the package to UTF-8
+func decodePackageString(value string) string {
+	if STRINGS_CHARSET == STRINGS_CHARSET_UTF8 {
+		return value
+	}
+
+	var sb strings.Builder
+	for ii := 0; ii < len(value); ii++ {
+		b := value[ii]
+		if b >= 0x80 && b < 0xA0 {
+			sb.WriteRune(WINDOWS_1252_HIGH[b-0x80])
+		} else {
+			sb.WriteRune(rune(b))
+		}
+	}
+	return sb.String()
+}
+
+// Converts a UTF-8 string to the encoding of the package, fails if it has a character the encoding doesn't have
+func encodePackageString(value string) (string, error) {
+	if !utf8.ValidString(value) {
+		return "", fmt.Errorf("it isn't valid UTF-8")
+	}
+	if STRINGS_CHARSET == STRINGS_CHARSET_UTF8 {
+		return value, nil
+	}
+
+	var sb strings.Builder
+	for _, r := range value {
+		if r < 0x80 || (r >= 0xA0 && r <= 0xFF) {
+			sb.WriteByte(byte(r))
+			continue
+		}
+		found := false
+		for idx, high := range WINDOWS_1252_HIGH {
+			if high == r {
+				sb.WriteByte(byte(0x80 + idx))
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("%q can't be written in %s", r, STRINGS_CHARSET)
+		}
+	}
+	return sb.String(), nil
+}
+
+// Quotes a string for a po file. Only the characters po files need escaped are, other bytes are kept as they are.
+func quotePoString(value string) string {
+	var sb strings.Builder
+	sb.WriteString("\"")
+	for ii := 0; ii < len(value); ii++ {
+		switch value[ii] {
+		case '\\':
+			sb.WriteString("\\\\")
+		case '"':
+			sb.WriteString("\\\"")
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		default:
+			sb.WriteByte(value[ii])
+		}
+	}
+	sb.WriteString("\"")
+	return sb.String()
+}
+
+// Writes a po keyword with its string, strings with line breaks in the middle are split after each of them
+func writePoString(writer CodeWriter, keyword string, value string) {
+	lines := strings.SplitAfter(value, "\n")
+	if len(lines) > 1 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= 1 {
+		writer.Appendf("%s %s\n", keyword, quotePoString(value))
+		return
+	}
+	writer.Appendf("%s \"\"\n", keyword)
+	for _, line := range lines {
+		writer.Appendf("%s\n", quotePoString(line))
+	}
+}
+
+// Writes the strings as a gettext po file. The string index is the message context, so strings that are the same
+// can still be translated differently and the import knows where each one goes.
+func RenderStringsPo(entries []*StringEntry, writer CodeWriter) {
+	writer.Appendf("# Strings of package %s\n", EXPORTING_PACKAGE)
+	writer.Append("msgid \"\"\n")
+	writer.Append("msgstr \"\"\n")
+	writer.Append("\"Content-Type: text/plain; charset=UTF-8\\n\"\n")
+
+	for _, entry := range entries {
+		writer.Append("\n")
+		for _, usage := range entry.GetUsages() {
+			writer.Appendf("#. %s\n", usage)
+		}
+		for _, location := range entry.GetLocations() {
+			writer.Appendf("#: %s\n", location)
+		}
+		writer.Appendf("msgctxt \"%d\"\n", entry.Index)
+		writePoString(writer, "msgid", entry.Source)
+		writePoString(writer, "msgstr", entry.Translation)
+	}
+}
+
+func RenderStringsCsv(entries []*StringEntry, writer CodeWriter) error {
+	var buffer bytes.Buffer
+	csvWriter := csv.NewWriter(&buffer)
+	csvWriter.Write(STRINGS_CSV_HEADER)
+	for _, entry := range entries {
+		context := append(entry.GetUsages(), entry.GetLocations()...)
+		csvWriter.Write([]string{strconv.Itoa(int(entry.Index)), entry.Source, entry.Translation, strings.Join(context, "; ")})
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return err
+	}
+	writer.Append(buffer.String())
+	return nil
+}
+
+func unquotePoString(value string) (string, error) {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return "", fmt.Errorf("expected a quoted string, got %s", value)
+	}
+	value = value[1 : len(value)-1]
+
+	var sb strings.Builder
+	for ii := 0; ii < len(value); ii++ {
+		if value[ii] != '\\' {
+			sb.WriteByte(value[ii])
+			continue
+		}
+		ii++
+		if ii == len(value) {
+			return "", fmt.Errorf("string ends with a backslash")
+		}
+		switch value[ii] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case '\\', '"':
+			sb.WriteByte(value[ii])
+		default:
+			return "", fmt.Errorf("unknown escape \\%c", value[ii])
+		}
+	}
+	return sb.String(), nil
+}
+
+type poEntry struct {
+	context string
+	id      string
+	str     string
+	fuzzy   bool
+}
+
+// Parses the entries of a po file, which are the keywords and strings the export writes
+func parsePoFile(data []byte) ([]*poEntry, error) {
+	result := []*poEntry{}
+	var entry *poEntry
+	var target *string
+	fuzzy := false
+
+	for lineNumber, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			if strings.HasPrefix(line, "#,") && strings.Contains(line, "fuzzy") {
+				fuzzy = true
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "\"") {
+			if target == nil {
+				return nil, fmt.Errorf("line %d: string without a keyword", lineNumber+1)
+			}
+			value, err := unquotePoString(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber+1, err)
+			}
+			*target += value
+			continue
+		}
+
+		keyword, value, _ := strings.Cut(line, " ")
+		unquoted, err := unquotePoString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber+1, err)
+		}
+		switch keyword {
+		case "msgctxt":
+			entry = &poEntry{fuzzy: fuzzy}
+			fuzzy = false
+			result = append(result, entry)
+			entry.context = unquoted
+			target = &entry.context
+		case "msgid":
+			if entry == nil || target != &entry.context {
+				entry = &poEntry{fuzzy: fuzzy}
+				fuzzy = false
+				result = append(result, entry)
+			}
+			entry.id = unquoted
+			target = &entry.id
+		case "msgstr":
+			if entry == nil {
+				return nil, fmt.Errorf("line %d: msgstr without a msgid", lineNumber+1)
+			}
+			entry.str = unquoted
+			target = &entry.str
+		default:
+			return nil, fmt.Errorf("line %d: unsupported keyword %s", lineNumber+1, keyword)
+		}
+	}
+	return result, nil
+}
+
+// Reads the translations from an exported file, keyed by the string index. Fuzzy translations in po files are left
+// out, the same as gettext does.
+func loadTranslations(filename string) ([]*StringEntry, error) {
+	format, err := getStringsFormat(filename)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*StringEntry{}
+	if format == STRINGS_FORMAT_PO {
+		entries, err := parsePoFile(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			// The header has no context
+			if len(entry.context) == 0 || entry.fuzzy {
+				continue
+			}
+			index, err := strconv.ParseUint(entry.context, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("message context '%s' isn't a string index", entry.context)
+			}
+			result = append(result, &StringEntry{Index: uint32(index), Source: entry.id, Translation: entry.str})
+		}
+		return result, nil
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for ii, record := range records {
+		if ii == 0 && len(record) > 0 && record[0] == STRINGS_CSV_HEADER[0] {
+			continue
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("row %d has %d columns, expected at least 3", ii+1, len(record))
+		}
+		index, err := strconv.ParseUint(record[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: '%s' isn't a string index", ii+1, record[0])
+		}
+		result = append(result, &StringEntry{Index: uint32(index), Source: record[1], Translation: record[2]})
+	}
+	return result, nil
+}
+
+func encodeStringTable(table []string) []byte {
+	result := binary.BigEndian.AppendUint32(nil, uint32(len(table)))
+	for _, s := range table {
+		result = appendCString(result, s)
+	}
+	return result
+}
+
+// Replaces the translated strings in the STAB section of a pkg file. Strings are only referred to by their index, so
+// nothing else in the package has to change. Returns the number of strings replaced.
+func applyTranslations(data []byte, translations []*StringEntry) ([]byte, int, error) {
+	formType, sections, err := splitPackageSections(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var stab *PackageSection
+	for _, section := range sections {
+		if section.Identifier == "STAB" {
+			stab = section
+		}
+	}
+	if stab == nil {
+		return nil, 0, fmt.Errorf("the package has no STAB section")
+	}
+
+	reader := newPkgReader(stab.Data)
+	count, err := reader.ReadUInt32BigEndian()
+	if err != nil {
+		return nil, 0, err
+	}
+	table := []string{}
+	for ii := uint32(0); ii < count; ii++ {
+		s, err := reader.ReadString()
+		if err != nil {
+			return nil, 0, err
+		}
+		table = append(table, s)
+	}
+
+	sort.SliceStable(translations, func(i, j int) bool { return translations[i].Index < translations[j].Index })
+
+	replaced := 0
+	for _, t := range translations {
+		if len(t.Translation) == 0 {
+			continue
+		}
+		if t.Index >= uint32(len(table)) {
+			Diagnose(DIAG_INVALID_TRANSLATION, "String %d is out of range, the string table has %d strings", t.Index, len(table))
+			continue
+		}
+		if strings.IndexByte(t.Translation, 0) >= 0 {
+			Diagnose(DIAG_INVALID_TRANSLATION, "The translation of string %d has a NUL character", t.Index)
+			continue
+		}
+		if source := decodePackageString(table[t.Index]); t.Source != source {
+			Diagnose(DIAG_STALE_TRANSLATION, "String %d is %q in the package but %q in the translations, skipping it", t.Index, source, t.Source)
+			continue
+		}
+		translation, err := encodePackageString(t.Translation)
+		if err != nil {
+			Diagnose(DIAG_INVALID_TRANSLATION, "The translation of string %d can't be written to the package, %v", t.Index, err)
+			continue
+		}
+		table[t.Index] = translation
+		replaced++
+	}
+
+	stab.Data = encodeStringTable(table)
+	stab.Padded = true
+	return joinPackageSections(formType, sections), replaced, nil
+}
+
+// Runs the strings export command, returns the exit code
+func ExportStrings(pkgFile string, outputFile string) int {
+	format, err := getStringsFormat(outputFile)
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "%v", err)
+		return GetExitCode()
+	}
+	err = ValidateDiagnosticsOptions()
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "Invalid diagnostics options: %v", err)
+		return GetExitCode()
+	}
+	err = validateStringsCharset()
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "%v", err)
+		return GetExitCode()
+	}
+
+	data, err := os.ReadFile(pkgFile)
+	if err != nil {
+		Diagnose(DIAG_READ_FAILED, "Failed to read file: %v", err)
+		return GetExitCode()
+	}
+
+	fmt.Printf("Decompiling package: %s\n", pkgFile)
+
+	if len(INCLUDES_DIR) > 0 {
+		LoadDeclarationsFromHeaders(INCLUDES_DIR)
+	}
+	if !loadPackage(data) {
+		return GetExitCode()
+	}
+	analyzeAllFunctions()
+
+	fmt.Printf("Writing strings: %s\n", outputFile)
+
+	output, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		Diagnose(DIAG_WRITE_FAILED, "Failed to write strings: %v", err)
+		return GetExitCode()
+	}
+	defer output.Close()
+
+	entries := GetStringEntries()
+	if format == STRINGS_FORMAT_PO {
+		RenderStringsPo(entries, NewCodeWriter(output))
+	} else {
+		err = RenderStringsCsv(entries, NewCodeWriter(output))
+		if err != nil {
+			Diagnose(DIAG_WRITE_FAILED, "Failed to write strings: %v", err)
+		}
+	}
+	return GetExitCode()
+}
+
+// Runs the strings import command, returns the exit code. The package isn't written if any translation is invalid.
+func ImportStrings(pkgFile string, translationsFile string, outputFile string) int {
+	err := ValidateDiagnosticsOptions()
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "Invalid diagnostics options: %v", err)
+		return GetExitCode()
+	}
+	err = validateStringsCharset()
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "%v", err)
+		return GetExitCode()
+	}
+
+	data, err := os.ReadFile(pkgFile)
+	if err != nil {
+		Diagnose(DIAG_READ_FAILED, "Failed to read file: %v", err)
+		return GetExitCode()
+	}
+	translations, err := loadTranslations(translationsFile)
+	if err != nil {
+		Diagnose(DIAG_INVALID_TRANSLATION, "Failed to read translations: %v", err)
+		return GetExitCode()
+	}
+
+	result, replaced, err := applyTranslations(data, translations)
+	if err != nil {
+		Diagnose(DIAG_READ_FAILED, "Failed to read file: %v", err)
+		return GetExitCode()
+	}
+	for _, d := range DIAGNOSTICS {
+		if d.Code == DIAG_INVALID_TRANSLATION {
+			fmt.Printf("Not writing the package because of invalid translations\n")
+			return EXIT_FATAL
+		}
+	}
+
+	fmt.Printf("Replaced %d strings, writing package: %s\n", replaced, outputFile)
+
+	err = os.WriteFile(outputFile, result, 0644)
+	if err != nil {
+		Diagnose(DIAG_WRITE_FAILED, "Failed to write package: %v", err)
+	}
+	return GetExitCode()
+}
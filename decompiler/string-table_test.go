@@ -0,0 +1,176 @@
+package decompiler
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Writes the built package and exports its strings, returns the package bytes and the exported file
+func exportTestStrings(t *testing.T, b *pkgBuilder, extension string) ([]byte, string) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	data := b.Bytes()
+	if err := os.WriteFile(INPUT_FILE, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stringsFile := filepath.Join(dir, "strings"+extension)
+	if exitCode := ExportStrings(INPUT_FILE, stringsFile); exitCode != EXIT_SUCCESS {
+		t.Fatalf("expected exit code %d, got %d", EXIT_SUCCESS, exitCode)
+	}
+	return data, stringsFile
+}
+
+func TestExportStrings(t *testing.T) {
+	_, stringsFile := exportTestStrings(t, buildCallsPackage(), ".po")
+	output, err := os.ReadFile(stringsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"#. Util.FindUnit( name )\n#: Golden.Approach:0x",
+		"#. Util.Print( text )\n#: local_function_1:0x",
+		"msgctxt \"1\"\nmsgid \"watching\"\nmsgstr \"\"\n",
+	} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("expected %q in:\n%s", expected, output)
+		}
+	}
+}
+
+func TestImportStrings(t *testing.T) {
+	data, stringsFile := exportTestStrings(t, buildStringsPackage(), ".po")
+	output, err := os.ReadFile(stringsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Translate the first string and the one with quotes and a line break
+	translated := strings.Replace(string(output), "msgid \"Hello\"\nmsgstr \"\"", "msgid \"Hello\"\nmsgstr \"Bonjour\"", 1)
+	translated = strings.TrimSuffix(translated, "msgstr \"\"\n") + "msgstr \"\"\n\"Bonjour \\\"l'inconnu\\\"\\n\"\n"
+	if translated == string(output) {
+		t.Fatalf("failed to translate:\n%s", output)
+	}
+	if err := os.WriteFile(stringsFile, []byte(translated), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newFile := filepath.Join(filepath.Dir(stringsFile), "translated.pkg")
+	if exitCode := ImportStrings(INPUT_FILE, stringsFile, newFile); exitCode != EXIT_SUCCESS {
+		t.Fatalf("expected exit code %d, got %d", EXIT_SUCCESS, exitCode)
+	}
+	newData, err := os.ReadFile(newFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, newData) {
+		t.Fatalf("expected the package to change")
+	}
+
+	if problems := validateTestPackage(t, newData); len(problems) > 0 {
+		t.Fatalf("expected a valid package, got %v", problems)
+	}
+	ResetState()
+	LoadDeclarationsFromHeaders(INCLUDES_DIR)
+	if err := readPackage(newData); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Bonjour", "", "Bonjour \"l'inconnu\"\n"}
+	if !reflect.DeepEqual(STRING_TABLE, expected) {
+		t.Fatalf("expected strings %q, got %q", expected, STRING_TABLE)
+	}
+}
+
+func TestImportStringsCsv(t *testing.T) {
+	data, stringsFile := exportTestStrings(t, buildStringsPackage(), ".csv")
+	newFile := filepath.Join(filepath.Dir(stringsFile), "translated.pkg")
+
+	// Nothing translated leaves the package the same
+	if exitCode := ImportStrings(INPUT_FILE, stringsFile, newFile); exitCode != EXIT_SUCCESS {
+		t.Fatalf("expected exit code %d, got %d", EXIT_SUCCESS, exitCode)
+	}
+	newData, err := os.ReadFile(newFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, newData) {
+		t.Fatalf("expected the package to be unchanged")
+	}
+
+	// A string that isn't in the package stops the import
+	os.Remove(newFile)
+	err = os.WriteFile(stringsFile, []byte("index,source,translation,context\n7,Hello,Bonjour,\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode := ImportStrings(INPUT_FILE, stringsFile, newFile); exitCode != EXIT_FATAL {
+		t.Fatalf("expected exit code %d, got %d", EXIT_FATAL, exitCode)
+	}
+	if _, err := os.Stat(newFile); err == nil {
+		t.Fatalf("expected the package not to be written")
+	}
+}
+
+// Greet(string name) prints a Windows-1252 string
+func buildCharsetPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Greet")
+
+	// Util.Print("Café – à bientôt");
+	b.String("Caf\xe9 \x96 \xe0 bient\xf4t").CallImported("util", "Print", 1).Pop()
+
+	b.End("greet.end", false)
+
+	return b
+}
+
+func TestStringsCharset(t *testing.T) {
+	_, stringsFile := exportTestStrings(t, buildCharsetPackage(), ".po")
+	output, err := os.ReadFile(stringsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(output), "msgid \"Café – à bientôt\"\n") {
+		t.Fatalf("expected the string to be converted to UTF-8:\n%s", output)
+	}
+
+	newFile := filepath.Join(filepath.Dir(stringsFile), "translated.pkg")
+	translate := func(translation string) int {
+		os.Remove(newFile)
+		translated := strings.TrimSuffix(string(output), "msgstr \"\"\n") + fmt.Sprintf("msgstr %q\n", translation)
+		if err := os.WriteFile(stringsFile, []byte(translated), 0644); err != nil {
+			t.Fatal(err)
+		}
+		DIAGNOSTICS = []*Diagnostic{}
+		return ImportStrings(INPUT_FILE, stringsFile, newFile)
+	}
+
+	// A character Windows-1252 doesn't have stops the import
+	if exitCode := translate("Café – 再见"); exitCode != EXIT_FATAL {
+		t.Fatalf("expected exit code %d, got %d", EXIT_FATAL, exitCode)
+	}
+
+	if exitCode := translate("“Tschüss” €"); exitCode != EXIT_SUCCESS {
+		t.Fatalf("expected exit code %d, got %d", EXIT_SUCCESS, exitCode)
+	}
+	newData, err := os.ReadFile(newFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ResetState()
+	LoadDeclarationsFromHeaders(INCLUDES_DIR)
+	if err := readPackage(newData); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"\x93Tsch\xfcss\x94 \x80"}
+	if !reflect.DeepEqual(STRING_TABLE, expected) {
+		t.Fatalf("expected strings %q, got %q", expected, STRING_TABLE)
+	}
+}
@@ -0,0 +1,45 @@
+package decompiler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Report(int value) has an if with another if inside it and an else, then a call too long for a narrow line
+func buildStylePackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Report")
+
+	// if (value > 1) { if (value > 2) { Util.Log(value); } } else { Util.Log(0); }
+	b.Int(1).Read(0).Op(OP_INT_GT).Jump(OP_JUMP_IF_FALSE, "report.else")
+	b.Int(2).Read(0).Op(OP_INT_GT).Jump(OP_JUMP_IF_FALSE, "report.endinner")
+	b.Read(0).CallImported("util", "Log", 1).Pop()
+	b.Label("report.endinner")
+	b.Jump(OP_JUMP, "report.endif")
+	b.Label("report.else")
+	b.Int(0).CallImported("util", "Log", 1).Pop()
+	b.Label("report.endif")
+
+	// Util.Distance(Util.FindUnit("first scout"), Util.FindUnit("second scout"));
+	b.String("first scout").CallImported("util", "FindUnit", 1)
+	b.String("second scout").CallImported("util", "FindUnit", 1)
+	b.CallImported("util", "Distance", 2).Pop()
+
+	b.End("report.end", false)
+
+	return b
+}
+
+func TestSameLineBracesAndWrapping(t *testing.T) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	STYLE_FILE = filepath.Join(dir, "style.json")
+	err := os.WriteFile(STYLE_FILE, []byte(`{"braceStyle": "same-line", "maxLineWidth": 40}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compareGolden(t, "style", decompileBuiltPackage(t, buildStylePackage()))
+}
@@ -0,0 +1,360 @@
+package decompiler
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Command line option for the task report
+var TASK_REPORT_FILE string
+
+// What happens to the htask handle a start returns
+const (
+	TASK_DROPPED  = "dropped"
+	TASK_STORED   = "stored"
+	TASK_RETURNED = "returned"
+	TASK_PASSED   = "passed"
+)
+
+// Parts of the names of imported functions that stop a task or wait for it to finish, matched ignoring case
+var TASK_HALT_NAMES = []string{"halt", "kill", "stop", "terminate", "abort", "cancel"}
+var TASK_JOIN_NAMES = []string{"join", "wait", "finish"}
+
+// A task started by a function and what happens to its handle afterwards
+type TaskStart struct {
+	offset uint32
+	task   *FunctionDeclaration
+	fate   string
+	// The variable the handle is stored in, or the function it is passed to
+	target string
+	// Started every time around a loop or every time a schedule runs
+	repeated bool
+	uses     []string
+	// Halted, joined or given to something else that is responsible for it
+	handled bool
+	// Why the task runs until it is stopped, empty if it finishes by itself
+	running string
+	// Tasks from other packages weren't decompiled, so there is no telling if they finish
+	known bool
+}
+
+type TaskFunctionReport struct {
+	function    *FunctionDefinition
+	longRunning string
+	starts      []*TaskStart
+}
+
+func matchesTaskName(name string, fragments []string) bool {
+	name = strings.ToLower(name)
+	for _, fragment := range fragments {
+		if strings.Contains(name, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTaskStart(node *OpGraph) bool {
+	return node.operation.opcode == OP_TASK_CALL_LOCAL || node.operation.opcode == OP_TASK_CALL_IMPORTED
+}
+
+// Checks if the elements can return, or break out of the loop they are the body of
+func canLeaveLoop(elements []BlockElement) bool {
+	result := false
+	WalkBlockElements(elements, func(e BlockElement) bool {
+		switch e.(type) {
+		case *WhileLoop, *DoWhileLoop, *ForLoop, *SwitchBlock, *ScheduleBlock:
+			// A break in here belongs to the inner block, only a return gets out
+			ForEachStatement([]BlockElement{e}, func(s *Statement) {
+				result = result || isReturnStatement(s)
+			})
+			return false
+		}
+		if s, ok := e.(*Statement); ok && s.graph.operation.opcode == OP_JUMP && s.graph.code != nil {
+			result = result || isReturnGraph(s.graph) || *s.graph.code == "break"
+		}
+		return true
+	})
+	return result
+}
+
+// Works out which functions run until something stops them. Functions with a schedule keep running it, and so do
+// functions with a loop that has no obvious bound and nothing to get out of it. Functions that call one of those
+// don't return either.
+func getLongRunningFunctions() map[*FunctionDeclaration]string {
+	result := map[*FunctionDeclaration]string{}
+	for _, fnc := range DECOMPILED_FUNCS {
+		WalkBlockElements(fnc.body, func(e BlockElement) bool {
+			if _, ok := result[fnc.declaration]; ok {
+				return false
+			}
+			if _, ok := e.(*ScheduleBlock); ok {
+				result[fnc.declaration] = "has a schedule"
+			}
+			if kind, bounded := getLoopBound(e); len(kind) > 0 && !bounded && !canLeaveLoop(*GetElementBodies(e)[0]) {
+				result[fnc.declaration] = fmt.Sprintf("has a %s loop with no obvious bound", kind)
+			}
+			return true
+		})
+	}
+
+	spreadToCallers(result)
+	return result
+}
+
+// Adds the functions that call any of the functions in the map, directly or through other functions, until no more
+// are found. The reason a caller is added is the function it calls.
+func spreadToCallers(result map[*FunctionDeclaration]string) {
+	for changed := true; changed; {
+		changed = false
+		for _, fnc := range DECOMPILED_FUNCS {
+			if _, ok := result[fnc.declaration]; ok {
+				continue
+			}
+			ForEachStatement(fnc.body, func(s *Statement) {
+				s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+					if node.operation.opcode != OP_FUNCTION_CALL_LOCAL {
+						return
+					}
+					if _, ok := result[fnc.declaration]; ok {
+						return
+					}
+					callee := node.operation.GetFunctionDeclaration()
+					if _, ok := result[callee]; ok {
+						result[fnc.declaration] = fmt.Sprintf("calls %s", callee.name)
+						changed = true
+					}
+				})
+			})
+		}
+	}
+}
+
+// Calls visit for each statement with whether it runs more than once each time the function runs
+func forEachStatementRepeated(elements []BlockElement, repeated bool, visit func(s *Statement, repeated bool)) {
+	for _, e := range elements {
+		for _, s := range GetElementStatements(e) {
+			visit(s, repeated)
+		}
+
+		bodyRepeated := repeated
+		switch e.(type) {
+		case *WhileLoop, *DoWhileLoop, *ForLoop, *ScheduleEveryBlock:
+			bodyRepeated = true
+		}
+		for _, body := range GetElementBodies(e) {
+			forEachStatementRepeated(*body, bodyRepeated, visit)
+		}
+	}
+}
+
+// Gets the offset of the next write to the variable after the offset, or the end of the function if there isn't one
+func getNextVariableWriteOffset(fd *FunctionDefinition, index uint32, offset uint32) uint32 {
+	result := fd.scope.functionEndOffset
+	ForEachStatement(fd.body, func(s *Statement) {
+		s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+			op := node.operation
+			if op.opcode == OP_VARIABLE_WRITE && op.GetVariableStackIndex() == index && op.offset > offset && op.offset < result {
+				result = op.offset
+			}
+		})
+	})
+	return result
+}
+
+// Follows a stored handle through the function to see if anything halts it, joins it or takes it over. Only the
+// reads up to the next write to the variable see this handle, the ones after that see the next one.
+func (ts *TaskStart) trackStoredHandle(fd *FunctionDefinition, index uint32, writeOffset uint32) {
+	nextWriteOffset := getNextVariableWriteOffset(fd, index, writeOffset)
+	ForEachStatement(fd.body, func(s *Statement) {
+		s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+			if node.operation.opcode != OP_VARIABLE_READ || node.operation.GetVariableStackIndex() != index || parent == nil {
+				return
+			}
+			if node.operation.offset <= writeOffset || node.operation.offset >= nextWriteOffset {
+				return
+			}
+
+			offset := parent.operation.offset
+			switch {
+			case parent.operation.IsFunctionCall():
+				callee := parent.operation.GetFunctionDeclaration()
+				name := callee.GetScopedName()
+				// Imported functions that don't halt or join the task only look at it, local ones can take it over
+				switch {
+				case parent.operation.opcode == OP_FUNCTION_CALL_IMPORTED && matchesTaskName(callee.name, TASK_HALT_NAMES):
+					ts.uses = append(ts.uses, fmt.Sprintf("halted by %s at 0x%08X", name, offset))
+					ts.handled = true
+				case parent.operation.opcode == OP_FUNCTION_CALL_IMPORTED && matchesTaskName(callee.name, TASK_JOIN_NAMES):
+					ts.uses = append(ts.uses, fmt.Sprintf("joined by %s at 0x%08X", name, offset))
+					ts.handled = true
+				case parent.operation.opcode == OP_FUNCTION_CALL_IMPORTED:
+					ts.uses = append(ts.uses, fmt.Sprintf("read by %s at 0x%08X", name, offset))
+				default:
+					ts.uses = append(ts.uses, fmt.Sprintf("passed to %s at 0x%08X", name, offset))
+					ts.handled = true
+				}
+
+			case isReturnGraph(parent):
+				ts.uses = append(ts.uses, fmt.Sprintf("returned at 0x%08X", offset))
+				ts.handled = true
+
+			case parent.operation.opcode == OP_VARIABLE_WRITE:
+				// Copying the handle to another variable doesn't stop the task, the copy goes out of scope too
+				name := "unknown"
+				if v := parent.operation.GetVariable(fd.scope); v != nil {
+					name = v.variableName
+				}
+				ts.uses = append(ts.uses, fmt.Sprintf("copied to %s at 0x%08X", name, offset))
+			}
+		})
+	})
+}
+
+// Finds each task the function starts and follows its handle
+func (fd *FunctionDefinition) GetTaskStarts() []*TaskStart {
+	result := []*TaskStart{}
+	forEachStatementRepeated(fd.body, false, func(s *Statement, repeated bool) {
+		s.graph.Walk(func(node *OpGraph, parent *OpGraph) {
+			if !isTaskStart(node) {
+				return
+			}
+
+			ts := &TaskStart{
+				offset:   node.operation.offset,
+				task:     node.operation.GetFunctionDeclaration(),
+				fate:     TASK_DROPPED,
+				repeated: repeated,
+			}
+
+			switch {
+			case parent == nil || parent.operation.opcode == OP_POP_STACK:
+
+			case parent.operation.opcode == OP_VARIABLE_WRITE:
+				ts.fate = TASK_STORED
+				ts.target = "unknown"
+				if v := parent.operation.GetVariable(fd.scope); v != nil {
+					ts.target = v.variableName
+				}
+				ts.trackStoredHandle(fd, parent.operation.GetVariableStackIndex(), parent.operation.offset)
+
+			case isReturnGraph(parent):
+				ts.fate = TASK_RETURNED
+				ts.handled = true
+
+			default:
+				ts.fate = TASK_PASSED
+				ts.target = "an expression"
+				if callee := parent.operation.GetFunctionDeclaration(); callee != nil {
+					ts.target = callee.GetScopedName()
+				}
+				ts.handled = true
+			}
+
+			result = append(result, ts)
+		})
+	})
+	return result
+}
+
+// A started task can run away if nothing is responsible for stopping it and it doesn't finish by itself
+func (ts *TaskStart) IsRunaway() bool {
+	return !ts.handled && (!ts.known || len(ts.running) > 0)
+}
+
+func GetTaskReports() []*TaskFunctionReport {
+	longRunning := getLongRunningFunctions()
+
+	result := []*TaskFunctionReport{}
+	for _, fnc := range DECOMPILED_FUNCS {
+		report := &TaskFunctionReport{
+			function:    fnc,
+			longRunning: longRunning[fnc.declaration],
+			starts:      fnc.GetTaskStarts(),
+		}
+		for _, ts := range report.starts {
+			ts.running = longRunning[ts.task]
+			ts.known = ts.task.pkg == "" || strings.EqualFold(ts.task.pkg, EXPORTING_PACKAGE)
+		}
+		if len(report.longRunning) > 0 || len(report.starts) > 0 {
+			result = append(result, report)
+		}
+	}
+	return result
+}
+
+func writeTaskReport() error {
+	fmt.Printf("Writing task report: %s\n", TASK_REPORT_FILE)
+
+	outputFile, err := os.OpenFile(TASK_REPORT_FILE, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	writer := NewCodeWriter(outputFile)
+	writer.Appendf("Package: %s (%s)\n", EXPORTING_PACKAGE, INPUT_FILE)
+
+	runaways := []string{}
+	for _, report := range GetTaskReports() {
+		name := report.function.declaration.GetScopedName()
+		writer.Appendf("\nFunction: %s\n", name)
+		writer.PushIndent()
+
+		if len(report.longRunning) > 0 {
+			writer.Appendf("Long running: %s\n", report.longRunning)
+		}
+
+		for _, ts := range report.starts {
+			writer.Appendf("Start: 0x%08X %s\n", ts.offset, ts.task.GetScopedName())
+			writer.PushIndent()
+
+			switch ts.fate {
+			case TASK_DROPPED:
+				writer.Append("Handle dropped\n")
+			case TASK_STORED:
+				writer.Appendf("Handle stored in %s\n", ts.target)
+			case TASK_RETURNED:
+				writer.Append("Handle returned\n")
+			case TASK_PASSED:
+				writer.Appendf("Handle passed to %s\n", ts.target)
+			}
+			for _, use := range ts.uses {
+				writer.Appendf("Handle %s\n", use)
+			}
+			if ts.fate == TASK_STORED && !ts.handled {
+				writer.Appendf("Handle leaks when %s returns\n", name)
+			}
+			if ts.repeated {
+				writer.Append("Started again every time around a loop or schedule\n")
+			}
+
+			switch {
+			case !ts.known:
+				writer.Append("Task isn't in this package, it may run until it is stopped\n")
+			case len(ts.running) > 0:
+				writer.Appendf("Task runs until it is stopped, it %s\n", ts.running)
+			default:
+				writer.Append("Task finishes by itself\n")
+			}
+
+			if ts.IsRunaway() {
+				runaways = append(runaways, fmt.Sprintf("%s 0x%08X %s", name, ts.offset, ts.task.GetScopedName()))
+			}
+
+			writer.PopIndent()
+		}
+
+		writer.PopIndent()
+	}
+
+	writer.Appendf("\nPossible runaway tasks: %d\n", len(runaways))
+	writer.PushIndent()
+	for _, runaway := range runaways {
+		writer.Appendf("%s\n", runaway)
+	}
+	writer.PopIndent()
+
+	return nil
+}
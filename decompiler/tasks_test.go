@@ -0,0 +1,164 @@
+package decompiler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Guard(hunit unit) starts tasks that are halted, leaked, dropped and started again every time around a loop
+func buildTasksPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Guard").Locals(3)
+
+	// watcher = start Watch(unit);
+	b.Read(0).Start("Watch", 1).Store(1)
+
+	// lost = start Watch(unit);
+	b.Read(0).Start("Watch", 1).Store(2)
+
+	// start Util.Wander(unit);
+	b.Read(0).StartImported("util", "Wander", 1).Pop()
+
+	// for (ii = 0; ii < 3; ii++) { start Ping(); }
+	b.Int(0).Store(3)
+	b.Label("guard.for")
+	b.Int(3).Read(3).Op(OP_INT_LT).Jump(OP_JUMP_IF_FALSE, "guard.endfor")
+	b.Start("Ping", 0).Pop()
+	b.Int(1).Read(3).Op(OP_INT_ADD).Store(3)
+	b.Jump(OP_JUMP, "guard.for")
+	b.Label("guard.endfor")
+
+	// Util.HaltTask(watcher);
+	b.Read(1).CallImported("util", "HaltTask", 1).Pop()
+
+	b.End("guard.end", false)
+
+	// task Watch(hunit unit) { schedule { every 1.0: { Util.Print("watching"); } } }
+	b.Label("Watch")
+	b.Label("watch.schedule")
+	b.Op(OP_SCHEDULE_START)
+	b.Every("watch.loop", 1.0)
+	b.String("watching").CallImported("util", "Print", 1).Pop()
+	b.Label("watch.loop")
+	b.Jump(OP_JUMP, "watch.schedule")
+	b.End("watch.end", true)
+
+	// task Ping() { Util.Print("ping"); }
+	b.Label("Ping")
+	b.String("ping").CallImported("util", "Print", 1).Pop()
+	b.End("ping.end", true)
+
+	return b
+}
+
+func TestTaskReport(t *testing.T) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	TASK_REPORT_FILE = filepath.Join(dir, "tasks.txt")
+	decompileBuiltPackage(t, buildTasksPackage())
+
+	report, err := os.ReadFile(TASK_REPORT_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Package: Golden (` + INPUT_FILE + `)
+
+Function: Golden.Guard
+	Start: 0x0000000A local_function_0
+		Handle stored in local_0
+		Handle halted by Util.HaltTask at 0x00000081
+		Task runs until it is stopped, it has a schedule
+	Start: 0x00000022 local_function_0
+		Handle stored in local_1
+		Handle leaks when Golden.Guard returns
+		Task runs until it is stopped, it has a schedule
+	Start: 0x0000003A Util.Wander
+		Handle dropped
+		Task isn't in this package, it may run until it is stopped
+	Start: 0x0000005C local_function_1
+		Handle dropped
+		Started again every time around a loop or schedule
+		Task finishes by itself
+
+Function: local_function_0
+	Long running: has a schedule
+
+Possible runaway tasks: 2
+	Golden.Guard 0x00000022 local_function_0
+	Golden.Guard 0x0000003A Util.Wander
+`
+	if string(report) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, report)
+	}
+}
+
+// Guard(hunit unit) reuses one handle variable for a task that loops forever and one that is halted
+func buildTaskHandlesPackage() *pkgBuilder {
+	b := newPkgBuilder("golden")
+
+	b.Export("Guard").Locals(1)
+
+	// spinner = start Spin(); Debug.PrintHandle(spinner);
+	b.Start("Spin", 0).Store(1)
+	b.Read(1).CallImported("debug", "PrintHandle", 1).Pop()
+
+	// spinner = start Ping(); Util.HaltTask(spinner);
+	b.Start("Ping", 0).Store(1)
+	b.Read(1).CallImported("util", "HaltTask", 1).Pop()
+
+	b.End("guard.end", false)
+
+	// task Spin() { while (1) { Util.Print("spinning"); } }
+	b.Label("Spin")
+	b.Label("spin.while")
+	b.Int(1).Jump(OP_JUMP_IF_FALSE, "spin.endwhile")
+	b.String("spinning").CallImported("util", "Print", 1).Pop()
+	b.Jump(OP_JUMP, "spin.while")
+	b.Label("spin.endwhile")
+	b.End("spin.end", true)
+
+	// task Ping() { Util.Print("ping"); }
+	b.Label("Ping")
+	b.String("ping").CallImported("util", "Print", 1).Pop()
+	b.End("ping.end", true)
+
+	return b
+}
+
+func TestTaskReportHandles(t *testing.T) {
+	dir := t.TempDir()
+	resetForTest(dir)
+	TASK_REPORT_FILE = filepath.Join(dir, "tasks.txt")
+	decompileBuiltPackage(t, buildTaskHandlesPackage())
+
+	report, err := os.ReadFile(TASK_REPORT_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Package: Golden (` + INPUT_FILE + `)
+
+Function: Golden.Guard
+	Start: 0x00000005 local_function_0
+		Handle stored in local_0
+		Handle read by Debug.PrintHandle at 0x0000001D
+		Handle leaks when Golden.Guard returns
+		Task runs until it is stopped, it has a while loop with no obvious bound
+	Start: 0x0000002B local_function_1
+		Handle stored in local_0
+		Handle halted by Util.HaltTask at 0x00000043
+		Task finishes by itself
+
+Function: local_function_0
+	Long running: has a while loop with no obvious bound
+
+Possible runaway tasks: 1
+	Golden.Guard 0x00000005 local_function_0
+`
+	if string(report) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, report)
+	}
+}
@@ -0,0 +1,5 @@
+// Functions exported by the package that copies golden test functions
+
+uses Util;
+
+prototype Copies.Report( int value );
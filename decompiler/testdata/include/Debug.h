@@ -0,0 +1,6 @@
+// Debug package used by the instrumentation tests
+
+prototype Debug.PrintString( string text );
+prototype Debug.PrintInt( int value );
+prototype Debug.PrintFloat( float value );
+prototype Debug.PrintHandle( hobject handle );
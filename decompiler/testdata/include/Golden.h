@@ -0,0 +1,16 @@
+// Functions exported by the golden test packages
+
+uses Util;
+
+prototype int Golden.Clamp( int value, int low, int high );
+prototype int Golden.SumTo( int count );
+prototype Golden.Describe( hunit unit );
+prototype task Golden.Patrol( hunit unit );
+prototype Golden.Report( int value );
+prototype Golden.Greet( string name );
+prototype float Golden.Approach( hunit unit, float speed );
+prototype int Golden.Fuzz( int a, int b );
+prototype Golden.Announce( eMode mode, bool loud );
+prototype Golden.Guard( hunit unit );
+prototype Golden.Hold( hunit unit, int count );
+prototype Golden.Retask( htask task, hunit unit );
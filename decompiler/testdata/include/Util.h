@@ -0,0 +1,43 @@
+// Utility package imported by the golden test packages
+
+handle hunit : hobject;
+
+enum eMode
+{
+	MODE_IDLE,
+	MODE_PATROL,
+	MODE_ATTACK = 4
+};
+
+prototype int Util.Random( int low, int high );
+prototype Util.Print( string text );
+prototype Util.Log( int value );
+prototype eMode Util.GetMode( hunit unit );
+prototype hunit Util.FindUnit( string name );
+prototype float Util.Distance( hunit a, hunit b );
+prototype task Util.Wander( hunit unit );
+prototype Util.HaltTask( htask task );
+prototype Util.Sleep( float seconds );
+prototype hobject Util.FindObject( string name );
+prototype hunit Util.Cast( hobject object );
+
+enum eFlags
+{
+	FLAG_NONE,
+	FLAG_ARMED = 1,
+	FLAG_MOVING = 2,
+	FLAG_HIDDEN = 4
+};
+
+enum eLevel
+{
+	LEVEL_LOW,
+	LEVEL_MEDIUM,
+	LEVEL_HIGH,
+	LEVEL_HIGHER,
+	LEVEL_HIGHEST
+};
+
+prototype Util.SetFlags( eFlags flags );
+prototype eFlags Util.GetFlags( hunit unit );
+prototype eLevel Util.GetLevel( hunit unit );
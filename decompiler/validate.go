@@ -0,0 +1,357 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+type validatedImport struct {
+	pkg    string
+	name   string
+	offset uint32
+}
+
+type validatedExport struct {
+	name   string
+	offset uint32
+}
+
+type validatedOperation struct {
+	opcode        byte
+	offset        uint32
+	data          []byte
+	functionIndex int
+}
+
+// Checks the internal consistency of a pkg file without decompiling it. Unlike readPackage it keeps going after a
+// problem so one run finds as many of them as it can.
+type pkgValidator struct {
+	problems    int
+	imports     []validatedImport
+	exports     []validatedExport
+	stringCount uint32
+	hasStrings  bool
+	code        []byte
+	hasCode     bool
+	// Whether every section could be walked, otherwise missing sections might just not have been reached
+	readAllSections bool
+	operations      []validatedOperation
+	offsetToOpId    map[uint32]int
+}
+
+func (v *pkgValidator) report(code string, format string, a ...interface{}) {
+	v.problems++
+	Diagnose(code, format, a...)
+}
+
+func (v *pkgValidator) reportAt(code string, offset uint32, format string, a ...interface{}) {
+	v.problems++
+	DiagnoseAt(code, offset, format, a...)
+}
+
+func isPrintableIdentifier(identifier []byte) bool {
+	for _, b := range identifier {
+		if b < 0x20 || b > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *pkgValidator) validateForm(reader *pkgReader) *pkgReader {
+	form, err := readSectionHeader(reader)
+	if err != nil {
+		v.report(DIAG_INVALID_FORM, "Failed to read the FORM header: %v", err)
+		return nil
+	}
+	if form.identifier != "FORM" {
+		v.report(DIAG_INVALID_FORM, "The file starts with a %q section instead of FORM", form.identifier)
+		return nil
+	}
+
+	length := form.length
+	if length != reader.Remaining() {
+		v.report(DIAG_INVALID_FORM, "The FORM length is %d but the file has %d bytes after the header", length, reader.Remaining())
+		if length > reader.Remaining() {
+			length = reader.Remaining()
+		}
+	}
+	reader, _ = reader.Section(form.identifier, length)
+
+	// The decompiler skips the form type, but it should still look like an identifier
+	formType, err := reader.ReadBytes(4)
+	if err != nil {
+		v.report(DIAG_INVALID_FORM, "The FORM is too short to have a type")
+		return nil
+	}
+	if !isPrintableIdentifier(formType) {
+		v.report(DIAG_INVALID_FORM, "The FORM type %X isn't a printable identifier", formType)
+	}
+	return reader
+}
+
+func (v *pkgValidator) validateSections(reader *pkgReader) {
+	for reader.Remaining() > 0 {
+		start := reader.Position()
+		section, err := readSectionHeader(reader)
+		if err != nil {
+			v.report(DIAG_INVALID_SECTION, "Failed to read the section header at file offset 0x%08X: %v", start, err)
+			return
+		}
+		if section.length > reader.Remaining() {
+			v.report(DIAG_INVALID_SECTION, "The %s section at file offset 0x%08X has length %d, but only %d bytes are left in the FORM", section.identifier, start, section.length, reader.Remaining())
+			return
+		}
+
+		sectionReader, _ := reader.Section(section.identifier, section.length)
+		err = v.validateSection(sectionReader, section)
+		if err != nil {
+			v.report(DIAG_INVALID_SECTION, "The %s section at file offset 0x%08X is malformed: %v", section.identifier, start, err)
+		}
+
+		if section.length%2 == 1 {
+			padding, err := reader.ReadBytes(1)
+			if err != nil {
+				v.report(DIAG_INVALID_SECTION, "The %s section at file offset 0x%08X has an odd length but no padding byte", section.identifier, start)
+			} else if padding[0] != 0 {
+				v.report(DIAG_INVALID_SECTION, "The %s section at file offset 0x%08X has a padding byte of 0x%02X instead of 0", section.identifier, start, padding[0])
+			}
+		}
+	}
+	v.readAllSections = true
+}
+
+func (v *pkgValidator) validateSection(reader *pkgReader, section *SectionHeader) error {
+	switch section.identifier {
+	case "PKHD":
+		name, err := reader.ReadString()
+		if err != nil {
+			return err
+		}
+		EXPORTING_PACKAGE = name
+
+	case "PIMP":
+		name, err := reader.ReadString()
+		if err != nil {
+			return err
+		}
+		if name != SYSTEM_PACKAGE {
+			if len(INCLUDES_DIR) > 0 {
+				if _, ok := PACKAGES[name]; !ok {
+					v.report(DIAG_UNKNOWN_IMPORT_PACKAGE, "Imported package '%s' isn't in the includes", name)
+				}
+			}
+		}
+		IMPORTING_PACKAGE = name
+
+	case "FIMP":
+		name, err := reader.ReadString()
+		if err != nil {
+			return err
+		}
+		count, err := reader.ReadUInt32BigEndian()
+		if err != nil {
+			return err
+		}
+		for ii := uint32(0); ii < count; ii++ {
+			offset, err := reader.ReadUInt32BigEndian()
+			if err != nil {
+				return err
+			}
+			v.imports = append(v.imports, validatedImport{pkg: IMPORTING_PACKAGE, name: name, offset: offset})
+		}
+
+	case "FEXP":
+		name, err := reader.ReadString()
+		if err != nil {
+			return err
+		}
+		offset, err := reader.ReadUInt32BigEndian()
+		if err != nil {
+			return err
+		}
+		v.exports = append(v.exports, validatedExport{name: name, offset: offset})
+
+	case "STAB":
+		count, err := reader.ReadUInt32BigEndian()
+		if err != nil {
+			return err
+		}
+		for ii := uint32(0); ii < count; ii++ {
+			_, err := reader.ReadString()
+			if err != nil {
+				return err
+			}
+		}
+		v.stringCount = count
+		v.hasStrings = true
+
+	case "CODE":
+		length, err := reader.ReadUInt32BigEndian()
+		if err != nil {
+			return err
+		}
+		if length > reader.Remaining() {
+			return reader.Error(ErrLengthOutOfRange, "code length %d is past the end of the section, %d bytes left", length, reader.Remaining())
+		}
+		v.code, _ = reader.ReadBytes(length)
+		v.hasCode = true
+	}
+	return nil
+}
+
+// Splits the code into operations without parsing their data, so nothing depends on the other sections
+func (v *pkgValidator) decodeCode() bool {
+	v.offsetToOpId = map[uint32]int{}
+	codeLength := uint32(len(v.code))
+	functionIndex := 0
+
+	for offset := uint32(0); offset < codeLength; {
+		opcode := v.code[offset]
+		opInfo, ok := OP_MAP[opcode]
+		if !ok {
+			v.reportAt(DIAG_INVALID_CODE, offset, "Unknown opcode 0x%02X, the rest of the code can't be checked", opcode)
+			return false
+		}
+		dataSize := uint32(opInfo.dataSize)
+		if dataSize > codeLength-offset-1 {
+			v.reportAt(DIAG_INVALID_CODE, offset, "%s needs %d bytes of data but the code ends first", opInfo.name, dataSize)
+			return false
+		}
+
+		v.offsetToOpId[offset] = len(v.operations)
+		v.operations = append(v.operations, validatedOperation{
+			opcode:        opcode,
+			offset:        offset,
+			data:          v.code[offset+1 : offset+1+dataSize],
+			functionIndex: functionIndex,
+		})
+		if opcode == OP_FUNCTION_END {
+			functionIndex++
+		}
+		offset += 1 + dataSize
+	}
+
+	if len(v.operations) > 0 && v.operations[len(v.operations)-1].opcode != OP_FUNCTION_END {
+		v.reportAt(DIAG_INVALID_CODE, v.operations[len(v.operations)-1].offset, "The code doesn't end with OP_FUNCTION_END")
+	}
+	return true
+}
+
+func (v *pkgValidator) isFunctionStart(offset uint32) bool {
+	idx, ok := v.offsetToOpId[offset]
+	return ok && (idx == 0 || v.operations[idx-1].opcode == OP_FUNCTION_END)
+}
+
+func (v *pkgValidator) validateCode() {
+	importOffsets := map[uint32]bool{}
+	for _, imp := range v.imports {
+		importOffsets[imp.offset] = true
+
+		idx, ok := v.offsetToOpId[imp.offset]
+		if !ok || (v.operations[idx].opcode != OP_FUNCTION_CALL_IMPORTED && v.operations[idx].opcode != OP_TASK_CALL_IMPORTED) {
+			v.reportAt(DIAG_INVALID_IMPORT_OFFSET, imp.offset, "The import of %s.%s doesn't point at an imported call", imp.pkg, imp.name)
+		}
+	}
+
+	for _, exp := range v.exports {
+		if !v.isFunctionStart(exp.offset) {
+			v.reportAt(DIAG_INVALID_EXPORT_OFFSET, exp.offset, "The export of %s doesn't point at the start of a function", exp.name)
+		}
+	}
+
+	for _, op := range v.operations {
+		opInfo := OP_MAP[op.opcode]
+		switch op.opcode {
+		case OP_JUMP, OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE, OP_JUMP_IF_NOT_DEBUG, OP_SCHEDULE_EVERY:
+			target := binary.LittleEndian.Uint32(op.data[0:4])
+			idx, ok := v.offsetToOpId[target]
+			if !ok {
+				v.reportAt(DIAG_INVALID_JUMP_TARGET, op.offset, "%s target 0x%08X isn't the start of an instruction", opInfo.name, target)
+			} else if v.operations[idx].functionIndex != op.functionIndex {
+				v.reportAt(DIAG_INVALID_JUMP_TARGET, op.offset, "%s target 0x%08X is in a different function", opInfo.name, target)
+			}
+
+		case OP_LITERAL_STRING:
+			index := binary.LittleEndian.Uint32(op.data[0:4])
+			if index >= v.stringCount {
+				v.reportAt(DIAG_INVALID_STRING_INDEX, op.offset, "String index %d is out of range, the string table has %d strings", index, v.stringCount)
+			}
+
+		case OP_FUNCTION_CALL_LOCAL, OP_TASK_CALL_LOCAL:
+			target := binary.LittleEndian.Uint32(op.data[4:8])
+			if !v.isFunctionStart(target) {
+				v.reportAt(DIAG_INVALID_CALL_TARGET, op.offset, "%s target 0x%08X isn't the start of a function", opInfo.name, target)
+			}
+
+		case OP_FUNCTION_CALL_IMPORTED, OP_TASK_CALL_IMPORTED:
+			if !importOffsets[op.offset] {
+				v.reportAt(DIAG_INVALID_IMPORT_OFFSET, op.offset, "%s has no function import", opInfo.name)
+			}
+		}
+	}
+}
+
+// Validates the bytes of a pkg file, returns the number of problems found. The problems are reported as diagnostics.
+func ValidatePackage(data []byte) int {
+	v := &pkgValidator{}
+
+	reader := v.validateForm(newPkgReader(data))
+	if reader == nil {
+		return v.problems
+	}
+	v.validateSections(reader)
+
+	if v.readAllSections {
+		if !v.hasStrings {
+			v.report(DIAG_INVALID_SECTION, "The package has no STAB section")
+		}
+		if !v.hasCode {
+			v.report(DIAG_INVALID_SECTION, "The package has no CODE section")
+		}
+	}
+	if !v.hasCode {
+		return v.problems
+	}
+
+	if v.decodeCode() {
+		v.validateCode()
+	}
+	return v.problems
+}
+
+// Runs the validate command, returns the exit code
+func Validate() int {
+	data, err := os.ReadFile(INPUT_FILE)
+	if err != nil {
+		Diagnose(DIAG_READ_FAILED, "Failed to read file: %v", err)
+		return GetExitCode()
+	}
+
+	fmt.Printf("Validating package: %s\n", INPUT_FILE)
+
+	err = ValidateDiagnosticsOptions()
+	if err != nil {
+		Diagnose(DIAG_INVALID_OPTION, "Invalid diagnostics options: %v", err)
+		return GetExitCode()
+	}
+
+	if len(INCLUDES_DIR) > 0 {
+		LoadDeclarationsFromHeaders(INCLUDES_DIR)
+	} else {
+		fmt.Printf("No includes directory, skipping the package import checks\n")
+	}
+
+	problems := ValidatePackage(data)
+	if problems > 0 {
+		fmt.Printf("Found %d problems\n", problems)
+		if GetExitCode() == EXIT_FATAL {
+			return EXIT_FATAL
+		}
+		return EXIT_THRESHOLD
+	}
+
+	fmt.Printf("Package is valid\n")
+	return GetExitCode()
+}
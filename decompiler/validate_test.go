@@ -0,0 +1,111 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func validateTestPackage(t *testing.T, data []byte) []string {
+	resetForTest(t.TempDir())
+	LoadDeclarationsFromHeaders(INCLUDES_DIR)
+	ValidatePackage(data)
+
+	codes := []string{}
+	for _, d := range DIAGNOSTICS {
+		codes = append(codes, d.Code)
+	}
+	return codes
+}
+
+func TestValidateGoldenPackages(t *testing.T) {
+	for _, gc := range goldenCases {
+		t.Run(gc.name, func(t *testing.T) {
+			codes := validateTestPackage(t, gc.build().Bytes())
+			if len(codes) > 0 {
+				t.Fatalf("expected a valid package, got %v", codes)
+			}
+		})
+	}
+}
+
+func TestValidateCorruptPackages(t *testing.T) {
+	// Builds a package with an exported function that calls a local one and an imported one
+	build := func() *pkgBuilder {
+		b := newPkgBuilder("golden")
+		b.Export("Report").String("hi").CallImported("util", "Print", 1).Pop().Call("helper", 0).Pop().End("report.end", false)
+		b.Label("helper").Int(2).Jump(OP_JUMP_IF_FALSE, "helper.end").Label("helper.body").Int(100).Pop().End("helper.end", false)
+		return b
+	}
+
+	cases := []struct {
+		name     string
+		corrupt  func(b *pkgBuilder) []byte
+		expected string
+	}{
+		{name: "valid", corrupt: func(b *pkgBuilder) []byte { return b.Bytes() }},
+		{name: "string index", corrupt: func(b *pkgBuilder) []byte {
+			binary.LittleEndian.PutUint32(b.code[1:], 3)
+			return b.Bytes()
+		}, expected: DIAG_INVALID_STRING_INDEX},
+		{name: "import offset", corrupt: func(b *pkgBuilder) []byte {
+			b.imports[0].functions[0].offsets[0]++
+			return b.Bytes()
+		}, expected: DIAG_INVALID_IMPORT_OFFSET},
+		{name: "export offset", corrupt: func(b *pkgBuilder) []byte {
+			b.exports[0].offset = b.labels["helper"] + 1
+			return b.Bytes()
+		}, expected: DIAG_INVALID_EXPORT_OFFSET},
+		{name: "jump into another function", corrupt: func(b *pkgBuilder) []byte {
+			b.labels["helper.end"] = b.labels["report.end"]
+			return b.Bytes()
+		}, expected: DIAG_INVALID_JUMP_TARGET},
+		{name: "jump between instructions", corrupt: func(b *pkgBuilder) []byte {
+			b.labels["helper.end"] = b.labels["helper.body"] + 1
+			return b.Bytes()
+		}, expected: DIAG_INVALID_JUMP_TARGET},
+		{name: "local call", corrupt: func(b *pkgBuilder) []byte {
+			b.labels["helper"]++
+			return b.Bytes()
+		}, expected: DIAG_INVALID_CALL_TARGET},
+		{name: "unknown import package", corrupt: func(b *pkgBuilder) []byte {
+			b.imports[0].name = "missing"
+			return b.Bytes()
+		}, expected: DIAG_UNKNOWN_IMPORT_PACKAGE},
+		{name: "form length", corrupt: func(b *pkgBuilder) []byte {
+			return append(b.Bytes(), 0, 0)
+		}, expected: DIAG_INVALID_FORM},
+		{name: "form type", corrupt: func(b *pkgBuilder) []byte {
+			data := b.Bytes()
+			data[8] = 0x01
+			return data
+		}, expected: DIAG_INVALID_FORM},
+		{name: "padding", corrupt: func(b *pkgBuilder) []byte {
+			// The PKHD section holds "golden\0" so it is padded
+			data := b.Bytes()
+			data[12+8+7] = 0xFF
+			return data
+		}, expected: DIAG_INVALID_SECTION},
+		{name: "truncated code", corrupt: func(b *pkgBuilder) []byte {
+			b.code = b.code[:len(b.code)-1]
+			return b.Bytes()
+		}, expected: DIAG_INVALID_CODE},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			codes := validateTestPackage(t, c.corrupt(build()))
+			if len(c.expected) == 0 {
+				if len(codes) > 0 {
+					t.Fatalf("expected a valid package, got %v", codes)
+				}
+				return
+			}
+			for _, code := range codes {
+				if code == c.expected {
+					return
+				}
+			}
+			t.Fatalf("expected %s, got %v", c.expected, codes)
+		})
+	}
+}
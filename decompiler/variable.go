@@ -1,7 +1,7 @@
 package decompiler
 
 import (
-	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -10,17 +10,22 @@ type HandleTypeInfo struct {
 	sourcePackage string
 }
 
-var HANDLE_MAP map[string]HandleTypeInfo = map[string]HandleTypeInfo{
-	"htask": {
-		baseType:      "hobject",
-		sourcePackage: SYSTEM_PACKAGE,
-	},
-	"hobject": {
-		baseType:      "",
-		sourcePackage: SYSTEM_PACKAGE,
-	},
+// Gets the handle types that are built in, before any headers add their own
+func newHandleMap() map[string]HandleTypeInfo {
+	return map[string]HandleTypeInfo{
+		"htask": {
+			baseType:      "hobject",
+			sourcePackage: SYSTEM_PACKAGE,
+		},
+		"hobject": {
+			baseType:      "",
+			sourcePackage: SYSTEM_PACKAGE,
+		},
+	}
 }
 
+var HANDLE_MAP = newHandleMap()
+
 func IsHandleType(typeName string) bool {
 	_, ok := HANDLE_MAP[typeName]
 	return ok
@@ -45,11 +50,13 @@ type Variable struct {
 	referencedTypes        map[string]bool
 	parameterAssignedTypes map[string]bool
 	handleEqualsTypes      map[string]bool
-	potentialNames         []NameProvider
-	nameProvider           NameProvider
-	refCount               int
-	assignmentCount        int
-	id                     int
+	// Assigned handle types that had a cast inserted for them
+	castAssignedTypes map[string]bool
+	potentialNames    []NameProvider
+	nameProvider      NameProvider
+	refCount          int
+	assignmentCount   int
+	id                int
 }
 
 func NewVariable(variableName string, typeName string, uniqueId bool) *Variable {
@@ -61,6 +68,7 @@ func NewVariable(variableName string, typeName string, uniqueId bool) *Variable
 		referencedTypes:        map[string]bool{},
 		parameterAssignedTypes: map[string]bool{},
 		handleEqualsTypes:      map[string]bool{},
+		castAssignedTypes:      map[string]bool{},
 	}
 	if uniqueId {
 		v.id = VARIABLE_ID_COUNTER
@@ -359,6 +367,89 @@ func (v *Variable) ResolveNamingConflict(index int) {
 type EnumTypeInfo struct {
 	nameToValue map[string]uint32
 	valueToName map[uint32]string
+	isFlags     bool
+}
+
+func isPowerOfTwo(value uint32) bool {
+	return value != 0 && value&(value-1) == 0
+}
+
+func bitCount(value uint32) int {
+	count := 0
+	for ; value != 0; value &= value - 1 {
+		count++
+	}
+	return count
+}
+
+// Detects enums where every member other than 0 is a single bit flag. Enums with members that combine flags are only
+// detected when the header spells the combination out, since a regular enum counting up from zero also has members
+// made of the bits of the others.
+func hasFlagValues(valueToName map[uint32]string) bool {
+	flagCount := 0
+	for value := range valueToName {
+		if value == 0 {
+			continue
+		}
+		if !isPowerOfTwo(value) {
+			return false
+		}
+		flagCount++
+	}
+
+	// Two flags can't be told apart from a regular enum counting up from zero, a third one has to skip 3
+	return flagCount >= 3
+}
+
+// Gets the member name for a value, flag enums will combine members with a | if there isn't an exact match
+func (e EnumTypeInfo) GetValueName(value uint32) string {
+	if name, ok := e.valueToName[value]; ok {
+		return name
+	}
+
+	if !e.isFlags || value == 0 {
+		return ""
+	}
+
+	members := []uint32{}
+	for k := range e.valueToName {
+		if k != 0 {
+			members = append(members, k)
+		}
+	}
+
+	// Prefer the members that combine the most flags so we don't spell out combinations that have a name
+	sort.Slice(members, func(i, j int) bool {
+		if bitCount(members[i]) != bitCount(members[j]) {
+			return bitCount(members[i]) > bitCount(members[j])
+		}
+		return members[i] < members[j]
+	})
+
+	remaining := value
+	used := []uint32{}
+	for _, member := range members {
+		if member&remaining == member {
+			used = append(used, member)
+			remaining &^= member
+		}
+	}
+
+	// Some of the bits don't belong to any member
+	if remaining != 0 {
+		return ""
+	}
+
+	sort.Slice(used, func(i, j int) bool {
+		return used[i] < used[j]
+	})
+
+	names := []string{}
+	for _, member := range used {
+		names = append(names, e.valueToName[member])
+	}
+
+	return strings.Join(names, " | ")
 }
 
 var ENUM_MAP map[string]EnumTypeInfo = map[string]EnumTypeInfo{}
@@ -368,11 +459,21 @@ func IsEnumType(typeName string) bool {
 	return ok
 }
 
+func IsFlagEnumType(typeName string) bool {
+	e, ok := ENUM_MAP[typeName]
+	return ok && e.isFlags
+}
+
 type Scope struct {
 	function                 *FunctionDeclaration
 	functionEndOffset        uint32
 	variables                []*Variable
 	localVariableIndexOffset uint32
+	unreachable              map[uint32]bool
+	deadStores               map[uint32]bool
+	deadCode                 map[BlockElement]string
+	debugBlocks              []*DebugBlockInfo
+	atomicProblems           map[BlockElement][]*AtomicProblem
 }
 
 func (s *Scope) GetVariableByStackIndex(stackIndex uint32) *Variable {
@@ -411,12 +512,12 @@ func HighestCommonAncestorType(leftType string, rightType string) string {
 func GetCastFunctionForHandleType(handleType string) string {
 	hdata, ok := HANDLE_MAP[handleType]
 	if !ok {
-		fmt.Printf("ERROR: Failed to get cast function for handle type %s: handle type not found.\n", handleType)
+		Diagnose(DIAG_CAST_FUNCTION_NOT_FOUND, "Failed to get cast function for handle type %s: handle type not found", handleType)
 		return UNKNOWN_TYPE
 	}
 	packageData, ok := PACKAGES[strings.ToLower(hdata.sourcePackage)]
 	if !ok {
-		fmt.Printf("ERROR: Failed to get cast function for handle type %s: source package %s not found.\n", handleType, hdata.sourcePackage)
+		Diagnose(DIAG_CAST_FUNCTION_NOT_FOUND, "Failed to get cast function for handle type %s: source package %s not found", handleType, hdata.sourcePackage)
 		return UNKNOWN_TYPE
 	}
 
@@ -426,6 +527,6 @@ func GetCastFunctionForHandleType(handleType string) string {
 		}
 	}
 
-	fmt.Printf("ERROR: Failed to get cast function for handle type %s: \"Cast\" function not found in source package %s.\n", handleType, hdata.sourcePackage)
+	Diagnose(DIAG_CAST_FUNCTION_NOT_FOUND, "Failed to get cast function for handle type %s: \"Cast\" function not found in source package %s", handleType, hdata.sourcePackage)
 	return UNKNOWN_TYPE
 }
@@ -0,0 +1,108 @@
+package decompiler
+
+// Gets the statements that belong to a block element itself, like conditionals, rather than its body
+func GetElementStatements(e BlockElement) []*Statement {
+	switch b := e.(type) {
+	case *Statement:
+		return []*Statement{b}
+
+	case *IfBlock:
+		return []*Statement{b.conditional}
+
+	case *ElseIfBlock:
+		return []*Statement{b.conditional}
+
+	case *WhileLoop:
+		return []*Statement{b.conditional}
+
+	case *DoWhileLoop:
+		return []*Statement{b.conditional}
+
+	case *ForLoop:
+		return []*Statement{b.init, b.conditional, b.increment}
+
+	case *SwitchBlock:
+		if b.conditional != nil {
+			return []*Statement{b.conditional}
+		}
+	}
+
+	return nil
+}
+
+// Gets pointers to the bodies of a block element so they can be walked or modified
+func GetElementBodies(e BlockElement) []*[]BlockElement {
+	switch b := e.(type) {
+	case *IfBlock:
+		return []*[]BlockElement{&b.body}
+
+	case *ElseIfBlock:
+		return []*[]BlockElement{&b.body}
+
+	case *ElseBlock:
+		return []*[]BlockElement{&b.body}
+
+	case *DebugBlock:
+		return []*[]BlockElement{&b.body}
+
+	case *AtomicBlock:
+		return []*[]BlockElement{&b.body}
+
+	case *ScheduleBlock:
+		return []*[]BlockElement{&b.body}
+
+	case *ScheduleEveryBlock:
+		return []*[]BlockElement{&b.body}
+
+	case *WhileLoop:
+		return []*[]BlockElement{&b.body}
+
+	case *DoWhileLoop:
+		return []*[]BlockElement{&b.body}
+
+	case *ForLoop:
+		return []*[]BlockElement{&b.body}
+
+	case *SwitchBlock:
+		return []*[]BlockElement{&b.body}
+
+	case *CaseBlock:
+		return []*[]BlockElement{&b.body}
+	}
+
+	return nil
+}
+
+// Calls visit for every element in the tree, parents before children. Returning false skips the element's bodies.
+func WalkBlockElements(elements []BlockElement, visit func(e BlockElement) bool) {
+	for _, e := range elements {
+		if !visit(e) {
+			continue
+		}
+		for _, body := range GetElementBodies(e) {
+			WalkBlockElements(*body, visit)
+		}
+	}
+}
+
+// Calls visit for every statement in the tree, including the conditionals of blocks
+func ForEachStatement(elements []BlockElement, visit func(s *Statement)) {
+	WalkBlockElements(elements, func(e BlockElement) bool {
+		for _, s := range GetElementStatements(e) {
+			visit(s)
+		}
+		return true
+	})
+}
+
+// Calls visit for this node and all of its descendants, parents before children
+func (og *OpGraph) Walk(visit func(node *OpGraph, parent *OpGraph)) {
+	og.walkInternal(nil, visit)
+}
+
+func (og *OpGraph) walkInternal(parent *OpGraph, visit func(node *OpGraph, parent *OpGraph)) {
+	visit(og, parent)
+	for _, child := range og.children {
+		child.walkInternal(og, visit)
+	}
+}
@@ -11,17 +11,47 @@ type CodeWriter interface {
 	PopIndent()
 	Append(s string)
 	Appendf(format string, a ...interface{})
+	// Gets the line and column the next append will start at, both starting from 1
+	Position() (int, int)
+	// Gets the line and column just after the last text written, leaving out the line breaks that follow it
+	TextEndPosition() (int, int)
+	IndentLevel() int
+	// Whether the block being rendered is followed by something that continues on its closing line, like an else
+	ContinuesBlock() bool
+	SetContinuesBlock(continues bool)
+	// Whether the output is only being written to measure how wide it is
+	IsMeasuring() bool
 }
 
 type codeWriter struct {
 	ioWrite io.Writer
 	indent  int
 	last    string
+	line    int
+	column  int
+	textEnd [2]int
+
+	continuesBlock bool
+	measuring      bool
 }
 
 func NewCodeWriter(w io.Writer) CodeWriter {
 	return &codeWriter{
 		ioWrite: w,
+		line:    1,
+		column:  1,
+		textEnd: [2]int{1, 1},
+	}
+}
+
+// Gets a writer for measuring how wide some output is, anything that only fits on a line when measured isn't done
+func NewMeasuringWriter(w io.Writer) CodeWriter {
+	return &codeWriter{
+		ioWrite:   w,
+		line:      1,
+		column:    1,
+		textEnd:   [2]int{1, 1},
+		measuring: true,
 	}
 }
 
@@ -36,15 +66,27 @@ func (cw *codeWriter) PopIndent() {
 	}
 }
 
+func advancePosition(line int, column int, s string) (int, int) {
+	if newline := strings.LastIndex(s, "\n"); newline != -1 {
+		return line + strings.Count(s, "\n"), len(s) - newline
+	}
+	return line, column + len(s)
+}
+
 func (cw *codeWriter) internalAppend(s string) {
 	fmt.Fprint(cw.ioWrite, s)
 	cw.last = s
+
+	if text := strings.TrimRight(s, "\n"); len(text) > 0 {
+		cw.textEnd[0], cw.textEnd[1] = advancePosition(cw.line, cw.column, text)
+	}
+	cw.line, cw.column = advancePosition(cw.line, cw.column, s)
 }
 
 func (cw *codeWriter) handleIndent() {
 	if strings.HasSuffix(cw.last, "\n") {
 		for ii := 0; ii < cw.indent; ii++ {
-			cw.internalAppend("\t")
+			cw.internalAppend(getIndentString())
 		}
 	}
 }
@@ -58,3 +100,31 @@ func (cw *codeWriter) Appendf(format string, a ...interface{}) {
 	cw.handleIndent()
 	cw.internalAppend(fmt.Sprintf(format, a...))
 }
+
+func (cw *codeWriter) Position() (int, int) {
+	// Indentation isn't written until the next append, but that is where the next append will start
+	if strings.HasSuffix(cw.last, "\n") {
+		return cw.line, cw.column + cw.indent*len(getIndentString())
+	}
+	return cw.line, cw.column
+}
+
+func (cw *codeWriter) TextEndPosition() (int, int) {
+	return cw.textEnd[0], cw.textEnd[1]
+}
+
+func (cw *codeWriter) IndentLevel() int {
+	return cw.indent
+}
+
+func (cw *codeWriter) ContinuesBlock() bool {
+	return cw.continuesBlock
+}
+
+func (cw *codeWriter) SetContinuesBlock(continues bool) {
+	cw.continuesBlock = continues
+}
+
+func (cw *codeWriter) IsMeasuring() bool {
+	return cw.measuring
+}
@@ -3,6 +3,6 @@ module pog-pkg-decompiler
 go 1.19
 
 require (
-	github.com/iancoleman/strcase v0.2.0 // indirect
-	github.com/juliangruber/go-intersect v1.1.0 // indirect
+	github.com/iancoleman/strcase v0.2.0
+	github.com/juliangruber/go-intersect v1.1.0
 )
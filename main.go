@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"pog-pkg-decompiler/decompiler"
 )
 
@@ -13,10 +14,88 @@ func main() {
 	flag.BoolVar(&decompiler.ASSEMBLY_ONLY, "assembly-only", false, "Have the decompiler output only the assembly for the package.")
 	flag.BoolVar(&decompiler.ASSEMBLY_OFFSET_PREFIX, "assembly-offset-prefix", true, "Prefix each line of assembly with its binary address.")
 	flag.BoolVar(&decompiler.DEBUG_LOGGING, "debug", false, "Output code that logs debug info at the start of every function.")
+	flag.StringVar(&decompiler.INSTRUMENT, "instrument", "", "Comma separated list of debug logging to add to the output: all, none, entry, exit, loops, schedules. Prefix a probe with - to disable it.")
+	flag.StringVar(&decompiler.INSTRUMENT_INCLUDE, "instrument-include", "", "Comma separated list of packages or Package.Function patterns to instrument, * and ? are wildcards.")
+	flag.StringVar(&decompiler.INSTRUMENT_EXCLUDE, "instrument-exclude", "", "Comma separated list of packages or Package.Function patterns not to instrument.")
+	flag.StringVar(&decompiler.INSTRUMENT_TAG, "instrument-tag", "", "A tag written at the start of every instrumentation message.")
+	flag.StringVar(&decompiler.DEBUG_BLOCKS, "debug-blocks", "keep", "What to do with the debug blocks of the package: keep, strip them out or collapse each into a comment.")
+	flag.StringVar(&decompiler.DEBUG_REPORT_FILE, "debug-report", "", "The file path to which the debug only code of the package and how it changes the behaviour will be written.")
+	flag.StringVar(&decompiler.TASK_REPORT_FILE, "task-report", "", "The file path to which a report of the tasks the package starts and whether anything stops them will be written.")
+	flag.BoolVar(&decompiler.ATOMIC_COMMENTS, "atomic-comments", false, "Add comments to the code in atomic blocks that starts tasks, can block, loops without an obvious bound or leaves the block early.")
+	flag.StringVar(&decompiler.ATOMIC_BLOCKING, "atomic-blocking", "", "Comma separated list of the packages, or Package.Function names, whose functions block the task calling them.")
+	flag.StringVar(&decompiler.SCHEDULE_REPORT_FILE, "schedule-report", "", "The file path to which a report of the every blocks of the package schedules, their intervals and the imported functions they call will be written.")
+	flag.StringVar(&decompiler.SIMPLIFY_RULES, "simplify", "all", "Comma separated list of expression simplifications to apply: all, none, double-negation, negated-comparison, de-morgan, bool-comparison, zero-comparison, constant-folding, parentheses. Prefix a rule with - to disable it.")
+	flag.BoolVar(&decompiler.ELSE_IF_CHAINS, "else-if", true, "Collapse else blocks that only contain an if into else if chains.")
+	flag.BoolVar(&decompiler.GUARD_CLAUSES, "guard-clauses", false, "Invert if/else blocks so branches that return come first as early return guard clauses.")
+	flag.BoolVar(&decompiler.DEAD_CODE_COMMENTS, "dead-code", false, "Add comments to unreachable code, assignments that are never read and unused local variables.")
+	flag.StringVar(&decompiler.DEAD_CODE_REPORT_FILE, "dead-code-report", "", "The file path to which a report of the dead code in the package will be written.")
+	flag.StringVar(&decompiler.SOURCE_MAP_FILE, "source-map", "", "The file path to which a JSON source map linking the output lines to code offsets will be written.")
+	flag.StringVar(&decompiler.HTML_OUTPUT_DIR, "html", "", "The directory to which a browsable html version of the package will be written. Decompile other packages to the same directory to link calls between them.")
+	flag.StringVar(&decompiler.JSON_AST_FILE, "json-ast", "", "The file path to which the decompiled functions will be written as a JSON syntax tree.")
+	flag.StringVar(&decompiler.STYLE_FILE, "style", "", "A JSON file with formatting options for the output code.")
+	flag.StringVar(&decompiler.DIAGNOSTICS_FILE, "diagnostics", "", "The file path to which all of the diagnostics will be written.")
+	flag.StringVar(&decompiler.DIAGNOSTICS_FORMAT, "diagnostics-format", "text", "The format of the diagnostics file: text or json.")
+	flag.StringVar(&decompiler.DIAGNOSTICS_FAIL_ON, "fail-on", "fatal", "Exit with a non-zero code if any diagnostic is at least this severe: info, warning, error, fatal or none.")
+	werror := flag.Bool("werror", false, "Exit with a non-zero code if there are any warnings or errors, the same as --fail-on warning.")
+	flag.StringVar(&decompiler.METRICS_FILE, "metrics", "", "The JSON file to which the decompilation quality metrics for the package will be added.")
+	flag.StringVar(&decompiler.FINGERPRINTS_FILE, "fingerprints", "", "The JSON file to which the fingerprints of the package's functions will be added, for the similar command.")
+	flag.StringVar(&decompiler.NAMES_FILE, "names", "", "A JSON file of name hints from the similar command, used to name and type local functions that match them.")
+	flag.Float64Var(&decompiler.SIMILARITY_THRESHOLD, "similarity", 0.8, "How similar functions have to be for the similar command to cluster them, from 0 to 1.")
+	flag.StringVar(&decompiler.STRINGS_CHARSET, "strings-charset", decompiler.STRINGS_CHARSET_WINDOWS_1252, "The encoding of the strings in the package: windows-1252 or utf-8. The strings files are always UTF-8.")
+	flag.StringVar(&decompiler.STRINGS_FORMAT, "strings-format", "", "The format of the strings export and import files: po or csv. Worked out from the file extension if not set.")
+	flag.StringVar(&decompiler.DIFF_FORMAT, "diff-format", "text", "The format of the diff command's report: text or json.")
+	flag.BoolVar(&decompiler.INSERT_HANDLE_CASTS, "insert-casts", true, "Insert Cast calls wherever a handle is used as a handle type it doesn't derive from.")
 	flag.Parse()
 
+	// Every command checks the fail on level, so it has to be set before any of them run
+	if *werror {
+		decompiler.DIAGNOSTICS_FAIL_ON = "warning"
+	}
+
 	// TODO: Proper arguments later when we need some
 	args := flag.Args()
+	if len(args) == 3 && args[0] == "compare" {
+		os.Exit(decompiler.CompareMetrics(args[1], args[2]))
+	}
+	if len(args) == 3 && args[0] == "diff" {
+		exitCode := decompiler.Diff(args[1], args[2])
+		decompiler.FinishDiagnostics()
+		os.Exit(exitCode)
+	}
+	if (len(args) == 2 || len(args) == 3) && args[0] == "similar" {
+		namesFile := ""
+		if len(args) == 3 {
+			namesFile = args[2]
+		}
+		os.Exit(decompiler.FindSimilarFunctions(args[1], namesFile))
+	}
+	if len(args) == 4 && args[0] == "strings" && args[1] == "export" {
+		exitCode := decompiler.ExportStrings(args[2], args[3])
+		decompiler.FinishDiagnostics()
+		os.Exit(exitCode)
+	}
+	if len(args) == 5 && args[0] == "strings" && args[1] == "import" {
+		exitCode := decompiler.ImportStrings(args[2], args[3], args[4])
+		decompiler.FinishDiagnostics()
+		os.Exit(exitCode)
+	}
+	if len(args) == 4 && args[0] == "patch" {
+		exitCode := decompiler.Patch(args[1], args[2], args[3])
+		decompiler.FinishDiagnostics()
+		os.Exit(exitCode)
+	}
+	if len(args) == 5 && args[0] == "replace" {
+		exitCode := decompiler.Replace(args[1], args[2], args[3], args[4])
+		decompiler.FinishDiagnostics()
+		os.Exit(exitCode)
+	}
+	if len(args) == 2 && args[0] == "validate" {
+		decompiler.INPUT_FILE = args[1]
+		exitCode := decompiler.Validate()
+		decompiler.FinishDiagnostics()
+		os.Exit(exitCode)
+	}
+
 	if len(args) != 1 {
 		fmt.Println("Invalid arguments")
 		return
@@ -24,4 +103,7 @@ func main() {
 	decompiler.INPUT_FILE = args[0]
 
 	decompiler.Decompile()
+	decompiler.FinishDiagnostics()
+
+	os.Exit(decompiler.GetExitCode())
 }